@@ -1,6 +1,7 @@
 package crowd
 
 import (
+	"context"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
@@ -8,6 +9,7 @@ import (
 	"github.com/valyala/fasthttp"
 	"net/url"
 	"runtime"
+	"sync"
 	"time"
 )
 
@@ -20,9 +22,44 @@ type API struct {
 	Client		*fasthttp.Client
 	Url			string
 	BasicAuth 	string
+	ctx			context.Context
+	flights		*flightGroup
+	limiter		*RateLimiter
+	mutatingLimiter	*RateLimiter
+	breaker		*CircuitBreaker
+	observer	Observer
+	tracer		Tracer
+	interceptors	[]Interceptor
+	dryRun		*DryRun
 }
 
-func NewAPI(url, application, applicationPassword string) (*API, error) {
+// Configures optional behaviour of an API.
+type Option func(*API)
+
+// Limit all requests to crowd to the given rate.
+func WithRateLimit(requestsPerSecond float64, burst int) Option {
+	return func(api *API) {
+		api.limiter = NewRateLimiter(requestsPerSecond, burst)
+	}
+}
+
+// Additionally limit mutating requests (POST, PUT and DELETE) to the given
+// rate, for example to protect crowd from bulk jobs.
+func WithMutatingRateLimit(requestsPerSecond float64, burst int) Option {
+	return func(api *API) {
+		api.mutatingLimiter = NewRateLimiter(requestsPerSecond, burst)
+	}
+}
+
+// Fail fast with ErrorCrowdUnavailable while the circuit breaker is open. A
+// breaker may be shared by several APIs talking to the same crowd server.
+func WithCircuitBreaker(breaker *CircuitBreaker) Option {
+	return func(api *API) {
+		api.breaker = breaker
+	}
+}
+
+func NewAPI(url, application, applicationPassword string, options ...Option) (*API, error) {
 
 	switch {
 	case url == "":
@@ -33,7 +70,7 @@ func NewAPI(url, application, applicationPassword string) (*API, error) {
 		return nil, ErrorGeneralEmptyPassword
 	}
 
-	return &API{
+	api := &API{
 		Client: &fasthttp.Client{
 			Name:                generateUserAgent(),
 			MaxIdleConnDuration: 5 * time.Second,
@@ -44,66 +81,128 @@ func NewAPI(url, application, applicationPassword string) (*API, error) {
 
 		Url:       url,
 		BasicAuth: generateBasicAuthString(application, applicationPassword),
-	}, nil
+		flights:   newFlightGroup(),
+	}
+
+	for _, option := range options {
+		option(api)
+	}
+
+	return api, nil
 }
 
-func (api *API) requestDelete(uri string) *fasthttp.Request {
+// Get a copy of the API which uses the given context for its requests. The
+// context bounds waiting for the rate limiter and, by its deadline, the
+// requests themselves. Identical GET requests may still be coalesced with
+// requests made under other contexts.
+func (api *API) WithContext(ctx context.Context) *API {
 
-	r := fasthttp.AcquireRequest()
-	r.SetRequestURI(api.Url + uri)
-	r.Header.Add("Authorization", "Basic "+api.BasicAuth)
-	r.Header.SetMethod("DELETE")
-	r.Header.Set("Content-Type", "application/json")
-	r.Header.Add("Accept", "application/json")
+	copied := *api
+	copied.ctx = ctx
 
-	return r
+	return &copied
 
 }
 
-func (api *API) requestPost(uri string) *fasthttp.Request {
+func (api *API) context() context.Context {
 
-	r := fasthttp.AcquireRequest()
-	r.SetRequestURI(api.Url + uri)
-	r.Header.Add("Authorization", "Basic "+api.BasicAuth)
-	r.Header.SetMethod("POST")
-	r.Header.Set("Content-Type", "application/json")
-	r.Header.Add("Accept", "application/json")
+	if api.ctx == nil {
+		return context.Background()
+	}
 
-	return r
+	return api.ctx
 
 }
 
-func (api *API) requestPut(uri string) *fasthttp.Request {
+func (api *API) setupRequest(request *fasthttp.Request, method, uri string) {
 
-	r := fasthttp.AcquireRequest()
-	r.SetRequestURI(api.Url + uri)
-	r.Header.Add("Authorization", "Basic "+api.BasicAuth)
-	r.Header.SetMethod("PUT")
-	r.Header.Set("Content-Type", "application/json")
-	r.Header.Add("Accept", "application/json")
-
-	return r
+	request.SetRequestURI(api.Url + uri)
+	request.Header.Add("Authorization", "Basic "+api.BasicAuth)
+	request.Header.SetMethod(method)
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Add("Accept", "application/json")
 
 }
 
-func (api *API) requestGet(uri string) *fasthttp.Request {
+// Send a request to crowd. A body other than nil is sent as JSON, the body of
+// a successful response is decoded into out, if it is not nil.
+//
+// Returns the status of the response and, for status 4xx, the error message
+// of crowd. If the request could not be made or its response not decoded,
+// the status is zero.
+func (api *API) do(ctx context.Context, method, uri string, body, out interface{}) (int, error) {
+
+	if method != "GET" {
 
-	r := fasthttp.AcquireRequest()
-	r.SetRequestURI(api.Url + uri)
-	r.Header.Add("Authorization", "Basic "+api.BasicAuth)
-	r.Header.SetMethod("GET")
-	r.Header.Set("Content-Type", "application/json")
-	r.Header.Add("Accept", "application/json")
+		if dryRun := api.dryRunOf(ctx); dryRun != nil {
 
-	return r
+			if status, handled, err := dryRun.handle(api.WithContext(ctx), method, uri, body); handled {
+				return status, err
+			}
+
+		}
+
+		return api.exchange(ctx, method, uri, body, func(data []byte) error {
+			return decodeResponse(data, out)
+		})
+	}
+
+	// Concurrent identical GET requests share a single round trip. The body
+	// is shared with all waiting callers, so it must be copied before the
+	// response is released.
+	status, data, err := api.flights.do(ctx, uri, func() (int, []byte, error) {
+
+		var data []byte
+
+		status, err := api.exchange(ctx, method, uri, nil, func(body []byte) error {
+			data = append([]byte(nil), body...)
+			return nil
+		})
+
+		return status, data, err
+
+	})
+
+	if err != nil {
+		return status, err
+	}
+
+	if isSuccess(status) {
+
+		if err := decodeResponse(data, out); err != nil {
+			return 0, err
+		}
+
+	}
+
+	return status, nil
 
 }
 
-func (api *API) doDeleteRequest(uri string) (int, error) {
-	request := api.requestDelete(uri)
+// Make a single request. The body of a successful response is passed to read
+// before the pooled response is released, read must not retain it.
+func (api *API) exchange(ctx context.Context, method, uri string, body interface{}, read func([]byte) error) (int, error) {
+
+	request := fasthttp.AcquireRequest()
 	response := fasthttp.AcquireResponse()
 
-	err := api.Client.Do(request, response)
+	defer fasthttp.ReleaseRequest(request)
+	defer fasthttp.ReleaseResponse(response)
+
+	api.setupRequest(request, method, uri)
+
+	if body != nil {
+
+		// Encode straight into the pooled request body.
+		err := json.NewEncoder(request.BodyWriter()).Encode(body)
+
+		if err != nil {
+			return 0, err
+		}
+
+	}
+
+	err := api.roundTrip(ctx, uri, request, response)
 
 	if err != nil {
 		return 0, err
@@ -111,105 +210,270 @@ func (api *API) doDeleteRequest(uri string) (int, error) {
 
 	status := response.StatusCode()
 
-	if !(status >= 200 && status <= 204) && status < 500 {
+	switch {
+	case isSuccess(status):
+
+		if err := read(response.Body()); err != nil {
+			return 0, err
+		}
+
+		return status, nil
+
+	case status < 500:
 		return status, getCrowdErrorMessage(response.Body())
+	default:
+		return status, nil
 	}
 
-	return status, nil
+}
 
+func isSuccess(status int) bool {
+	return status >= 200 && status <= 299
 }
 
-func (api *API) doGetRequest(uri string) (int, []byte, error) {
-	request := api.requestGet(uri)
-	response := fasthttp.AcquireResponse()
+// Decode a response body which is already in memory. This is not streaming:
+// fasthttp v1.18 reads whole responses into a buffer and has no body stream,
+// so the buffer is decoded in place, without copying it first.
+func decodeResponse(data []byte, out interface{}) error {
 
-	err := api.Client.Do(request, response)
+	if out == nil {
+		return nil
+	}
 
-	if err != nil {
-		return 0, nil, err
+	return json.Unmarshal(data, out)
+
+}
+
+type flightCall struct {
+	done   chan struct{}
+	status int
+	body   []byte
+	err    error
+	// Whether the call failed because the context of the caller running it
+	// ended, the result is then not handed to the waiting callers.
+	abandoned bool
+}
+
+// Deduplicates concurrent calls with the same key, in the style of
+// golang.org/x/sync/singleflight.
+type flightGroup struct {
+	mu    sync.Mutex
+	calls map[string]*flightCall
+}
+
+func newFlightGroup() *flightGroup {
+	return &flightGroup{calls: make(map[string]*flightCall)}
+}
+
+// Run fn, unless a call with the same key is already in flight. In that case
+// wait for it and return its result instead. Waiting stops when ctx ends, and
+// if the call in flight is abandoned because the context of its caller ended,
+// fn is run again.
+func (g *flightGroup) do(ctx context.Context, key string, fn func() (int, []byte, error)) (int, []byte, error) {
+
+	if g == nil {
+		return fn()
 	}
 
-	status := response.StatusCode()
+	for {
+
+		g.mu.Lock()
+
+		call, ok := g.calls[key]
+
+		if !ok {
+
+			call = &flightCall{done: make(chan struct{})}
+			g.calls[key] = call
+
+			g.mu.Unlock()
+
+			g.run(ctx, key, call, fn)
+
+			return call.status, call.body, call.err
+
+		}
+
+		g.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return 0, nil, ctx.Err()
+		case <-call.done:
+		}
+
+		if !call.abandoned {
+			return call.status, call.body, call.err
+		}
 
-	if !(status >= 200 && status <= 204) && status < 500 {
-		return status, nil, getCrowdErrorMessage(response.Body())
 	}
 
-	responseBody := response.Body()
+}
+
+func (g *flightGroup) run(ctx context.Context, key string, call *flightCall, fn func() (int, []byte, error)) {
+
+	defer func() {
+		g.mu.Lock()
+		delete(g.calls, key)
+		g.mu.Unlock()
+		close(call.done)
+	}()
 
-	return status, responseBody, err
+	call.status, call.body, call.err = fn()
+	call.abandoned = call.err != nil && ctx.Err() != nil
 
 }
 
-func (api *API) doPostRequest(uri string, body interface{}) (int, error) {
+// Execute a request. Every request to crowd passes through here.
+func (api *API) roundTrip(ctx context.Context, uri string, request *fasthttp.Request, response *fasthttp.Response) error {
 
-	request := api.requestPost(uri)
-	response := fasthttp.AcquireResponse()
+	info := RequestInfo{
+		Operation: operationName(string(request.Header.Method()), uri),
+		Method:    string(request.Header.Method()),
+		Path:      requestPath(uri),
+		Query:     requestQuery(uri),
+	}
 
-	defer fasthttp.ReleaseRequest(request)
-	defer fasthttp.ReleaseResponse(response)
+	var finish func(RequestInfo)
 
-	bodyContent, err := json.Marshal(body)
+	if api.tracer != nil {
+		finish = api.tracer.StartRequest(ctx, info, &request.Header)
+	}
 
-	if err != nil {
-		return 0, err
+	ctx = context.WithValue(ctx, operationContextKey, info.Operation)
+
+	start := time.Now()
+
+	err := api.send(ctx, request, response)
+
+	info.Duration = time.Since(start)
+	info.Err = err
+
+	if err == nil {
+		info.Status = response.StatusCode()
+	}
+
+	if api.observer != nil {
+		api.observer.ObserveRequest(info)
 	}
 
-	request.SetBody(bodyContent)
+	if finish != nil {
+		finish(info)
+	}
 
-	err = api.Client.Do(request, response)
+	return err
+
+}
+
+// Send a request through the circuit breaker and the rate limiters. The
+// breaker is asked first, so that requests are not queued by the limiters
+// while crowd is unavailable.
+func (api *API) send(ctx context.Context, request *fasthttp.Request, response *fasthttp.Response) error {
+
+	if api.breaker == nil {
+		return api.limit(ctx, request, response)
+	}
+
+	generation, err := api.breaker.allow()
 
 	if err != nil {
-		return 0, err
+		return err
 	}
 
-	status := response.StatusCode()
+	err = api.limit(ctx, request, response)
 
-	if !(status >= 200 && status <= 204) && status < 500 {
-		return status, getCrowdErrorMessage(response.Body())
+	// A request given up by the caller says nothing about crowd.
+	if err != nil && givenUp(ctx, err) {
+		api.breaker.cancel(generation)
+		return err
 	}
 
-	return status, nil
+	api.breaker.done(generation, err == nil && response.StatusCode() < 500)
+
+	return err
 
 }
 
-func (api *API) doPutRequest(uri string, body interface{}) (int, error) {
+// Whether a request failed because its context was canceled or its deadline
+// passed. The transport may time out just before the context notices.
+func givenUp(ctx context.Context, err error) bool {
 
-	request := api.requestPut(uri)
-	response := fasthttp.AcquireResponse()
+	if ctx.Err() != nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
 
-	defer fasthttp.ReleaseRequest(request)
-	defer fasthttp.ReleaseResponse(response)
+	deadline, ok := ctx.Deadline()
 
-	bodyContent, err := json.Marshal(body)
+	return ok && !time.Now().Before(deadline)
+
+}
+
+// Wait for the rate limiters and execute the request.
+func (api *API) limit(ctx context.Context, request *fasthttp.Request, response *fasthttp.Response) error {
+
+	if api.limiter != nil {
+
+		if err := api.limiter.Wait(ctx); err != nil {
+			return err
+		}
 
-	if err != nil {
-		return 0, err
 	}
 
-	request.SetBody(bodyContent)
+	if api.mutatingLimiter != nil && isMutatingMethod(string(request.Header.Method())) {
 
-	err = api.Client.Do(request, response)
+		if err := api.mutatingLimiter.Wait(ctx); err != nil {
+
+			// The request is not made, its general token is not used.
+			if api.limiter != nil {
+				api.limiter.cancel()
+			}
+
+			return err
+
+		}
 
-	if err != nil {
-		return 0, err
 	}
 
-	status := response.StatusCode()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 
-	if !(status >= 200 && status <= 204) && status < 500 {
-		return status, getCrowdErrorMessage(response.Body())
+	return api.execute(ctx, request, response)
+
+}
+
+// Pass the request through the interceptors to the transport.
+func (api *API) execute(ctx context.Context, request *fasthttp.Request, response *fasthttp.Response) error {
+
+	roundTrip := RoundTrip(api.transport)
+
+	for i := len(api.interceptors) - 1; i >= 0; i-- {
+		roundTrip = api.interceptors[i](roundTrip)
 	}
 
-	return status, nil
+	return roundTrip(ctx, request, response)
 
 }
 
+func (api *API) transport(ctx context.Context, request *fasthttp.Request, response *fasthttp.Response) error {
+
+	if deadline, ok := ctx.Deadline(); ok {
+		return api.Client.DoDeadline(request, response, deadline)
+	}
+
+	return api.Client.Do(request, response)
+
+}
+
+func isMutatingMethod(method string) bool {
+	return method == "POST" || method == "PUT" || method == "DELETE"
+}
+
 func getCrowdErrorMessage(data []byte) error {
 	crowdErrorMessage := &crowdErrorMessage{}
 	err := json.Unmarshal(data, crowdErrorMessage)
 
-	if err != nil {
+	if err != nil || crowdErrorMessage.Message == "" {
 		return nil
 	}
 
@@ -220,10 +484,27 @@ func urlEscape(s string) string {
 	return url.QueryEscape(s)
 }
 
+func pathEscape(s string) string {
+	return url.PathEscape(s)
+}
+
 func unknownResponse(status int) error {
 	return fmt.Errorf("Unknown response: %d", status)
 }
 
+// Get the error for a response the caller did not expect: the error of the
+// request or crowd's error message if there is one, an unknown response
+// error otherwise.
+func responseError(status int, err error) error {
+
+	if err != nil {
+		return err
+	}
+
+	return unknownResponse(status)
+
+}
+
 func generateBasicAuthString(username string, password string) string {
 	return base64.StdEncoding.EncodeToString([]byte(username + ":" + password))
 }
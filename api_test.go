@@ -1,18 +1,21 @@
 package crowd
 
 import (
+	"context"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/stretchr/testify/assert"
 	"github.com/valyala/fasthttp"
-	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"runtime"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 type testmessage struct {
@@ -90,61 +93,211 @@ func TestNewAPI(t *testing.T) {
 
 }
 
-func TestRequestDelete(t *testing.T){
+func TestNewAPIOptions(t *testing.T) {
 
-	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	api, err := NewAPI("http://crowd", "testapp", "password", WithRateLimit(10, 5), WithMutatingRateLimit(1, 1))
+
+	assert.Nil(t, err)
+	assert.NotNil(t, api.limiter)
+	assert.NotNil(t, api.mutatingLimiter)
+
+}
+
+func TestWithContext(t *testing.T) {
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		w.WriteHeader(http.StatusNoContent)
+
+	}))
 	defer server.Close()
 
-	api, err := NewAPI(server.URL, "testapp", "password")
+	api, err := NewAPI(server.URL, "testapp", "password", WithMutatingRateLimit(0.001, 1))
 
 	assert.Nil(t, err)
-	assert.ObjectsAreEqual(API{}, api)
 
-	request := api.requestDelete("/testuri")
+	err = api.RemoveUser("testuser")
+
+	assert.Nil(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
 
-	assert.ObjectsAreEqual(request, fasthttp.Request{})
-	assert.Equal(t, server.URL+"/testuri", request.URI().String())
-	assert.Equal(t, []byte("DELETE"), request.Header.Method())
+	err = api.WithContext(ctx).RemoveUser("testuser")
+
+	assert.Equal(t, context.Canceled, err)
+	assert.Equal(t, context.Background(), api.context())
 
 }
 
-func TestRequestPost(t *testing.T){
+func TestWithMutatingRateLimit(t *testing.T) {
 
-	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		if r.Method == "GET" {
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte("{}"))
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+
+	}))
 	defer server.Close()
 
-	api, err := NewAPI(server.URL, "testapp", "password")
+	api, err := NewAPI(server.URL, "testapp", "password", WithRateLimit(0.001, 2), WithMutatingRateLimit(0.001, 1))
 
 	assert.Nil(t, err)
 
-	request := api.requestPost("/testuri")
+	err = api.RemoveUser("testuser")
+
+	assert.Nil(t, err)
 
-	assert.ObjectsAreEqual(request, fasthttp.Request{})
-	assert.Equal(t, server.URL+"/testuri", request.URI().String())
-	assert.Equal(t, []byte("POST"), request.Header.Method())
-	assert.Equal(t, "application/json", string(request.Header.ContentType()))
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	err = api.WithContext(ctx).RemoveUser("testuser")
+
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	// The general token of the request which was not made is returned.
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	_, err = api.WithContext(ctx).GetCookieConfig()
+
+	assert.Nil(t, err)
 
 }
 
-func TestRequestPut(t *testing.T){
+func TestWithCircuitBreaker(t *testing.T) {
 
-	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	requests := 0
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		requests++
+
+		w.WriteHeader(http.StatusServiceUnavailable)
+
+	}))
 	defer server.Close()
 
-	api, err := NewAPI(server.URL, "testapp", "password")
+	breaker := NewCircuitBreaker(BreakerSettings{ConsecutiveFailures: 2, OpenTimeout: time.Minute})
+
+	api, err := NewAPI(server.URL, "testapp", "password", WithCircuitBreaker(breaker))
 
 	assert.Nil(t, err)
 
-	request := api.requestPut("/testuri")
+	for i := 0; i < 2; i++ {
+
+		status, err := api.do(context.Background(), "GET", "/testuri", nil, nil)
+
+		assert.Nil(t, err)
+		assert.Equal(t, 503, status)
+
+	}
+
+	_, err = api.GetUser("testuser")
 
-	assert.ObjectsAreEqual(request, fasthttp.Request{})
-	assert.Equal(t, server.URL+"/testuri", request.URI().String())
-	assert.Equal(t, []byte("PUT"), request.Header.Method())
-	assert.Equal(t, "application/json", string(request.Header.ContentType()))
+	assert.Equal(t, ErrorCrowdUnavailable, err)
+	assert.Equal(t, 2, requests)
+	assert.Equal(t, BreakerOpen, breaker.State())
 
 }
 
-func TestRequestGet(t *testing.T){
+func TestWithCircuitBreakerContext(t *testing.T) {
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		time.Sleep(100 * time.Millisecond)
+
+		w.WriteHeader(http.StatusNoContent)
+
+	}))
+	defer server.Close()
+
+	breaker := NewCircuitBreaker(BreakerSettings{ConsecutiveFailures: 1, OpenTimeout: time.Minute})
+	api, err := NewAPI(server.URL, "testapp", "password", WithCircuitBreaker(breaker), WithRateLimit(0.001, 1))
+
+	assert.Nil(t, err)
+
+	// Requests given up by the caller are not failures of crowd.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	err = api.WithContext(ctx).RemoveUser("testuser")
+
+	assert.NotNil(t, err)
+	assert.Equal(t, BreakerClosed, breaker.State())
+
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	err = api.WithContext(ctx).RemoveUser("testuser")
+
+	assert.Equal(t, context.DeadlineExceeded, err, "the rate limiter has no token left")
+	assert.Equal(t, BreakerClosed, breaker.State())
+
+	// An open breaker fails fast instead of waiting for the rate limiter.
+	breaker.done(allowed(t, breaker), false)
+
+	start := time.Now()
+
+	err = api.RemoveUser("testuser")
+
+	assert.Equal(t, ErrorCrowdUnavailable, err)
+	assert.True(t, time.Since(start) < time.Second)
+
+}
+
+type testObserver struct {
+	infos []RequestInfo
+}
+
+func (o *testObserver) ObserveRequest(info RequestInfo) {
+	o.infos = append(o.infos, info)
+}
+
+func TestWithObserver(t *testing.T) {
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		w.WriteHeader(http.StatusNotFound)
+
+	}))
+	defer server.Close()
+
+	observer := &testObserver{}
+
+	api, err := NewAPI(server.URL, "testapp", "password", WithObserver(observer))
+
+	assert.Nil(t, err)
+
+	_, err = api.GetUser("testuser")
+
+	assert.Equal(t, ErrorUserNotFound, err)
+	assert.Len(t, observer.infos, 1)
+	assert.Equal(t, "GetUser", observer.infos[0].Operation)
+	assert.Equal(t, "GET", observer.infos[0].Method)
+	assert.Equal(t, "/rest/usermanagement/1/user", observer.infos[0].Path)
+	assert.Equal(t, 404, observer.infos[0].Status)
+	assert.Nil(t, observer.infos[0].Err)
+
+}
+
+func TestOperationName(t *testing.T){
+
+	assert.Equal(t, "RemoveUserFromGroup", operationName("DELETE", "/rest/usermanagement/1/user/group/direct?username=a&groupname=b"))
+	assert.Equal(t, "GetEvents", operationName("GET", "/rest/usermanagement/1/event/token"))
+	assert.Equal(t, "SearchUsers", operationName("GET", "/rest/usermanagement/1/search?entity-type=user&expand=user&restriction="))
+	assert.Equal(t, "SearchGroups", operationName("GET", "/rest/usermanagement/1/search?entity-type=group&expand=group&restriction="))
+	assert.Equal(t, "ValidateSession", operationName("POST", "/rest/usermanagement/1/session/token"))
+	assert.Equal(t, "Unknown", operationName("PATCH", "/rest/usermanagement/1/user"))
+
+}
+
+func TestSetupRequest(t *testing.T){
 
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
 	defer server.Close()
@@ -153,15 +306,24 @@ func TestRequestGet(t *testing.T){
 
 	assert.Nil(t, err)
 
-	request := api.requestGet("/testuri")
+	for _, method := range []string{"DELETE", "GET", "POST", "PUT"} {
+
+		request := fasthttp.AcquireRequest()
 
-	assert.ObjectsAreEqual(request, fasthttp.Request{})
-	assert.Equal(t, server.URL+"/testuri", request.URI().String())
-	assert.Equal(t, []byte("GET"), request.Header.Method())
+		api.setupRequest(request, method, "/testuri")
+
+		assert.Equal(t, server.URL+"/testuri", request.URI().String())
+		assert.Equal(t, []byte(method), request.Header.Method())
+		assert.Equal(t, "application/json", string(request.Header.ContentType()))
+		assert.Equal(t, "Basic "+api.BasicAuth, string(request.Header.Peek("Authorization")))
+
+		fasthttp.ReleaseRequest(request)
+
+	}
 
 }
 
-func TestDoDeleteRequest(t *testing.T){
+func TestDoDelete(t *testing.T){
 
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 
@@ -177,13 +339,14 @@ func TestDoDeleteRequest(t *testing.T){
 
 	assert.Nil(t, err)
 
-	res, err := api.doDeleteRequest("/testuri")
+	res, err := api.do(context.Background(), "DELETE", "/testuri", nil, nil)
 
+	assert.Nil(t, err)
 	assert.Equal(t, 200, res)
 
 }
 
-func TestDoGetRequest(t *testing.T){
+func TestDoGet(t *testing.T){
 
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 
@@ -204,16 +367,155 @@ func TestDoGetRequest(t *testing.T){
 
 	assert.Nil(t, err)
 
-	status, res, err := api.doGetRequest("/testuri")
-	expectedResponse, err := json.Marshal(testmessage{Test: "message"})
+	res := &testmessage{}
+
+	status, err := api.do(context.Background(), "GET", "/testuri", nil, res)
 
 	assert.Nil(t, err)
 	assert.Equal(t, 200, status)
-	assert.Equal(t, expectedResponse , res)
+	assert.Equal(t, &testmessage{Test: "message"}, res)
 
 }
 
-func TestDoPostRequest(t *testing.T){
+func TestDoGetInvalidResponse(t *testing.T){
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("no json"))
+
+	}))
+	defer server.Close()
+
+	api, err := NewAPI(server.URL, "testapp", "password")
+
+	assert.Nil(t, err)
+
+	status, err := api.do(context.Background(), "GET", "/testuri", nil, &testmessage{})
+
+	assert.NotNil(t, err)
+	assert.Equal(t, 0, status)
+
+}
+
+func TestDoGetCoalescing(t *testing.T){
+
+	var requests int32
+
+	release := make(chan struct{})
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		atomic.AddInt32(&requests, 1)
+
+		<-release
+
+		respBytes, err := json.Marshal(testmessage{Test: "message"})
+
+		assert.Nil(t, err)
+
+		w.WriteHeader(http.StatusOK)
+		w.Write(respBytes)
+
+	}))
+	defer server.Close()
+
+	api, err := NewAPI(server.URL, "testapp", "password")
+
+	assert.Nil(t, err)
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < 10; i++ {
+
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			res := &testmessage{}
+
+			status, err := api.do(context.Background(), "GET", "/testuri", nil, res)
+
+			assert.Nil(t, err)
+			assert.Equal(t, 200, status)
+			assert.Equal(t, &testmessage{Test: "message"}, res)
+		}()
+
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	assert.Equal(t, int32(1), atomic.LoadInt32(&requests))
+
+}
+
+func TestFlightGroupContext(t *testing.T) {
+
+	flights := newFlightGroup()
+
+	leaderCtx, cancelLeader := context.WithCancel(context.Background())
+	started := make(chan struct{})
+	release := make(chan struct{})
+	leaderErr := make(chan error)
+
+	go func() {
+
+		_, _, err := flights.do(leaderCtx, "key", func() (int, []byte, error) {
+			close(started)
+			<-release
+			return 0, nil, leaderCtx.Err()
+		})
+
+		leaderErr <- err
+
+	}()
+
+	<-started
+
+	// A waiter stops waiting when its own context ends.
+	waiterCtx, cancelWaiter := context.WithCancel(context.Background())
+	cancelWaiter()
+
+	_, _, err := flights.do(waiterCtx, "key", func() (int, []byte, error) {
+		t.Error("the call is in flight")
+		return 0, nil, nil
+	})
+
+	assert.Equal(t, context.Canceled, err)
+
+	// A waiter whose context is live does not get the cancellation of the
+	// leader, the call is run again.
+	type result struct {
+		status int
+		body   []byte
+		err    error
+	}
+
+	waiter := make(chan result)
+
+	go func() {
+
+		status, body, err := flights.do(context.Background(), "key", func() (int, []byte, error) {
+			return 200, []byte("ok"), nil
+		})
+
+		waiter <- result{status, body, err}
+
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	cancelLeader()
+	close(release)
+
+	assert.Equal(t, context.Canceled, <-leaderErr)
+	assert.Equal(t, result{200, []byte("ok"), nil}, <-waiter)
+
+}
+
+func TestDoPost(t *testing.T){
 
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 
@@ -227,15 +529,16 @@ func TestDoPostRequest(t *testing.T){
 			return
 		}
 
-		body, err := ioutil.ReadAll(r.Body)
-		expectedBody, err := json.Marshal(testmessage{Test: "message"})
+		body := &testmessage{}
+		err := json.NewDecoder(r.Body).Decode(body)
 
 		assert.Nil(t, err)
 		assert.Equal(t, "POST", r.Method)
 		assert.Equal(t, "/testuri", r.RequestURI)
-		assert.Equal(t, expectedBody, body)
+		assert.Equal(t, &testmessage{Test: "message"}, body)
 
 		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`{"test": "response"}`))
 
 	}))
 	defer server.Close()
@@ -245,20 +548,22 @@ func TestDoPostRequest(t *testing.T){
 	assert.Nil(t, err)
 
 	body := testmessage{Test: "message"}
+	res := &testmessage{}
 
-	status, err := api.doPostRequest("/testuri", body)
+	status, err := api.do(context.Background(), "POST", "/testuri", body, res)
 
 	assert.Nil(t, err)
 	assert.Equal(t, 200, status)
+	assert.Equal(t, &testmessage{Test: "response"}, res)
 
-	status400, err := api.doPostRequest("/testuri400", body)
+	status400, err := api.do(context.Background(), "POST", "/testuri400", body, nil)
 
 	assert.Equal(t, 400, status400)
 	assert.Equal(t, errors.New("Test Error"), err)
 
 }
 
-func TestDoPutRequest(t *testing.T){
+func TestDoPut(t *testing.T){
 
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 
@@ -272,13 +577,13 @@ func TestDoPutRequest(t *testing.T){
 			return
 		}
 
-		body, err := ioutil.ReadAll(r.Body)
-		expectedBody, err := json.Marshal(testmessage{Test: "message"})
+		body := &testmessage{}
+		err := json.NewDecoder(r.Body).Decode(body)
 
 		assert.Nil(t, err)
 		assert.Equal(t, "PUT", r.Method)
 		assert.Equal(t, "/testuri", r.RequestURI)
-		assert.Equal(t, expectedBody, body)
+		assert.Equal(t, &testmessage{Test: "message"}, body)
 
 		w.WriteHeader(http.StatusOK)
 
@@ -291,14 +596,91 @@ func TestDoPutRequest(t *testing.T){
 
 	body := testmessage{Test: "message"}
 
-	status, err := api.doPutRequest("/testuri", body)
+	status, err := api.do(context.Background(), "PUT", "/testuri", body, nil)
 
 	assert.Nil(t, err)
 	assert.Equal(t, 200, status)
 
-	status400, err := api.doPostRequest("/testuri400", body)
+	status400, err := api.do(context.Background(), "PUT", "/testuri400", body, nil)
 
 	assert.Equal(t, 400, status400)
 	assert.Equal(t, errors.New("Test Error"), err)
 
-}
\ No newline at end of file
+}
+
+func TestResponseError(t *testing.T){
+
+	testError := errors.New("Test Error")
+
+	assert.Equal(t, testError, responseError(400, testError))
+	assert.Equal(t, unknownResponse(500), responseError(500, nil))
+
+}
+
+func newBenchmarkServer() *httptest.Server {
+
+	respBytes, _ := json.Marshal(User{Name: "testuser", FirstName: "Test", LastName: "User", Email: "test@example.com"})
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		switch r.Method {
+		case "GET":
+			w.WriteHeader(http.StatusOK)
+			w.Write(respBytes)
+		case "DELETE":
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusCreated)
+		}
+
+	}))
+
+}
+
+func BenchmarkGetUser(b *testing.B) {
+
+	server := newBenchmarkServer()
+	defer server.Close()
+
+	api, _ := NewAPI(server.URL, "testapp", "password")
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		api.GetUser("testuser")
+	}
+
+}
+
+func BenchmarkAddUser(b *testing.B) {
+
+	server := newBenchmarkServer()
+	defer server.Close()
+
+	api, _ := NewAPI(server.URL, "testapp", "password")
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		api.AddUser("testuser", "secret", "Test", "User", "", "test@example.com", true)
+	}
+
+}
+
+func BenchmarkRemoveUser(b *testing.B) {
+
+	server := newBenchmarkServer()
+	defer server.Close()
+
+	api, _ := NewAPI(server.URL, "testapp", "password")
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		api.RemoveUser("testuser")
+	}
+
+}
@@ -0,0 +1,151 @@
+package crowd
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Runs the same operation for many users concurrently. A failed item does not
+// stop the others, the result reports each item.
+type Batch struct {
+	client Client
+	// Number of items processed concurrently.
+	concurrency int
+}
+
+// Create a batch which runs at most concurrency calls at once, 4 if it is not
+// positive. Use a CachedAPI as the client to keep its caches consistent.
+func NewBatch(client Client, concurrency int) *Batch {
+
+	if concurrency <= 0 {
+		concurrency = 4
+	}
+
+	return &Batch{client: client, concurrency: concurrency}
+
+}
+
+// The outcome of an item of a batch.
+type BatchItem struct {
+	// The user the item is about.
+	Name string
+	// The error of the call, for example ErrorUserNotFound, or nil.
+	Err error
+}
+
+type BatchResult struct {
+	// Items in the order they were given, for attributes sorted by name.
+	Items []*BatchItem
+}
+
+// Count the items which succeeded.
+func (r *BatchResult) Succeeded() int {
+	return len(r.Items) - len(r.Failed())
+}
+
+// Get the items which failed.
+func (r *BatchResult) Failed() []*BatchItem {
+
+	failed := []*BatchItem{}
+
+	for _, item := range r.Items {
+		if item.Err != nil {
+			failed = append(failed, item)
+		}
+	}
+
+	return failed
+
+}
+
+// Get a *BatchError with the failed items, or nil if all succeeded.
+func (r *BatchResult) Err() error {
+
+	failed := r.Failed()
+
+	if len(failed) == 0 {
+		return nil
+	}
+
+	return &BatchError{Failed: failed, Total: len(r.Items)}
+
+}
+
+// Returned by BatchResult.Err if items of a batch failed.
+type BatchError struct {
+	Failed []*BatchItem
+	Total  int
+}
+
+func (e *BatchError) Error() string {
+	return fmt.Sprintf("%d of %d items failed, first %s: %v", len(e.Failed), e.Total, e.Failed[0].Name, e.Failed[0].Err)
+}
+
+// Add users to a group. Users which are already members fail with
+// ErrorUserAlreadyInGroup.
+func (b *Batch) AddUsersToGroup(groupName string, userNames []string) *BatchResult {
+
+	return b.run(userNames, func(userName string) error {
+		return b.client.AddUserToGroup(userName, groupName)
+	})
+
+}
+
+// Remove users.
+func (b *Batch) RemoveUsers(userNames []string) *BatchResult {
+	return b.run(userNames, b.client.RemoveUser)
+}
+
+// Store attributes of users, by user name. Attributes which are not given are
+// left unchanged.
+func (b *Batch) StoreAttributesForUsers(attributes map[string]*Attributes) *BatchResult {
+
+	userNames := make([]string, 0, len(attributes))
+
+	for userName := range attributes {
+		userNames = append(userNames, userName)
+	}
+
+	sort.Strings(userNames)
+
+	return b.run(userNames, func(userName string) error {
+		return b.client.StoreUserAttributes(userName, attributes[userName])
+	})
+
+}
+
+// Call the function for each name with at most b.concurrency calls at once.
+func (b *Batch) run(names []string, call func(name string) error) *BatchResult {
+
+	result := &BatchResult{Items: make([]*BatchItem, len(names))}
+	indexes := make(chan int)
+
+	wg := sync.WaitGroup{}
+
+	for i := 0; i < b.concurrency && i < len(names); i++ {
+
+		wg.Add(1)
+
+		go func() {
+
+			defer wg.Done()
+
+			for index := range indexes {
+				result.Items[index] = &BatchItem{Name: names[index], Err: call(names[index])}
+			}
+
+		}()
+
+	}
+
+	for index := range names {
+		indexes <- index
+	}
+
+	close(indexes)
+	wg.Wait()
+
+	return result
+
+}
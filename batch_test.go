@@ -0,0 +1,123 @@
+package crowd_test
+
+import (
+	"github.com/agile-rcm/crowd-go"
+	"github.com/agile-rcm/crowd-go/crowdmock"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Records the highest number of concurrent AddUserToGroup calls.
+type concurrencyClient struct {
+	*crowdmock.Client
+
+	mutex   sync.Mutex
+	running int
+	highest int
+}
+
+func (c *concurrencyClient) AddUserToGroup(userName, groupName string) error {
+
+	c.mutex.Lock()
+	c.running++
+
+	if c.running > c.highest {
+		c.highest = c.running
+	}
+
+	c.mutex.Unlock()
+
+	time.Sleep(5 * time.Millisecond)
+
+	c.mutex.Lock()
+	c.running--
+	c.mutex.Unlock()
+
+	return c.Client.AddUserToGroup(userName, groupName)
+
+}
+
+func newBatchClient(t *testing.T) *crowdmock.Client {
+
+	client := crowdmock.NewClient()
+
+	assert.Nil(t, client.CreateGroup("developers", "", true))
+
+	for _, name := range []string{"alice", "bob", "carol", "dave", "erin"} {
+		assert.Nil(t, client.AddUser(name, "password", "", "", "", "", true))
+	}
+
+	return client
+
+}
+
+func TestBatch_AddUsersToGroup(t *testing.T) {
+
+	client := &concurrencyClient{Client: newBatchClient(t)}
+
+	assert.Nil(t, client.Client.AddUserToGroup("erin", "developers"))
+
+	result := crowd.NewBatch(client, 2).AddUsersToGroup("developers", []string{"alice", "bob", "missing", "carol", "dave", "erin"})
+
+	assert.Len(t, result.Items, 6)
+	assert.Equal(t, 4, result.Succeeded())
+	assert.Equal(t, "missing", result.Items[2].Name)
+	assert.Equal(t, crowd.ErrorUserNotFound, result.Items[2].Err)
+	assert.Equal(t, crowd.ErrorUserAlreadyInGroup, result.Items[5].Err)
+	assert.Equal(t, 2, client.highest)
+
+	err, ok := result.Err().(*crowd.BatchError)
+
+	if assert.True(t, ok) {
+		assert.Len(t, err.Failed, 2)
+		assert.Equal(t, "2 of 6 items failed, first missing: User could not be found", err.Error())
+	}
+
+	members, _ := client.GetGroupMembers("developers")
+
+	assert.Len(t, members.Users, 5)
+
+}
+
+func TestBatch_RemoveUsers(t *testing.T) {
+
+	client := newBatchClient(t)
+
+	// One at a time, so that the second removal of alice is the one failing.
+	result := crowd.NewBatch(client, 1).RemoveUsers([]string{"alice", "bob", "alice"})
+
+	assert.Nil(t, result.Items[0].Err)
+	assert.Nil(t, result.Items[1].Err)
+	assert.Equal(t, crowd.ErrorUserNotFound, result.Items[2].Err)
+
+	_, err := client.GetUser("bob")
+
+	assert.Equal(t, crowd.ErrorUserNotFound, err)
+
+	result = crowd.NewBatch(client, 4).RemoveUsers(nil)
+
+	assert.Empty(t, result.Items)
+	assert.Nil(t, result.Err())
+
+}
+
+func TestBatch_StoreAttributesForUsers(t *testing.T) {
+
+	client := newBatchClient(t)
+
+	result := crowd.NewBatch(client, 3).StoreAttributesForUsers(map[string]*crowd.Attributes{
+		"carol":   {Attributes: []*crowd.Attribute{{Name: "team", Values: []string{"ops"}}}},
+		"alice":   {Attributes: []*crowd.Attribute{{Name: "team", Values: []string{"backend"}}}},
+		"missing": {Attributes: []*crowd.Attribute{{Name: "team", Values: []string{"none"}}}},
+	})
+
+	assert.Equal(t, []*crowd.BatchItem{{Name: "alice"}, {Name: "carol"}, {Name: "missing", Err: crowd.ErrorUserNotFound}}, result.Items)
+
+	attributes, err := client.GetUserAttributes("carol")
+
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"ops"}, attributes.Attributes[0].Values)
+
+}
@@ -0,0 +1,275 @@
+package crowd
+
+import (
+	"sync"
+	"time"
+)
+
+type BreakerState int
+
+const (
+	// Requests pass, failures are counted.
+	BreakerClosed BreakerState = iota
+	// Requests fail fast with ErrorCrowdUnavailable.
+	BreakerOpen
+	// A limited number of trial requests pass to probe if crowd recovered.
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+
+}
+
+// Configuration of a circuit breaker. Thresholds set to zero are disabled.
+type BreakerSettings struct {
+	// Open the breaker after this many consecutive failures.
+	ConsecutiveFailures int
+	// Open the breaker when the ratio of failed requests reaches this value,
+	// once at least MinRequests were made.
+	FailureRatio float64
+	MinRequests  int
+	// Reset the counters of the closed breaker in this interval. If zero,
+	// the counters are only reset when the breaker closes.
+	Interval time.Duration
+	// How long the breaker stays open before it lets trial requests pass.
+	OpenTimeout time.Duration
+	// Number of trial requests in half-open state, all of them must succeed
+	// to close the breaker again. Defaults to one.
+	HalfOpenRequests int
+	// Called after every state change.
+	OnStateChange func(from, to BreakerState)
+}
+
+// Stops sending requests to crowd while it is failing. Transport errors and
+// responses with a status of 500 or above count as failures.
+type CircuitBreaker struct {
+	mu          sync.Mutex
+	settings    BreakerSettings
+	state       BreakerState
+	requests    int
+	failures    int
+	consecutive int
+	halfOpen    int
+	successes   int
+	// Incremented whenever the counters are reset, so that the outcome of
+	// a request allowed before is not counted.
+	generation uint64
+	expires    time.Time
+	pending    [][2]BreakerState
+	now        func() time.Time
+}
+
+// Create a new circuit breaker.
+func NewCircuitBreaker(settings BreakerSettings) *CircuitBreaker {
+
+	if settings.HalfOpenRequests < 1 {
+		settings.HalfOpenRequests = 1
+	}
+
+	return &CircuitBreaker{
+		settings: settings,
+		now:      time.Now,
+	}
+
+}
+
+// Get the current state of the breaker.
+func (b *CircuitBreaker) State() BreakerState {
+
+	b.mu.Lock()
+	defer b.unlock()
+
+	return b.currentState(b.now())
+
+}
+
+// Check whether a request may pass. Every allowed request must be followed
+// by a call to done or cancel with the returned generation.
+func (b *CircuitBreaker) allow() (uint64, error) {
+
+	b.mu.Lock()
+	defer b.unlock()
+
+	state := b.currentState(b.now())
+
+	switch {
+	case state == BreakerOpen:
+		return 0, ErrorCrowdUnavailable
+	case state == BreakerHalfOpen && b.halfOpen >= b.settings.HalfOpenRequests:
+		return 0, ErrorCrowdUnavailable
+	case state == BreakerHalfOpen:
+		b.halfOpen++
+	}
+
+	return b.generation, nil
+
+}
+
+// Record the outcome of an allowed request. Outcomes of requests allowed in
+// an earlier generation are ignored.
+func (b *CircuitBreaker) done(generation uint64, success bool) {
+
+	b.mu.Lock()
+	defer b.unlock()
+
+	now := b.now()
+	state := b.currentState(now)
+
+	if generation != b.generation {
+		return
+	}
+
+	switch state {
+	case BreakerClosed:
+
+		b.requests++
+
+		if success {
+			b.consecutive = 0
+		} else {
+			b.failures++
+			b.consecutive++
+		}
+
+		if b.tripped() {
+			b.setState(BreakerOpen, now)
+		}
+
+	case BreakerHalfOpen:
+
+		if !success {
+			b.setState(BreakerOpen, now)
+			break
+		}
+
+		b.successes++
+
+		if b.successes >= b.settings.HalfOpenRequests {
+			b.setState(BreakerClosed, now)
+		}
+
+	}
+
+}
+
+// Forget an allowed request which has no outcome, because the context of
+// the caller ended. In the half-open state it frees the trial.
+func (b *CircuitBreaker) cancel(generation uint64) {
+
+	b.mu.Lock()
+	defer b.unlock()
+
+	state := b.currentState(b.now())
+
+	if generation == b.generation && state == BreakerHalfOpen && b.halfOpen > 0 {
+		b.halfOpen--
+	}
+
+}
+
+func (b *CircuitBreaker) tripped() bool {
+
+	settings := b.settings
+
+	if settings.ConsecutiveFailures > 0 && b.consecutive >= settings.ConsecutiveFailures {
+		return true
+	}
+
+	if settings.FailureRatio > 0 && b.requests >= settings.MinRequests {
+		return float64(b.failures)/float64(b.requests) >= settings.FailureRatio
+	}
+
+	return false
+
+}
+
+// Advance the state by time and return it.
+func (b *CircuitBreaker) currentState(now time.Time) BreakerState {
+
+	switch b.state {
+	case BreakerClosed:
+
+		if b.settings.Interval > 0 && !now.Before(b.expires) {
+			b.resetCounts()
+			b.expires = b.closedExpiry(now)
+		}
+
+	case BreakerOpen:
+
+		if !now.Before(b.expires) {
+			b.setState(BreakerHalfOpen, now)
+		}
+
+	}
+
+	return b.state
+
+}
+
+func (b *CircuitBreaker) setState(state BreakerState, now time.Time) {
+
+	b.pending = append(b.pending, [2]BreakerState{b.state, state})
+	b.state = state
+	b.resetCounts()
+
+	switch state {
+	case BreakerClosed:
+		b.expires = b.closedExpiry(now)
+	case BreakerOpen:
+		b.expires = now.Add(b.settings.OpenTimeout)
+	case BreakerHalfOpen:
+		b.expires = time.Time{}
+	}
+
+}
+
+func (b *CircuitBreaker) resetCounts() {
+
+	b.generation++
+	b.requests = 0
+	b.failures = 0
+	b.consecutive = 0
+	b.halfOpen = 0
+	b.successes = 0
+
+}
+
+func (b *CircuitBreaker) closedExpiry(now time.Time) time.Time {
+
+	if b.settings.Interval <= 0 {
+		return time.Time{}
+	}
+
+	return now.Add(b.settings.Interval)
+
+}
+
+// Release the lock and report the state changes made while holding it. The
+// callback runs without the lock, so it may use the breaker.
+func (b *CircuitBreaker) unlock() {
+
+	pending := b.pending
+	b.pending = nil
+
+	b.mu.Unlock()
+
+	if b.settings.OnStateChange == nil {
+		return
+	}
+
+	for _, change := range pending {
+		b.settings.OnStateChange(change[0], change[1])
+	}
+
+}
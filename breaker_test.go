@@ -0,0 +1,158 @@
+package crowd
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker_ConsecutiveFailures(t *testing.T) {
+
+	now := time.Now()
+
+	var changes []string
+
+	breaker := NewCircuitBreaker(BreakerSettings{
+		ConsecutiveFailures: 2,
+		OpenTimeout:         time.Minute,
+		OnStateChange: func(from, to BreakerState) {
+			changes = append(changes, from.String()+"->"+to.String())
+		},
+	})
+	breaker.now = func() time.Time { return now }
+
+	for _, success := range []bool{false, true, false, false} {
+		breaker.done(allowed(t, breaker), success)
+	}
+
+	assert.Equal(t, BreakerOpen, breaker.State())
+	assertRejected(t, breaker)
+
+	now = now.Add(time.Minute)
+
+	generation := allowed(t, breaker)
+	assertRejected(t, breaker)
+
+	breaker.done(generation, true)
+
+	assert.Equal(t, BreakerClosed, breaker.State())
+	assert.Equal(t, []string{"closed->open", "open->half-open", "half-open->closed"}, changes)
+
+}
+
+func TestCircuitBreaker_FailureRatio(t *testing.T) {
+
+	now := time.Now()
+
+	breaker := NewCircuitBreaker(BreakerSettings{
+		FailureRatio: 0.5,
+		MinRequests:  4,
+		Interval:     time.Minute,
+		OpenTimeout:  time.Minute,
+	})
+	breaker.now = func() time.Time { return now }
+
+	for _, success := range []bool{false, true, true} {
+		breaker.done(allowed(t, breaker), success)
+	}
+
+	// The counters are reset after the interval.
+	now = now.Add(time.Minute)
+
+	for _, success := range []bool{false, true, false} {
+		breaker.done(allowed(t, breaker), success)
+	}
+
+	assert.Equal(t, BreakerClosed, breaker.State())
+
+	breaker.done(allowed(t, breaker), true)
+
+	assert.Equal(t, BreakerOpen, breaker.State())
+
+	now = now.Add(time.Minute)
+
+	breaker.done(allowed(t, breaker), false)
+
+	assert.Equal(t, BreakerOpen, breaker.State())
+
+}
+
+func TestCircuitBreaker_Cancel(t *testing.T) {
+
+	now := time.Now()
+
+	breaker := NewCircuitBreaker(BreakerSettings{ConsecutiveFailures: 1, OpenTimeout: time.Minute})
+	breaker.now = func() time.Time { return now }
+
+	breaker.cancel(allowed(t, breaker))
+
+	assert.Equal(t, BreakerClosed, breaker.State())
+
+	breaker.done(allowed(t, breaker), false)
+
+	now = now.Add(time.Minute)
+
+	// A canceled trial lets another trial pass.
+	generation := allowed(t, breaker)
+	assertRejected(t, breaker)
+
+	breaker.cancel(generation)
+
+	allowed(t, breaker)
+	assert.Equal(t, BreakerHalfOpen, breaker.State())
+
+}
+
+func TestCircuitBreaker_StaleOutcome(t *testing.T) {
+
+	now := time.Now()
+
+	breaker := NewCircuitBreaker(BreakerSettings{ConsecutiveFailures: 1, OpenTimeout: time.Minute})
+	breaker.now = func() time.Time { return now }
+
+	// A slow request allowed while the breaker was closed.
+	slow := allowed(t, breaker)
+	canceled := allowed(t, breaker)
+
+	breaker.done(allowed(t, breaker), false)
+
+	now = now.Add(time.Minute)
+
+	trial := allowed(t, breaker)
+
+	// Its late success is no successful trial, and its cancellation frees
+	// no trial.
+	breaker.done(slow, true)
+	breaker.cancel(canceled)
+
+	assert.Equal(t, BreakerHalfOpen, breaker.State())
+	assertRejected(t, breaker)
+
+	breaker.done(trial, true)
+
+	assert.Equal(t, BreakerClosed, breaker.State())
+
+}
+
+// Check that the breaker lets a request pass and get its generation.
+func allowed(t *testing.T, breaker *CircuitBreaker) uint64 {
+
+	t.Helper()
+
+	generation, err := breaker.allow()
+
+	assert.Nil(t, err)
+
+	return generation
+
+}
+
+func assertRejected(t *testing.T, breaker *CircuitBreaker) {
+
+	t.Helper()
+
+	_, err := breaker.allow()
+
+	assert.Equal(t, ErrorCrowdUnavailable, err)
+
+}
@@ -0,0 +1,109 @@
+package crowd
+
+import (
+	"sync"
+	"time"
+)
+
+type ttlCacheEntry struct {
+	value   interface{}
+	expires time.Time
+}
+
+// Size of the cache at which expired entries are dropped for the first time.
+const minTTLCacheSweep = 64
+
+// A simple map based cache whose entries expire after a fixed duration. A
+// duration of zero or less disables the cache.
+type ttlCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[string]ttlCacheEntry
+	// Size of the cache at which expired entries are dropped next.
+	nextSweep int
+	now       func() time.Time
+}
+
+func newTTLCache(ttl time.Duration) *ttlCache {
+
+	return &ttlCache{
+		ttl:       ttl,
+		entries:   make(map[string]ttlCacheEntry),
+		nextSweep: minTTLCacheSweep,
+		now:       time.Now,
+	}
+
+}
+
+func (c *ttlCache) get(key string) (interface{}, bool) {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+
+	if !ok {
+		return nil, false
+	}
+
+	if !c.now().Before(entry.expires) {
+		delete(c.entries, key)
+		return nil, false
+	}
+
+	return entry.value, true
+
+}
+
+func (c *ttlCache) set(key string, value interface{}) {
+
+	if c.ttl <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+
+	// Drop expired entries whenever the cache doubled in size, so keys which
+	// are never requested again don't pile up. The cost of a sweep is spread
+	// over the entries added since the last one.
+	if len(c.entries) >= c.nextSweep {
+
+		for k, entry := range c.entries {
+			if !now.Before(entry.expires) {
+				delete(c.entries, k)
+			}
+		}
+
+		c.nextSweep = 2 * len(c.entries)
+
+		if c.nextSweep < minTTLCacheSweep {
+			c.nextSweep = minTTLCacheSweep
+		}
+
+	}
+
+	c.entries[key] = ttlCacheEntry{value: value, expires: now.Add(c.ttl)}
+
+}
+
+func (c *ttlCache) delete(key string) {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.entries, key)
+
+}
+
+func (c *ttlCache) clear() {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries = make(map[string]ttlCacheEntry)
+	c.nextSweep = minTTLCacheSweep
+
+}
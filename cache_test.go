@@ -0,0 +1,78 @@
+package crowd
+
+import (
+	"github.com/stretchr/testify/assert"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestTTLCache(t *testing.T) {
+
+	now := time.Now()
+
+	cache := newTTLCache(time.Minute)
+	cache.now = func() time.Time { return now }
+
+	cache.set("key", "value")
+
+	value, ok := cache.get("key")
+
+	assert.True(t, ok)
+	assert.Equal(t, "value", value)
+
+	now = now.Add(time.Minute)
+
+	_, ok = cache.get("key")
+
+	assert.False(t, ok)
+
+	cache.set("key", "value")
+	cache.delete("key")
+
+	_, ok = cache.get("key")
+
+	assert.False(t, ok)
+
+	cache.set("key", "value")
+	cache.clear()
+
+	_, ok = cache.get("key")
+
+	assert.False(t, ok)
+
+}
+
+func TestTTLCache_Disabled(t *testing.T) {
+
+	cache := newTTLCache(0)
+
+	cache.set("key", "value")
+
+	_, ok := cache.get("key")
+
+	assert.False(t, ok)
+	assert.Empty(t, cache.entries)
+
+}
+
+func TestTTLCache_Sweep(t *testing.T) {
+
+	now := time.Now()
+
+	cache := newTTLCache(time.Minute)
+	cache.now = func() time.Time { return now }
+
+	for i := 0; i < minTTLCacheSweep; i++ {
+		cache.set(strconv.Itoa(i), i)
+	}
+
+	now = now.Add(time.Minute)
+
+	// Expired entries are dropped once the cache doubled in size.
+	cache.set("key", "value")
+
+	assert.Len(t, cache.entries, 1)
+	assert.Equal(t, minTTLCacheSweep, cache.nextSweep)
+
+}
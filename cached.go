@@ -0,0 +1,554 @@
+package crowd
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Time to live of the different caches of a CachedAPI. A duration of zero
+// disables the respective cache.
+type CacheConfig struct {
+	UserTTL       time.Duration
+	AttributesTTL time.Duration
+	GroupTTL      time.Duration
+	MembershipTTL time.Duration
+	// How long a user that could not be found is remembered as missing.
+	NotFoundTTL time.Duration
+}
+
+// Hit and miss counters of a CachedAPI.
+type CacheStats struct {
+	Hits   uint64
+	Misses uint64
+}
+
+// Wraps an API with read-through caches for users, attributes, groups and
+// memberships. Mutating calls made through the CachedAPI invalidate the
+// affected entries, all other calls are passed on to the underlying API.
+// Cached values are shared between callers and must not be modified.
+type CachedAPI struct {
+	api *API
+
+	users       *ttlCache
+	notFound    *ttlCache
+	attributes  *ttlCache
+	groups      *ttlCache
+	memberships *ttlCache
+
+	stats *cacheCounters
+}
+
+// Shared by a CachedAPI and its copies made by WithContext.
+type cacheCounters struct {
+	hits   uint64
+	misses uint64
+}
+
+// Create a new cached API.
+func NewCachedAPI(api *API, config CacheConfig) *CachedAPI {
+
+	return &CachedAPI{
+		api:         api,
+		users:       newTTLCache(config.UserTTL),
+		notFound:    newTTLCache(config.NotFoundTTL),
+		attributes:  newTTLCache(config.AttributesTTL),
+		groups:      newTTLCache(config.GroupTTL),
+		memberships: newTTLCache(config.MembershipTTL),
+		stats:       &cacheCounters{},
+	}
+
+}
+
+// Get a copy of the cached API which uses the given context for its
+// requests. The copy shares the caches of c.
+func (c *CachedAPI) WithContext(ctx context.Context) *CachedAPI {
+
+	copied := *c
+	copied.api = c.api.WithContext(ctx)
+
+	return &copied
+
+}
+
+// Get the hit and miss counters of all caches.
+func (c *CachedAPI) Stats() CacheStats {
+
+	return CacheStats{
+		Hits:   atomic.LoadUint64(&c.stats.hits),
+		Misses: atomic.LoadUint64(&c.stats.misses),
+	}
+
+}
+
+// Evict all cached entries of a user.
+func (c *CachedAPI) InvalidateUser(userName string) {
+
+	key := cacheKey(userName)
+
+	c.users.delete(key)
+	c.notFound.delete(key)
+	c.attributes.delete(key)
+	c.memberships.delete(key)
+
+}
+
+// Evict a cached group. As the group may be nested in other groups, the
+// cached memberships of all users are evicted as well.
+func (c *CachedAPI) InvalidateGroup(groupName string) {
+
+	c.groups.delete(cacheKey(groupName))
+	c.memberships.clear()
+
+}
+
+// Evict all cached entries.
+func (c *CachedAPI) Flush() {
+
+	c.users.clear()
+	c.notFound.clear()
+	c.attributes.clear()
+	c.groups.clear()
+	c.memberships.clear()
+
+}
+
+// Keep the caches consistent with crowd by polling its event feed in the
+// given interval, evicting the entries named in each event. All entries are
+// evicted when the event token expires. Blocks until the context is done, the
+// event feed is not available for the application or the application has no
+// permission to read it. Other errors, for example while crowd cannot be
+// reached, are retried in the next interval.
+func (c *CachedAPI) WatchEvents(ctx context.Context, interval time.Duration) error {
+
+	// The requests end with the context too.
+	api := c.api.WithContext(ctx)
+
+	eventToken, err := api.GetEventToken()
+
+	switch {
+	case isWatchStopped(err):
+		return err
+	case err == nil:
+		// Entries cached before the token was issued may already be stale.
+		c.Flush()
+	default:
+		// Without a token, another one is requested in the next interval.
+		eventToken = ""
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+
+		if eventToken == "" {
+
+			eventToken, err = api.GetEventToken()
+
+			if isWatchStopped(err) {
+				return err
+			}
+
+			if err != nil {
+				continue
+			}
+
+			c.Flush()
+
+		}
+
+		events, err := api.GetEvents(eventToken)
+
+		switch {
+		case errors.Is(err, ErrorEventTokenExpired):
+			c.Flush()
+			eventToken = ""
+		case isWatchStopped(err):
+			return err
+		case err != nil:
+			// Try again with the same token, the events are not lost.
+		default:
+			c.applyEvents(events)
+			eventToken = events.NewEventToken
+		}
+
+	}
+
+}
+
+// Whether an error of the event feed will not go away by trying again.
+func isWatchStopped(err error) bool {
+	return errors.Is(err, ErrorEventsNotAvailable) || errors.Is(err, ErrorGeneralNoPermissions)
+}
+
+func (c *CachedAPI) applyEvents(events *Events) {
+
+	for _, event := range events.Events {
+
+		switch {
+		case event.User != nil:
+			c.InvalidateUser(event.User.Name)
+		case event.Group != nil && event.ChildGroups == nil && event.ParentGroups == nil:
+			c.InvalidateGroup(event.Group.Name)
+		case event.ChildUser != nil:
+			c.memberships.delete(cacheKey(event.ChildUser.Name))
+		default:
+			// A changed group membership affects the nested memberships of
+			// an unknown number of users.
+			c.memberships.clear()
+		}
+
+	}
+
+}
+
+// Get details of a crowd user.
+func (c *CachedAPI) GetUser(userName string) (*User, error) {
+
+	key := cacheKey(userName)
+
+	if _, ok := c.notFound.get(key); ok {
+		c.hit()
+		return nil, ErrorUserNotFound
+	}
+
+	if cached, ok := c.users.get(key); ok {
+		c.hit()
+		return cached.(*User), nil
+	}
+
+	c.miss()
+
+	user, err := c.api.GetUser(userName)
+
+	switch {
+	case err == ErrorUserNotFound:
+		c.notFound.set(key, true)
+	case err == nil:
+		c.users.set(key, user)
+	}
+
+	return user, err
+
+}
+
+// Get the attributes of a crowd user.
+func (c *CachedAPI) GetUserAttributes(userName string) (*Attributes, error) {
+
+	key := cacheKey(userName)
+
+	if _, ok := c.notFound.get(key); ok {
+		c.hit()
+		return nil, ErrorUserNotFound
+	}
+
+	if cached, ok := c.attributes.get(key); ok {
+		c.hit()
+		return cached.(*Attributes), nil
+	}
+
+	c.miss()
+
+	attributes, err := c.api.GetUserAttributes(userName)
+
+	switch {
+	case err == ErrorUserNotFound:
+		c.notFound.set(key, true)
+	case err == nil:
+		c.attributes.set(key, attributes)
+	}
+
+	return attributes, err
+
+}
+
+// Get the groups a crowd user is a direct or nested member of.
+func (c *CachedAPI) GetNestedGroupsForUser(userName string) (*Groups, error) {
+
+	key := cacheKey(userName)
+
+	if _, ok := c.notFound.get(key); ok {
+		c.hit()
+		return nil, ErrorUserNotFound
+	}
+
+	if cached, ok := c.memberships.get(key); ok {
+		c.hit()
+		return cached.(*Groups), nil
+	}
+
+	c.miss()
+
+	groups, err := c.api.GetNestedGroupsForUser(userName)
+
+	switch {
+	case err == ErrorUserNotFound:
+		c.notFound.set(key, true)
+	case err == nil:
+		c.memberships.set(key, groups)
+	}
+
+	return groups, err
+
+}
+
+// Get details of a group.
+func (c *CachedAPI) GetGroup(groupName string) (*Group, error) {
+
+	key := cacheKey(groupName)
+
+	if cached, ok := c.groups.get(key); ok {
+		c.hit()
+		return cached.(*Group), nil
+	}
+
+	c.miss()
+
+	group, err := c.api.GetGroup(groupName)
+
+	if err == nil {
+		c.groups.set(key, group)
+	}
+
+	return group, err
+
+}
+
+// Add a new crowd user.
+func (c *CachedAPI) AddUser(userName, userPassword, userFirstName, userLastName, userDisplayName, userEmail string, isActive bool) error {
+
+	defer c.InvalidateUser(userName)
+
+	return c.api.AddUser(userName, userPassword, userFirstName, userLastName, userDisplayName, userEmail, isActive)
+
+}
+
+// Remove a crowd user.
+func (c *CachedAPI) RemoveUser(userName string) error {
+
+	defer c.InvalidateUser(userName)
+
+	return c.api.RemoveUser(userName)
+
+}
+
+// Update details of a crowd user.
+func (c *CachedAPI) UpdateUser(userName, userFirstName, userLastName, userDisplayName, userEmail string, isActive bool) error {
+
+	defer c.InvalidateUser(userName)
+
+	return c.api.UpdateUser(userName, userFirstName, userLastName, userDisplayName, userEmail, isActive)
+
+}
+
+// Rename a crowd user.
+func (c *CachedAPI) RenameUser(userName, newUserName string) error {
+
+	defer c.InvalidateUser(userName)
+	defer c.InvalidateUser(newUserName)
+
+	return c.api.RenameUser(userName, newUserName)
+
+}
+
+// Store (new) attributes for a crowd user.
+func (c *CachedAPI) StoreUserAttributes(userName string, attributes *Attributes) error {
+
+	defer c.attributes.delete(cacheKey(userName))
+
+	return c.api.StoreUserAttributes(userName, attributes)
+
+}
+
+// Remove attributes from a crowd user.
+func (c *CachedAPI) RemoveUserAttribute(userName, attributeName string) error {
+
+	defer c.attributes.delete(cacheKey(userName))
+
+	return c.api.RemoveUserAttribute(userName, attributeName)
+
+}
+
+// Add a user to an existing group.
+func (c *CachedAPI) AddUserToGroup(userName, groupName string) error {
+
+	defer c.memberships.delete(cacheKey(userName))
+
+	return c.api.AddUserToGroup(userName, groupName)
+
+}
+
+// Remove a user from a group.
+func (c *CachedAPI) RemoveUserFromGroup(userName, groupName string) error {
+
+	defer c.memberships.delete(cacheKey(userName))
+
+	return c.api.RemoveUserFromGroup(userName, groupName)
+
+}
+
+// Create a new group.
+func (c *CachedAPI) CreateGroup(groupName, description string, isActive bool) error {
+
+	defer c.groups.delete(cacheKey(groupName))
+
+	return c.api.CreateGroup(groupName, description, isActive)
+
+}
+
+// Update the description and the active state of a group.
+func (c *CachedAPI) UpdateGroup(groupName, description string, isActive bool) error {
+
+	defer c.InvalidateGroup(groupName)
+
+	return c.api.UpdateGroup(groupName, description, isActive)
+
+}
+
+// Remove a group.
+func (c *CachedAPI) RemoveGroup(groupName string) error {
+
+	defer c.InvalidateGroup(groupName)
+
+	return c.api.RemoveGroup(groupName)
+
+}
+
+// Add a new child group membership.
+func (c *CachedAPI) AddChildGroupMembership(parentGroupName, childGroupName string) error {
+
+	defer c.memberships.clear()
+
+	return c.api.AddChildGroupMembership(parentGroupName, childGroupName)
+
+}
+
+// Remove a child group membership.
+func (c *CachedAPI) RemoveChildGroupMembership(parentGroupName, childGroupName string) error {
+
+	defer c.memberships.clear()
+
+	return c.api.RemoveChildGroupMembership(parentGroupName, childGroupName)
+
+}
+
+// Add a new parent group membership.
+func (c *CachedAPI) AddParentGroupMembership(parentGroupName, childGroupName string) error {
+
+	defer c.memberships.clear()
+
+	return c.api.AddParentGroupMembership(parentGroupName, childGroupName)
+
+}
+
+// Set the password of a crowd user.
+func (c *CachedAPI) SetUserPassword(userName, userPassword string) error {
+	return c.api.SetUserPassword(userName, userPassword)
+}
+
+// Authenticate a crowd user.
+func (c *CachedAPI) AuthenticateUser(userName, userPassword string) error {
+	return c.api.AuthenticateUser(userName, userPassword)
+}
+
+// Get the attributes of a group.
+func (c *CachedAPI) GetGroupAttributes(groupName string) (*Attributes, error) {
+	return c.api.GetGroupAttributes(groupName)
+}
+
+// Store (new) attributes for a group.
+func (c *CachedAPI) StoreGroupAttributes(groupName string, attributes *Attributes) error {
+	return c.api.StoreGroupAttributes(groupName, attributes)
+}
+
+// Remove attributes from a group.
+func (c *CachedAPI) RemoveGroupAttribute(groupName, attributeName string) error {
+	return c.api.RemoveGroupAttribute(groupName, attributeName)
+}
+
+// Get the direct members of a group.
+func (c *CachedAPI) GetGroupMembers(groupName string) (*Users, error) {
+	return c.api.GetGroupMembers(groupName)
+}
+
+// Get the direct and nested members of a group.
+func (c *CachedAPI) GetNestedGroupMembers(groupName string) (*Users, error) {
+	return c.api.GetNestedGroupMembers(groupName)
+}
+
+// Get the direct child groups of a group.
+func (c *CachedAPI) GetChildGroups(groupName string) (*Groups, error) {
+	return c.api.GetChildGroups(groupName)
+}
+
+// Get the groups a crowd user is a direct member of.
+func (c *CachedAPI) GetDirectGroupsForUser(userName string) (*Groups, error) {
+	return c.api.GetDirectGroupsForUser(userName)
+}
+
+// Create a new session for a crowd user.
+func (c *CachedAPI) CreateSession(userName, userPassword string, validationFactors *ValidationFactors) (*Session, error) {
+	return c.api.CreateSession(userName, userPassword, validationFactors)
+}
+
+// Get a session.
+func (c *CachedAPI) GetSession(token string) (*Session, error) {
+	return c.api.GetSession(token)
+}
+
+// Validate a session.
+func (c *CachedAPI) ValidateSession(token string, validationFactors *ValidationFactors) (*Session, error) {
+	return c.api.ValidateSession(token, validationFactors)
+}
+
+// Invalidate a session.
+func (c *CachedAPI) InvalidateSession(token string) error {
+	return c.api.InvalidateSession(token)
+}
+
+// Get the cookie configuration of crowd.
+func (c *CachedAPI) GetCookieConfig() (*CookieConfig, error) {
+	return c.api.GetCookieConfig()
+}
+
+// Search for users.
+func (c *CachedAPI) SearchUsers(restriction string, startIndex, maxResults int) (*Users, error) {
+	return c.api.SearchUsers(restriction, startIndex, maxResults)
+}
+
+// Search for groups.
+func (c *CachedAPI) SearchGroups(restriction string, startIndex, maxResults int) (*Groups, error) {
+	return c.api.SearchGroups(restriction, startIndex, maxResults)
+}
+
+// Get the current event token.
+func (c *CachedAPI) GetEventToken() (string, error) {
+	return c.api.GetEventToken()
+}
+
+// Get the events since the given event token.
+func (c *CachedAPI) GetEvents(eventToken string) (*Events, error) {
+	return c.api.GetEvents(eventToken)
+}
+
+func (c *CachedAPI) hit() {
+	atomic.AddUint64(&c.stats.hits, 1)
+}
+
+func (c *CachedAPI) miss() {
+	atomic.AddUint64(&c.stats.misses, 1)
+}
+
+// Crowd names are case insensitive.
+func cacheKey(name string) string {
+	return strings.ToLower(name)
+}
@@ -0,0 +1,392 @@
+package crowd
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/valyala/fasthttp"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCachedAPI_GetUser(t *testing.T) {
+
+	requests := map[string]int{}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		requests[r.Method+" "+r.RequestURI]++
+
+		switch {
+		case r.Method == "GET" && r.URL.Query().Get("username") == "testuser":
+			respBytes, err := json.Marshal(User{Name: "testuser"})
+
+			assert.Nil(t, err)
+
+			w.WriteHeader(http.StatusOK)
+			w.Write(respBytes)
+		case r.Method == "GET":
+			w.WriteHeader(http.StatusNotFound)
+		case r.Method == "PUT":
+			w.WriteHeader(http.StatusNoContent)
+		}
+
+	}))
+	defer server.Close()
+
+	api, err := NewAPI(server.URL, "testapp", "password")
+
+	assert.Nil(t, err)
+
+	cached := NewCachedAPI(api, CacheConfig{UserTTL: time.Minute, NotFoundTTL: time.Minute})
+
+	for _, userName := range []string{"testuser", "TestUser", "TESTUSER"} {
+
+		user, err := cached.GetUser(userName)
+
+		assert.Nil(t, err)
+		assert.Equal(t, &User{Name: "testuser"}, user)
+
+		_, err = cached.GetUser("missinguser")
+
+		assert.Equal(t, ErrorUserNotFound, err)
+
+	}
+
+	assert.Equal(t, 1, requests["GET /rest/usermanagement/1/user?username=testuser"])
+	assert.Equal(t, 1, requests["GET /rest/usermanagement/1/user?username=missinguser"])
+	assert.Equal(t, CacheStats{Hits: 4, Misses: 2}, cached.Stats())
+
+	err = cached.UpdateUser("testuser", "Test", "", "", "", true)
+
+	assert.Nil(t, err)
+
+	_, err = cached.GetUser("testuser")
+
+	assert.Nil(t, err)
+	assert.Equal(t, 3, requests["GET /rest/usermanagement/1/user?username=testuser"])
+	assert.Equal(t, CacheStats{Hits: 4, Misses: 3}, cached.Stats())
+
+}
+
+func TestCachedAPI_GetNestedGroupsForUser(t *testing.T) {
+
+	lookups := 0
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		switch r.URL.Path {
+		case "/rest/usermanagement/1/user/group/nested":
+			lookups++
+
+			respBytes, err := json.Marshal(Groups{Groups: []*Group{{Name: "testgroup"}}})
+
+			assert.Nil(t, err)
+
+			w.WriteHeader(http.StatusOK)
+			w.Write(respBytes)
+		case "/rest/usermanagement/1/user/group/direct":
+			w.WriteHeader(http.StatusCreated)
+		default:
+			http.Error(w, "not found", http.StatusNotFound)
+		}
+
+	}))
+	defer server.Close()
+
+	api, err := NewAPI(server.URL, "testapp", "password")
+
+	assert.Nil(t, err)
+
+	cached := NewCachedAPI(api, CacheConfig{MembershipTTL: time.Minute})
+
+	_, err = cached.GetNestedGroupsForUser("testuser")
+
+	assert.Nil(t, err)
+
+	groups, err := cached.GetNestedGroupsForUser("testuser")
+
+	assert.Nil(t, err)
+	assert.Equal(t, &Groups{Groups: []*Group{{Name: "testgroup"}}}, groups)
+	assert.Equal(t, 1, lookups)
+
+	err = cached.AddUserToGroup("testuser", "othergroup")
+
+	assert.Nil(t, err)
+
+	_, err = cached.GetNestedGroupsForUser("testuser")
+
+	assert.Nil(t, err)
+	assert.Equal(t, 2, lookups)
+
+}
+
+func TestCachedAPI_WatchEvents(t *testing.T) {
+
+	expired := make(chan struct{})
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		assert.Equal(t, "GET", r.Method)
+
+		var resp Events
+
+		switch r.URL.Path {
+		case "/rest/usermanagement/1/event":
+			resp = Events{NewEventToken: "token1"}
+		case "/rest/usermanagement/1/event/token1":
+			resp = Events{NewEventToken: "token2", Events: []*Event{{Operation: "UPDATED", User: &User{Name: "testuser"}}}}
+		case "/rest/usermanagement/1/event/token2":
+			w.WriteHeader(http.StatusBadRequest)
+			select {
+			case expired <- struct{}{}:
+			default:
+			}
+			return
+		}
+
+		respBytes, err := json.Marshal(resp)
+
+		assert.Nil(t, err)
+
+		w.WriteHeader(http.StatusOK)
+		w.Write(respBytes)
+
+	}))
+	defer server.Close()
+
+	api, err := NewAPI(server.URL, "testapp", "password")
+
+	assert.Nil(t, err)
+
+	type watchKey struct{}
+
+	// The event requests are made with the context of the watch.
+	api.Use(func(next RoundTrip) RoundTrip {
+		return func(ctx context.Context, request *fasthttp.Request, response *fasthttp.Response) error {
+			assert.Equal(t, true, ctx.Value(watchKey{}))
+			return next(ctx, request, response)
+		}
+	})
+
+	cached := NewCachedAPI(api, CacheConfig{UserTTL: time.Hour, GroupTTL: time.Hour})
+
+	ctx, cancel := context.WithCancel(context.WithValue(context.Background(), watchKey{}, true))
+	done := make(chan error)
+
+	go func() {
+		done <- cached.WatchEvents(ctx, time.Millisecond)
+	}()
+
+	<-expired
+
+	cancel()
+
+	assert.Equal(t, context.Canceled, <-done)
+
+}
+
+func TestCachedAPI_applyEvents(t *testing.T) {
+
+	cached := NewCachedAPI(nil, CacheConfig{UserTTL: time.Hour, GroupTTL: time.Hour, MembershipTTL: time.Hour})
+
+	cached.users.set("testuser", &User{Name: "testuser"})
+	cached.users.set("otheruser", &User{Name: "otheruser"})
+	cached.groups.set("testgroup", &Group{Name: "testgroup"})
+	cached.memberships.set("testuser", &Groups{})
+	cached.memberships.set("otheruser", &Groups{})
+
+	cached.applyEvents(&Events{Events: []*Event{
+		{Operation: "UPDATED", User: &User{Name: "TestUser"}},
+		{Operation: "CREATED", ChildUser: &User{Name: "otheruser"}, ParentGroups: &Groups{}},
+	}})
+
+	_, ok := cached.users.get("testuser")
+	assert.False(t, ok)
+
+	_, ok = cached.users.get("otheruser")
+	assert.True(t, ok)
+
+	_, ok = cached.memberships.get("otheruser")
+	assert.False(t, ok)
+
+	_, ok = cached.groups.get("testgroup")
+	assert.True(t, ok)
+
+	cached.applyEvents(&Events{Events: []*Event{
+		{Operation: "DELETED", Group: &Group{Name: "testgroup"}},
+	}})
+
+	_, ok = cached.groups.get("testgroup")
+	assert.False(t, ok)
+
+}
+
+func TestCachedAPI_WithContext(t *testing.T) {
+
+	lookups := 0
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		switch r.Method {
+		case "GET":
+			lookups++
+
+			respBytes, err := json.Marshal(User{Name: "testuser"})
+
+			assert.Nil(t, err)
+
+			w.WriteHeader(http.StatusOK)
+			w.Write(respBytes)
+		case "PUT":
+			w.WriteHeader(http.StatusNoContent)
+		}
+
+	}))
+	defer server.Close()
+
+	api, err := NewAPI(server.URL, "testapp", "password")
+
+	assert.Nil(t, err)
+
+	cached := NewCachedAPI(api, CacheConfig{UserTTL: time.Minute})
+
+	_, err = cached.GetUser("testuser")
+
+	assert.Nil(t, err)
+
+	// The copy shares the caches.
+	withContext := cached.WithContext(context.Background())
+
+	_, err = withContext.GetUser("testuser")
+
+	assert.Nil(t, err)
+	assert.Equal(t, 1, lookups)
+
+	err = withContext.UpdateUser("testuser", "Test", "", "", "", true)
+
+	assert.Nil(t, err)
+
+	_, err = cached.GetUser("testuser")
+
+	// UpdateUser looks the user up itself.
+	assert.Nil(t, err)
+	assert.Equal(t, 3, lookups)
+	assert.Equal(t, CacheStats{Hits: 1, Misses: 2}, cached.Stats())
+
+}
+
+func TestCachedAPI_WatchEventsNotAvailable(t *testing.T) {
+
+	tokens := 0
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		switch r.URL.Path {
+		case "/rest/usermanagement/1/event":
+
+			tokens++
+
+			// The feed is turned off after the watch started.
+			if tokens > 1 {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+
+			respBytes, err := json.Marshal(Events{NewEventToken: "token1"})
+
+			assert.Nil(t, err)
+
+			w.WriteHeader(http.StatusOK)
+			w.Write(respBytes)
+
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+		}
+
+	}))
+	defer server.Close()
+
+	api, err := NewAPI(server.URL, "testapp", "password")
+
+	assert.Nil(t, err)
+
+	cached := NewCachedAPI(api, CacheConfig{UserTTL: time.Hour})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	err = cached.WatchEvents(ctx, time.Millisecond)
+
+	assert.Equal(t, ErrorEventsNotAvailable, err)
+	assert.Equal(t, 2, tokens)
+
+}
+
+func TestCachedAPI_WatchEventsRetry(t *testing.T) {
+
+	tokens := 0
+	polled := make(chan struct{})
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		switch r.URL.Path {
+		case "/rest/usermanagement/1/event":
+
+			tokens++
+
+			// Crowd is briefly unavailable when the watch starts.
+			if tokens == 1 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+
+			respBytes, err := json.Marshal(Events{NewEventToken: "token1"})
+
+			assert.Nil(t, err)
+
+			w.WriteHeader(http.StatusOK)
+			w.Write(respBytes)
+
+		case "/rest/usermanagement/1/event/token1":
+
+			select {
+			case polled <- struct{}{}:
+			default:
+			}
+
+			respBytes, err := json.Marshal(Events{NewEventToken: "token1"})
+
+			assert.Nil(t, err)
+
+			w.WriteHeader(http.StatusOK)
+			w.Write(respBytes)
+
+		}
+
+	}))
+	defer server.Close()
+
+	api, err := NewAPI(server.URL, "testapp", "password")
+
+	assert.Nil(t, err)
+
+	cached := NewCachedAPI(api, CacheConfig{UserTTL: time.Hour})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+
+	go func() {
+		done <- cached.WatchEvents(ctx, time.Millisecond)
+	}()
+
+	<-polled
+
+	cancel()
+
+	assert.Equal(t, context.Canceled, <-done)
+	assert.Equal(t, 2, tokens)
+
+}
@@ -0,0 +1,64 @@
+package crowd
+
+// The crowd operations of an API. Code which depends on Client instead of
+// *API can be tested without a crowd server, for example with the fake in
+// the crowdmock package.
+//
+// Implementations return the same errors as the API, for example
+// ErrorUserNotFound if a user does not exist.
+type Client interface {
+	// Users
+	GetUser(userName string) (*User, error)
+	AddUser(userName, userPassword, userFirstName, userLastName, userDisplayName, userEmail string, isActive bool) error
+	RemoveUser(userName string) error
+	UpdateUser(userName, userFirstName, userLastName, userDisplayName, userEmail string, isActive bool) error
+	RenameUser(userName, newUserName string) error
+	SetUserPassword(userName, userPassword string) error
+	AuthenticateUser(userName, userPassword string) error
+
+	// User attributes
+	GetUserAttributes(userName string) (*Attributes, error)
+	StoreUserAttributes(userName string, attributes *Attributes) error
+	RemoveUserAttribute(userName, attributeName string) error
+
+	// Groups
+	GetGroup(groupName string) (*Group, error)
+	GetGroupAttributes(groupName string) (*Attributes, error)
+	StoreGroupAttributes(groupName string, attributes *Attributes) error
+	RemoveGroupAttribute(groupName, attributeName string) error
+	CreateGroup(groupName, description string, isActive bool) error
+	UpdateGroup(groupName, description string, isActive bool) error
+	RemoveGroup(groupName string) error
+	GetGroupMembers(groupName string) (*Users, error)
+	GetNestedGroupMembers(groupName string) (*Users, error)
+	GetChildGroups(groupName string) (*Groups, error)
+
+	// Memberships
+	GetDirectGroupsForUser(userName string) (*Groups, error)
+	GetNestedGroupsForUser(userName string) (*Groups, error)
+	AddUserToGroup(userName, groupName string) error
+	RemoveUserFromGroup(userName, groupName string) error
+	AddChildGroupMembership(parentGroupName, childGroupName string) error
+	RemoveChildGroupMembership(parentGroupName, childGroupName string) error
+	AddParentGroupMembership(parentGroupName, childGroupName string) error
+
+	// Sessions
+	CreateSession(userName, userPassword string, validationFactors *ValidationFactors) (*Session, error)
+	GetSession(token string) (*Session, error)
+	ValidateSession(token string, validationFactors *ValidationFactors) (*Session, error)
+	InvalidateSession(token string) error
+	GetCookieConfig() (*CookieConfig, error)
+
+	// Search
+	SearchUsers(restriction string, startIndex, maxResults int) (*Users, error)
+	SearchGroups(restriction string, startIndex, maxResults int) (*Groups, error)
+
+	// Events
+	GetEventToken() (string, error)
+	GetEvents(eventToken string) (*Events, error)
+}
+
+var (
+	_ Client = (*API)(nil)
+	_ Client = (*CachedAPI)(nil)
+)
@@ -0,0 +1,1143 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"github.com/agile-rcm/crowd-go"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+type command struct {
+	path    string
+	args    string
+	summary string
+	run     func(c *cli, command *command, args []string) error
+}
+
+var commands = []*command{
+	{"user get", "[flags] <user>", "Show a user", userGet},
+	{"user add", "[flags] <user>", "Add a user, the password is read from stdin unless set", userAdd},
+	{"user update", "[flags] <user>", "Update the details of a user", userUpdate},
+	{"user remove", "<user>", "Remove a user", userRemove},
+	{"user rename", "<user> <new name>", "Rename a user", userRename},
+	{"user passwd", "[flags] <user>", "Set the password of a user, read from stdin unless set", userPasswd},
+	{"user import", "[flags] <file.csv>", "Import users from a CSV file, - for stdin, and write a report", userImport},
+	{"user groups", "[flags] <user>", "List the groups of a user, including nested groups", userGroups},
+	{"user attr get", "[flags] <user>", "Show the attributes of a user", userAttrGet},
+	{"user attr set", "<user> <attribute> <value>...", "Set the values of a user attribute", userAttrSet},
+	{"user attr rm", "<user> <attribute>", "Remove a user attribute", userAttrRm},
+	{"group create", "[flags] <group>", "Create a group", groupCreate},
+	{"group rm", "<group>", "Remove a group", groupRm},
+	{"group get", "[flags] <group>", "Show a group", groupGet},
+	{"group members", "[flags] <group>", "List the members of a group", groupMembers},
+	{"group tree", "[flags] [group]", "Show the groups nested in a group, or all groups", groupTree},
+	{"membership add", "<user> <group>", "Add a user to a group", membershipAdd},
+	{"membership rm", "<user> <group>", "Remove a user from a group", membershipRm},
+	{"session validate", "[flags] <token>", "Validate a single sign-on session", sessionValidate},
+	{"search", "[flags] [restriction]", "Search users or groups with a crowd query language restriction", search},
+	{"export", "[flags]", "Export all users and groups with their attributes and direct memberships", export},
+	{"diff", "--from <profile> [flags]", "Compare users, attributes, groups and memberships of two servers, exit with 6 if they differ", diff},
+	{"sync", "--from <profile> [flags]", "Copy users, attributes, groups and memberships missing in one server from another", syncDirectories},
+	{"reconcile plan", "[flags] <state.yaml>", "Show the changes which bring the groups to a desired state, - for stdin", reconcilePlan},
+	{"reconcile apply", "[flags] <state.yaml>", "Show and apply the changes which bring the groups to a desired state", reconcileApply},
+}
+
+// Parse the flags of a command and check the number of remaining arguments.
+// A maximum of -1 allows any number of arguments.
+func (c *cli) parse(flags *flag.FlagSet, args []string, min, max int) ([]string, error) {
+
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+
+	args = flags.Args()
+
+	if len(args) < min || (max >= 0 && len(args) > max) {
+		return nil, usagef("wrong number of arguments")
+	}
+
+	return args, nil
+
+}
+
+// Parse the arguments of a command without flags.
+func (c *cli) parseArgs(command *command, args []string, count int) ([]string, error) {
+	return c.parse(c.flagSet(command), args, count, count)
+}
+
+// Parse the flags and arguments of a read command, with its output format.
+func (c *cli) parseRead(flags *flag.FlagSet, output *string, args []string, min, max int) ([]string, *format, error) {
+
+	args, err := c.parse(flags, args, min, max)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	format, err := parseFormat(*output)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return args, format, nil
+
+}
+
+// Get a secret from a flag value, or else the first line of stdin.
+func (c *cli) secret(value string) (string, error) {
+
+	if value != "" {
+		return value, nil
+	}
+
+	scanner := bufio.NewScanner(c.stdin)
+
+	if !scanner.Scan() {
+
+		if err := scanner.Err(); err != nil {
+			return "", err
+		}
+
+		return "", usagef("no password given")
+
+	}
+
+	return strings.TrimRight(scanner.Text(), "\r"), nil
+
+}
+
+// Users
+
+func userGet(c *cli, command *command, args []string) error {
+
+	flags := c.flagSet(command)
+	output := outputFlag(flags)
+
+	args, format, err := c.parseRead(flags, output, args, 1, 1)
+
+	if err != nil {
+		return err
+	}
+
+	api, err := c.api()
+
+	if err != nil {
+		return err
+	}
+
+	user, err := api.GetUser(args[0])
+
+	if err != nil {
+		return err
+	}
+
+	return c.render(format, userView(user))
+
+}
+
+func userAdd(c *cli, command *command, args []string) error {
+
+	flags := c.flagSet(command)
+
+	password := flags.String("password", "", "`password` of the user")
+	firstName := flags.String("first-name", "", "first `name`")
+	lastName := flags.String("last-name", "", "last `name`")
+	displayName := flags.String("display-name", "", "display `name`, defaults to first and last name")
+	email := flags.String("email", "", "email `address`")
+	inactive := flags.Bool("inactive", false, "add the user as inactive")
+
+	args, err := c.parse(flags, args, 1, 1)
+
+	if err != nil {
+		return err
+	}
+
+	secret, err := c.secret(*password)
+
+	if err != nil {
+		return err
+	}
+
+	api, err := c.api()
+
+	if err != nil {
+		return err
+	}
+
+	return api.AddUser(args[0], secret, *firstName, *lastName, *displayName, *email, !*inactive)
+
+}
+
+func userUpdate(c *cli, command *command, args []string) error {
+
+	flags := c.flagSet(command)
+
+	firstName := flags.String("first-name", "", "first `name`")
+	lastName := flags.String("last-name", "", "last `name`")
+	displayName := flags.String("display-name", "", "display `name`")
+	email := flags.String("email", "", "email `address`")
+	active := flags.Bool("active", true, "whether the user is active, unchanged if not set")
+
+	args, err := c.parse(flags, args, 1, 1)
+
+	if err != nil {
+		return err
+	}
+
+	api, err := c.api()
+
+	if err != nil {
+		return err
+	}
+
+	isActive := *active
+
+	if !isSet(flags, "active") {
+
+		user, err := api.GetUser(args[0])
+
+		if err != nil {
+			return err
+		}
+
+		isActive = user.IsActive
+
+	}
+
+	return api.UpdateUser(args[0], *firstName, *lastName, *displayName, *email, isActive)
+
+}
+
+func userRemove(c *cli, command *command, args []string) error {
+
+	args, err := c.parseArgs(command, args, 1)
+
+	if err != nil {
+		return err
+	}
+
+	api, err := c.api()
+
+	if err != nil {
+		return err
+	}
+
+	return api.RemoveUser(args[0])
+
+}
+
+func userRename(c *cli, command *command, args []string) error {
+
+	args, err := c.parseArgs(command, args, 2)
+
+	if err != nil {
+		return err
+	}
+
+	api, err := c.api()
+
+	if err != nil {
+		return err
+	}
+
+	return api.RenameUser(args[0], args[1])
+
+}
+
+func userPasswd(c *cli, command *command, args []string) error {
+
+	flags := c.flagSet(command)
+
+	password := flags.String("password", "", "new `password`")
+
+	args, err := c.parse(flags, args, 1, 1)
+
+	if err != nil {
+		return err
+	}
+
+	secret, err := c.secret(*password)
+
+	if err != nil {
+		return err
+	}
+
+	api, err := c.api()
+
+	if err != nil {
+		return err
+	}
+
+	return api.SetUserPassword(args[0], secret)
+
+}
+
+func userGroups(c *cli, command *command, args []string) error {
+
+	flags := c.flagSet(command)
+	output := outputFlag(flags)
+
+	args, format, err := c.parseRead(flags, output, args, 1, 1)
+
+	if err != nil {
+		return err
+	}
+
+	api, err := c.api()
+
+	if err != nil {
+		return err
+	}
+
+	groups, err := api.GetNestedGroupsForUser(args[0])
+
+	if err != nil {
+		return err
+	}
+
+	return c.render(format, groupsView(groups.Groups))
+
+}
+
+func userImport(c *cli, command *command, args []string) error {
+
+	flags := c.flagSet(command)
+
+	mapping := crowd.DefaultImportMapping()
+	options := &crowd.ImportOptions{}
+
+	update := flags.Bool("update", false, "update existing users instead of skipping them")
+	reportPath := flags.String("report", "", "write the report to `file` instead of stdout")
+	flags.BoolVar(&options.DryRun, "dry-run", false, "check the file without changing anything")
+	flags.BoolVar(&options.GeneratePasswords, "generate-passwords", false, "give new users without a password a random one")
+	flags.IntVar(&options.Workers, "workers", 4, "`number` of users imported concurrently")
+	flags.StringVar(&mapping.Separator, "separator", mapping.Separator, "`separator` of groups and attribute values in a cell")
+	flags.Var(&mappingFlag{mapping}, "map", "read a `field=column` from another column, fields are name, first-name, last-name,\ndisplay-name, email, password, active, groups and attribute:<name>")
+
+	args, err := c.parse(flags, args, 1, 1)
+
+	if err != nil {
+		return err
+	}
+
+	if *update {
+		options.Existing = crowd.UpdateExistingUsers
+	}
+
+	input := c.stdin
+
+	if args[0] != "-" {
+
+		file, err := os.Open(args[0])
+
+		if err != nil {
+			return err
+		}
+
+		defer file.Close()
+
+		input = file
+
+	}
+
+	output := c.stdout
+
+	if *reportPath != "" {
+
+		file, err := os.Create(*reportPath)
+
+		if err != nil {
+			return err
+		}
+
+		defer file.Close()
+
+		output = file
+
+	}
+
+	api, err := c.api()
+
+	if err != nil {
+		return err
+	}
+
+	report, err := crowd.ImportUsers(api, input, mapping, options)
+
+	if writeErr := report.WriteCSV(output); err == nil {
+		err = writeErr
+	}
+
+	if err != nil {
+		return err
+	}
+
+	prefix := ""
+
+	if options.DryRun {
+		prefix = "dry run: "
+	}
+
+	fmt.Fprintf(c.stderr, "%s%d created, %d updated, %d skipped, %d failed\n", prefix,
+		report.Count(crowd.ImportCreated), report.Count(crowd.ImportUpdated), report.Count(crowd.ImportSkipped), report.Failed())
+
+	if failed := report.Failed(); failed > 0 {
+		return fmt.Errorf("%d of %d rows failed", failed, len(report.Results))
+	}
+
+	return nil
+
+}
+
+// User attributes
+
+func userAttrGet(c *cli, command *command, args []string) error {
+
+	flags := c.flagSet(command)
+	output := outputFlag(flags)
+
+	args, format, err := c.parseRead(flags, output, args, 1, 1)
+
+	if err != nil {
+		return err
+	}
+
+	api, err := c.api()
+
+	if err != nil {
+		return err
+	}
+
+	attributes, err := api.GetUserAttributes(args[0])
+
+	if err != nil {
+		return err
+	}
+
+	return c.render(format, attributesView(attributes))
+
+}
+
+func userAttrSet(c *cli, command *command, args []string) error {
+
+	args, err := c.parse(c.flagSet(command), args, 3, -1)
+
+	if err != nil {
+		return err
+	}
+
+	api, err := c.api()
+
+	if err != nil {
+		return err
+	}
+
+	attributes := &crowd.Attributes{Attributes: []*crowd.Attribute{{Name: args[1], Values: args[2:]}}}
+
+	return api.StoreUserAttributes(args[0], attributes)
+
+}
+
+func userAttrRm(c *cli, command *command, args []string) error {
+
+	args, err := c.parseArgs(command, args, 2)
+
+	if err != nil {
+		return err
+	}
+
+	api, err := c.api()
+
+	if err != nil {
+		return err
+	}
+
+	return api.RemoveUserAttribute(args[0], args[1])
+
+}
+
+// Groups
+
+func groupCreate(c *cli, command *command, args []string) error {
+
+	flags := c.flagSet(command)
+
+	description := flags.String("description", "", "`description` of the group")
+	inactive := flags.Bool("inactive", false, "create the group as inactive")
+
+	args, err := c.parse(flags, args, 1, 1)
+
+	if err != nil {
+		return err
+	}
+
+	api, err := c.api()
+
+	if err != nil {
+		return err
+	}
+
+	return api.CreateGroup(args[0], *description, !*inactive)
+
+}
+
+func groupRm(c *cli, command *command, args []string) error {
+
+	args, err := c.parseArgs(command, args, 1)
+
+	if err != nil {
+		return err
+	}
+
+	api, err := c.api()
+
+	if err != nil {
+		return err
+	}
+
+	return api.RemoveGroup(args[0])
+
+}
+
+func groupGet(c *cli, command *command, args []string) error {
+
+	flags := c.flagSet(command)
+	output := outputFlag(flags)
+
+	args, format, err := c.parseRead(flags, output, args, 1, 1)
+
+	if err != nil {
+		return err
+	}
+
+	api, err := c.api()
+
+	if err != nil {
+		return err
+	}
+
+	group, err := api.GetGroup(args[0])
+
+	if err != nil {
+		return err
+	}
+
+	return c.render(format, groupView(group))
+
+}
+
+func groupMembers(c *cli, command *command, args []string) error {
+
+	flags := c.flagSet(command)
+
+	nested := flags.Bool("nested", false, "include the members of nested groups")
+	output := outputFlag(flags)
+
+	args, format, err := c.parseRead(flags, output, args, 1, 1)
+
+	if err != nil {
+		return err
+	}
+
+	api, err := c.api()
+
+	if err != nil {
+		return err
+	}
+
+	var users *crowd.Users
+
+	if *nested {
+		users, err = api.GetNestedGroupMembers(args[0])
+	} else {
+		users, err = api.GetGroupMembers(args[0])
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return c.render(format, usersView(users.Users))
+
+}
+
+func groupTree(c *cli, command *command, args []string) error {
+
+	flags := c.flagSet(command)
+
+	dot := flags.Bool("dot", false, "write a Graphviz DOT graph instead of a text tree")
+	members := flags.Bool("members", false, "label the groups of the DOT graph with their number of direct members")
+
+	args, err := c.parse(flags, args, 0, 1)
+
+	if err != nil {
+		return err
+	}
+
+	if len(args) == 0 && !*dot {
+		return usagef("the text tree needs a group, use --dot for all groups")
+	}
+
+	if *members && !*dot {
+		return usagef("--members is only used with --dot")
+	}
+
+	api, err := c.api()
+
+	if err != nil {
+		return err
+	}
+
+	graph, err := crowd.BuildGroupGraph(api, &crowd.GroupGraphOptions{Roots: args, Members: *members})
+
+	if err != nil {
+		return err
+	}
+
+	if *dot {
+		return graph.WriteDOT(c.stdout)
+	}
+
+	return graph.WriteTree(c.stdout, args[0])
+
+}
+
+// Memberships
+
+func membershipAdd(c *cli, command *command, args []string) error {
+
+	args, err := c.parseArgs(command, args, 2)
+
+	if err != nil {
+		return err
+	}
+
+	api, err := c.api()
+
+	if err != nil {
+		return err
+	}
+
+	return api.AddUserToGroup(args[0], args[1])
+
+}
+
+func membershipRm(c *cli, command *command, args []string) error {
+
+	args, err := c.parseArgs(command, args, 2)
+
+	if err != nil {
+		return err
+	}
+
+	api, err := c.api()
+
+	if err != nil {
+		return err
+	}
+
+	return api.RemoveUserFromGroup(args[0], args[1])
+
+}
+
+// Sessions
+
+func sessionValidate(c *cli, command *command, args []string) error {
+
+	flags := c.flagSet(command)
+
+	remoteAddress := flags.String("remote-address", "", "remote `address` the session is bound to")
+	output := outputFlag(flags)
+
+	args, format, err := c.parseRead(flags, output, args, 1, 1)
+
+	if err != nil {
+		return err
+	}
+
+	api, err := c.api()
+
+	if err != nil {
+		return err
+	}
+
+	factors := &crowd.ValidationFactors{ValidationFactors: []*crowd.ValidationFactor{}}
+
+	if *remoteAddress != "" {
+		factors.ValidationFactors = append(factors.ValidationFactors, &crowd.ValidationFactor{Name: "remote_address", Value: *remoteAddress})
+	}
+
+	session, err := api.ValidateSession(args[0], factors)
+
+	if err != nil {
+		return err
+	}
+
+	return c.render(format, sessionView(session))
+
+}
+
+// Search
+
+func search(c *cli, command *command, args []string) error {
+
+	flags := c.flagSet(command)
+
+	groups := flags.Bool("groups", false, "search groups instead of users")
+	startIndex := flags.Int("start", 0, "`index` of the first result")
+	maxResults := flags.Int("max", 100, "maximum `number` of results")
+	output := outputFlag(flags)
+
+	args, format, err := c.parseRead(flags, output, args, 0, -1)
+
+	if err != nil {
+		return err
+	}
+
+	api, err := c.api()
+
+	if err != nil {
+		return err
+	}
+
+	restriction := strings.Join(args, " ")
+
+	if *groups {
+
+		result, err := api.SearchGroups(restriction, *startIndex, *maxResults)
+
+		if err != nil {
+			return err
+		}
+
+		return c.render(format, groupsView(result.Groups))
+
+	}
+
+	result, err := api.SearchUsers(restriction, *startIndex, *maxResults)
+
+	if err != nil {
+		return err
+	}
+
+	return c.render(format, usersView(result.Users))
+
+}
+
+// A flag mapping an import field to a column.
+type mappingFlag struct {
+	mapping *crowd.ImportMapping
+}
+
+func (f *mappingFlag) String() string {
+	return ""
+}
+
+func (f *mappingFlag) Set(value string) error {
+
+	i := strings.Index(value, "=")
+
+	if i < 0 {
+		return fmt.Errorf("expected field=column")
+	}
+
+	field, column := value[:i], value[i+1:]
+
+	fields := map[string]*string{
+		"name":         &f.mapping.Name,
+		"first-name":   &f.mapping.FirstName,
+		"last-name":    &f.mapping.LastName,
+		"display-name": &f.mapping.DisplayName,
+		"email":        &f.mapping.Email,
+		"password":     &f.mapping.Password,
+		"active":       &f.mapping.Active,
+		"groups":       &f.mapping.Groups,
+	}
+
+	if target, ok := fields[field]; ok {
+		*target = column
+		return nil
+	}
+
+	if strings.HasPrefix(field, "attribute:") {
+
+		if f.mapping.Attributes == nil {
+			f.mapping.Attributes = make(map[string]string)
+		}
+
+		f.mapping.Attributes[column] = strings.TrimPrefix(field, "attribute:")
+
+		return nil
+
+	}
+
+	return fmt.Errorf("unknown field %q", field)
+
+}
+
+// Export
+
+func export(c *cli, command *command, args []string) error {
+
+	flags := c.flagSet(command)
+
+	format := flags.String("format", "json", "output `format`: json, csv or ldif")
+	outputPath := flags.String("output", "", "write to `file` instead of stdout, the users for csv")
+	groupsPath := flags.String("groups-output", "", "write the groups to `file`, required to export groups as csv")
+	baseDN := flags.String("base-dn", "dc=crowd", "base `dn` of the ldif entries")
+	ldifOptions := &crowd.LDIFOptions{}
+	flags.BoolVar(&ldifOptions.CrowdSchema, "crowd-schema", false, "write the active flag and attributes with the crowdEntry schema, see the library documentation")
+	options := &crowd.ExportOptions{}
+	flags.IntVar(&options.PageSize, "page-size", 1000, "`number` of users and groups requested at once")
+
+	if _, err := c.parse(flags, args, 0, 0); err != nil {
+		return err
+	}
+
+	if *format != "json" && *format != "csv" && *format != "ldif" {
+		return usagef("unknown export format %q", *format)
+	}
+
+	if *groupsPath != "" && *format != "csv" {
+		return usagef("--groups-output is only used by the csv format")
+	}
+
+	if ldifOptions.CrowdSchema && *format != "ldif" {
+		return usagef("--crowd-schema is only used by the ldif format")
+	}
+
+	output := c.stdout
+
+	if *outputPath != "" {
+
+		file, err := os.Create(*outputPath)
+
+		if err != nil {
+			return err
+		}
+
+		defer file.Close()
+
+		output = file
+
+	}
+
+	var writer crowd.ExportWriter
+
+	switch *format {
+	case "csv":
+
+		var groups io.Writer
+
+		if *groupsPath != "" {
+
+			file, err := os.Create(*groupsPath)
+
+			if err != nil {
+				return err
+			}
+
+			defer file.Close()
+
+			groups = file
+
+		}
+
+		writer = crowd.NewCSVExportWriter(output, groups)
+
+	case "ldif":
+		writer = crowd.NewLDIFExportWriter(output, *baseDN, ldifOptions)
+	default:
+		writer = crowd.NewJSONExportWriter(output)
+	}
+
+	api, err := c.api()
+
+	if err != nil {
+		return err
+	}
+
+	return crowd.Export(api, writer, options)
+
+}
+
+// Diff and sync
+
+func diff(c *cli, command *command, args []string) error {
+
+	flags := c.flagSet(command)
+	servers := serverFlags(flags)
+
+	if _, err := c.parse(flags, args, 0, 0); err != nil {
+		return err
+	}
+
+	source, target, err := servers.clients(c)
+
+	if err != nil {
+		return err
+	}
+
+	diff, err := crowd.DiffDirectories(source, target, servers.options)
+
+	if err != nil {
+		return err
+	}
+
+	if diff.Empty() {
+		fmt.Fprintln(c.stderr, "no differences")
+		return nil
+	}
+
+	if err := diff.WriteText(c.stdout); err != nil {
+		return err
+	}
+
+	return errDifferences
+
+}
+
+func syncDirectories(c *cli, command *command, args []string) error {
+
+	flags := c.flagSet(command)
+	servers := serverFlags(flags)
+
+	conflicts := flags.String("conflicts", "source-wins", "what to do with users and groups which differ: source-wins, skip or rename")
+	options := &crowd.SyncOptions{}
+	flags.StringVar(&options.RenameSuffix, "rename-suffix", "-migrated", "`suffix` of users and groups copied under a new name with --conflicts rename")
+
+	if _, err := c.parse(flags, args, 0, 0); err != nil {
+		return err
+	}
+
+	switch *conflicts {
+	case "source-wins":
+		options.Conflicts = crowd.SourceWins
+	case "skip":
+		options.Conflicts = crowd.SkipConflicts
+	case "rename":
+		options.Conflicts = crowd.RenameConflicts
+	default:
+		return usagef("unknown conflict policy %q", *conflicts)
+	}
+
+	source, target, err := servers.clients(c)
+
+	if err != nil {
+		return err
+	}
+
+	diff, err := crowd.DiffDirectories(source, target, servers.options)
+
+	if err != nil {
+		return err
+	}
+
+	report := diff.Sync(target, options)
+
+	for _, step := range report.Steps {
+
+		if step.Err != nil {
+			fmt.Fprintf(c.stdout, "%s: %v\n", step.Description, step.Err)
+			continue
+		}
+
+		fmt.Fprintln(c.stdout, step.Description)
+
+	}
+
+	if len(report.PasswordsNotCopied) > 0 {
+		fmt.Fprintf(c.stderr, "passwords cannot be copied, %d new users have a random password and must reset it\n", len(report.PasswordsNotCopied))
+	}
+
+	if failed := report.Failed(); failed > 0 {
+		return fmt.Errorf("%d of %d changes failed", failed, len(report.Steps))
+	}
+
+	return nil
+
+}
+
+// The servers of the diff and sync commands.
+type servers struct {
+	from    string
+	to      string
+	options *crowd.ExportOptions
+}
+
+func serverFlags(flags *flag.FlagSet) *servers {
+
+	s := &servers{options: &crowd.ExportOptions{}}
+
+	flags.StringVar(&s.from, "from", "", "`profile` of the source server")
+	flags.StringVar(&s.to, "to", "", "`profile` of the target server, the configured server if not set")
+	flags.IntVar(&s.options.PageSize, "page-size", 1000, "`number` of users and groups requested at once")
+
+	return s
+
+}
+
+// Get the clients of the source and the target server.
+func (s *servers) clients(c *cli) (crowd.Client, crowd.Client, error) {
+
+	if s.from == "" {
+		return nil, nil, usagef("--from is required")
+	}
+
+	source, err := c.server(s.from)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	target, err := c.server(s.to)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return source, target, nil
+
+}
+
+// Get the client of a profile, or of the configured server without one.
+func (c *cli) server(profile string) (crowd.Client, error) {
+
+	if profile == "" {
+		return c.api()
+	}
+
+	return c.profileAPI(profile)
+
+}
+
+// Reconcile
+
+func reconcilePlan(c *cli, command *command, args []string) error {
+
+	plan, err := c.plan(command, args)
+
+	if err != nil {
+		return err
+	}
+
+	if len(plan.Steps) == 0 {
+		fmt.Fprintln(c.stderr, "no changes")
+	}
+
+	fmt.Fprint(c.stdout, plan)
+
+	return nil
+
+}
+
+func reconcileApply(c *cli, command *command, args []string) error {
+
+	plan, err := c.plan(command, args)
+
+	if err != nil {
+		return err
+	}
+
+	if len(plan.Steps) == 0 {
+		fmt.Fprintln(c.stderr, "no changes")
+		return nil
+	}
+
+	fmt.Fprint(c.stdout, plan)
+
+	api, err := c.api()
+
+	if err != nil {
+		return err
+	}
+
+	if err := plan.Apply(api); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(c.stderr, "%d changes applied\n", len(plan.Steps))
+
+	return nil
+
+}
+
+// Read the desired state of a reconcile command and plan its changes.
+func (c *cli) plan(command *command, args []string) (*crowd.Plan, error) {
+
+	flags := c.flagSet(command)
+
+	options := &crowd.ReconcileOptions{}
+	flags.BoolVar(&options.Prune, "prune", false, "remove groups, members, child groups and attributes which are not in the desired state")
+	flags.StringVar(&options.PrunePrefix, "prefix", "", "only prune groups whose name starts with `prefix`, required with --prune")
+	flags.BoolVar(&options.PruneAll, "prune-all", false, "prune without a prefix, removing every group which is not in the desired state")
+
+	args, err := c.parse(flags, args, 1, 1)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if options.PrunePrefix != "" && !options.Prune {
+		return nil, usagef("--prefix is only used with --prune")
+	}
+
+	if options.PruneAll {
+
+		if options.PrunePrefix != "" {
+			return nil, usagef("--prune-all and --prefix cannot be combined")
+		}
+
+		options.Prune = true
+
+	}
+
+	if options.Prune && options.PrunePrefix == "" && !options.PruneAll {
+		return nil, usagef("--prune needs --prefix, or --prune-all to prune every group")
+	}
+
+	var data []byte
+
+	if args[0] == "-" {
+		data, err = ioutil.ReadAll(c.stdin)
+	} else {
+		data, err = ioutil.ReadFile(args[0])
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	desired, err := crowd.ParseDesiredState(data)
+
+	if err != nil {
+		return nil, err
+	}
+
+	api, err := c.api()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return crowd.PlanReconcile(api, desired, options)
+
+}
+
+// Check whether a flag was given on the command line.
+func isSet(flags *flag.FlagSet, name string) bool {
+
+	set := false
+
+	flags.Visit(func(f *flag.Flag) {
+		set = set || f.Name == name
+	})
+
+	return set
+
+}
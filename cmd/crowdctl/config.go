@@ -0,0 +1,402 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"flag"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Connection settings of crowdctl, resolved from the flags, the environment
+// and the selected profile.
+type config struct {
+	URL         string
+	Application string
+	Password    string
+	TLS         *tls.Config
+	// Check and print mutating requests instead of sending them.
+	DryRun bool
+}
+
+// The config file. Settings outside of profiles apply when no profile is
+// selected and are the defaults of all profiles.
+//
+//	default-profile: dev
+//	profiles:
+//	  dev:
+//	    url: https://crowd.dev.example.com
+//	    application: crowdctl
+//	    password-command: [pass, show, crowd/dev]
+//	  prod:
+//	    url: https://crowd.example.com
+//	    application: crowdctl
+//	    password-env: CROWD_PROD_PASSWORD
+//	    tls:
+//	      ca-file: ~/.config/crowdctl/prod-ca.pem
+type configFile struct {
+	profile        `yaml:",inline"`
+	DefaultProfile string              `yaml:"default-profile"`
+	Profiles       map[string]*profile `yaml:"profiles"`
+}
+
+// The settings of a crowd server. The application password is taken from the
+// first source which is set: password, password-env, password-file or
+// password-command, which is run without a shell and must print the password.
+type profile struct {
+	URL             string    `yaml:"url"`
+	Application     string    `yaml:"application"`
+	Password        string    `yaml:"password"`
+	PasswordEnv     string    `yaml:"password-env"`
+	PasswordFile    string    `yaml:"password-file"`
+	PasswordCommand []string  `yaml:"password-command"`
+	TLS             tlsConfig `yaml:"tls"`
+}
+
+type tlsConfig struct {
+	// PEM file with the certificate authorities to trust, instead of the
+	// system ones.
+	CAFile string `yaml:"ca-file"`
+	// Client certificate and key, in PEM files.
+	CertFile string `yaml:"cert-file"`
+	KeyFile  string `yaml:"key-file"`
+	// Expected name in the certificate of the server.
+	ServerName         string `yaml:"server-name"`
+	InsecureSkipVerify bool   `yaml:"insecure-skip-verify"`
+}
+
+type globalFlags struct {
+	URL         string
+	Application string
+	Password    string
+	path        string
+	profile     string
+	dryRun      bool
+}
+
+func (f *globalFlags) register(flags *flag.FlagSet) {
+
+	flags.StringVar(&f.URL, "url", "", "base `url` of the crowd server (CROWD_URL)")
+	flags.StringVar(&f.Application, "application", "", "application `name` (CROWD_APPLICATION)")
+	flags.StringVar(&f.Password, "password", "", "application `password` (CROWD_PASSWORD)")
+	flags.StringVar(&f.path, "config", "", "config `file` (CROWDCTL_CONFIG, default ~/.config/crowdctl/config.yaml)")
+	flags.StringVar(&f.profile, "profile", "", "`name` of the profile in the config file (CROWDCTL_PROFILE)")
+	flags.BoolVar(&f.dryRun, "dry-run", false, "check changes and print them to stderr instead of making them")
+
+}
+
+// Merge the settings of the flags, the environment and the config file, in
+// this order of precedence. The application, password and TLS settings of the
+// profile are only used for the url of the profile. A missing default config
+// file is not an error.
+func loadConfig(flags globalFlags, getenv func(string) string) (config, error) {
+
+	file, err := readConfigFile(flags.path, getenv)
+
+	if err != nil {
+		return config{}, err
+	}
+
+	selected, err := file.lookup(first(flags.profile, getenv("CROWDCTL_PROFILE"), file.DefaultProfile))
+
+	if err != nil {
+		return config{}, err
+	}
+
+	merged := config{
+		URL:         first(flags.URL, getenv("CROWD_URL"), selected.URL),
+		Application: first(flags.Application, getenv("CROWD_APPLICATION")),
+		Password:    first(flags.Password, getenv("CROWD_PASSWORD")),
+		DryRun:      flags.dryRun,
+	}
+
+	if merged.URL == "" {
+		return config{}, errors.New("no crowd server configured, set --url, CROWD_URL or a profile")
+	}
+
+	// Never use the credentials or TLS settings of a profile with another
+	// server.
+	if merged.URL != selected.URL {
+
+		if merged.Application == "" || merged.Password == "" {
+			return config{}, errors.New("the crowd server is not the one of the profile, set --application and --password or CROWD_APPLICATION and CROWD_PASSWORD")
+		}
+
+		return merged, nil
+
+	}
+
+	merged.Application = first(merged.Application, selected.Application)
+
+	if err := selected.complete(&merged, getenv); err != nil {
+		return config{}, err
+	}
+
+	return merged, nil
+
+}
+
+// Get the settings of a profile of the config file alone, for commands which
+// talk to several servers.
+func loadProfile(flags globalFlags, name string, getenv func(string) string) (config, error) {
+
+	file, err := readConfigFile(flags.path, getenv)
+
+	if err != nil {
+		return config{}, err
+	}
+
+	selected, err := file.lookup(name)
+
+	if err != nil {
+		return config{}, err
+	}
+
+	if selected.URL == "" {
+		return config{}, fmt.Errorf("profile %q has no url", name)
+	}
+
+	loaded := config{URL: selected.URL, Application: selected.Application, DryRun: flags.dryRun}
+
+	if err := selected.complete(&loaded, getenv); err != nil {
+		return config{}, err
+	}
+
+	return loaded, nil
+
+}
+
+// Complete a config with the password, unless it is set, and the TLS
+// settings of the profile.
+func (p *profile) complete(c *config, getenv func(string) string) error {
+
+	var err error
+
+	if c.Password == "" {
+
+		c.Password, err = p.password(getenv)
+
+		if err != nil {
+			return err
+		}
+
+	}
+
+	c.TLS, err = p.TLS.build(getenv)
+
+	return err
+
+}
+
+func readConfigFile(path string, getenv func(string) string) (*configFile, error) {
+
+	if path == "" {
+		path = getenv("CROWDCTL_CONFIG")
+	}
+
+	explicit := path != ""
+
+	if !explicit {
+		path = defaultConfigPath(getenv)
+	}
+
+	file := &configFile{}
+
+	if path == "" {
+		return file, nil
+	}
+
+	data, err := ioutil.ReadFile(expandHome(path, getenv))
+
+	switch {
+	case err == nil:
+	case !explicit && os.IsNotExist(err):
+		return file, nil
+	default:
+		return nil, err
+	}
+
+	if err := yaml.Unmarshal(data, file); err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %v", path, err)
+	}
+
+	return file, nil
+
+}
+
+// Get the settings of a profile, completed with the defaults of the file.
+// Without a name, the defaults are returned.
+func (f *configFile) lookup(name string) (*profile, error) {
+
+	if name == "" {
+		return &f.profile, nil
+	}
+
+	selected, ok := f.Profiles[name]
+
+	if !ok || selected == nil {
+		return nil, fmt.Errorf("unknown profile %q", name)
+	}
+
+	merged := *selected
+	merged.URL = first(merged.URL, f.URL)
+	merged.Application = first(merged.Application, f.Application)
+
+	if !merged.hasPassword() {
+		merged.Password = f.Password
+		merged.PasswordEnv = f.PasswordEnv
+		merged.PasswordFile = f.PasswordFile
+		merged.PasswordCommand = f.PasswordCommand
+	}
+
+	if merged.TLS == (tlsConfig{}) {
+		merged.TLS = f.TLS
+	}
+
+	return &merged, nil
+
+}
+
+func (p *profile) hasPassword() bool {
+	return p.Password != "" || p.PasswordEnv != "" || p.PasswordFile != "" || len(p.PasswordCommand) > 0
+}
+
+// Resolve the application password from its source.
+func (p *profile) password(getenv func(string) string) (string, error) {
+
+	switch {
+	case p.Password != "":
+		return p.Password, nil
+	case p.PasswordEnv != "":
+
+		password := getenv(p.PasswordEnv)
+
+		if password == "" {
+			return "", fmt.Errorf("password environment variable %s is not set", p.PasswordEnv)
+		}
+
+		return password, nil
+
+	case p.PasswordFile != "":
+
+		data, err := ioutil.ReadFile(expandHome(p.PasswordFile, getenv))
+
+		if err != nil {
+			return "", err
+		}
+
+		return strings.TrimRight(string(data), "\r\n"), nil
+
+	case len(p.PasswordCommand) > 0:
+
+		stderr := &bytes.Buffer{}
+
+		command := exec.Command(p.PasswordCommand[0], p.PasswordCommand[1:]...)
+		command.Stderr = stderr
+
+		output, err := command.Output()
+
+		if err != nil {
+			return "", fmt.Errorf("password command %s failed: %v %s", p.PasswordCommand[0], err, strings.TrimSpace(stderr.String()))
+		}
+
+		return strings.TrimRight(string(output), "\r\n"), nil
+
+	default:
+		return "", nil
+	}
+
+}
+
+// Build the TLS configuration, nil if no option is set.
+func (t tlsConfig) build(getenv func(string) string) (*tls.Config, error) {
+
+	if t == (tlsConfig{}) {
+		return nil, nil
+	}
+
+	config := &tls.Config{
+		ServerName:         t.ServerName,
+		InsecureSkipVerify: t.InsecureSkipVerify,
+	}
+
+	if t.CAFile != "" {
+
+		data, err := ioutil.ReadFile(expandHome(t.CAFile, getenv))
+
+		if err != nil {
+			return nil, err
+		}
+
+		config.RootCAs = x509.NewCertPool()
+
+		if !config.RootCAs.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificates found in %s", t.CAFile)
+		}
+
+	}
+
+	if t.CertFile != "" || t.KeyFile != "" {
+
+		certificate, err := tls.LoadX509KeyPair(expandHome(t.CertFile, getenv), expandHome(t.KeyFile, getenv))
+
+		if err != nil {
+			return nil, err
+		}
+
+		config.Certificates = []tls.Certificate{certificate}
+
+	}
+
+	return config, nil
+
+}
+
+// Get the path of the config file in the user's config directory, or an
+// empty path if it is not known.
+func defaultConfigPath(getenv func(string) string) string {
+
+	dir := getenv("XDG_CONFIG_HOME")
+
+	if dir == "" && getenv("HOME") != "" {
+		dir = filepath.Join(getenv("HOME"), ".config")
+	}
+
+	if dir == "" {
+		return ""
+	}
+
+	return filepath.Join(dir, "crowdctl", "config.yaml")
+
+}
+
+// Replace a leading ~ with the home directory.
+func expandHome(path string, getenv func(string) string) string {
+
+	if path != "~" && !strings.HasPrefix(path, "~/") {
+		return path
+	}
+
+	return filepath.Join(getenv("HOME"), path[1:])
+
+}
+
+// Get the first value which is not empty.
+func first(values ...string) string {
+
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+
+	return ""
+
+}
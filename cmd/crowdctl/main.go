@@ -0,0 +1,276 @@
+// Command crowdctl manages users, groups and memberships of a crowd server.
+//
+// Usage:
+//
+//	crowdctl [global flags] <command> [flags] [arguments]
+//
+// The server and the application credentials are read from the global flags,
+// the environment variables CROWD_URL, CROWD_APPLICATION and CROWD_PASSWORD,
+// or a profile of the config file ~/.config/crowdctl/config.yaml, selected
+// with --profile, in that order. Profiles may read the password from an
+// environment variable, a file or a helper command, and set TLS options. The
+// application, password and TLS options of a profile are only used with the
+// url of the profile. Run crowdctl without arguments to list the commands.
+//
+// The read commands write JSON by default, select another format with
+// -o table, yaml, csv or template=<go template>.
+//
+// With --dry-run, changes are checked against crowd and printed to stderr
+// instead of being made.
+//
+// Exit codes: 0 on success, 1 on other errors, 2 on invalid arguments, 3 if
+// a user, group or session is not found, 4 if the application has no
+// permission, 5 if crowd cannot be reached and 6 if diff found differences.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/agile-rcm/crowd-go"
+	"github.com/valyala/fasthttp"
+	"io"
+	"net"
+	"os"
+	"strings"
+)
+
+// Exit codes.
+const (
+	exitOK               = 0
+	exitError            = 1
+	exitUsage            = 2
+	exitNotFound         = 3
+	exitPermissionDenied = 4
+	exitUnavailable      = 5
+	exitDifferences      = 6
+)
+
+// Returned by the diff command if the servers differ, which is not reported
+// as an error.
+var errDifferences = errors.New("differences found")
+
+// An error caused by invalid arguments, reported with the usage of the
+// command.
+type usageError struct {
+	message string
+}
+
+func (e *usageError) Error() string {
+	return e.message
+}
+
+func usagef(format string, args ...interface{}) error {
+	return &usageError{message: fmt.Sprintf(format, args...)}
+}
+
+// The environment of an invocation.
+type cli struct {
+	stdin  io.Reader
+	stdout io.Writer
+	stderr io.Writer
+	getenv func(string) string
+
+	flags  globalFlags
+	client crowd.Client
+}
+
+func main() {
+
+	c := &cli{
+		stdin:  os.Stdin,
+		stdout: os.Stdout,
+		stderr: os.Stderr,
+		getenv: os.Getenv,
+	}
+
+	os.Exit(c.run(os.Args[1:]))
+
+}
+
+// Run crowdctl with the given arguments and return the exit code.
+func (c *cli) run(args []string) int {
+
+	global := flag.NewFlagSet("crowdctl", flag.ContinueOnError)
+	global.SetOutput(c.stderr)
+	global.Usage = func() { c.usage(global) }
+
+	flags := globalFlags{}
+	flags.register(global)
+
+	if err := global.Parse(args); err != nil {
+		return exitUsage
+	}
+
+	command, args := findCommand(global.Args())
+
+	if command == nil {
+		c.usage(global)
+		return exitUsage
+	}
+
+	c.flags = flags
+
+	err := command.run(c, command, args)
+
+	var usage *usageError
+
+	switch {
+	case err == nil:
+		return exitOK
+	case err == errDifferences:
+		return exitDifferences
+	case errors.As(err, &usage):
+		fmt.Fprintf(c.stderr, "crowdctl %s: %v\nusage: crowdctl %s %s\n", command.path, err, command.path, command.args)
+		return exitUsage
+	case err == flag.ErrHelp:
+		return exitUsage
+	default:
+		fmt.Fprintf(c.stderr, "crowdctl %s: %v\n", command.path, err)
+		return exitCode(err)
+	}
+
+}
+
+// Get the exit code of a failed command, distinguishing missing entities,
+// missing permissions and an unreachable server.
+func exitCode(err error) int {
+
+	var netErr net.Error
+
+	switch {
+	case errors.Is(err, crowd.ErrorUserNotFound),
+		errors.Is(err, crowd.ErrorGroupNotFound),
+		errors.Is(err, crowd.ErrorSessionNotFound):
+		return exitNotFound
+	case errors.Is(err, crowd.ErrorGeneralNoPermissions):
+		return exitPermissionDenied
+	case errors.Is(err, crowd.ErrorCrowdUnavailable),
+		errors.Is(err, context.DeadlineExceeded),
+		errors.Is(err, fasthttp.ErrDialTimeout),
+		errors.Is(err, fasthttp.ErrNoFreeConns),
+		errors.Is(err, fasthttp.ErrConnectionClosed),
+		errors.As(err, &netErr):
+		return exitUnavailable
+	default:
+		return exitError
+	}
+
+}
+
+// Get the client for the configured server, created on first use.
+func (c *cli) api() (crowd.Client, error) {
+
+	if c.client != nil {
+		return c.client, nil
+	}
+
+	config, err := loadConfig(c.flags, c.getenv)
+
+	if err != nil {
+		return nil, err
+	}
+
+	api, err := c.newAPI(config)
+
+	if err != nil {
+		return nil, err
+	}
+
+	c.client = api
+
+	return api, nil
+
+}
+
+// Get a client for the server of a profile of the config file.
+func (c *cli) profileAPI(name string) (crowd.Client, error) {
+
+	config, err := loadProfile(c.flags, name, c.getenv)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return c.newAPI(config)
+
+}
+
+func (c *cli) newAPI(config config) (*crowd.API, error) {
+
+	options := []crowd.Option{}
+
+	if config.DryRun {
+		options = append(options, crowd.WithDryRun(&crowd.DryRun{
+			CheckExistence: true,
+			Log: func(request *crowd.DryRunRequest) {
+				fmt.Fprintf(c.stderr, "dry run: %s\n", request)
+			},
+		}))
+	}
+
+	api, err := crowd.NewAPI(config.URL, config.Application, config.Password, options...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	api.Client.TLSConfig = config.TLS
+
+	return api, nil
+
+}
+
+// Create the flag set of a command.
+func (c *cli) flagSet(command *command) *flag.FlagSet {
+
+	flags := flag.NewFlagSet("crowdctl "+command.path, flag.ContinueOnError)
+	flags.SetOutput(c.stderr)
+	flags.Usage = func() {
+		fmt.Fprintf(c.stderr, "usage: crowdctl %s %s\n\n%s\n", command.path, command.args, command.summary)
+		flags.PrintDefaults()
+	}
+
+	return flags
+
+}
+
+func (c *cli) usage(global *flag.FlagSet) {
+
+	fmt.Fprintf(c.stderr, "usage: crowdctl [global flags] <command> [flags] [arguments]\n\nCommands:\n")
+
+	for _, command := range commands {
+		fmt.Fprintf(c.stderr, "  %-40s %s\n", command.path+" "+command.args, command.summary)
+	}
+
+	fmt.Fprintf(c.stderr, "\nGlobal flags:\n")
+	global.PrintDefaults()
+
+}
+
+// Find the command named by the leading arguments, preferring the longest
+// name, and return it with the remaining arguments.
+func findCommand(args []string) (*command, []string) {
+
+	var found *command
+	rest := args
+
+	for _, command := range commands {
+
+		words := strings.Fields(command.path)
+
+		if len(words) > len(args) || (found != nil && len(words) <= len(strings.Fields(found.path))) {
+			continue
+		}
+
+		if strings.Join(args[:len(words)], " ") == command.path {
+			found = command
+			rest = args[len(words):]
+		}
+
+	}
+
+	return found, rest
+
+}
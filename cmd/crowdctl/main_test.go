@@ -0,0 +1,777 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/pem"
+	"github.com/agile-rcm/crowd-go"
+	"github.com/agile-rcm/crowd-go/crowdtest"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"log"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Run crowdctl against the server with the given stdin. Returns the exit
+// code, stdout and stderr.
+func runCLI(server *crowdtest.Server, stdin string, args ...string) (int, string, string) {
+
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+
+	env := map[string]string{
+		"CROWD_URL":         server.URL,
+		"CROWD_APPLICATION": server.Application,
+		"CROWD_PASSWORD":    server.ApplicationPassword,
+	}
+
+	c := &cli{
+		stdin:  strings.NewReader(stdin),
+		stdout: stdout,
+		stderr: stderr,
+		getenv: func(key string) string { return env[key] },
+	}
+
+	code := c.run(args)
+
+	return code, stdout.String(), stderr.String()
+
+}
+
+func TestCLI_User(t *testing.T) {
+
+	server := crowdtest.NewServer()
+	defer server.Close()
+
+	code, _, _ := runCLI(server, "secret\n", "user", "add", "--first-name", "Test", "--email", "test@example.com", "testuser")
+
+	assert.Equal(t, exitOK, code)
+	assert.Nil(t, server.Backend.AuthenticateUser("testuser", "secret"))
+
+	code, _, _ = runCLI(server, "", "user", "update", "--last-name", "User", "testuser")
+
+	assert.Equal(t, exitOK, code)
+
+	code, stdout, _ := runCLI(server, "", "user", "get", "testuser")
+
+	assert.Equal(t, exitOK, code)
+
+	user := crowd.User{}
+
+	assert.Nil(t, json.Unmarshal([]byte(stdout), &user))
+	assert.Equal(t, "User", user.LastName)
+	assert.True(t, user.IsActive)
+
+	code, _, _ = runCLI(server, "", "user", "passwd", "--password", "newsecret", "testuser")
+
+	assert.Equal(t, exitOK, code)
+	assert.Nil(t, server.Backend.AuthenticateUser("testuser", "newsecret"))
+
+	code, _, _ = runCLI(server, "", "user", "rename", "testuser", "renameduser")
+
+	assert.Equal(t, exitOK, code)
+
+	code, _, _ = runCLI(server, "", "user", "remove", "renameduser")
+
+	assert.Equal(t, exitOK, code)
+
+	code, _, stderr := runCLI(server, "", "user", "get", "renameduser")
+
+	assert.Equal(t, exitNotFound, code)
+	assert.Equal(t, "crowdctl user get: User could not be found\n", stderr)
+
+}
+
+func TestCLI_UserAttr(t *testing.T) {
+
+	server := crowdtest.NewServer()
+	defer server.Close()
+
+	server.SeedUser("testuser", "password")
+
+	code, _, _ := runCLI(server, "", "user", "attr", "set", "testuser", "team", "a", "b")
+
+	assert.Equal(t, exitOK, code)
+
+	code, stdout, _ := runCLI(server, "", "user", "attr", "get", "testuser")
+
+	assert.Equal(t, exitOK, code)
+	assert.JSONEq(t, `{"team":["a","b"]}`, stdout)
+
+	code, _, _ = runCLI(server, "", "user", "attr", "rm", "testuser", "team")
+
+	assert.Equal(t, exitOK, code)
+
+	_, stdout, _ = runCLI(server, "", "user", "attr", "get", "testuser")
+
+	assert.JSONEq(t, `{}`, stdout)
+
+}
+
+func TestCLI_Groups(t *testing.T) {
+
+	server := crowdtest.NewServer()
+	defer server.Close()
+
+	server.SeedGroup("developers", "staff")
+	server.SeedUser("testuser", "password")
+
+	code, _, _ := runCLI(server, "", "group", "create", "--description", "Operations", "ops")
+
+	assert.Equal(t, exitOK, code)
+
+	code, stdout, _ := runCLI(server, "", "group", "get", "ops")
+
+	assert.Equal(t, exitOK, code)
+	assert.Contains(t, stdout, `"description": "Operations"`)
+
+	code, _, _ = runCLI(server, "", "membership", "add", "testuser", "developers")
+
+	assert.Equal(t, exitOK, code)
+
+	_, stdout, _ = runCLI(server, "", "group", "members", "staff")
+
+	assert.JSONEq(t, `[]`, stdout)
+
+	_, stdout, _ = runCLI(server, "", "group", "members", "--nested", "staff")
+
+	assert.Contains(t, stdout, `"name": "testuser"`)
+
+	code, _, _ = runCLI(server, "", "membership", "rm", "testuser", "developers")
+
+	assert.Equal(t, exitOK, code)
+
+	code, _, _ = runCLI(server, "", "group", "rm", "ops")
+
+	assert.Equal(t, exitOK, code)
+
+}
+
+func TestCLI_SessionValidate(t *testing.T) {
+
+	server := crowdtest.NewServer()
+	defer server.Close()
+
+	server.SeedUser("testuser", "password")
+
+	factors := &crowd.ValidationFactors{ValidationFactors: []*crowd.ValidationFactor{{Name: "remote_address", Value: "10.0.0.1"}}}
+	session, err := server.Backend.CreateSession("testuser", "password", factors)
+
+	assert.Nil(t, err)
+
+	code, stdout, _ := runCLI(server, "", "session", "validate", "--remote-address", "10.0.0.1", session.Token)
+
+	assert.Equal(t, exitOK, code)
+	assert.Contains(t, stdout, session.Token)
+
+	code, _, _ = runCLI(server, "", "session", "validate", session.Token)
+
+	assert.Equal(t, exitError, code)
+
+}
+
+func TestCLI_Search(t *testing.T) {
+
+	server := crowdtest.NewServer()
+	defer server.Close()
+
+	server.SeedUser("alice", "password")
+	server.SeedUser("bob", "password", "developers")
+
+	code, stdout, _ := runCLI(server, "", "search", "name", "=", "ali*")
+
+	assert.Equal(t, exitOK, code)
+
+	users := []*crowd.User{}
+
+	assert.Nil(t, json.Unmarshal([]byte(stdout), &users))
+	assert.Len(t, users, 1)
+	assert.Equal(t, "alice", users[0].Name)
+
+	code, stdout, _ = runCLI(server, "", "search", "--groups")
+
+	assert.Equal(t, exitOK, code)
+	assert.Contains(t, stdout, "developers")
+
+}
+
+func TestCLI_Output(t *testing.T) {
+
+	server := crowdtest.NewServer()
+	defer server.Close()
+
+	server.SeedUser("alice", "password", "developers")
+	server.SeedUser("bob", "password", "developers")
+	server.SeedAttributes("alice", map[string][]string{"team": {"a", "b"}})
+
+	code, stdout, _ := runCLI(server, "", "group", "members", "-o", "table", "developers")
+
+	assert.Equal(t, exitOK, code)
+	assert.Equal(t, []string{"NAME", "FIRST-NAME", "LAST-NAME", "DISPLAY-NAME", "EMAIL", "ACTIVE"}, strings.Fields(strings.Split(stdout, "\n")[0]))
+	assert.Equal(t, []string{"alice", "alice", "alice", "alice@example.com", "true"}, strings.Fields(strings.Split(stdout, "\n")[1]))
+
+	code, stdout, _ = runCLI(server, "", "user", "attr", "get", "-o", "csv", "alice")
+
+	assert.Equal(t, exitOK, code)
+	assert.Equal(t, "name,value\nteam,a\nteam,b\n", stdout)
+
+	code, stdout, _ = runCLI(server, "", "user", "groups", "--output", "yaml", "bob")
+
+	assert.Equal(t, exitOK, code)
+	assert.Equal(t, "- name: developers\n  description: \"\"\n  type: GROUP\n  active: true\n", stdout)
+
+	code, stdout, _ = runCLI(server, "", "user", "get", "alice")
+
+	assert.Equal(t, exitOK, code)
+	assert.NotContains(t, stdout, "password")
+	assert.NotContains(t, stdout, "attributes")
+
+	// Inactive groups keep their active field.
+	assert.Nil(t, server.Backend.CreateGroup("retired", "", false))
+
+	code, stdout, _ = runCLI(server, "", "group", "get", "retired")
+
+	assert.Equal(t, exitOK, code)
+	assert.JSONEq(t, `{"name": "retired", "description": "", "type": "GROUP", "active": false}`, stdout)
+
+	code, stdout, _ = runCLI(server, "", "group", "get", "-o", "template={{.Active}}", "retired")
+
+	assert.Equal(t, exitOK, code)
+	assert.Equal(t, "false", stdout)
+
+	code, stdout, _ = runCLI(server, "", "search", "-o", "template={{range .}}{{.Name}} {{end}}")
+
+	assert.Equal(t, exitOK, code)
+	assert.Equal(t, "alice bob ", stdout)
+
+	code, _, stderr := runCLI(server, "", "group", "get", "-o", "xml", "developers")
+
+	assert.Equal(t, exitUsage, code)
+	assert.Contains(t, stderr, `unknown output format "xml"`)
+
+}
+
+func TestCLI_ExitCodes(t *testing.T) {
+
+	server := crowdtest.NewServer()
+	defer server.Close()
+
+	code, _, _ := runCLI(server, "", "group", "get", "missing")
+
+	assert.Equal(t, exitNotFound, code)
+
+	server.Fail("POST", "/user", 403, 1)
+
+	code, _, _ = runCLI(server, "", "user", "add", "--password", "secret", "testuser")
+
+	assert.Equal(t, exitPermissionDenied, code)
+
+	server.Close()
+
+	code, _, _ = runCLI(server, "", "group", "get", "missing")
+
+	assert.Equal(t, exitUnavailable, code)
+
+}
+
+func TestCLI_UserImport(t *testing.T) {
+
+	server := crowdtest.NewServer()
+	defer server.Close()
+
+	server.SeedGroup("developers")
+	server.SeedUser("existing", "password")
+
+	input := "login,mail,password,groups,attribute:team\nalice,alice@example.com,secret,developers,backend\nexisting,,,,\nbob,,,,\n"
+
+	code, _, stderr := runCLI(server, input, "user", "import", "--dry-run", "--map", "name=login", "--map", "email=mail", "-")
+
+	assert.Equal(t, exitError, code)
+	assert.Contains(t, stderr, "dry run: 1 created, 0 updated, 1 skipped, 1 failed")
+	assert.Equal(t, 1, server.Backend.CallCount("AddUser"), "only the seeded user")
+
+	code, stdout, stderr := runCLI(server, input, "user", "import", "--map", "name=login", "--map", "email=mail", "-")
+
+	assert.Equal(t, exitError, code)
+	assert.Contains(t, stderr, "1 of 3 rows failed")
+	assert.Contains(t, stdout, "2,alice,created,\n3,existing,skipped,\n4,bob,created,")
+
+	user, err := server.Backend.GetUser("alice")
+
+	assert.Nil(t, err)
+	assert.Equal(t, "alice@example.com", user.Email)
+
+	members, err := server.Backend.GetGroupMembers("developers")
+
+	assert.Nil(t, err)
+	assert.Len(t, members.Users, 1)
+
+}
+
+func TestCLI_Export(t *testing.T) {
+
+	server := crowdtest.NewServer()
+	defer server.Close()
+
+	server.SeedGroup("developers")
+	server.SeedUser("alice", "password", "developers")
+	server.SeedAttributes("alice", map[string][]string{"team": {"backend"}})
+
+	code, stdout, _ := runCLI(server, "", "export")
+
+	assert.Equal(t, exitOK, code)
+	assert.Contains(t, stdout, `"attributes":{"team":["backend"]},"groups":["developers"]`)
+
+	groupsPath := filepath.Join(t.TempDir(), "groups.csv")
+
+	code, stdout, _ = runCLI(server, "", "export", "--format", "csv", "--groups-output", groupsPath)
+
+	assert.Equal(t, exitOK, code)
+
+	groups, err := ioutil.ReadFile(groupsPath)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "name,description,active,child-groups,attributes\ndevelopers,,true,,{}\n", string(groups))
+
+	// The export can be imported into another server.
+	target := crowdtest.NewServer()
+	defer target.Close()
+
+	target.SeedGroup("developers")
+
+	code, _, _ = runCLI(target, stdout, "user", "import", "--generate-passwords", "-")
+
+	assert.Equal(t, exitOK, code)
+
+	attributes, err := target.Backend.GetUserAttributes("alice")
+
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"backend"}, attributes.Attributes[0].Values)
+
+	code, stdout, _ = runCLI(server, "", "export", "--format", "ldif", "--base-dn", "dc=example,dc=com")
+
+	assert.Equal(t, exitOK, code)
+	assert.Contains(t, stdout, "dn: uid=alice,ou=users,dc=example,dc=com\n")
+
+	code, _, _ = runCLI(server, "", "export", "--format", "xml")
+
+	assert.Equal(t, exitUsage, code)
+
+}
+
+func TestCLI_Reconcile(t *testing.T) {
+
+	server := crowdtest.NewServer()
+	defer server.Close()
+
+	server.SeedGroup("team-old")
+	server.SeedUser("alice", "password")
+
+	state := "groups:\n  - name: team-developers\n    members: [alice]\n"
+
+	code, stdout, _ := runCLI(server, state, "reconcile", "plan", "--prune", "--prefix", "team-", "-")
+
+	assert.Equal(t, exitOK, code)
+	assert.Equal(t, "+ create group team-developers (\"\", active)\n+ add user alice to group team-developers\n- remove group team-old\n", stdout)
+
+	_, err := server.Backend.GetGroup("team-developers")
+
+	assert.Equal(t, crowd.ErrorGroupNotFound, err, "plan does not change anything")
+
+	code, _, stderr := runCLI(server, state, "reconcile", "apply", "--prune", "--prefix", "team-", "-")
+
+	assert.Equal(t, exitOK, code)
+	assert.Equal(t, "3 changes applied\n", stderr)
+
+	code, stdout, stderr = runCLI(server, state, "reconcile", "apply", "--prune", "--prefix", "team-", "-")
+
+	assert.Equal(t, exitOK, code)
+	assert.Empty(t, stdout)
+	assert.Equal(t, "no changes\n", stderr)
+
+	code, _, _ = runCLI(server, state, "reconcile", "plan", "--prefix", "team-", "-")
+
+	assert.Equal(t, exitUsage, code)
+
+	// Pruning without a prefix has to be acknowledged.
+	code, _, _ = runCLI(server, state, "reconcile", "plan", "--prune", "-")
+
+	assert.Equal(t, exitUsage, code)
+
+	code, _, _ = runCLI(server, state, "reconcile", "plan", "--prune-all", "--prefix", "team-", "-")
+
+	assert.Equal(t, exitUsage, code)
+
+	server.SeedGroup("crowd-administrators")
+
+	code, stdout, _ = runCLI(server, state, "reconcile", "plan", "--prune-all", "-")
+
+	assert.Equal(t, exitOK, code)
+	assert.Equal(t, "- remove group crowd-administrators\n", stdout)
+
+}
+
+func TestCLI_DryRun(t *testing.T) {
+
+	server := crowdtest.NewServer()
+	defer server.Close()
+
+	server.SeedGroup("developers")
+	server.SeedUser("alice", "password")
+
+	code, _, stderr := runCLI(server, "", "--dry-run", "membership", "add", "alice", "developers")
+
+	assert.Equal(t, exitOK, code)
+	assert.Equal(t, "dry run: AddUserToGroup POST /rest/usermanagement/1/user/group/direct?username=alice {\"name\":\"developers\"}\n", stderr)
+	assert.Equal(t, 0, server.Backend.CallCount("AddUserToGroup"))
+
+	code, _, stderr = runCLI(server, "secret\n", "--dry-run", "user", "add", "bob")
+
+	assert.Equal(t, exitOK, code)
+	assert.Contains(t, stderr, `"password":"[redacted]"`)
+	assert.NotContains(t, stderr, "secret")
+
+	code, _, stderr = runCLI(server, "", "--dry-run", "user", "passwd", "--password", "secret", "alice")
+
+	assert.Equal(t, exitOK, code)
+	assert.NotContains(t, stderr, "secret")
+
+	code, _, _ = runCLI(server, "", "--dry-run", "membership", "add", "bob", "developers")
+
+	assert.Equal(t, exitNotFound, code)
+
+}
+
+func TestCLI_DiffSync(t *testing.T) {
+
+	source := crowdtest.NewServer()
+	defer source.Close()
+
+	source.SeedGroup("developers")
+	source.SeedUser("alice", "password", "developers")
+	source.SeedUser("bob", "password")
+
+	target := crowdtest.NewServer()
+	defer target.Close()
+
+	target.SeedUser("alice", "password")
+
+	config := filepath.Join(t.TempDir(), "config.yaml")
+
+	assert.Nil(t, ioutil.WriteFile(config, []byte(`
+profiles:
+  old:
+    url: `+source.URL+`
+    application: `+source.Application+`
+    password: `+source.ApplicationPassword+`
+`), 0600))
+
+	code, stdout, _ := runCLI(target, "", "--config", config, "diff", "--from", "old")
+
+	assert.Equal(t, exitDifferences, code)
+	assert.Equal(t, "~ user alice: group developers (added)\n+ user bob\n+ group developers\n", stdout)
+
+	code, stdout, stderr := runCLI(target, "", "--config", config, "sync", "--from", "old", "--conflicts", "skip")
+
+	assert.Equal(t, exitOK, code)
+	assert.Equal(t, "create group developers\ncreate user bob\nadd user alice to group developers\n", stdout)
+	assert.Equal(t, "passwords cannot be copied, 1 new users have a random password and must reset it\n", stderr)
+
+	code, stdout, stderr = runCLI(target, "", "--config", config, "diff", "--from", "old")
+
+	assert.Equal(t, exitOK, code)
+	assert.Empty(t, stdout)
+	assert.Equal(t, "no differences\n", stderr)
+
+	code, _, _ = runCLI(target, "", "--config", config, "diff")
+
+	assert.Equal(t, exitUsage, code)
+
+	code, _, _ = runCLI(target, "", "--config", config, "sync", "--from", "old", "--conflicts", "merge")
+
+	assert.Equal(t, exitUsage, code)
+
+}
+
+func TestCLI_GroupTree(t *testing.T) {
+
+	server := crowdtest.NewServer()
+	defer server.Close()
+
+	server.SeedGroup("backend", "developers")
+	server.SeedGroup("frontend", "developers")
+	server.SeedGroup("developers", "staff")
+	server.SeedUser("alice", "password", "backend")
+
+	code, stdout, _ := runCLI(server, "", "group", "tree", "staff")
+
+	assert.Equal(t, exitOK, code)
+	assert.Equal(t, "staff\n└── developers\n    ├── backend\n    └── frontend\n", stdout)
+
+	code, stdout, _ = runCLI(server, "", "group", "tree", "--dot", "--members", "developers")
+
+	assert.Equal(t, exitOK, code)
+	assert.Contains(t, stdout, `"backend" [label="backend (1)"];`)
+	assert.Contains(t, stdout, `"developers" -> "frontend";`)
+	assert.NotContains(t, stdout, `"staff"`)
+
+	code, stdout, _ = runCLI(server, "", "group", "tree", "--dot")
+
+	assert.Equal(t, exitOK, code)
+	assert.Contains(t, stdout, `"staff" -> "developers";`)
+
+	code, _, _ = runCLI(server, "", "group", "tree", "missing")
+
+	assert.Equal(t, exitNotFound, code)
+
+	code, _, _ = runCLI(server, "", "group", "tree")
+
+	assert.Equal(t, exitUsage, code)
+
+}
+
+func TestCLI_Usage(t *testing.T) {
+
+	server := crowdtest.NewServer()
+	defer server.Close()
+
+	code, _, stderr := runCLI(server, "")
+
+	assert.Equal(t, exitUsage, code)
+	assert.Contains(t, stderr, "user attr set")
+
+	code, _, stderr = runCLI(server, "", "user", "rename", "testuser")
+
+	assert.Equal(t, exitUsage, code)
+	assert.Contains(t, stderr, "usage: crowdctl user rename <user> <new name>")
+
+	code, _, _ = runCLI(server, "", "group", "explode")
+
+	assert.Equal(t, exitUsage, code)
+
+}
+
+func TestLoadConfig(t *testing.T) {
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "crowdctl", "config.yaml")
+
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "config.yaml"), []byte("url: https://file\napplication: fileapp\npassword: filepassword\n"), 0600))
+
+	env := map[string]string{
+		"CROWDCTL_CONFIG":   filepath.Join(dir, "config.yaml"),
+		"CROWD_APPLICATION": "envapp",
+	}
+
+	flags := globalFlags{}
+	flags.URL = "https://flag"
+
+	config, err := loadConfig(globalFlags{}, func(key string) string { return env[key] })
+
+	assert.Nil(t, err)
+	assert.Equal(t, "https://file", config.URL)
+	assert.Equal(t, "envapp", config.Application)
+	assert.Equal(t, "filepassword", config.Password)
+
+	// The password of the file is not sent to another server.
+	_, err = loadConfig(flags, func(key string) string { return env[key] })
+
+	assert.EqualError(t, err, "the crowd server is not the one of the profile, set --application and --password or CROWD_APPLICATION and CROWD_PASSWORD")
+
+	env["CROWD_PASSWORD"] = "envpassword"
+
+	config, err = loadConfig(flags, func(key string) string { return env[key] })
+
+	assert.Nil(t, err)
+	assert.Equal(t, "https://flag", config.URL)
+	assert.Equal(t, "envpassword", config.Password)
+
+	// A missing default config file is fine, a missing explicit one is not.
+	env = map[string]string{"XDG_CONFIG_HOME": dir, "CROWD_URL": "https://env", "CROWD_APPLICATION": "envapp", "CROWD_PASSWORD": "envpassword"}
+
+	_, err = loadConfig(globalFlags{}, func(key string) string { return env[key] })
+
+	assert.Nil(t, err)
+	assert.Equal(t, path, defaultConfigPath(func(key string) string { return env[key] }))
+
+	flags = globalFlags{path: filepath.Join(dir, "missing.yaml")}
+
+	_, err = loadConfig(flags, func(key string) string { return env[key] })
+
+	assert.NotNil(t, err)
+
+}
+
+func TestLoadConfig_Profiles(t *testing.T) {
+
+	dir := t.TempDir()
+
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "password"), []byte("filepassword\n"), 0600))
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "config.yaml"), []byte(`
+application: defaultapp
+default-profile: dev
+profiles:
+  dev:
+    url: https://dev
+    password-env: DEV_PASSWORD
+  test:
+    url: https://test
+    application: testapp
+    password-file: ~/password
+  prod:
+    url: https://prod
+    password-command: [echo, commandpassword]
+    tls:
+      server-name: crowd.example.com
+`), 0600))
+
+	env := map[string]string{
+		"HOME":            dir,
+		"CROWDCTL_CONFIG": "~/config.yaml",
+		"DEV_PASSWORD":    "envpassword",
+	}
+
+	getenv := func(key string) string { return env[key] }
+
+	config, err := loadConfig(globalFlags{}, getenv)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "https://dev", config.URL)
+	assert.Equal(t, "defaultapp", config.Application)
+	assert.Equal(t, "envpassword", config.Password)
+	assert.Nil(t, config.TLS)
+
+	config, err = loadConfig(globalFlags{profile: "test"}, getenv)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "testapp", config.Application)
+	assert.Equal(t, "filepassword", config.Password)
+
+	env["CROWDCTL_PROFILE"] = "prod"
+
+	config, err = loadConfig(globalFlags{}, getenv)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "https://prod", config.URL)
+	assert.Equal(t, "commandpassword", config.Password)
+	assert.Equal(t, "crowd.example.com", config.TLS.ServerName)
+
+	// The password of the environment takes precedence over the profile.
+	env["CROWD_PASSWORD"] = "override"
+
+	config, err = loadConfig(globalFlags{}, getenv)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "override", config.Password)
+
+	_, err = loadConfig(globalFlags{profile: "missing"}, getenv)
+
+	assert.EqualError(t, err, `unknown profile "missing"`)
+
+	delete(env, "DEV_PASSWORD")
+
+	_, err = loadConfig(globalFlags{profile: "dev"}, getenv)
+
+	assert.Nil(t, err)
+
+	delete(env, "CROWD_PASSWORD")
+
+	_, err = loadConfig(globalFlags{profile: "dev"}, getenv)
+
+	assert.EqualError(t, err, "password environment variable DEV_PASSWORD is not set")
+
+	// The password of the profile is only used for its own url.
+	env["CROWD_URL"] = "https://other"
+
+	_, err = loadConfig(globalFlags{profile: "test"}, getenv)
+
+	assert.EqualError(t, err, "the crowd server is not the one of the profile, set --application and --password or CROWD_APPLICATION and CROWD_PASSWORD")
+
+	// Neither are its application and TLS settings.
+	env["CROWD_PASSWORD"] = "otherpassword"
+
+	_, err = loadConfig(globalFlags{profile: "prod"}, getenv)
+
+	assert.EqualError(t, err, "the crowd server is not the one of the profile, set --application and --password or CROWD_APPLICATION and CROWD_PASSWORD")
+
+	env["CROWD_APPLICATION"] = "otherapp"
+
+	config, err = loadConfig(globalFlags{profile: "prod"}, getenv)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "https://other", config.URL)
+	assert.Equal(t, "otherapp", config.Application)
+	assert.Equal(t, "otherpassword", config.Password)
+	assert.Nil(t, config.TLS)
+
+	delete(env, "CROWD_APPLICATION")
+	delete(env, "CROWD_PASSWORD")
+
+	env["CROWD_URL"] = "https://test"
+
+	config, err = loadConfig(globalFlags{profile: "test"}, getenv)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "testapp", config.Application)
+	assert.Equal(t, "filepassword", config.Password)
+
+}
+
+func TestCLI_TLSProfile(t *testing.T) {
+
+	server := crowdtest.NewServer()
+	defer server.Close()
+
+	server.SeedUser("testuser", "password")
+
+	tlsServer := httptest.NewUnstartedServer(server.Config.Handler)
+	tlsServer.Config.ErrorLog = log.New(ioutil.Discard, "", 0)
+	tlsServer.StartTLS()
+	defer tlsServer.Close()
+
+	dir := t.TempDir()
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: tlsServer.Certificate().Raw})
+
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "ca.pem"), ca, 0600))
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "config.yaml"), []byte(`
+profiles:
+  secure:
+    url: `+tlsServer.URL+`
+    application: `+server.Application+`
+    password-command: [echo, `+server.ApplicationPassword+`]
+    tls:
+      ca-file: `+filepath.Join(dir, "ca.pem")+`
+  untrusted:
+    url: `+tlsServer.URL+`
+    application: `+server.Application+`
+    password: `+server.ApplicationPassword+`
+`), 0600))
+
+	run := func(args ...string) (int, string) {
+
+		stdout := &bytes.Buffer{}
+
+		c := &cli{
+			stdin:  strings.NewReader(""),
+			stdout: stdout,
+			stderr: &bytes.Buffer{},
+			getenv: func(string) string { return "" },
+		}
+
+		return c.run(append([]string{"--config", filepath.Join(dir, "config.yaml")}, args...)), stdout.String()
+
+	}
+
+	code, stdout := run("--profile", "secure", "user", "get", "testuser")
+
+	assert.Equal(t, exitOK, code)
+	assert.Contains(t, stdout, `"name": "testuser"`)
+
+	code, _ = run("--profile", "untrusted", "user", "get", "testuser")
+
+	assert.Equal(t, exitError, code)
+
+}
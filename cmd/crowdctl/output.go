@@ -0,0 +1,302 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/agile-rcm/crowd-go"
+	"gopkg.in/yaml.v3"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"text/template"
+	"time"
+)
+
+// An output format of the read commands: table, json, yaml, csv, or
+// template=<go template>, which is executed with the output value of the
+// result, for example {{range .}}{{.Name}}{{end}} for a list of users.
+type format struct {
+	name     string
+	template *template.Template
+}
+
+// A result of a read command: the value written as JSON, YAML or passed to
+// templates, and its columns and rows for tables and CSV.
+type view struct {
+	value   interface{}
+	columns []string
+	rows    [][]string
+}
+
+// Register the -o flag of a read command, also named --output.
+func outputFlag(flags *flag.FlagSet) *string {
+
+	output := new(string)
+
+	flags.StringVar(output, "o", "json", "output `format`: table, json, yaml, csv or template=<go template>")
+	flags.StringVar(output, "output", "json", "output `format`, same as -o")
+
+	return output
+
+}
+
+func parseFormat(value string) (*format, error) {
+
+	name := value
+	text := ""
+
+	if i := strings.Index(value, "="); i >= 0 {
+		name, text = value[:i], value[i+1:]
+	}
+
+	switch name {
+	case "table", "json", "yaml", "csv":
+
+		if text != "" {
+			return nil, usagef("output format %s takes no argument", name)
+		}
+
+		return &format{name: name}, nil
+
+	case "template":
+
+		tmpl, err := template.New("output").Parse(text)
+
+		if err != nil {
+			return nil, usagef("invalid output template: %v", err)
+		}
+
+		return &format{name: name, template: tmpl}, nil
+
+	default:
+		return nil, usagef("unknown output format %q", value)
+	}
+
+}
+
+// Write a result in the format.
+func (c *cli) render(format *format, v *view) error {
+
+	switch format.name {
+	case "table":
+
+		writer := tabwriter.NewWriter(c.stdout, 0, 4, 2, ' ', 0)
+
+		headers := make([]string, len(v.columns))
+
+		for i, column := range v.columns {
+			headers[i] = strings.ToUpper(column)
+		}
+
+		fmt.Fprintln(writer, strings.Join(headers, "\t"))
+
+		for _, row := range v.rows {
+			fmt.Fprintln(writer, strings.Join(row, "\t"))
+		}
+
+		return writer.Flush()
+
+	case "csv":
+
+		writer := csv.NewWriter(c.stdout)
+
+		if err := writer.Write(v.columns); err != nil {
+			return err
+		}
+
+		if err := writer.WriteAll(v.rows); err != nil {
+			return err
+		}
+
+		return writer.Error()
+
+	case "yaml":
+
+		// Go through JSON for the same field names in both formats.
+		data, err := json.Marshal(v.value)
+
+		if err != nil {
+			return err
+		}
+
+		node := &yaml.Node{}
+
+		if err := yaml.Unmarshal(data, node); err != nil {
+			return err
+		}
+
+		clearStyle(node)
+
+		encoder := yaml.NewEncoder(c.stdout)
+		encoder.SetIndent(2)
+
+		if err := encoder.Encode(node); err != nil {
+			return err
+		}
+
+		return encoder.Close()
+
+	case "template":
+		return format.template.Execute(c.stdout, v.value)
+	default:
+
+		encoder := json.NewEncoder(c.stdout)
+		encoder.SetIndent("", "  ")
+
+		return encoder.Encode(v.value)
+
+	}
+
+}
+
+// Reset the flow style the JSON syntax gives to the nodes, so that they are
+// written as block YAML.
+func clearStyle(node *yaml.Node) {
+
+	node.Style = 0
+
+	for _, child := range node.Content {
+		clearStyle(child)
+	}
+
+}
+
+// Views
+
+// The output values of users, groups and sessions. Unlike the crowd structs
+// they always have the same fields and never a password.
+type userOutput struct {
+	Name        string `json:"name"`
+	FirstName   string `json:"first-name"`
+	LastName    string `json:"last-name"`
+	DisplayName string `json:"display-name"`
+	Email       string `json:"email"`
+	Active      bool   `json:"active"`
+}
+
+type groupOutput struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Type        string `json:"type"`
+	Active      bool   `json:"active"`
+}
+
+type sessionOutput struct {
+	Token       string      `json:"token"`
+	User        *userOutput `json:"user"`
+	CreatedDate int64       `json:"created-date"`
+	ExpiryDate  int64       `json:"expiry-date"`
+}
+
+var (
+	userColumns    = []string{"name", "first-name", "last-name", "display-name", "email", "active"}
+	groupColumns   = []string{"name", "description", "type", "active"}
+	sessionColumns = []string{"token", "user", "created-date", "expiry-date"}
+)
+
+func userView(user *crowd.User) *view {
+	return &view{value: newUserOutput(user), columns: userColumns, rows: [][]string{userRow(user)}}
+}
+
+func usersView(users []*crowd.User) *view {
+
+	values := make([]*userOutput, len(users))
+	rows := make([][]string, len(users))
+
+	for i, user := range users {
+		values[i] = newUserOutput(user)
+		rows[i] = userRow(user)
+	}
+
+	return &view{value: values, columns: userColumns, rows: rows}
+
+}
+
+func newUserOutput(user *crowd.User) *userOutput {
+
+	return &userOutput{
+		Name:        user.Name,
+		FirstName:   user.FirstName,
+		LastName:    user.LastName,
+		DisplayName: user.DisplayName,
+		Email:       user.Email,
+		Active:      user.IsActive,
+	}
+
+}
+
+func userRow(user *crowd.User) []string {
+	return []string{user.Name, user.FirstName, user.LastName, user.DisplayName, user.Email, strconv.FormatBool(user.IsActive)}
+}
+
+func groupView(group *crowd.Group) *view {
+	return &view{value: newGroupOutput(group), columns: groupColumns, rows: [][]string{groupRow(group)}}
+}
+
+func groupsView(groups []*crowd.Group) *view {
+
+	values := make([]*groupOutput, len(groups))
+	rows := make([][]string, len(groups))
+
+	for i, group := range groups {
+		values[i] = newGroupOutput(group)
+		rows[i] = groupRow(group)
+	}
+
+	return &view{value: values, columns: groupColumns, rows: rows}
+
+}
+
+func newGroupOutput(group *crowd.Group) *groupOutput {
+	return &groupOutput{Name: group.Name, Description: group.Description, Type: group.Type, Active: group.Active}
+}
+
+func groupRow(group *crowd.Group) []string {
+	return []string{group.Name, group.Description, group.Type, strconv.FormatBool(group.Active)}
+}
+
+// Attributes are written as a map of names to values, and one row per value.
+func attributesView(attributes *crowd.Attributes) *view {
+
+	values := make(map[string][]string)
+	rows := [][]string{}
+
+	for _, attribute := range attributes.Attributes {
+
+		values[attribute.Name] = attribute.Values
+
+		if len(attribute.Values) == 0 {
+			rows = append(rows, []string{attribute.Name, ""})
+		}
+
+		for _, value := range attribute.Values {
+			rows = append(rows, []string{attribute.Name, value})
+		}
+
+	}
+
+	return &view{value: values, columns: []string{"name", "value"}, rows: rows}
+
+}
+
+func sessionView(session *crowd.Session) *view {
+
+	value := &sessionOutput{Token: session.Token, CreatedDate: session.CreatedDate, ExpiryDate: session.ExpiryDate}
+	userName := ""
+
+	if session.User != nil {
+		value.User = newUserOutput(session.User)
+		userName = session.User.Name
+	}
+
+	row := []string{session.Token, userName, formatMillis(session.CreatedDate), formatMillis(session.ExpiryDate)}
+
+	return &view{value: value, columns: sessionColumns, rows: [][]string{row}}
+
+}
+
+func formatMillis(millis int64) string {
+	return time.Unix(0, millis*int64(time.Millisecond)).UTC().Format(time.RFC3339)
+}
@@ -1,9 +1,6 @@
 package crowd
 
-import (
-	"encoding/json"
-	"fmt"
-)
+import "fmt"
 
 // User management
 
@@ -16,17 +13,7 @@ func (api *API) GetUser(userName string) (*User, error) {
 		"/rest/usermanagement/1/user?username=%s", urlEscape(userName),
 	)
 
-	status, result, err := api.doGetRequest(url)
-
-	if status == 200 {
-
-		err = json.Unmarshal(result, user)
-
-		if err != nil {
-			return nil, err
-		}
-
-	}
+	status, err := api.do(api.context(), "GET", url, nil, user)
 
 	switch status {
 	case 200:
@@ -34,7 +21,7 @@ func (api *API) GetUser(userName string) (*User, error) {
 	case 404:
 		return nil, ErrorUserNotFound
 	default:
-		return nil, unknownResponse(status)
+		return nil, responseError(status, err)
 	}
 
 }
@@ -58,11 +45,7 @@ func (api *API) AddUser(userName, userPassword, userFirstName, userLastName, use
 
 	url := "/rest/usermanagement/1/user"
 
-	status, err := api.doPostRequest(url, body)
-
-	if err != nil {
-		return err
-	}
+	status, err := api.do(api.context(), "POST", url, body, nil)
 
 	switch status {
 	case 201:
@@ -72,7 +55,7 @@ func (api *API) AddUser(userName, userPassword, userFirstName, userLastName, use
 	case 403:
 		return ErrorGeneralNoPermissions
 	default:
-		return unknownResponse(status)
+		return responseError(status, err)
 	}
 
 }
@@ -82,11 +65,7 @@ func (api *API) RemoveUser(userName string) error {
 
 	url := fmt.Sprintf("/rest/usermanagement/1/user?username=%s", urlEscape(userName))
 
-	status, err := api.doDeleteRequest(url)
-
-	if err != nil {
-		return err
-	}
+	status, err := api.do(api.context(), "DELETE", url, nil, nil)
 
 	switch status {
 	case 204:
@@ -96,7 +75,7 @@ func (api *API) RemoveUser(userName string) error {
 	case 404:
 		return ErrorUserNotFound
 	default:
-		return unknownResponse(status)
+		return responseError(status, err)
 	}
 
 }
@@ -130,23 +109,67 @@ func (api *API) UpdateUser(userName, userFirstName, userLastName, userDisplayNam
 
 	url := fmt.Sprintf("/rest/usermanagement/1/user?username=%s", urlEscape(userName))
 
-	status, err := api.doPutRequest(url, body)
+	status, err := api.do(api.context(), "PUT", url, body, nil)
 
-	if err != nil {
-		return err
+	switch status {
+	case 204:
+		return nil
+	case 400:
+		return ErrorInvalidUserDataOrMismatch
+	case 403:
+		return ErrorGeneralNoPermissions
+	case 404:
+		return ErrorUserNotFound
+	default:
+		return responseError(status, err)
+	}
+
+}
+
+// Rename a crowd user.
+func (api *API) RenameUser(userName, newUserName string) error {
+
+	body := UserRename{NewName: newUserName}
+
+	url := fmt.Sprintf("/rest/usermanagement/1/user/rename?username=%s", urlEscape(userName))
+
+	status, err := api.do(api.context(), "POST", url, body, nil)
+
+	switch status {
+	case 200:
+		return nil
+	case 400:
+		return ErrorInvalidUserDataOrUserExists
+	case 403:
+		return ErrorGeneralNoPermissions
+	case 404:
+		return ErrorUserNotFound
+	default:
+		return responseError(status, err)
 	}
 
+}
+
+// Set the password of a crowd user.
+func (api *API) SetUserPassword(userName, userPassword string) error {
+
+	body := PasswordValue{Value: userPassword}
+
+	url := fmt.Sprintf("/rest/usermanagement/1/user/password?username=%s", urlEscape(userName))
+
+	status, err := api.do(api.context(), "PUT", url, body, nil)
+
 	switch status {
 	case 204:
 		return nil
 	case 400:
-		return ErrorInvalidUserDataOrMismatch
+		return ErrorInvalidPassword
 	case 403:
 		return ErrorGeneralNoPermissions
 	case 404:
 		return ErrorUserNotFound
 	default:
-		return unknownResponse(status)
+		return responseError(status, err)
 	}
 
 }
@@ -160,17 +183,7 @@ func (api *API) GetUserAttributes(userName string) (*Attributes, error) {
 		"/rest/usermanagement/1/user/attribute?username=%s", urlEscape(userName),
 	)
 
-	status, result, err := api.doGetRequest(url)
-
-	if status == 200 {
-
-		err = json.Unmarshal(result, attributes)
-
-		if err != nil {
-			return nil, err
-		}
-
-	}
+	status, err := api.do(api.context(), "GET", url, nil, attributes)
 
 	switch status {
 	case 200:
@@ -178,7 +191,7 @@ func (api *API) GetUserAttributes(userName string) (*Attributes, error) {
 	case 404:
 		return nil, ErrorUserNotFound
 	default:
-		return nil, unknownResponse(status)
+		return nil, responseError(status, err)
 	}
 
 }
@@ -190,11 +203,7 @@ func (api *API) StoreUserAttributes(userName string, attributes *Attributes) err
 
 	url := fmt.Sprintf("/rest/usermanagement/1/user/attribute?username=%s", urlEscape(userName))
 
-	status, err := api.doPostRequest(url, body)
-
-	if err != nil {
-		return err
-	}
+	status, err := api.do(api.context(), "POST", url, body, nil)
 
 	switch status {
 	case 204:
@@ -202,9 +211,9 @@ func (api *API) StoreUserAttributes(userName string, attributes *Attributes) err
 	case 403:
 		return ErrorGeneralNoPermissions
 	case 404:
-		return ErrorGroupNotFound
+		return ErrorUserNotFound
 	default:
-		return unknownResponse(status)
+		return responseError(status, err)
 	}
 
 }
@@ -214,11 +223,7 @@ func (api *API) RemoveUserAttribute(userName, attributeName string) error {
 
 	url := fmt.Sprintf("/rest/usermanagement/1/user/attribute?username=%s&attributename=%s", urlEscape(userName), urlEscape(attributeName))
 
-	status, err := api.doDeleteRequest(url)
-
-	if err != nil {
-		return err
-	}
+	status, err := api.do(api.context(), "DELETE", url, nil, nil)
 
 	switch status {
 	case 204:
@@ -228,7 +233,74 @@ func (api *API) RemoveUserAttribute(userName, attributeName string) error {
 	case 404:
 		return ErrorUserNotFound
 	default:
-		return unknownResponse(status)
+		return responseError(status, err)
+	}
+
+}
+
+// Authenticate a crowd user with the given password.
+func (api *API) AuthenticateUser(userName, userPassword string) error {
+
+	body := PasswordValue{Value: userPassword}
+
+	url := fmt.Sprintf(
+		"/rest/usermanagement/1/authentication?username=%s",
+		urlEscape(userName),
+	)
+
+	status, err := api.do(api.context(), "POST", url, body, nil)
+
+	switch status {
+	case 200:
+		return nil
+	case 400:
+		return ErrorInvalidCredentials
+	case 403:
+		return ErrorGeneralNoPermissions
+	default:
+		return responseError(status, err)
+	}
+
+}
+
+// Get the groups a crowd user is a direct or nested member of.
+func (api *API) GetNestedGroupsForUser(userName string) (*Groups, error) {
+
+	url := fmt.Sprintf(
+		"/rest/usermanagement/1/user/group/nested?username=%s&expand=group",
+		urlEscape(userName),
+	)
+
+	groups, status, err := api.listGroups(url)
+
+	switch status {
+	case 200:
+		return groups, nil
+	case 404:
+		return nil, ErrorUserNotFound
+	default:
+		return nil, responseError(status, err)
+	}
+
+}
+
+// Get the groups a user is a direct member of.
+func (api *API) GetDirectGroupsForUser(userName string) (*Groups, error) {
+
+	url := fmt.Sprintf(
+		"/rest/usermanagement/1/user/group/direct?username=%s&expand=group",
+		urlEscape(userName),
+	)
+
+	groups, status, err := api.listGroups(url)
+
+	switch status {
+	case 200:
+		return groups, nil
+	case 404:
+		return nil, ErrorUserNotFound
+	default:
+		return nil, responseError(status, err)
 	}
 
 }
@@ -243,11 +315,7 @@ func (api *API) AddUserToGroup(userName, groupName string) error {
 		urlEscape(userName),
 	)
 
-	status, err := api.doPostRequest(url, body)
-
-	if err != nil {
-		return err
-	}
+	status, err := api.do(api.context(), "POST", url, body, nil)
 
 	switch status {
 	case 201:
@@ -261,7 +329,7 @@ func (api *API) AddUserToGroup(userName, groupName string) error {
 	case 409:
 		return ErrorUserAlreadyInGroup
 	default:
-		return unknownResponse(status)
+		return responseError(status, err)
 	}
 }
 
@@ -270,11 +338,7 @@ func (api *API) RemoveUserFromGroup(userName, groupName string) error {
 
 	url := fmt.Sprintf("/rest/usermanagement/1/user/group/direct?username=%s&groupname=%s", urlEscape(userName), urlEscape(groupName))
 
-	status, err := api.doDeleteRequest(url)
-
-	if err != nil {
-		return err
-	}
+	status, err := api.do(api.context(), "DELETE", url, nil, nil)
 
 	switch status {
 	case 204:
@@ -284,7 +348,7 @@ func (api *API) RemoveUserFromGroup(userName, groupName string) error {
 	case 404:
 		return ErrorUserNotFound
 	default:
-		return unknownResponse(status)
+		return responseError(status, err)
 	}
 
 }
@@ -303,11 +367,7 @@ func (api *API) CreateGroup(groupName, description string, isActive bool) error
 
 	url := "/rest/usermanagement/1/group"
 
-	status, err := api.doPostRequest(url, body)
-
-	if err != nil {
-		return err
-	}
+	status, err := api.do(api.context(), "POST", url, body, nil)
 
 	switch status {
 	case 201:
@@ -317,29 +377,201 @@ func (api *API) CreateGroup(groupName, description string, isActive bool) error
 	case 403:
 		return ErrorGeneralNoPermissions
 	default:
-		return unknownResponse(status)
+		return responseError(status, err)
 	}
 }
 
-// Remove a group.
-func (api *API) RemoveGroup(groupName string) error {
+// Update the description and the active state of a group.
+func (api *API) UpdateGroup(groupName, description string, isActive bool) error {
+
+	body := Group{
+		Name:        groupName,
+		Description: description,
+		Type:        "GROUP",
+		Active:      isActive,
+	}
 
 	url := fmt.Sprintf("/rest/usermanagement/1/group?groupname=%s", urlEscape(groupName))
 
-	status, err := api.doDeleteRequest(url)
+	status, err := api.do(api.context(), "PUT", url, body, nil)
 
-	if err != nil {
-		return err
+	switch status {
+	case 204:
+		return nil
+	case 403:
+		return ErrorGeneralNoPermissions
+	case 404:
+		return ErrorGroupNotFound
+	default:
+		return responseError(status, err)
 	}
 
+}
+
+// Get details of a group.
+func (api *API) GetGroup(groupName string) (*Group, error) {
+
+	group := &Group{}
+
+	url := fmt.Sprintf(
+		"/rest/usermanagement/1/group?groupname=%s", urlEscape(groupName),
+	)
+
+	status, err := api.do(api.context(), "GET", url, nil, group)
+
+	switch status {
+	case 200:
+		return group, nil
+	case 404:
+		return nil, ErrorGroupNotFound
+	default:
+		return nil, responseError(status, err)
+	}
+
+}
+
+// Get the attributes of a group.
+func (api *API) GetGroupAttributes(groupName string) (*Attributes, error) {
+
+	attributes := &Attributes{}
+
+	url := fmt.Sprintf(
+		"/rest/usermanagement/1/group/attribute?groupname=%s", urlEscape(groupName),
+	)
+
+	status, err := api.do(api.context(), "GET", url, nil, attributes)
+
+	switch status {
+	case 200:
+		return attributes, nil
+	case 404:
+		return nil, ErrorGroupNotFound
+	default:
+		return nil, responseError(status, err)
+	}
+
+}
+
+// Store (new) attributes for a group.
+func (api *API) StoreGroupAttributes(groupName string, attributes *Attributes) error {
+
+	body := attributes
+
+	url := fmt.Sprintf("/rest/usermanagement/1/group/attribute?groupname=%s", urlEscape(groupName))
+
+	status, err := api.do(api.context(), "POST", url, body, nil)
+
 	switch status {
 	case 204:
 		return nil
+	case 403:
+		return ErrorGeneralNoPermissions
 	case 404:
 		return ErrorGroupNotFound
 	default:
-		return unknownResponse(status)
+		return responseError(status, err)
 	}
+
+}
+
+// Remove an attribute from a group.
+func (api *API) RemoveGroupAttribute(groupName, attributeName string) error {
+
+	url := fmt.Sprintf("/rest/usermanagement/1/group/attribute?groupname=%s&attributename=%s", urlEscape(groupName), urlEscape(attributeName))
+
+	status, err := api.do(api.context(), "DELETE", url, nil, nil)
+
+	switch status {
+	case 204:
+		return nil
+	case 403:
+		return ErrorGeneralNoPermissions
+	case 404:
+		return ErrorGroupNotFound
+	default:
+		return responseError(status, err)
+	}
+
+}
+
+// Remove a group.
+func (api *API) RemoveGroup(groupName string) error {
+
+	url := fmt.Sprintf("/rest/usermanagement/1/group?groupname=%s", urlEscape(groupName))
+
+	status, err := api.do(api.context(), "DELETE", url, nil, nil)
+
+	switch status {
+	case 204:
+		return nil
+	case 404:
+		return ErrorGroupNotFound
+	default:
+		return responseError(status, err)
+	}
+}
+
+// Get the direct members of a group.
+func (api *API) GetGroupMembers(groupName string) (*Users, error) {
+
+	url := fmt.Sprintf(
+		"/rest/usermanagement/1/group/user/direct?groupname=%s&expand=user",
+		urlEscape(groupName),
+	)
+
+	users, status, err := api.listUsers(url)
+
+	switch status {
+	case 200:
+		return users, nil
+	case 404:
+		return nil, ErrorGroupNotFound
+	default:
+		return nil, responseError(status, err)
+	}
+
+}
+
+// Get the direct and nested members of a group.
+func (api *API) GetNestedGroupMembers(groupName string) (*Users, error) {
+
+	url := fmt.Sprintf(
+		"/rest/usermanagement/1/group/user/nested?groupname=%s&expand=user",
+		urlEscape(groupName),
+	)
+
+	users, status, err := api.listUsers(url)
+
+	switch status {
+	case 200:
+		return users, nil
+	case 404:
+		return nil, ErrorGroupNotFound
+	default:
+		return nil, responseError(status, err)
+	}
+
+}
+
+// Get the direct child groups of a group.
+func (api *API) GetChildGroups(groupName string) (*Groups, error) {
+
+	url := fmt.Sprintf(
+		"/rest/usermanagement/1/group/child-group/direct?groupname=%s&expand=group",
+		urlEscape(groupName),
+	)
+
+	groups, status, err := api.listGroups(url)
+
+	switch status {
+	case 200:
+		return groups, nil
+	case 404:
+		return nil, ErrorGroupNotFound
+	default:
+		return nil, responseError(status, err)
+	}
+
 }
 
 // Add a new child group membership.
@@ -351,11 +583,7 @@ func (api *API) AddChildGroupMembership(parentGroupName, childGroupName string)
 
 	url := fmt.Sprintf("/rest/usermanagement/1/group/child-group/direct?groupname=%s", urlEscape(parentGroupName))
 
-	status, err := api.doPostRequest(url, body)
-
-	if err != nil {
-		return err
-	}
+	status, err := api.do(api.context(), "POST", url, body, nil)
 
 	switch status {
 	case 201:
@@ -365,7 +593,31 @@ func (api *API) AddChildGroupMembership(parentGroupName, childGroupName string)
 	case 404:
 		return ErrorGroupNotFound
 	default:
-		return unknownResponse(status)
+		return responseError(status, err)
+	}
+
+}
+
+// Remove a child group membership. Returns ErrorGroupNotFound if either group
+// or the membership does not exist.
+func (api *API) RemoveChildGroupMembership(parentGroupName, childGroupName string) error {
+
+	url := fmt.Sprintf(
+		"/rest/usermanagement/1/group/child-group/direct?groupname=%s&child-groupname=%s",
+		urlEscape(parentGroupName), urlEscape(childGroupName),
+	)
+
+	status, err := api.do(api.context(), "DELETE", url, nil, nil)
+
+	switch status {
+	case 204:
+		return nil
+	case 403:
+		return ErrorGeneralNoPermissions
+	case 404:
+		return ErrorGroupNotFound
+	default:
+		return responseError(status, err)
 	}
 
 }
@@ -379,11 +631,7 @@ func (api *API) AddParentGroupMembership(parentGroupName, childGroupName string)
 
 	url := fmt.Sprintf("/rest/usermanagement/1/group/parent-group/direct?groupname=%s", urlEscape(childGroupName))
 
-	status, err := api.doPostRequest(url, body)
-
-	if err != nil {
-		return err
-	}
+	status, err := api.do(api.context(), "POST", url, body, nil)
 
 	switch status {
 	case 201:
@@ -393,7 +641,271 @@ func (api *API) AddParentGroupMembership(parentGroupName, childGroupName string)
 	case 404:
 		return ErrorGroupNotFound
 	default:
-		return unknownResponse(status)
+		return responseError(status, err)
 	}
 
-}
\ No newline at end of file
+}
+
+// Search
+
+// Search users matching a restriction in crowd query language, for example
+// `email = "*@example.com" and active = true`. An empty restriction matches
+// all users. Returns at most maxResults users, starting at startIndex.
+func (api *API) SearchUsers(restriction string, startIndex, maxResults int) (*Users, error) {
+
+	users := &Users{}
+
+	url := fmt.Sprintf(
+		"/rest/usermanagement/1/search?entity-type=user&expand=user&restriction=%s&start-index=%d&max-results=%d",
+		urlEscape(restriction), startIndex, maxResults,
+	)
+
+	status, err := api.do(api.context(), "GET", url, nil, users)
+
+	switch status {
+	case 200:
+		return users, nil
+	case 400:
+		return nil, ErrorInvalidSearchRestriction
+	default:
+		return nil, responseError(status, err)
+	}
+
+}
+
+// Search groups matching a restriction in crowd query language, for example
+// `name = "dev-*"`. An empty restriction matches all groups. Returns at most
+// maxResults groups, starting at startIndex.
+func (api *API) SearchGroups(restriction string, startIndex, maxResults int) (*Groups, error) {
+
+	groups := &Groups{}
+
+	url := fmt.Sprintf(
+		"/rest/usermanagement/1/search?entity-type=group&expand=group&restriction=%s&start-index=%d&max-results=%d",
+		urlEscape(restriction), startIndex, maxResults,
+	)
+
+	status, err := api.do(api.context(), "GET", url, nil, groups)
+
+	switch status {
+	case 200:
+		return groups, nil
+	case 400:
+		return nil, ErrorInvalidSearchRestriction
+	default:
+		return nil, responseError(status, err)
+	}
+
+}
+
+// Number of entities requested per page of a listing.
+const listPageSize = 1000
+
+// Get all users of a paged listing. Returns the status of the last request.
+func (api *API) listUsers(url string) (*Users, int, error) {
+
+	users := &Users{Users: []*User{}}
+
+	for startIndex := 0; ; startIndex += listPageSize {
+
+		page := &Users{}
+
+		status, err := api.do(api.context(), "GET", fmt.Sprintf("%s&start-index=%d&max-results=%d", url, startIndex, listPageSize), nil, page)
+
+		if status != 200 {
+			return nil, status, err
+		}
+
+		users.Users = append(users.Users, page.Users...)
+
+		if len(page.Users) < listPageSize {
+			return users, status, nil
+		}
+
+	}
+
+}
+
+// Get all groups of a paged listing. Returns the status of the last request.
+func (api *API) listGroups(url string) (*Groups, int, error) {
+
+	groups := &Groups{Groups: []*Group{}}
+
+	for startIndex := 0; ; startIndex += listPageSize {
+
+		page := &Groups{}
+
+		status, err := api.do(api.context(), "GET", fmt.Sprintf("%s&start-index=%d&max-results=%d", url, startIndex, listPageSize), nil, page)
+
+		if status != 200 {
+			return nil, status, err
+		}
+
+		groups.Groups = append(groups.Groups, page.Groups...)
+
+		if len(page.Groups) < listPageSize {
+			return groups, status, nil
+		}
+
+	}
+
+}
+
+// Events
+
+// Get a token for the current position in the crowd event feed.
+func (api *API) GetEventToken() (string, error) {
+
+	events := &Events{}
+
+	url := "/rest/usermanagement/1/event"
+
+	status, err := api.do(api.context(), "GET", url, nil, events)
+
+	switch status {
+	case 200:
+		return events.NewEventToken, nil
+	case 400:
+		return "", ErrorEventsNotAvailable
+	case 401, 403:
+		return "", ErrorGeneralNoPermissions
+	default:
+		return "", responseError(status, err)
+	}
+
+}
+
+// Get the events which happened since the given event token was issued.
+func (api *API) GetEvents(eventToken string) (*Events, error) {
+
+	events := &Events{}
+
+	url := fmt.Sprintf("/rest/usermanagement/1/event/%s", pathEscape(eventToken))
+
+	status, err := api.do(api.context(), "GET", url, nil, events)
+
+	switch status {
+	case 200:
+		return events, nil
+	case 400:
+		return nil, ErrorEventTokenExpired
+	case 401, 403:
+		return nil, ErrorGeneralNoPermissions
+	default:
+		return nil, responseError(status, err)
+	}
+
+}
+
+// Sessions
+
+// Authenticate a crowd user and create a single sign-on session, bound to the
+// given validation factors, which may be nil.
+func (api *API) CreateSession(userName, userPassword string, validationFactors *ValidationFactors) (*Session, error) {
+
+	session := &Session{}
+
+	body := authenticationContext{
+		UserName:          userName,
+		Password:          userPassword,
+		ValidationFactors: validationFactors,
+	}
+
+	url := "/rest/usermanagement/1/session"
+
+	status, err := api.do(api.context(), "POST", url, body, session)
+
+	switch status {
+	case 201:
+		return session, nil
+	case 400:
+		return nil, ErrorInvalidCredentials
+	case 403:
+		return nil, ErrorGeneralNoPermissions
+	default:
+		return nil, responseError(status, err)
+	}
+
+}
+
+// Get a session without validating it.
+func (api *API) GetSession(token string) (*Session, error) {
+
+	session := &Session{}
+
+	url := fmt.Sprintf("/rest/usermanagement/1/session/%s", pathEscape(token))
+
+	status, err := api.do(api.context(), "GET", url, nil, session)
+
+	switch status {
+	case 200:
+		return session, nil
+	case 404:
+		return nil, ErrorSessionNotFound
+	default:
+		return nil, responseError(status, err)
+	}
+
+}
+
+// Validate a session against the given validation factors, which extends it.
+func (api *API) ValidateSession(token string, validationFactors *ValidationFactors) (*Session, error) {
+
+	session := &Session{}
+
+	if validationFactors == nil {
+		validationFactors = &ValidationFactors{ValidationFactors: []*ValidationFactor{}}
+	}
+
+	url := fmt.Sprintf("/rest/usermanagement/1/session/%s", pathEscape(token))
+
+	status, err := api.do(api.context(), "POST", url, validationFactors, session)
+
+	switch status {
+	case 200:
+		return session, nil
+	case 400:
+		return nil, ErrorInvalidValidationFactors
+	case 404:
+		return nil, ErrorSessionNotFound
+	default:
+		return nil, responseError(status, err)
+	}
+
+}
+
+// Invalidate a session. Invalidating an unknown session is not an error.
+func (api *API) InvalidateSession(token string) error {
+
+	url := fmt.Sprintf("/rest/usermanagement/1/session/%s", pathEscape(token))
+
+	status, err := api.do(api.context(), "DELETE", url, nil, nil)
+
+	switch status {
+	case 204:
+		return nil
+	case 403:
+		return ErrorGeneralNoPermissions
+	default:
+		return responseError(status, err)
+	}
+
+}
+
+// Get the single sign-on cookie configuration.
+func (api *API) GetCookieConfig() (*CookieConfig, error) {
+
+	config := &CookieConfig{}
+
+	url := "/rest/usermanagement/1/config/cookie"
+
+	status, err := api.do(api.context(), "GET", url, nil, config)
+
+	switch status {
+	case 200:
+		return config, nil
+	default:
+		return nil, responseError(status, err)
+	}
+
+}
@@ -280,6 +280,84 @@ func TestAPI_RemoveUserFromGroup(t *testing.T) {
 
 }
 
+func TestAPI_AuthenticateUser(t *testing.T) {
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		assert.Equal(t, "POST", r.Method)
+		assert.Equal(t, "/rest/usermanagement/1/authentication?username=testuser", r.RequestURI)
+
+		body := new(bytes.Buffer)
+		_, err := body.ReadFrom(r.Body)
+		content := &PasswordValue{}
+		err = json.Unmarshal(body.Bytes(), content)
+
+		assert.Nil(t, err)
+
+		if content.Value != "secret" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		respBytes, err := json.Marshal(User{Name: "testuser"})
+
+		if err != nil {
+			http.Error(w, string(respBytes), http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		w.Write(respBytes)
+
+	}))
+	defer server.Close()
+
+	api, err := NewAPI(server.URL, "testapp", "password")
+
+	assert.Nil(t, err)
+
+	err = api.AuthenticateUser("testuser", "secret")
+
+	assert.Nil(t, err)
+
+	err = api.AuthenticateUser("testuser", "wrong")
+
+	assert.Equal(t, ErrorInvalidCredentials, err)
+
+}
+
+func TestAPI_GetNestedGroupsForUser(t *testing.T) {
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		assert.Equal(t, "GET", r.Method)
+		assert.Equal(t, "/rest/usermanagement/1/user/group/nested?username=testuser&expand=group&start-index=0&max-results=1000", r.RequestURI)
+
+		resp := Groups{Groups: []*Group{{Name: "testgroup", Type: "GROUP"}}}
+		respBytes, err := json.Marshal(resp)
+
+		if err != nil {
+			http.Error(w, string(respBytes), http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		w.Write(respBytes)
+
+	}))
+	defer server.Close()
+
+	api, err := NewAPI(server.URL, "testapp", "password")
+
+	assert.Nil(t, err)
+
+	res, err := api.GetNestedGroupsForUser("testuser")
+
+	assert.Nil(t, err)
+	assert.Equal(t, &Groups{Groups: []*Group{{Name: "testgroup", Type: "GROUP"}}}, res)
+
+}
+
 // Groupmanagement
 
 func TestAPI_CreateGroup(t *testing.T) {
@@ -319,6 +397,38 @@ func TestAPI_CreateGroup(t *testing.T) {
 
 }
 
+func TestAPI_GetGroup(t *testing.T) {
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		assert.Equal(t, "GET", r.Method)
+		assert.Equal(t, "/rest/usermanagement/1/group?groupname=testgroup", r.RequestURI)
+
+		resp := Group{Name: "testgroup", Type: "GROUP"}
+		respBytes, err := json.Marshal(resp)
+
+		if err != nil {
+			http.Error(w, string(respBytes), http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		w.Write(respBytes)
+
+	}))
+	defer server.Close()
+
+	api, err := NewAPI(server.URL, "testapp", "password")
+
+	assert.Nil(t, err)
+
+	res, err := api.GetGroup("testgroup")
+
+	assert.Nil(t, err)
+	assert.Equal(t, &Group{Name: "testgroup", Type: "GROUP"}, res)
+
+}
+
 func TestAPI_RemoveGroup(t *testing.T) {
 
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -413,4 +523,623 @@ func TestAPI_AddParentGroupMembership(t *testing.T) {
 
 	assert.Nil(t, err)
 
-}
\ No newline at end of file
+}
+// Events
+
+func TestAPI_GetEventToken(t *testing.T) {
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		assert.Equal(t, "GET", r.Method)
+		assert.Equal(t, "/rest/usermanagement/1/event", r.RequestURI)
+
+		resp := Events{NewEventToken: "testtoken"}
+		respBytes, err := json.Marshal(resp)
+
+		if err != nil {
+			http.Error(w, string(respBytes), http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		w.Write(respBytes)
+
+	}))
+	defer server.Close()
+
+	api, err := NewAPI(server.URL, "testapp", "password")
+
+	assert.Nil(t, err)
+
+	res, err := api.GetEventToken()
+
+	assert.Nil(t, err)
+	assert.Equal(t, "testtoken", res)
+
+}
+
+func TestAPI_GetEvents(t *testing.T) {
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		assert.Equal(t, "GET", r.Method)
+
+		if r.RequestURI == "/rest/usermanagement/1/event/expiredtoken" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		assert.Equal(t, "/rest/usermanagement/1/event/testtoken", r.RequestURI)
+
+		resp := Events{NewEventToken: "newtoken", Events: []*Event{{Operation: "DELETED", User: &User{Name: "testuser"}}}}
+		respBytes, err := json.Marshal(resp)
+
+		if err != nil {
+			http.Error(w, string(respBytes), http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		w.Write(respBytes)
+
+	}))
+	defer server.Close()
+
+	api, err := NewAPI(server.URL, "testapp", "password")
+
+	assert.Nil(t, err)
+
+	res, err := api.GetEvents("testtoken")
+
+	assert.Nil(t, err)
+	assert.Equal(t, &Events{NewEventToken: "newtoken", Events: []*Event{{Operation: "DELETED", User: &User{Name: "testuser"}}}}, res)
+
+	_, err = api.GetEvents("expiredtoken")
+
+	assert.Equal(t, ErrorEventTokenExpired, err)
+
+}
+
+func TestAPI_CreateSession(t *testing.T) {
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		assert.Equal(t, "POST", r.Method)
+		assert.Equal(t, "/rest/usermanagement/1/session", r.RequestURI)
+
+		body := authenticationContext{}
+		err := json.NewDecoder(r.Body).Decode(&body)
+
+		assert.Nil(t, err)
+
+		if body.Password != "password" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		assert.Equal(t, &ValidationFactors{ValidationFactors: []*ValidationFactor{{Name: "remote_address", Value: "127.0.0.1"}}}, body.ValidationFactors)
+
+		respBytes, err := json.Marshal(Session{Token: "testtoken", User: &User{Name: body.UserName}})
+
+		assert.Nil(t, err)
+
+		w.WriteHeader(http.StatusCreated)
+		w.Write(respBytes)
+
+	}))
+	defer server.Close()
+
+	api, err := NewAPI(server.URL, "testapp", "password")
+
+	assert.Nil(t, err)
+
+	factors := &ValidationFactors{ValidationFactors: []*ValidationFactor{{Name: "remote_address", Value: "127.0.0.1"}}}
+
+	session, err := api.CreateSession("testuser", "password", factors)
+
+	assert.Nil(t, err)
+	assert.Equal(t, &Session{Token: "testtoken", User: &User{Name: "testuser"}}, session)
+
+	_, err = api.CreateSession("testuser", "wrong", factors)
+
+	assert.Equal(t, ErrorInvalidCredentials, err)
+
+}
+
+func TestAPI_GetSession(t *testing.T) {
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		assert.Equal(t, "GET", r.Method)
+
+		if r.RequestURI != "/rest/usermanagement/1/session/testtoken" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		respBytes, err := json.Marshal(Session{Token: "testtoken"})
+
+		assert.Nil(t, err)
+
+		w.WriteHeader(http.StatusOK)
+		w.Write(respBytes)
+
+	}))
+	defer server.Close()
+
+	api, err := NewAPI(server.URL, "testapp", "password")
+
+	assert.Nil(t, err)
+
+	session, err := api.GetSession("testtoken")
+
+	assert.Nil(t, err)
+	assert.Equal(t, &Session{Token: "testtoken"}, session)
+
+	_, err = api.GetSession("othertoken")
+
+	assert.Equal(t, ErrorSessionNotFound, err)
+
+}
+
+func TestAPI_ValidateSession(t *testing.T) {
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		assert.Equal(t, "POST", r.Method)
+
+		body := ValidationFactors{}
+		err := json.NewDecoder(r.Body).Decode(&body)
+
+		assert.Nil(t, err)
+
+		switch {
+		case r.RequestURI != "/rest/usermanagement/1/session/testtoken":
+			w.WriteHeader(http.StatusNotFound)
+		case len(body.ValidationFactors) > 0:
+			w.WriteHeader(http.StatusBadRequest)
+		default:
+			respBytes, err := json.Marshal(Session{Token: "testtoken"})
+
+			assert.Nil(t, err)
+
+			w.WriteHeader(http.StatusOK)
+			w.Write(respBytes)
+		}
+
+	}))
+	defer server.Close()
+
+	api, err := NewAPI(server.URL, "testapp", "password")
+
+	assert.Nil(t, err)
+
+	session, err := api.ValidateSession("testtoken", nil)
+
+	assert.Nil(t, err)
+	assert.Equal(t, &Session{Token: "testtoken"}, session)
+
+	_, err = api.ValidateSession("testtoken", &ValidationFactors{ValidationFactors: []*ValidationFactor{{Name: "remote_address", Value: "10.0.0.1"}}})
+
+	assert.Equal(t, ErrorInvalidValidationFactors, err)
+
+	_, err = api.ValidateSession("othertoken", nil)
+
+	assert.Equal(t, ErrorSessionNotFound, err)
+
+}
+
+func TestAPI_InvalidateSession(t *testing.T) {
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		assert.Equal(t, "DELETE", r.Method)
+		assert.Equal(t, "/rest/usermanagement/1/session/testtoken", r.RequestURI)
+
+		w.WriteHeader(http.StatusNoContent)
+
+	}))
+	defer server.Close()
+
+	api, err := NewAPI(server.URL, "testapp", "password")
+
+	assert.Nil(t, err)
+
+	err = api.InvalidateSession("testtoken")
+
+	assert.Nil(t, err)
+
+}
+
+func TestAPI_GetCookieConfig(t *testing.T) {
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		assert.Equal(t, "GET", r.Method)
+		assert.Equal(t, "/rest/usermanagement/1/config/cookie", r.RequestURI)
+
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`{"domain":".example.com","secure":true,"name":"crowd.token_key"}`))
+
+	}))
+	defer server.Close()
+
+	api, err := NewAPI(server.URL, "testapp", "password")
+
+	assert.Nil(t, err)
+
+	config, err := api.GetCookieConfig()
+
+	assert.Nil(t, err)
+	assert.Equal(t, &CookieConfig{Domain: ".example.com", Secure: true, Name: "crowd.token_key"}, config)
+
+}
+
+func TestAPI_RenameUser(t *testing.T) {
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		assert.Equal(t, "POST", r.Method)
+		assert.Equal(t, "/rest/usermanagement/1/user/rename?username=testuser", r.RequestURI)
+
+		body := UserRename{}
+		err := json.NewDecoder(r.Body).Decode(&body)
+
+		assert.Nil(t, err)
+
+		if body.NewName == "existinguser" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`{"name":"` + body.NewName + `"}`))
+
+	}))
+	defer server.Close()
+
+	api, err := NewAPI(server.URL, "testapp", "password")
+
+	assert.Nil(t, err)
+
+	err = api.RenameUser("testuser", "newuser")
+
+	assert.Nil(t, err)
+
+	err = api.RenameUser("testuser", "existinguser")
+
+	assert.Equal(t, ErrorInvalidUserDataOrUserExists, err)
+
+}
+
+func TestAPI_SetUserPassword(t *testing.T) {
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		assert.Equal(t, "PUT", r.Method)
+		assert.Equal(t, "/rest/usermanagement/1/user/password?username=testuser", r.RequestURI)
+
+		body := PasswordValue{}
+		err := json.NewDecoder(r.Body).Decode(&body)
+
+		assert.Nil(t, err)
+
+		if body.Value == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+
+	}))
+	defer server.Close()
+
+	api, err := NewAPI(server.URL, "testapp", "password")
+
+	assert.Nil(t, err)
+
+	err = api.SetUserPassword("testuser", "newpassword")
+
+	assert.Nil(t, err)
+
+	err = api.SetUserPassword("testuser", "")
+
+	assert.Equal(t, ErrorInvalidPassword, err)
+
+}
+
+func TestAPI_GetGroupMembers(t *testing.T) {
+
+	pages := 0
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		assert.Equal(t, "GET", r.Method)
+		assert.Equal(t, "/rest/usermanagement/1/group/user/direct", r.URL.Path)
+
+		if r.URL.Query().Get("groupname") != "testgroup" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		pages++
+
+		// A full first page and a partial second page.
+		resp := Users{Users: []*User{}}
+		count := listPageSize
+
+		if r.URL.Query().Get("start-index") != "0" {
+			count = 1
+		}
+
+		for i := 0; i < count; i++ {
+			resp.Users = append(resp.Users, &User{Name: "testuser"})
+		}
+
+		respBytes, err := json.Marshal(resp)
+
+		assert.Nil(t, err)
+
+		w.WriteHeader(http.StatusOK)
+		w.Write(respBytes)
+
+	}))
+	defer server.Close()
+
+	api, err := NewAPI(server.URL, "testapp", "password")
+
+	assert.Nil(t, err)
+
+	users, err := api.GetGroupMembers("testgroup")
+
+	assert.Nil(t, err)
+	assert.Len(t, users.Users, listPageSize+1)
+	assert.Equal(t, 2, pages)
+
+	_, err = api.GetGroupMembers("othergroup")
+
+	assert.Equal(t, ErrorGroupNotFound, err)
+
+}
+
+func TestAPI_SearchUsers(t *testing.T) {
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		assert.Equal(t, "GET", r.Method)
+		assert.Equal(t, "/rest/usermanagement/1/search", r.URL.Path)
+		assert.Equal(t, "user", r.URL.Query().Get("entity-type"))
+		assert.Equal(t, "10", r.URL.Query().Get("max-results"))
+
+		if r.URL.Query().Get("restriction") != `email = "*@example.com"` {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`{"users":[{"name":"testuser"}]}`))
+
+	}))
+	defer server.Close()
+
+	api, err := NewAPI(server.URL, "testapp", "password")
+
+	assert.Nil(t, err)
+
+	users, err := api.SearchUsers(`email = "*@example.com"`, 0, 10)
+
+	assert.Nil(t, err)
+	assert.Equal(t, &Users{Users: []*User{{Name: "testuser"}}}, users)
+
+	_, err = api.SearchUsers("invalid", 0, 10)
+
+	assert.Equal(t, ErrorInvalidSearchRestriction, err)
+
+}
+
+func TestAPI_GetDirectGroupsForUser(t *testing.T) {
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		assert.Equal(t, "GET", r.Method)
+		assert.Equal(t, "/rest/usermanagement/1/user/group/direct?username=testuser&expand=group&start-index=0&max-results=1000", r.RequestURI)
+
+		resp := Groups{Groups: []*Group{{Name: "testgroup"}}}
+		respBytes, err := json.Marshal(resp)
+
+		assert.Nil(t, err)
+
+		w.WriteHeader(http.StatusOK)
+		w.Write(respBytes)
+
+	}))
+	defer server.Close()
+
+	api, err := NewAPI(server.URL, "testapp", "password")
+
+	assert.Nil(t, err)
+
+	groups, err := api.GetDirectGroupsForUser("testuser")
+
+	assert.Nil(t, err)
+	assert.Equal(t, &Groups{Groups: []*Group{{Name: "testgroup"}}}, groups)
+
+}
+
+func TestAPI_GetGroupAttributes(t *testing.T) {
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		assert.Equal(t, "GET", r.Method)
+		assert.Equal(t, "/rest/usermanagement/1/group/attribute", r.URL.Path)
+
+		if r.URL.Query().Get("groupname") != "testgroup" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		resp := Attributes{Attributes: []*Attribute{{Name: "team", Values: []string{"a"}}}}
+		respBytes, err := json.Marshal(resp)
+
+		assert.Nil(t, err)
+
+		w.WriteHeader(http.StatusOK)
+		w.Write(respBytes)
+
+	}))
+	defer server.Close()
+
+	api, err := NewAPI(server.URL, "testapp", "password")
+
+	assert.Nil(t, err)
+
+	attributes, err := api.GetGroupAttributes("testgroup")
+
+	assert.Nil(t, err)
+	assert.Equal(t, &Attributes{Attributes: []*Attribute{{Name: "team", Values: []string{"a"}}}}, attributes)
+
+	_, err = api.GetGroupAttributes("othergroup")
+
+	assert.Equal(t, ErrorGroupNotFound, err)
+
+}
+
+func TestAPI_StoreGroupAttributes(t *testing.T) {
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		assert.Equal(t, "POST", r.Method)
+		assert.Equal(t, "/rest/usermanagement/1/group/attribute?groupname=testgroup", r.RequestURI)
+
+		content := &Attributes{}
+
+		assert.Nil(t, json.NewDecoder(r.Body).Decode(content))
+		assert.Equal(t, "team", content.Attributes[0].Name)
+
+		w.WriteHeader(http.StatusNoContent)
+
+	}))
+	defer server.Close()
+
+	api, err := NewAPI(server.URL, "testapp", "password")
+
+	assert.Nil(t, err)
+
+	err = api.StoreGroupAttributes("testgroup", &Attributes{Attributes: []*Attribute{{Name: "team", Values: []string{"a"}}}})
+
+	assert.Nil(t, err)
+
+}
+
+func TestAPI_GetChildGroups(t *testing.T) {
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		assert.Equal(t, "GET", r.Method)
+		assert.Equal(t, "/rest/usermanagement/1/group/child-group/direct", r.URL.Path)
+
+		if r.URL.Query().Get("groupname") != "testgroup" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		resp := Groups{Groups: []*Group{{Name: "childgroup"}}}
+		respBytes, err := json.Marshal(resp)
+
+		assert.Nil(t, err)
+
+		w.WriteHeader(http.StatusOK)
+		w.Write(respBytes)
+
+	}))
+	defer server.Close()
+
+	api, err := NewAPI(server.URL, "testapp", "password")
+
+	assert.Nil(t, err)
+
+	groups, err := api.GetChildGroups("testgroup")
+
+	assert.Nil(t, err)
+	assert.Equal(t, "childgroup", groups.Groups[0].Name)
+
+	_, err = api.GetChildGroups("othergroup")
+
+	assert.Equal(t, ErrorGroupNotFound, err)
+
+}
+
+func TestAPI_UpdateGroup(t *testing.T) {
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		assert.Equal(t, "PUT", r.Method)
+		assert.Equal(t, "/rest/usermanagement/1/group", r.URL.Path)
+
+		if r.URL.Query().Get("groupname") != "testgroup" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		content := &Group{}
+
+		assert.Nil(t, json.NewDecoder(r.Body).Decode(content))
+		assert.Equal(t, &Group{Name: "testgroup", Description: "Test group", Type: "GROUP", Active: false}, content)
+
+		w.WriteHeader(http.StatusNoContent)
+
+	}))
+	defer server.Close()
+
+	api, err := NewAPI(server.URL, "testapp", "password")
+
+	assert.Nil(t, err)
+	assert.Nil(t, api.UpdateGroup("testgroup", "Test group", false))
+	assert.Equal(t, ErrorGroupNotFound, api.UpdateGroup("othergroup", "", true))
+
+}
+
+func TestAPI_RemoveGroupAttribute(t *testing.T) {
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		assert.Equal(t, "DELETE", r.Method)
+		assert.Equal(t, "/rest/usermanagement/1/group/attribute?groupname=testgroup&attributename=owner", r.RequestURI)
+
+		w.WriteHeader(http.StatusNoContent)
+
+	}))
+	defer server.Close()
+
+	api, err := NewAPI(server.URL, "testapp", "password")
+
+	assert.Nil(t, err)
+	assert.Nil(t, api.RemoveGroupAttribute("testgroup", "owner"))
+
+}
+
+func TestAPI_RemoveChildGroupMembership(t *testing.T) {
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		assert.Equal(t, "DELETE", r.Method)
+		assert.Equal(t, "/rest/usermanagement/1/group/child-group/direct", r.URL.Path)
+		assert.Equal(t, "parentgroup", r.URL.Query().Get("groupname"))
+
+		if r.URL.Query().Get("child-groupname") != "childgroup" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+
+	}))
+	defer server.Close()
+
+	api, err := NewAPI(server.URL, "testapp", "password")
+
+	assert.Nil(t, err)
+	assert.Nil(t, api.RemoveChildGroupMembership("parentgroup", "childgroup"))
+	assert.Equal(t, ErrorGroupNotFound, api.RemoveChildGroupMembership("parentgroup", "othergroup"))
+
+}
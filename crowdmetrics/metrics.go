@@ -0,0 +1,192 @@
+// Package crowdmetrics exposes Prometheus metrics for crowd API clients.
+//
+//	collector := crowdmetrics.NewCollector("")
+//	prometheus.MustRegister(collector)
+//
+//	api, err := crowd.NewAPI(url, application, password, crowd.WithObserver(collector))
+package crowdmetrics
+
+import (
+	"context"
+	"errors"
+	"github.com/agile-rcm/crowd-go"
+	"github.com/prometheus/client_golang/prometheus"
+	"net"
+	"strconv"
+	"sync"
+)
+
+// Error classes used to label requests.
+const (
+	ErrorClassNone        = "none"
+	ErrorClassClient      = "client"
+	ErrorClassServer      = "server"
+	ErrorClassUnavailable = "unavailable"
+	ErrorClassTimeout     = "timeout"
+	ErrorClassCanceled    = "canceled"
+	ErrorClassTransport   = "transport"
+)
+
+// Collects metrics of crowd requests. It is used as crowd.Observer and
+// registered as prometheus.Collector.
+type Collector struct {
+	requests    *prometheus.CounterVec
+	duration    *prometheus.HistogramVec
+	cacheDesc   *prometheus.Desc
+	breakerDesc *prometheus.Desc
+
+	mu      sync.Mutex
+	caches  []*crowd.CachedAPI
+	breaker *crowd.CircuitBreaker
+}
+
+// Create a new collector. The namespace prefixes all metric names and
+// defaults to "crowd_client".
+func NewCollector(namespace string) *Collector {
+
+	if namespace == "" {
+		namespace = "crowd_client"
+	}
+
+	labels := []string{"operation", "status", "error"}
+
+	return &Collector{
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "requests_total",
+			Help:      "Number of requests to crowd.",
+		}, labels),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "request_duration_seconds",
+			Help:      "Duration of requests to crowd, including time spent waiting for the rate limiter.",
+			Buckets:   prometheus.DefBuckets,
+		}, labels),
+		cacheDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "cache", "lookups_total"),
+			"Number of lookups in the caches of a CachedAPI.",
+			[]string{"result"}, nil,
+		),
+		breakerDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "circuit_breaker", "state"),
+			"State of the circuit breaker, 1 for the current state and 0 otherwise.",
+			[]string{"state"}, nil,
+		),
+	}
+
+}
+
+// Record a finished request, implements crowd.Observer.
+func (c *Collector) ObserveRequest(info crowd.RequestInfo) {
+
+	labels := prometheus.Labels{
+		"operation": info.Operation,
+		"status":    strconv.Itoa(info.Status),
+		"error":     ErrorClass(info),
+	}
+
+	c.requests.With(labels).Inc()
+	c.duration.With(labels).Observe(info.Duration.Seconds())
+
+}
+
+// Export the hit and miss counters of a cached API. With several caches the
+// counters are summed up.
+func (c *Collector) MonitorCache(cache *crowd.CachedAPI) {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.caches = append(c.caches, cache)
+
+}
+
+// Export the state of a circuit breaker.
+func (c *Collector) MonitorBreaker(breaker *crowd.CircuitBreaker) {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.breaker = breaker
+
+}
+
+// Implements prometheus.Collector.
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+
+	c.requests.Describe(ch)
+	c.duration.Describe(ch)
+	ch <- c.cacheDesc
+	ch <- c.breakerDesc
+
+}
+
+// Implements prometheus.Collector.
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+
+	c.requests.Collect(ch)
+	c.duration.Collect(ch)
+
+	c.mu.Lock()
+	caches := c.caches
+	breaker := c.breaker
+	c.mu.Unlock()
+
+	if len(caches) > 0 {
+
+		var stats crowd.CacheStats
+
+		for _, cache := range caches {
+			cacheStats := cache.Stats()
+			stats.Hits += cacheStats.Hits
+			stats.Misses += cacheStats.Misses
+		}
+
+		ch <- prometheus.MustNewConstMetric(c.cacheDesc, prometheus.CounterValue, float64(stats.Hits), "hit")
+		ch <- prometheus.MustNewConstMetric(c.cacheDesc, prometheus.CounterValue, float64(stats.Misses), "miss")
+
+	}
+
+	if breaker != nil {
+
+		current := breaker.State()
+
+		for _, state := range []crowd.BreakerState{crowd.BreakerClosed, crowd.BreakerOpen, crowd.BreakerHalfOpen} {
+
+			value := 0.0
+
+			if state == current {
+				value = 1
+			}
+
+			ch <- prometheus.MustNewConstMetric(c.breakerDesc, prometheus.GaugeValue, value, state.String())
+
+		}
+
+	}
+
+}
+
+// Classify the outcome of a request.
+func ErrorClass(info crowd.RequestInfo) string {
+
+	var netErr net.Error
+
+	switch {
+	case errors.Is(info.Err, crowd.ErrorCrowdUnavailable):
+		return ErrorClassUnavailable
+	case errors.Is(info.Err, context.Canceled):
+		return ErrorClassCanceled
+	case errors.As(info.Err, &netErr) && netErr.Timeout():
+		return ErrorClassTimeout
+	case info.Err != nil:
+		return ErrorClassTransport
+	case info.Status >= 500:
+		return ErrorClassServer
+	case info.Status >= 400:
+		return ErrorClassClient
+	default:
+		return ErrorClassNone
+	}
+
+}
@@ -0,0 +1,86 @@
+package crowdmetrics
+
+import (
+	"context"
+	"fmt"
+	"github.com/agile-rcm/crowd-go"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCollector_ObserveRequest(t *testing.T) {
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		if r.URL.Query().Get("username") == "testuser" {
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte(`{"name": "testuser"}`))
+			return
+		}
+
+		w.WriteHeader(http.StatusNotFound)
+
+	}))
+	defer server.Close()
+
+	collector := NewCollector("")
+
+	api, err := crowd.NewAPI(server.URL, "testapp", "password", crowd.WithObserver(collector))
+
+	assert.Nil(t, err)
+
+	_, err = api.GetUser("testuser")
+
+	assert.Nil(t, err)
+
+	_, err = api.GetUser("missinguser")
+
+	assert.Equal(t, crowd.ErrorUserNotFound, err)
+
+	assert.Equal(t, 1.0, testutil.ToFloat64(collector.requests.WithLabelValues("GetUser", "200", ErrorClassNone)))
+	assert.Equal(t, 1.0, testutil.ToFloat64(collector.requests.WithLabelValues("GetUser", "404", ErrorClassClient)))
+
+}
+
+func TestCollector_Collect(t *testing.T) {
+
+	collector := NewCollector("test")
+
+	breaker := crowd.NewCircuitBreaker(crowd.BreakerSettings{ConsecutiveFailures: 1, OpenTimeout: time.Minute})
+
+	collector.MonitorBreaker(breaker)
+	collector.MonitorCache(crowd.NewCachedAPI(nil, crowd.CacheConfig{}))
+
+	expected := `
+# HELP test_cache_lookups_total Number of lookups in the caches of a CachedAPI.
+# TYPE test_cache_lookups_total counter
+test_cache_lookups_total{result="hit"} 0
+test_cache_lookups_total{result="miss"} 0
+# HELP test_circuit_breaker_state State of the circuit breaker, 1 for the current state and 0 otherwise.
+# TYPE test_circuit_breaker_state gauge
+test_circuit_breaker_state{state="closed"} 1
+test_circuit_breaker_state{state="half-open"} 0
+test_circuit_breaker_state{state="open"} 0
+`
+
+	err := testutil.CollectAndCompare(collector, strings.NewReader(expected), "test_cache_lookups_total", "test_circuit_breaker_state")
+
+	assert.Nil(t, err)
+
+}
+
+func TestErrorClass(t *testing.T) {
+
+	assert.Equal(t, ErrorClassNone, ErrorClass(crowd.RequestInfo{Status: 204}))
+	assert.Equal(t, ErrorClassClient, ErrorClass(crowd.RequestInfo{Status: 403}))
+	assert.Equal(t, ErrorClassServer, ErrorClass(crowd.RequestInfo{Status: 503}))
+	assert.Equal(t, ErrorClassUnavailable, ErrorClass(crowd.RequestInfo{Err: crowd.ErrorCrowdUnavailable}))
+	assert.Equal(t, ErrorClassUnavailable, ErrorClass(crowd.RequestInfo{Err: fmt.Errorf("get user: %w", crowd.ErrorCrowdUnavailable)}))
+	assert.Equal(t, ErrorClassCanceled, ErrorClass(crowd.RequestInfo{Err: fmt.Errorf("get user: %w", context.Canceled)}))
+
+}
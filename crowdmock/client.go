@@ -0,0 +1,1301 @@
+// Package crowdmock provides an in-memory fake of crowd.Client for testing
+// code which talks to crowd without running a crowd server.
+package crowdmock
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"github.com/agile-rcm/crowd-go"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A recorded call of a Client method.
+type Call struct {
+	Method string
+	Args   []interface{}
+}
+
+// An in-memory fake of crowd.Client. Users, groups, memberships, sessions and
+// events are kept in maps and behave like crowd, returning the same errors as
+// crowd.API. Names are case insensitive. All calls are recorded.
+type Client struct {
+	// Called before every method with its name and arguments. If it returns
+	// an error, the method returns that error without changing any state.
+	// Use it to inject failures, for example crowd.ErrorGeneralNoPermissions.
+	Hook func(method string, args ...interface{}) error
+
+	// Returned by GetCookieConfig.
+	CookieConfig crowd.CookieConfig
+
+	// Lifetime of sessions, extended by every validation.
+	SessionTTL time.Duration
+
+	mu         sync.Mutex
+	now        func() time.Time
+	users      map[string]*user
+	groups     map[string]*group
+	sessions   map[string]*session
+	events     []*crowd.Event
+	generation int
+	calls      []Call
+}
+
+type user struct {
+	user       crowd.User
+	password   string
+	attributes map[string][]string
+	groups     map[string]bool
+}
+
+type group struct {
+	group      crowd.Group
+	attributes map[string][]string
+	children   map[string]bool
+}
+
+type session struct {
+	userKey string
+	factors []*crowd.ValidationFactor
+	created time.Time
+	expires time.Time
+}
+
+// Create a new, empty fake.
+func NewClient() *Client {
+
+	return &Client{
+		CookieConfig: crowd.CookieConfig{Name: "crowd.token_key"},
+		SessionTTL:   30 * time.Minute,
+		now:          time.Now,
+		users:        make(map[string]*user),
+		groups:       make(map[string]*group),
+		sessions:     make(map[string]*session),
+	}
+
+}
+
+// Get all calls made so far, in order.
+func (c *Client) Calls() []Call {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return append([]Call(nil), c.calls...)
+
+}
+
+// Count the calls made to the given method.
+func (c *Client) CallCount(method string) int {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	count := 0
+
+	for _, call := range c.calls {
+		if call.Method == method {
+			count++
+		}
+	}
+
+	return count
+
+}
+
+// Invalidate all issued event tokens, as crowd does when it is restarted.
+func (c *Client) ExpireEventTokens() {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+	c.events = nil
+
+}
+
+// Record a call and run the hook. Must be called with the lock held, the
+// lock is released while the hook runs.
+func (c *Client) call(method string, args ...interface{}) error {
+
+	c.calls = append(c.calls, Call{Method: method, Args: args})
+
+	if c.Hook == nil {
+		return nil
+	}
+
+	c.mu.Unlock()
+	defer c.mu.Lock()
+
+	return c.Hook(method, args...)
+
+}
+
+// Users
+
+// Get details of a crowd user.
+func (c *Client) GetUser(userName string) (*crowd.User, error) {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.call("GetUser", userName); err != nil {
+		return nil, err
+	}
+
+	u, ok := c.users[key(userName)]
+
+	if !ok {
+		return nil, crowd.ErrorUserNotFound
+	}
+
+	copied := u.user
+
+	return &copied, nil
+
+}
+
+// Add a new crowd user.
+func (c *Client) AddUser(userName, userPassword, userFirstName, userLastName, userDisplayName, userEmail string, isActive bool) error {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.call("AddUser", userName, userPassword, userFirstName, userLastName, userDisplayName, userEmail, isActive); err != nil {
+		return err
+	}
+
+	if _, ok := c.users[key(userName)]; ok || userName == "" || userPassword == "" {
+		return crowd.ErrorInvalidUserDataOrUserExists
+	}
+
+	if userDisplayName == "" {
+		userDisplayName = userFirstName + userLastName
+	}
+
+	c.users[key(userName)] = &user{
+		user: crowd.User{
+			Name:        userName,
+			FirstName:   userFirstName,
+			LastName:    userLastName,
+			DisplayName: userDisplayName,
+			Email:       userEmail,
+			Key:         key(userName),
+			IsActive:    isActive,
+		},
+		password:   userPassword,
+		attributes: make(map[string][]string),
+		groups:     make(map[string]bool),
+	}
+
+	c.userEvent("CREATED", userName)
+
+	return nil
+
+}
+
+// Remove a crowd user, with all memberships and sessions.
+func (c *Client) RemoveUser(userName string) error {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.call("RemoveUser", userName); err != nil {
+		return err
+	}
+
+	u, ok := c.users[key(userName)]
+
+	if !ok {
+		return crowd.ErrorUserNotFound
+	}
+
+	delete(c.users, key(userName))
+
+	for token, s := range c.sessions {
+		if s.userKey == key(userName) {
+			delete(c.sessions, token)
+		}
+	}
+
+	c.userEvent("DELETED", u.user.Name)
+
+	return nil
+
+}
+
+// Update details of a crowd user. Empty values keep the current details.
+func (c *Client) UpdateUser(userName, userFirstName, userLastName, userDisplayName, userEmail string, isActive bool) error {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.call("UpdateUser", userName, userFirstName, userLastName, userDisplayName, userEmail, isActive); err != nil {
+		return err
+	}
+
+	u, ok := c.users[key(userName)]
+
+	if !ok {
+		return crowd.ErrorUserNotFound
+	}
+
+	if userFirstName != "" {
+		u.user.FirstName = userFirstName
+	}
+
+	if userLastName != "" {
+		u.user.LastName = userLastName
+	}
+
+	if userDisplayName != "" {
+		u.user.DisplayName = userDisplayName
+	}
+
+	if userEmail != "" {
+		u.user.Email = userEmail
+	}
+
+	u.user.IsActive = isActive
+
+	c.userEvent("UPDATED", u.user.Name)
+
+	return nil
+
+}
+
+// Rename a crowd user. The key, memberships, attributes and sessions of the
+// user are kept.
+func (c *Client) RenameUser(userName, newUserName string) error {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.call("RenameUser", userName, newUserName); err != nil {
+		return err
+	}
+
+	u, ok := c.users[key(userName)]
+
+	if !ok {
+		return crowd.ErrorUserNotFound
+	}
+
+	if _, exists := c.users[key(newUserName)]; newUserName == "" || exists && key(newUserName) != key(userName) {
+		return crowd.ErrorInvalidUserDataOrUserExists
+	}
+
+	oldUserName := u.user.Name
+	u.user.Name = newUserName
+
+	delete(c.users, key(userName))
+	c.users[key(newUserName)] = u
+
+	for _, s := range c.sessions {
+		if s.userKey == key(userName) {
+			s.userKey = key(newUserName)
+		}
+	}
+
+	c.userEvent("DELETED", oldUserName)
+	c.userEvent("CREATED", newUserName)
+
+	return nil
+
+}
+
+// Set the password of a crowd user. Empty passwords are rejected.
+func (c *Client) SetUserPassword(userName, userPassword string) error {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.call("SetUserPassword", userName, userPassword); err != nil {
+		return err
+	}
+
+	u, ok := c.users[key(userName)]
+
+	if !ok {
+		return crowd.ErrorUserNotFound
+	}
+
+	if userPassword == "" {
+		return crowd.ErrorInvalidPassword
+	}
+
+	u.password = userPassword
+
+	return nil
+
+}
+
+// Authenticate a crowd user with the given password. Inactive users can not
+// authenticate.
+func (c *Client) AuthenticateUser(userName, userPassword string) error {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.call("AuthenticateUser", userName, userPassword); err != nil {
+		return err
+	}
+
+	if _, ok := c.authenticate(userName, userPassword); !ok {
+		return crowd.ErrorInvalidCredentials
+	}
+
+	return nil
+
+}
+
+func (c *Client) authenticate(userName, userPassword string) (*user, bool) {
+
+	u, ok := c.users[key(userName)]
+
+	if !ok || !u.user.IsActive || u.password != userPassword {
+		return nil, false
+	}
+
+	return u, true
+
+}
+
+// User attributes
+
+// Get the attributes of a crowd user, sorted by name.
+func (c *Client) GetUserAttributes(userName string) (*crowd.Attributes, error) {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.call("GetUserAttributes", userName); err != nil {
+		return nil, err
+	}
+
+	u, ok := c.users[key(userName)]
+
+	if !ok {
+		return nil, crowd.ErrorUserNotFound
+	}
+
+	return copyAttributes(u.attributes), nil
+
+}
+
+// Store (new) attributes for a crowd user, replacing the values of existing
+// attributes with the same name.
+func (c *Client) StoreUserAttributes(userName string, attributes *crowd.Attributes) error {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.call("StoreUserAttributes", userName, attributes); err != nil {
+		return err
+	}
+
+	u, ok := c.users[key(userName)]
+
+	if !ok {
+		return crowd.ErrorUserNotFound
+	}
+
+	for _, attribute := range attributes.Attributes {
+		u.attributes[attribute.Name] = append([]string(nil), attribute.Values...)
+	}
+
+	c.userEvent("UPDATED", u.user.Name)
+
+	return nil
+
+}
+
+// Remove attributes from a crowd user.
+func (c *Client) RemoveUserAttribute(userName, attributeName string) error {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.call("RemoveUserAttribute", userName, attributeName); err != nil {
+		return err
+	}
+
+	u, ok := c.users[key(userName)]
+
+	if !ok {
+		return crowd.ErrorUserNotFound
+	}
+
+	delete(u.attributes, attributeName)
+
+	c.userEvent("UPDATED", u.user.Name)
+
+	return nil
+
+}
+
+// Groups
+
+// Get details of a group.
+func (c *Client) GetGroup(groupName string) (*crowd.Group, error) {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.call("GetGroup", groupName); err != nil {
+		return nil, err
+	}
+
+	g, ok := c.groups[key(groupName)]
+
+	if !ok {
+		return nil, crowd.ErrorGroupNotFound
+	}
+
+	copied := g.group
+
+	return &copied, nil
+
+}
+
+// Get the attributes of a group, sorted by name.
+func (c *Client) GetGroupAttributes(groupName string) (*crowd.Attributes, error) {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.call("GetGroupAttributes", groupName); err != nil {
+		return nil, err
+	}
+
+	g, ok := c.groups[key(groupName)]
+
+	if !ok {
+		return nil, crowd.ErrorGroupNotFound
+	}
+
+	return copyAttributes(g.attributes), nil
+
+}
+
+// Store (new) attributes for a group, replacing the values of existing
+// attributes with the same name.
+func (c *Client) StoreGroupAttributes(groupName string, attributes *crowd.Attributes) error {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.call("StoreGroupAttributes", groupName, attributes); err != nil {
+		return err
+	}
+
+	g, ok := c.groups[key(groupName)]
+
+	if !ok {
+		return crowd.ErrorGroupNotFound
+	}
+
+	for _, attribute := range attributes.Attributes {
+		g.attributes[attribute.Name] = append([]string(nil), attribute.Values...)
+	}
+
+	c.groupEvent("UPDATED", g.group.Name)
+
+	return nil
+
+}
+
+// Remove an attribute from a group.
+func (c *Client) RemoveGroupAttribute(groupName, attributeName string) error {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.call("RemoveGroupAttribute", groupName, attributeName); err != nil {
+		return err
+	}
+
+	g, ok := c.groups[key(groupName)]
+
+	if !ok {
+		return crowd.ErrorGroupNotFound
+	}
+
+	delete(g.attributes, attributeName)
+
+	c.groupEvent("UPDATED", g.group.Name)
+
+	return nil
+
+}
+
+// Create a new group.
+func (c *Client) CreateGroup(groupName, description string, isActive bool) error {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.call("CreateGroup", groupName, description, isActive); err != nil {
+		return err
+	}
+
+	if _, ok := c.groups[key(groupName)]; ok {
+		return crowd.ErrorGroupAlreadyExists
+	}
+
+	c.groups[key(groupName)] = &group{
+		group: crowd.Group{
+			Name:        groupName,
+			Description: description,
+			Type:        "GROUP",
+			Active:      isActive,
+		},
+		attributes: make(map[string][]string),
+		children:   make(map[string]bool),
+	}
+
+	c.groupEvent("CREATED", groupName)
+
+	return nil
+
+}
+
+// Update the description and the active state of a group.
+func (c *Client) UpdateGroup(groupName, description string, isActive bool) error {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.call("UpdateGroup", groupName, description, isActive); err != nil {
+		return err
+	}
+
+	g, ok := c.groups[key(groupName)]
+
+	if !ok {
+		return crowd.ErrorGroupNotFound
+	}
+
+	g.group.Description = description
+	g.group.Active = isActive
+
+	c.groupEvent("UPDATED", g.group.Name)
+
+	return nil
+
+}
+
+// Remove a group, with all its memberships.
+func (c *Client) RemoveGroup(groupName string) error {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.call("RemoveGroup", groupName); err != nil {
+		return err
+	}
+
+	g, ok := c.groups[key(groupName)]
+
+	if !ok {
+		return crowd.ErrorGroupNotFound
+	}
+
+	delete(c.groups, key(groupName))
+
+	for _, u := range c.users {
+		delete(u.groups, key(groupName))
+	}
+
+	for _, parent := range c.groups {
+		delete(parent.children, key(groupName))
+	}
+
+	c.groupEvent("DELETED", g.group.Name)
+
+	return nil
+
+}
+
+// Get the direct members of a group, sorted by name.
+func (c *Client) GetGroupMembers(groupName string) (*crowd.Users, error) {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.call("GetGroupMembers", groupName); err != nil {
+		return nil, err
+	}
+
+	if _, ok := c.groups[key(groupName)]; !ok {
+		return nil, crowd.ErrorGroupNotFound
+	}
+
+	return c.members(map[string]bool{key(groupName): true}), nil
+
+}
+
+// Get the direct and nested members of a group, sorted by name.
+func (c *Client) GetNestedGroupMembers(groupName string) (*crowd.Users, error) {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.call("GetNestedGroupMembers", groupName); err != nil {
+		return nil, err
+	}
+
+	if _, ok := c.groups[key(groupName)]; !ok {
+		return nil, crowd.ErrorGroupNotFound
+	}
+
+	// Walk down from the group to all groups nested in it.
+	nested := make(map[string]bool)
+	pending := []string{key(groupName)}
+
+	for len(pending) > 0 {
+
+		groupKey := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+
+		if nested[groupKey] {
+			continue
+		}
+
+		nested[groupKey] = true
+
+		for childKey := range c.groups[groupKey].children {
+			pending = append(pending, childKey)
+		}
+
+	}
+
+	return c.members(nested), nil
+
+}
+
+// Get the direct child groups of a group, sorted by name.
+func (c *Client) GetChildGroups(groupName string) (*crowd.Groups, error) {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.call("GetChildGroups", groupName); err != nil {
+		return nil, err
+	}
+
+	g, ok := c.groups[key(groupName)]
+
+	if !ok {
+		return nil, crowd.ErrorGroupNotFound
+	}
+
+	return c.groupList(g.children), nil
+
+}
+
+// Get the users which are direct members of any of the given groups.
+func (c *Client) members(groupKeys map[string]bool) *crowd.Users {
+
+	users := &crowd.Users{Users: []*crowd.User{}}
+
+	for _, u := range c.users {
+
+		for groupKey := range u.groups {
+
+			if groupKeys[groupKey] {
+				copied := u.user
+				users.Users = append(users.Users, &copied)
+				break
+			}
+
+		}
+
+	}
+
+	sort.Slice(users.Users, func(i, j int) bool {
+		return key(users.Users[i].Name) < key(users.Users[j].Name)
+	})
+
+	return users
+
+}
+
+// Memberships
+
+// Get the groups a crowd user is a direct member of, sorted by name.
+func (c *Client) GetDirectGroupsForUser(userName string) (*crowd.Groups, error) {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.call("GetDirectGroupsForUser", userName); err != nil {
+		return nil, err
+	}
+
+	u, ok := c.users[key(userName)]
+
+	if !ok {
+		return nil, crowd.ErrorUserNotFound
+	}
+
+	return c.groupList(u.groups), nil
+
+}
+
+// Get the groups a crowd user is a direct or nested member of, sorted by
+// name.
+func (c *Client) GetNestedGroupsForUser(userName string) (*crowd.Groups, error) {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.call("GetNestedGroupsForUser", userName); err != nil {
+		return nil, err
+	}
+
+	u, ok := c.users[key(userName)]
+
+	if !ok {
+		return nil, crowd.ErrorUserNotFound
+	}
+
+	// Walk up from the direct groups to all their parents.
+	nested := make(map[string]bool)
+	pending := make([]string, 0, len(u.groups))
+
+	for groupKey := range u.groups {
+		pending = append(pending, groupKey)
+	}
+
+	for len(pending) > 0 {
+
+		groupKey := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+
+		if nested[groupKey] {
+			continue
+		}
+
+		nested[groupKey] = true
+
+		for parentKey, parent := range c.groups {
+			if parent.children[groupKey] {
+				pending = append(pending, parentKey)
+			}
+		}
+
+	}
+
+	return c.groupList(nested), nil
+
+}
+
+// Get the given groups, sorted by name.
+func (c *Client) groupList(groupKeys map[string]bool) *crowd.Groups {
+
+	groups := &crowd.Groups{Groups: []*crowd.Group{}}
+
+	for groupKey := range groupKeys {
+		copied := c.groups[groupKey].group
+		groups.Groups = append(groups.Groups, &copied)
+	}
+
+	sort.Slice(groups.Groups, func(i, j int) bool {
+		return key(groups.Groups[i].Name) < key(groups.Groups[j].Name)
+	})
+
+	return groups
+
+}
+
+// Add a user to an existing group.
+func (c *Client) AddUserToGroup(userName, groupName string) error {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.call("AddUserToGroup", userName, groupName); err != nil {
+		return err
+	}
+
+	u, ok := c.users[key(userName)]
+
+	if !ok {
+		return crowd.ErrorUserNotFound
+	}
+
+	g, ok := c.groups[key(groupName)]
+
+	if !ok {
+		return crowd.ErrorGroupNotFound
+	}
+
+	if u.groups[key(groupName)] {
+		return crowd.ErrorUserAlreadyInGroup
+	}
+
+	u.groups[key(groupName)] = true
+
+	c.events = append(c.events, &crowd.Event{
+		Operation:    "CREATED",
+		ChildUser:    &crowd.User{Name: u.user.Name},
+		ParentGroups: &crowd.Groups{Groups: []*crowd.Group{{Name: g.group.Name}}},
+	})
+
+	return nil
+
+}
+
+// Remove a user from a group. As crowd.API, returns ErrorUserNotFound if the
+// user, the group or the membership does not exist.
+func (c *Client) RemoveUserFromGroup(userName, groupName string) error {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.call("RemoveUserFromGroup", userName, groupName); err != nil {
+		return err
+	}
+
+	u, ok := c.users[key(userName)]
+
+	if !ok || !u.groups[key(groupName)] {
+		return crowd.ErrorUserNotFound
+	}
+
+	delete(u.groups, key(groupName))
+
+	c.events = append(c.events, &crowd.Event{
+		Operation:    "DELETED",
+		ChildUser:    &crowd.User{Name: u.user.Name},
+		ParentGroups: &crowd.Groups{Groups: []*crowd.Group{{Name: c.groups[key(groupName)].group.Name}}},
+	})
+
+	return nil
+
+}
+
+// Add a new child group membership. Adding an existing membership is a no-op.
+func (c *Client) AddChildGroupMembership(parentGroupName, childGroupName string) error {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.call("AddChildGroupMembership", parentGroupName, childGroupName); err != nil {
+		return err
+	}
+
+	if _, ok := c.groups[key(parentGroupName)]; !ok {
+		return crowd.ErrorGroupNotFound
+	}
+
+	if _, ok := c.groups[key(childGroupName)]; !ok {
+		return crowd.ErrorGroupNotFoundOrCircularDependency
+	}
+
+	return c.addGroupMembership(parentGroupName, childGroupName)
+
+}
+
+// Add a new parent group membership. Adding an existing membership is a
+// no-op.
+func (c *Client) AddParentGroupMembership(parentGroupName, childGroupName string) error {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.call("AddParentGroupMembership", parentGroupName, childGroupName); err != nil {
+		return err
+	}
+
+	if _, ok := c.groups[key(childGroupName)]; !ok {
+		return crowd.ErrorGroupNotFound
+	}
+
+	if _, ok := c.groups[key(parentGroupName)]; !ok {
+		return crowd.ErrorGroupNotFoundOrCircularDependency
+	}
+
+	return c.addGroupMembership(parentGroupName, childGroupName)
+
+}
+
+func (c *Client) addGroupMembership(parentGroupName, childGroupName string) error {
+
+	parent := c.groups[key(parentGroupName)]
+	child := c.groups[key(childGroupName)]
+
+	if parent.children[key(childGroupName)] {
+		return nil
+	}
+
+	if c.isDescendant(key(parentGroupName), key(childGroupName)) {
+		return crowd.ErrorGroupNotFoundOrCircularDependency
+	}
+
+	parent.children[key(childGroupName)] = true
+
+	c.events = append(c.events, &crowd.Event{
+		Operation:   "CREATED",
+		Group:       &crowd.Group{Name: parent.group.Name},
+		ChildGroups: &crowd.Groups{Groups: []*crowd.Group{{Name: child.group.Name}}},
+	})
+
+	return nil
+
+}
+
+// Remove a child group membership. Returns ErrorGroupNotFound if either group
+// or the membership does not exist.
+func (c *Client) RemoveChildGroupMembership(parentGroupName, childGroupName string) error {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.call("RemoveChildGroupMembership", parentGroupName, childGroupName); err != nil {
+		return err
+	}
+
+	parent, ok := c.groups[key(parentGroupName)]
+
+	if !ok || !parent.children[key(childGroupName)] {
+		return crowd.ErrorGroupNotFound
+	}
+
+	delete(parent.children, key(childGroupName))
+
+	c.events = append(c.events, &crowd.Event{
+		Operation:   "DELETED",
+		Group:       &crowd.Group{Name: parent.group.Name},
+		ChildGroups: &crowd.Groups{Groups: []*crowd.Group{{Name: c.groups[key(childGroupName)].group.Name}}},
+	})
+
+	return nil
+
+}
+
+// Check whether the group is the ancestor group itself or nested in it.
+func (c *Client) isDescendant(groupKey, ancestorKey string) bool {
+
+	if groupKey == ancestorKey {
+		return true
+	}
+
+	for childKey := range c.groups[ancestorKey].children {
+		if c.isDescendant(groupKey, childKey) {
+			return true
+		}
+	}
+
+	return false
+
+}
+
+// Sessions
+
+// Authenticate a crowd user and create a single sign-on session, bound to the
+// given validation factors, which may be nil.
+func (c *Client) CreateSession(userName, userPassword string, validationFactors *crowd.ValidationFactors) (*crowd.Session, error) {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.call("CreateSession", userName, userPassword, validationFactors); err != nil {
+		return nil, err
+	}
+
+	u, ok := c.authenticate(userName, userPassword)
+
+	if !ok {
+		return nil, crowd.ErrorInvalidCredentials
+	}
+
+	token, err := newToken()
+
+	if err != nil {
+		return nil, err
+	}
+
+	now := c.now()
+
+	s := &session{
+		userKey: key(u.user.Name),
+		created: now,
+		expires: now.Add(c.SessionTTL),
+	}
+
+	if validationFactors != nil {
+		s.factors = validationFactors.ValidationFactors
+	}
+
+	c.sessions[token] = s
+
+	return c.session(token, s), nil
+
+}
+
+// Get a session without validating it.
+func (c *Client) GetSession(token string) (*crowd.Session, error) {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.call("GetSession", token); err != nil {
+		return nil, err
+	}
+
+	s, ok := c.activeSession(token)
+
+	if !ok {
+		return nil, crowd.ErrorSessionNotFound
+	}
+
+	return c.session(token, s), nil
+
+}
+
+// Validate a session against the given validation factors, which extends it.
+// The factors must match the ones the session was created with.
+func (c *Client) ValidateSession(token string, validationFactors *crowd.ValidationFactors) (*crowd.Session, error) {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.call("ValidateSession", token, validationFactors); err != nil {
+		return nil, err
+	}
+
+	s, ok := c.activeSession(token)
+
+	if !ok {
+		return nil, crowd.ErrorSessionNotFound
+	}
+
+	var factors []*crowd.ValidationFactor
+
+	if validationFactors != nil {
+		factors = validationFactors.ValidationFactors
+	}
+
+	if !sameFactors(s.factors, factors) {
+		return nil, crowd.ErrorInvalidValidationFactors
+	}
+
+	s.expires = c.now().Add(c.SessionTTL)
+
+	return c.session(token, s), nil
+
+}
+
+// Invalidate a session. Invalidating an unknown session is not an error.
+func (c *Client) InvalidateSession(token string) error {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.call("InvalidateSession", token); err != nil {
+		return err
+	}
+
+	delete(c.sessions, token)
+
+	return nil
+
+}
+
+// Get the single sign-on cookie configuration.
+func (c *Client) GetCookieConfig() (*crowd.CookieConfig, error) {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.call("GetCookieConfig"); err != nil {
+		return nil, err
+	}
+
+	config := c.CookieConfig
+
+	return &config, nil
+
+}
+
+func (c *Client) activeSession(token string) (*session, bool) {
+
+	s, ok := c.sessions[token]
+
+	if !ok {
+		return nil, false
+	}
+
+	if !c.now().Before(s.expires) {
+		delete(c.sessions, token)
+		return nil, false
+	}
+
+	return s, true
+
+}
+
+func (c *Client) session(token string, s *session) *crowd.Session {
+
+	copied := c.users[s.userKey].user
+
+	return &crowd.Session{
+		Token:       token,
+		User:        &copied,
+		CreatedDate: s.created.UnixNano() / int64(time.Millisecond),
+		ExpiryDate:  s.expires.UnixNano() / int64(time.Millisecond),
+	}
+
+}
+
+func sameFactors(a, b []*crowd.ValidationFactor) bool {
+
+	if len(a) != len(b) {
+		return false
+	}
+
+	values := make(map[string]string, len(a))
+
+	for _, factor := range a {
+		values[factor.Name] = factor.Value
+	}
+
+	for _, factor := range b {
+
+		value, ok := values[factor.Name]
+
+		if !ok || value != factor.Value {
+			return false
+		}
+
+	}
+
+	return true
+
+}
+
+func newToken() (string, error) {
+
+	token := make([]byte, 16)
+
+	if _, err := rand.Read(token); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(token), nil
+
+}
+
+// Events
+
+// Get a token for the current position in the event feed.
+func (c *Client) GetEventToken() (string, error) {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.call("GetEventToken"); err != nil {
+		return "", err
+	}
+
+	return c.eventToken(len(c.events)), nil
+
+}
+
+// Get the events which happened since the given event token was issued.
+func (c *Client) GetEvents(eventToken string) (*crowd.Events, error) {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.call("GetEvents", eventToken); err != nil {
+		return nil, err
+	}
+
+	position, ok := c.eventPosition(eventToken)
+
+	if !ok {
+		return nil, crowd.ErrorEventTokenExpired
+	}
+
+	return &crowd.Events{
+		NewEventToken:                       c.eventToken(len(c.events)),
+		IncrementalSynchronisationAvailable: true,
+		Events:                              append([]*crowd.Event{}, c.events[position:]...),
+	}, nil
+
+}
+
+// Event tokens name the generation of the feed and the position in it.
+func (c *Client) eventToken(position int) string {
+	return fmt.Sprintf("%d-%d", c.generation, position)
+}
+
+func (c *Client) eventPosition(eventToken string) (int, bool) {
+
+	parts := strings.SplitN(eventToken, "-", 2)
+
+	if len(parts) != 2 || parts[0] != strconv.Itoa(c.generation) {
+		return 0, false
+	}
+
+	position, err := strconv.Atoi(parts[1])
+
+	if err != nil || position < 0 || position > len(c.events) {
+		return 0, false
+	}
+
+	return position, true
+
+}
+
+func (c *Client) userEvent(operation, userName string) {
+
+	c.events = append(c.events, &crowd.Event{
+		Operation: operation,
+		User:      &crowd.User{Name: userName},
+	})
+
+}
+
+func (c *Client) groupEvent(operation, groupName string) {
+
+	c.events = append(c.events, &crowd.Event{
+		Operation: operation,
+		Group:     &crowd.Group{Name: groupName},
+	})
+
+}
+
+// Crowd names are case insensitive.
+// Copy attributes, sorted by name.
+func copyAttributes(values map[string][]string) *crowd.Attributes {
+
+	attributes := &crowd.Attributes{Attributes: []*crowd.Attribute{}}
+
+	for name, attributeValues := range values {
+		attributes.Attributes = append(attributes.Attributes, &crowd.Attribute{
+			Name:   name,
+			Values: append([]string(nil), attributeValues...),
+		})
+	}
+
+	sort.Slice(attributes.Attributes, func(i, j int) bool {
+		return attributes.Attributes[i].Name < attributes.Attributes[j].Name
+	})
+
+	return attributes
+
+}
+
+func key(name string) string {
+	return strings.ToLower(name)
+}
+
+var _ crowd.Client = (*Client)(nil)
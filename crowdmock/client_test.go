@@ -0,0 +1,364 @@
+package crowdmock
+
+import (
+	"github.com/agile-rcm/crowd-go"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestClient_Users(t *testing.T) {
+
+	client := NewClient()
+
+	err := client.AddUser("testuser", "password", "Test", "User", "", "test@example.com", true)
+
+	assert.Nil(t, err)
+
+	err = client.AddUser("TestUser", "password", "", "", "", "", true)
+
+	assert.Equal(t, crowd.ErrorInvalidUserDataOrUserExists, err)
+
+	err = client.UpdateUser("TESTUSER", "", "", "", "new@example.com", true)
+
+	assert.Nil(t, err)
+
+	user, err := client.GetUser("testuser")
+
+	assert.Nil(t, err)
+	assert.Equal(t, "TestUser", user.DisplayName)
+	assert.Equal(t, "new@example.com", user.Email)
+
+	assert.Nil(t, client.AuthenticateUser("testuser", "password"))
+	assert.Equal(t, crowd.ErrorInvalidCredentials, client.AuthenticateUser("testuser", "wrong"))
+
+	err = client.RemoveUser("testuser")
+
+	assert.Nil(t, err)
+
+	_, err = client.GetUser("testuser")
+
+	assert.Equal(t, crowd.ErrorUserNotFound, err)
+
+}
+
+func TestClient_UserAttributes(t *testing.T) {
+
+	client := NewClient()
+
+	err := client.StoreUserAttributes("testuser", &crowd.Attributes{})
+
+	assert.Equal(t, crowd.ErrorUserNotFound, err)
+
+	assert.Nil(t, client.AddUser("testuser", "password", "", "", "", "", true))
+
+	err = client.StoreUserAttributes("testuser", &crowd.Attributes{Attributes: []*crowd.Attribute{
+		{Name: "b", Values: []string{"1"}},
+		{Name: "a", Values: []string{"2", "3"}},
+	}})
+
+	assert.Nil(t, err)
+
+	err = client.RemoveUserAttribute("testuser", "b")
+
+	assert.Nil(t, err)
+
+	attributes, err := client.GetUserAttributes("testuser")
+
+	assert.Nil(t, err)
+	assert.Equal(t, &crowd.Attributes{Attributes: []*crowd.Attribute{{Name: "a", Values: []string{"2", "3"}}}}, attributes)
+
+}
+
+func TestClient_Memberships(t *testing.T) {
+
+	client := NewClient()
+
+	assert.Nil(t, client.AddUser("testuser", "password", "", "", "", "", true))
+	assert.Nil(t, client.CreateGroup("developers", "", true))
+	assert.Nil(t, client.CreateGroup("staff", "", true))
+	assert.Nil(t, client.CreateGroup("everyone", "", true))
+
+	assert.Equal(t, crowd.ErrorGroupAlreadyExists, client.CreateGroup("Staff", "", true))
+	assert.Equal(t, crowd.ErrorGroupNotFound, client.AddUserToGroup("testuser", "missing"))
+
+	assert.Nil(t, client.AddUserToGroup("testuser", "developers"))
+	assert.Equal(t, crowd.ErrorUserAlreadyInGroup, client.AddUserToGroup("testuser", "developers"))
+
+	assert.Nil(t, client.AddChildGroupMembership("staff", "developers"))
+	assert.Nil(t, client.AddParentGroupMembership("everyone", "staff"))
+	assert.Equal(t, crowd.ErrorGroupNotFoundOrCircularDependency, client.AddChildGroupMembership("developers", "everyone"))
+
+	groups, err := client.GetNestedGroupsForUser("testuser")
+
+	assert.Nil(t, err)
+	assert.Equal(t, []*crowd.Group{
+		{Name: "developers", Type: "GROUP", Active: true},
+		{Name: "everyone", Type: "GROUP", Active: true},
+		{Name: "staff", Type: "GROUP", Active: true},
+	}, groups.Groups)
+
+	assert.Nil(t, client.RemoveGroup("staff"))
+
+	groups, err = client.GetNestedGroupsForUser("testuser")
+
+	assert.Nil(t, err)
+	assert.Equal(t, []*crowd.Group{{Name: "developers", Type: "GROUP", Active: true}}, groups.Groups)
+
+	assert.Nil(t, client.RemoveUserFromGroup("testuser", "developers"))
+	assert.Equal(t, crowd.ErrorUserNotFound, client.RemoveUserFromGroup("testuser", "developers"))
+
+}
+
+func TestClient_Sessions(t *testing.T) {
+
+	client := NewClient()
+	now := time.Now()
+	client.now = func() time.Time { return now }
+
+	assert.Nil(t, client.AddUser("testuser", "password", "", "", "", "", true))
+
+	factors := &crowd.ValidationFactors{ValidationFactors: []*crowd.ValidationFactor{{Name: "remote_address", Value: "127.0.0.1"}}}
+
+	_, err := client.CreateSession("testuser", "wrong", factors)
+
+	assert.Equal(t, crowd.ErrorInvalidCredentials, err)
+
+	session, err := client.CreateSession("testuser", "password", factors)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "testuser", session.User.Name)
+
+	_, err = client.ValidateSession(session.Token, nil)
+
+	assert.Equal(t, crowd.ErrorInvalidValidationFactors, err)
+
+	now = now.Add(20 * time.Minute)
+
+	_, err = client.ValidateSession(session.Token, factors)
+
+	assert.Nil(t, err)
+
+	now = now.Add(20 * time.Minute)
+
+	_, err = client.GetSession(session.Token)
+
+	assert.Nil(t, err)
+
+	assert.Nil(t, client.InvalidateSession(session.Token))
+
+	_, err = client.GetSession(session.Token)
+
+	assert.Equal(t, crowd.ErrorSessionNotFound, err)
+
+}
+
+func TestClient_Events(t *testing.T) {
+
+	client := NewClient()
+
+	token, err := client.GetEventToken()
+
+	assert.Nil(t, err)
+
+	assert.Nil(t, client.AddUser("testuser", "password", "", "", "", "", true))
+	assert.Nil(t, client.CreateGroup("testgroup", "", true))
+
+	events, err := client.GetEvents(token)
+
+	assert.Nil(t, err)
+	assert.Equal(t, []*crowd.Event{
+		{Operation: "CREATED", User: &crowd.User{Name: "testuser"}},
+		{Operation: "CREATED", Group: &crowd.Group{Name: "testgroup"}},
+	}, events.Events)
+
+	events, err = client.GetEvents(events.NewEventToken)
+
+	assert.Nil(t, err)
+	assert.Empty(t, events.Events)
+
+	client.ExpireEventTokens()
+
+	_, err = client.GetEvents(events.NewEventToken)
+
+	assert.Equal(t, crowd.ErrorEventTokenExpired, err)
+
+}
+
+func TestClient_Hook(t *testing.T) {
+
+	client := NewClient()
+	client.Hook = func(method string, args ...interface{}) error {
+
+		if method == "CreateGroup" {
+			return crowd.ErrorGeneralNoPermissions
+		}
+
+		return nil
+
+	}
+
+	assert.Equal(t, crowd.ErrorGeneralNoPermissions, client.CreateGroup("testgroup", "", true))
+
+	_, err := client.GetGroup("testgroup")
+
+	assert.Equal(t, crowd.ErrorGroupNotFound, err)
+	assert.Equal(t, []Call{
+		{Method: "CreateGroup", Args: []interface{}{"testgroup", "", true}},
+		{Method: "GetGroup", Args: []interface{}{"testgroup"}},
+	}, client.Calls())
+	assert.Equal(t, 1, client.CallCount("GetGroup"))
+
+}
+
+func TestClient_GroupAuthorizer(t *testing.T) {
+
+	client := NewClient()
+
+	assert.Nil(t, client.AddUser("testuser", "password", "", "", "", "", true))
+	assert.Nil(t, client.CreateGroup("admins", "", true))
+	assert.Nil(t, client.AddUserToGroup("testuser", "admins"))
+
+	authorizer := crowd.NewGroupAuthorizer(client, 0)
+	handler := authorizer.RequireGroups(crowd.MatchAnyGroup, "admins")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	request := httptest.NewRequest("GET", "/", nil)
+	request.SetBasicAuth("testuser", "password")
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+
+	assert.Nil(t, client.RemoveUserFromGroup("testuser", "admins"))
+
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+
+	assert.Equal(t, http.StatusForbidden, recorder.Code)
+
+}
+
+func TestClient_RenameUser(t *testing.T) {
+
+	client := NewClient()
+
+	assert.Nil(t, client.AddUser("testuser", "password", "", "", "", "", true))
+	assert.Nil(t, client.AddUser("otheruser", "password", "", "", "", "", true))
+	assert.Nil(t, client.CreateGroup("testgroup", "", true))
+	assert.Nil(t, client.AddUserToGroup("testuser", "testgroup"))
+
+	assert.Equal(t, crowd.ErrorInvalidUserDataOrUserExists, client.RenameUser("testuser", "otheruser"))
+	assert.Nil(t, client.RenameUser("testuser", "renameduser"))
+
+	_, err := client.GetUser("testuser")
+
+	assert.Equal(t, crowd.ErrorUserNotFound, err)
+
+	members, err := client.GetGroupMembers("testgroup")
+
+	assert.Nil(t, err)
+	assert.Equal(t, "renameduser", members.Users[0].Name)
+	assert.Equal(t, "testuser", members.Users[0].Key)
+
+	assert.Equal(t, crowd.ErrorInvalidPassword, client.SetUserPassword("renameduser", ""))
+	assert.Nil(t, client.SetUserPassword("renameduser", "newpassword"))
+	assert.Nil(t, client.AuthenticateUser("renameduser", "newpassword"))
+
+}
+
+func TestClient_GetNestedGroupMembers(t *testing.T) {
+
+	client := NewClient()
+
+	assert.Nil(t, client.CreateGroup("staff", "", true))
+	assert.Nil(t, client.CreateGroup("developers", "", true))
+	assert.Nil(t, client.AddChildGroupMembership("staff", "developers"))
+
+	for _, userName := range []string{"b", "a"} {
+		assert.Nil(t, client.AddUser(userName, "password", "", "", "", "", true))
+		assert.Nil(t, client.AddUserToGroup(userName, "developers"))
+	}
+
+	direct, err := client.GetGroupMembers("staff")
+
+	assert.Nil(t, err)
+	assert.Empty(t, direct.Users)
+
+	nested, err := client.GetNestedGroupMembers("staff")
+
+	assert.Nil(t, err)
+	assert.Equal(t, "a", nested.Users[0].Name)
+	assert.Equal(t, "b", nested.Users[1].Name)
+
+	_, err = client.GetNestedGroupMembers("missing")
+
+	assert.Equal(t, crowd.ErrorGroupNotFound, err)
+
+}
+
+func TestClient_Search(t *testing.T) {
+
+	client := NewClient()
+
+	assert.Nil(t, client.AddUser("alice", "password", "", "", "", "alice@example.com", true))
+	assert.Nil(t, client.AddUser("bob", "password", "", "", "", "bob@example.org", true))
+	assert.Nil(t, client.AddUser("carol", "password", "", "", "", "carol@example.com", false))
+	assert.Nil(t, client.CreateGroup("dev-backend", "", true))
+	assert.Nil(t, client.CreateGroup("ops", "", true))
+
+	users, err := client.SearchUsers(`email = "*@example.com" AND active = true`, 0, 10)
+
+	assert.Nil(t, err)
+	assert.Len(t, users.Users, 1)
+	assert.Equal(t, "alice", users.Users[0].Name)
+
+	users, err = client.SearchUsers("", 1, 1)
+
+	assert.Nil(t, err)
+	assert.Len(t, users.Users, 1)
+	assert.Equal(t, "bob", users.Users[0].Name)
+
+	groups, err := client.SearchGroups("name = dev-*", 0, 10)
+
+	assert.Nil(t, err)
+	assert.Len(t, groups.Groups, 1)
+
+	_, err = client.SearchUsers("nickname = x", 0, 10)
+
+	assert.Equal(t, crowd.ErrorInvalidSearchRestriction, err)
+
+}
+
+func TestClient_GroupAttributes(t *testing.T) {
+
+	client := NewClient()
+
+	assert.Equal(t, crowd.ErrorGroupNotFound, client.StoreGroupAttributes("staff", &crowd.Attributes{}))
+
+	assert.Nil(t, client.CreateGroup("staff", "", true))
+	assert.Nil(t, client.CreateGroup("developers", "", true))
+	assert.Nil(t, client.AddChildGroupMembership("staff", "developers"))
+	assert.Nil(t, client.StoreGroupAttributes("staff", &crowd.Attributes{Attributes: []*crowd.Attribute{{Name: "owner", Values: []string{"alice"}}}}))
+
+	attributes, err := client.GetGroupAttributes("staff")
+
+	assert.Nil(t, err)
+	assert.Equal(t, &crowd.Attributes{Attributes: []*crowd.Attribute{{Name: "owner", Values: []string{"alice"}}}}, attributes)
+
+	children, err := client.GetChildGroups("staff")
+
+	assert.Nil(t, err)
+	assert.Equal(t, []*crowd.Group{{Name: "developers", Type: "GROUP", Active: true}}, children.Groups)
+
+	assert.Nil(t, client.AddUser("testuser", "password", "", "", "", "", true))
+	assert.Nil(t, client.AddUserToGroup("testuser", "developers"))
+
+	direct, err := client.GetDirectGroupsForUser("testuser")
+
+	assert.Nil(t, err)
+	assert.Len(t, direct.Groups, 1)
+
+}
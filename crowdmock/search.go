@@ -0,0 +1,194 @@
+package crowdmock
+
+import (
+	"github.com/agile-rcm/crowd-go"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// A single `field = value` term of a restriction.
+type term struct {
+	field string
+	value string
+}
+
+var (
+	termPattern = regexp.MustCompile(`^\s*(\w+)\s*=\s*(?:"([^"]*)"|(\S+))\s*$`)
+	andPattern  = regexp.MustCompile(`(?i)\s+and\s+`)
+)
+
+// Search users matching a restriction. Supports the subset of crowd query
+// language made of `field = value` terms joined with "and", where values may
+// contain * as wildcard. The fields are name, email, firstName, lastName,
+// displayName and active.
+func (c *Client) SearchUsers(restriction string, startIndex, maxResults int) (*crowd.Users, error) {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.call("SearchUsers", restriction, startIndex, maxResults); err != nil {
+		return nil, err
+	}
+
+	terms, ok := parseRestriction(restriction, "name", "email", "firstname", "lastname", "displayname", "active")
+
+	if !ok {
+		return nil, crowd.ErrorInvalidSearchRestriction
+	}
+
+	users := []*crowd.User{}
+
+	for _, u := range c.users {
+
+		fields := map[string]string{
+			"name":        u.user.Name,
+			"email":       u.user.Email,
+			"firstname":   u.user.FirstName,
+			"lastname":    u.user.LastName,
+			"displayname": u.user.DisplayName,
+			"active":      strconv.FormatBool(u.user.IsActive),
+		}
+
+		if matchTerms(terms, fields) {
+			copied := u.user
+			users = append(users, &copied)
+		}
+
+	}
+
+	sort.Slice(users, func(i, j int) bool {
+		return key(users[i].Name) < key(users[j].Name)
+	})
+
+	start, end := pageBounds(len(users), startIndex, maxResults)
+
+	return &crowd.Users{Users: users[start:end]}, nil
+
+}
+
+// Search groups matching a restriction, see SearchUsers. The fields are
+// name, description and active.
+func (c *Client) SearchGroups(restriction string, startIndex, maxResults int) (*crowd.Groups, error) {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.call("SearchGroups", restriction, startIndex, maxResults); err != nil {
+		return nil, err
+	}
+
+	terms, ok := parseRestriction(restriction, "name", "description", "active")
+
+	if !ok {
+		return nil, crowd.ErrorInvalidSearchRestriction
+	}
+
+	groups := []*crowd.Group{}
+
+	for _, g := range c.groups {
+
+		fields := map[string]string{
+			"name":        g.group.Name,
+			"description": g.group.Description,
+			"active":      strconv.FormatBool(g.group.Active),
+		}
+
+		if matchTerms(terms, fields) {
+			copied := g.group
+			groups = append(groups, &copied)
+		}
+
+	}
+
+	sort.Slice(groups, func(i, j int) bool {
+		return key(groups[i].Name) < key(groups[j].Name)
+	})
+
+	start, end := pageBounds(len(groups), startIndex, maxResults)
+
+	return &crowd.Groups{Groups: groups[start:end]}, nil
+
+}
+
+// Parse a restriction into terms, checking the fields against the given
+// lower case field names.
+func parseRestriction(restriction string, fields ...string) ([]term, bool) {
+
+	if strings.TrimSpace(restriction) == "" {
+		return nil, true
+	}
+
+	var terms []term
+
+	for _, part := range andPattern.Split(restriction, -1) {
+
+		match := termPattern.FindStringSubmatch(part)
+
+		if match == nil {
+			return nil, false
+		}
+
+		field := strings.ToLower(match[1])
+		known := false
+
+		for _, name := range fields {
+			known = known || name == field
+		}
+
+		if !known {
+			return nil, false
+		}
+
+		value := match[2]
+
+		if match[3] != "" {
+			value = match[3]
+		}
+
+		terms = append(terms, term{field: field, value: strings.ToLower(value)})
+
+	}
+
+	return terms, true
+
+}
+
+// Check whether all terms match, case insensitively.
+func matchTerms(terms []term, fields map[string]string) bool {
+
+	for _, t := range terms {
+
+		if matched, _ := path.Match(t.value, strings.ToLower(fields[t.field])); !matched {
+			return false
+		}
+
+	}
+
+	return true
+
+}
+
+func pageBounds(length, startIndex, maxResults int) (int, int) {
+
+	start := startIndex
+
+	if start < 0 {
+		start = 0
+	}
+
+	if start > length {
+		start = length
+	}
+
+	end := length
+
+	if maxResults >= 0 && start+maxResults < end {
+		end = start + maxResults
+	}
+
+	return start, end
+
+}
@@ -0,0 +1,189 @@
+// Package crowdotel traces crowd API requests with OpenTelemetry.
+//
+//	api, err := crowd.NewAPI(url, application, password,
+//		crowd.WithTracer(crowdotel.NewTracer(crowdotel.WithRedactedUsernames())),
+//	)
+//
+//	user, err := api.WithContext(ctx).GetUser(userName)
+package crowdotel
+
+import (
+	"context"
+	"github.com/agile-rcm/crowd-go"
+	"github.com/valyala/fasthttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+	"go.opentelemetry.io/otel/trace"
+	"strings"
+)
+
+const instrumentationName = "github.com/agile-rcm/crowd-go/crowdotel"
+
+// The value replacing redacted user names and tokens.
+const Redacted = "REDACTED"
+
+// Paths which end with a session or event token. The tokens are credentials
+// and are always redacted.
+var tokenPaths = []string{
+	"/rest/usermanagement/1/session/",
+	"/rest/usermanagement/1/event/",
+}
+
+// Query parameters replaced by WithRedactedUsernames.
+var redactedParams = []string{"username", "restriction"}
+
+// Creates a span for every crowd request, implements crowd.Tracer.
+type Tracer struct {
+	provider        trace.TracerProvider
+	propagator      propagation.TextMapPropagator
+	redactUsernames bool
+	tracer          trace.Tracer
+}
+
+// Configures a Tracer.
+type Option func(*Tracer)
+
+// Use the given tracer provider instead of the global one.
+func WithTracerProvider(provider trace.TracerProvider) Option {
+	return func(t *Tracer) {
+		t.provider = provider
+	}
+}
+
+// Use the given propagator instead of the global one to pass the trace
+// context on to crowd.
+func WithPropagator(propagator propagation.TextMapPropagator) Option {
+	return func(t *Tracer) {
+		t.propagator = propagator
+	}
+}
+
+// Replace user names and search restrictions, which may contain user names
+// or email addresses, in the recorded request target.
+func WithRedactedUsernames() Option {
+	return func(t *Tracer) {
+		t.redactUsernames = true
+	}
+}
+
+// Create a new tracer.
+func NewTracer(options ...Option) *Tracer {
+
+	t := &Tracer{
+		provider:   otel.GetTracerProvider(),
+		propagator: otel.GetTextMapPropagator(),
+	}
+
+	for _, option := range options {
+		option(t)
+	}
+
+	t.tracer = t.provider.Tracer(instrumentationName, trace.WithInstrumentationVersion(crowd.VERSION))
+
+	return t
+
+}
+
+// Start a span as child of the span in the context, implements crowd.Tracer.
+// The API does not retry requests, so every span covers exactly one attempt
+// and its retry count is always zero.
+func (t *Tracer) StartRequest(ctx context.Context, info crowd.RequestInfo, header *fasthttp.RequestHeader) func(crowd.RequestInfo) {
+
+	target := redactPath(info.Path)
+
+	if info.Query != "" {
+		target += "?" + t.query(info.Query)
+	}
+
+	ctx, span := t.tracer.Start(ctx, "crowd."+info.Operation,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("crowd.operation", info.Operation),
+			semconv.HTTPMethodKey.String(info.Method),
+			semconv.HTTPTargetKey.String(target),
+			attribute.Int("crowd.retry_count", 0),
+		),
+	)
+
+	t.propagator.Inject(ctx, headerCarrier{header})
+
+	return func(info crowd.RequestInfo) {
+
+		if info.Status != 0 {
+			span.SetAttributes(semconv.HTTPStatusCodeKey.Int(info.Status))
+		}
+
+		switch {
+		case info.Err != nil:
+			span.RecordError(info.Err)
+			span.SetStatus(codes.Error, info.Err.Error())
+		case info.Status >= 500:
+			span.SetStatus(codes.Error, fasthttp.StatusMessage(info.Status))
+		}
+
+		span.End()
+
+	}
+
+}
+
+// Replace the token of a session or event path.
+func redactPath(path string) string {
+
+	for _, prefix := range tokenPaths {
+		if strings.HasPrefix(path, prefix) && len(path) > len(prefix) {
+			return prefix + Redacted
+		}
+	}
+
+	return path
+
+}
+
+func (t *Tracer) query(query string) string {
+
+	if !t.redactUsernames {
+		return query
+	}
+
+	params := strings.Split(query, "&")
+
+	for i, param := range params {
+		for _, name := range redactedParams {
+			if strings.HasPrefix(param, name+"=") {
+				params[i] = name + "=" + Redacted
+			}
+		}
+	}
+
+	return strings.Join(params, "&")
+
+}
+
+// Adapts fasthttp request headers to propagation.TextMapCarrier.
+type headerCarrier struct {
+	header *fasthttp.RequestHeader
+}
+
+func (c headerCarrier) Get(key string) string {
+	return string(c.header.Peek(key))
+}
+
+func (c headerCarrier) Set(key, value string) {
+	c.header.Set(key, value)
+}
+
+func (c headerCarrier) Keys() []string {
+
+	var keys []string
+
+	c.header.VisitAll(func(key, _ []byte) {
+		keys = append(keys, string(key))
+	})
+
+	return keys
+
+}
@@ -0,0 +1,154 @@
+package crowdotel
+
+import (
+	"context"
+	"github.com/agile-rcm/crowd-go"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTracer_StartRequest(t *testing.T) {
+
+	var traceparent string
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		traceparent = r.Header.Get("traceparent")
+
+		w.WriteHeader(http.StatusNotFound)
+
+	}))
+	defer server.Close()
+
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	tracer := NewTracer(
+		WithTracerProvider(provider),
+		WithPropagator(propagation.TraceContext{}),
+		WithRedactedUsernames(),
+	)
+
+	api, err := crowd.NewAPI(server.URL, "testapp", "password", crowd.WithTracer(tracer))
+
+	assert.Nil(t, err)
+
+	ctx, parent := provider.Tracer("test").Start(context.Background(), "parent")
+
+	_, err = api.WithContext(ctx).GetUser("testuser")
+
+	parent.End()
+
+	assert.Equal(t, crowd.ErrorUserNotFound, err)
+
+	spans := recorder.Ended()
+
+	assert.Len(t, spans, 2)
+
+	span := spans[0]
+
+	assert.Equal(t, "crowd.GetUser", span.Name())
+	assert.Equal(t, parent.SpanContext().SpanID(), span.Parent().SpanID())
+	assert.Equal(t, codes.Unset, span.Status().Code)
+	assert.Contains(t, traceparent, span.SpanContext().SpanID().String())
+	assert.ElementsMatch(t, []attribute.KeyValue{
+		attribute.String("crowd.operation", "GetUser"),
+		attribute.String("http.method", "GET"),
+		attribute.String("http.target", "/rest/usermanagement/1/user?username=REDACTED"),
+		attribute.Int("http.status_code", 404),
+		attribute.Int("crowd.retry_count", 0),
+	}, span.Attributes())
+
+}
+
+func TestTracer_StartRequestError(t *testing.T) {
+
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	api, err := crowd.NewAPI("http://127.0.0.1:1", "testapp", "password",
+		crowd.WithTracer(NewTracer(WithTracerProvider(provider))),
+	)
+
+	assert.Nil(t, err)
+
+	err = api.RemoveUser("testuser")
+
+	assert.NotNil(t, err)
+
+	spans := recorder.Ended()
+
+	assert.Len(t, spans, 1)
+	assert.Equal(t, "crowd.RemoveUser", spans[0].Name())
+	assert.Equal(t, codes.Error, spans[0].Status().Code)
+
+}
+
+func TestTracer_StartRequestTokens(t *testing.T) {
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	api, err := crowd.NewAPI(server.URL, "testapp", "password",
+		crowd.WithTracer(NewTracer(WithTracerProvider(provider))),
+	)
+
+	assert.Nil(t, err)
+
+	_, err = api.GetSession("secret-session-token")
+
+	assert.NotNil(t, err)
+
+	_, err = api.GetEvents("secret-event-token")
+
+	assert.NotNil(t, err)
+
+	spans := recorder.Ended()
+
+	if assert.Len(t, spans, 2) {
+		assert.Contains(t, spans[0].Attributes(), attribute.String("http.target", "/rest/usermanagement/1/session/REDACTED"))
+		assert.Contains(t, spans[1].Attributes(), attribute.String("http.target", "/rest/usermanagement/1/event/REDACTED"))
+	}
+
+}
+
+func TestTracer_StartRequestRestriction(t *testing.T) {
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	api, err := crowd.NewAPI(server.URL, "testapp", "password",
+		crowd.WithTracer(NewTracer(WithTracerProvider(provider), WithRedactedUsernames())),
+	)
+
+	assert.Nil(t, err)
+
+	_, err = api.SearchUsers(`email = "alice@example.com"`, 0, 10)
+
+	assert.NotNil(t, err)
+
+	spans := recorder.Ended()
+
+	if assert.Len(t, spans, 1) {
+		assert.Contains(t, spans[0].Attributes(), attribute.String("http.target",
+			"/rest/usermanagement/1/search?entity-type=user&expand=user&restriction=REDACTED&start-index=0&max-results=10"))
+	}
+
+}
@@ -0,0 +1,211 @@
+package crowdtest
+
+import (
+	"context"
+	"github.com/agile-rcm/crowd-go"
+	"github.com/valyala/fasthttp"
+	"math/rand"
+	"net"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// A fault injected into a request. The zero value lets the request pass.
+type Fault struct {
+	// Delay the request. The delay ends early if the context is done.
+	Latency time.Duration
+	// Fail the request with a connection reset instead of sending it.
+	Reset bool
+	// Answer with this status and a crowd error body instead of sending
+	// the request.
+	Status int
+	// Send the request, but truncate the body of the response so it is not
+	// valid JSON.
+	MalformedJSON bool
+}
+
+// Selects the requests a fault is injected into.
+type ChaosRule struct {
+	// Method and path of the matched requests. The pattern is matched
+	// against the path of the request, relative to the REST resources, with
+	// path.Match. An empty method or pattern matches all requests.
+	Method  string
+	Pattern string
+
+	// Faults for the first matching requests, in order. A zero Fault in the
+	// script lets a request pass.
+	Script []Fault
+
+	// Once the script is used up, inject Fault with this probability.
+	Probability float64
+	Fault       Fault
+
+	// Inject the probabilistic fault into this many consecutive matching
+	// requests once it fires, for example to simulate a burst of 5xx.
+	Burst int
+}
+
+// Injects faults into the requests of an API, to test its behaviour when
+// crowd is slow or failing. Add it with api.Use(chaos.Interceptor()), it
+// wraps the transport or the interceptors added after it, for example a
+// Replayer.
+type Chaos struct {
+	mu       sync.Mutex
+	rules    []*chaosRule
+	random   *rand.Rand
+	injected int
+}
+
+type chaosRule struct {
+	ChaosRule
+	position int
+	burst    int
+}
+
+// Create a chaos interceptor applying the given rules. The faults of all
+// matching rules are combined. The seed makes probabilistic faults
+// reproducible.
+func NewChaos(seed int64, rules ...ChaosRule) *Chaos {
+
+	c := &Chaos{random: rand.New(rand.NewSource(seed))}
+
+	for _, rule := range rules {
+		c.rules = append(c.rules, &chaosRule{ChaosRule: rule})
+	}
+
+	return c
+
+}
+
+// Get the number of requests a fault was injected into.
+func (c *Chaos) Injected() int {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.injected
+
+}
+
+// Get the interceptor injecting the faults.
+func (c *Chaos) Interceptor() crowd.Interceptor {
+
+	return func(next crowd.RoundTrip) crowd.RoundTrip {
+		return func(ctx context.Context, request *fasthttp.Request, response *fasthttp.Response) error {
+
+			fault := c.fault(string(request.Header.Method()), string(request.URI().Path()))
+
+			if fault.Latency > 0 {
+
+				timer := time.NewTimer(fault.Latency)
+
+				select {
+				case <-timer.C:
+				case <-ctx.Done():
+					timer.Stop()
+					return ctx.Err()
+				}
+
+			}
+
+			switch {
+			case fault.Reset:
+				return &net.OpError{
+					Op:  "read",
+					Net: "tcp",
+					Err: os.NewSyscallError("read", syscall.ECONNRESET),
+				}
+			case fault.Status != 0:
+				response.SetStatusCode(fault.Status)
+				response.Header.SetContentType("application/json")
+				response.SetBodyString(`{"reason":"OPERATION_FAILED","message":"Injected fault"}`)
+				return nil
+			}
+
+			err := next(ctx, request, response)
+
+			if err == nil && fault.MalformedJSON {
+				body := response.Body()
+				response.SetBody(append([]byte(`{"`), body[:len(body)/2]...))
+			}
+
+			return err
+
+		}
+	}
+
+}
+
+// Decide on the fault for a request.
+func (c *Chaos) fault(method, requestPath string) Fault {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	resource := strings.TrimPrefix(requestPath, restPath)
+	combined := Fault{}
+
+	for _, rule := range c.rules {
+
+		if rule.Method != "" && rule.Method != method {
+			continue
+		}
+
+		if matched, _ := path.Match(rule.Pattern, resource); rule.Pattern != "" && !matched {
+			continue
+		}
+
+		combined = combine(combined, rule.next(c.random))
+
+	}
+
+	if combined != (Fault{}) {
+		c.injected++
+	}
+
+	return combined
+
+}
+
+// Get the fault for the next matching request.
+func (r *chaosRule) next(random *rand.Rand) Fault {
+
+	if r.position < len(r.Script) {
+		r.position++
+		return r.Script[r.position-1]
+	}
+
+	if r.burst > 0 {
+		r.burst--
+		return r.Fault
+	}
+
+	if r.Probability <= 0 || random.Float64() >= r.Probability {
+		return Fault{}
+	}
+
+	if r.Burst > 1 {
+		r.burst = r.Burst - 1
+	}
+
+	return r.Fault
+
+}
+
+func combine(a, b Fault) Fault {
+
+	a.Latency += b.Latency
+	a.Reset = a.Reset || b.Reset
+	a.MalformedJSON = a.MalformedJSON || b.MalformedJSON
+
+	if a.Status == 0 {
+		a.Status = b.Status
+	}
+
+	return a
+
+}
@@ -0,0 +1,125 @@
+package crowdtest
+
+import (
+	"context"
+	"errors"
+	"github.com/agile-rcm/crowd-go"
+	"github.com/stretchr/testify/assert"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestChaos_Script(t *testing.T) {
+
+	server := NewServer()
+	defer server.Close()
+
+	server.SeedUser("testuser", "password")
+
+	chaos := NewChaos(1, ChaosRule{
+		Method:  "GET",
+		Pattern: "/user",
+		Script:  []Fault{{Reset: true}, {Status: 503}, {MalformedJSON: true}, {}},
+	})
+
+	api := server.API()
+	api.Use(chaos.Interceptor())
+
+	_, err := api.GetUser("testuser")
+
+	assert.True(t, errors.Is(err, syscall.ECONNRESET))
+
+	_, err = api.GetUser("testuser")
+
+	assert.EqualError(t, err, "Unknown response: 503")
+
+	_, err = api.GetUser("testuser")
+
+	assert.NotNil(t, err)
+
+	user, err := api.GetUser("testuser")
+
+	assert.Nil(t, err)
+	assert.Equal(t, "testuser", user.Name)
+
+	_, err = api.GetGroup("testgroup")
+
+	assert.Equal(t, crowd.ErrorGroupNotFound, err)
+	assert.Equal(t, 3, chaos.Injected())
+
+}
+
+func TestChaos_Burst(t *testing.T) {
+
+	server := NewServer()
+	defer server.Close()
+
+	chaos := NewChaos(1, ChaosRule{
+		Probability: 1,
+		Fault:       Fault{Status: 500},
+		Burst:       3,
+	}, ChaosRule{
+		Pattern: "/session/*",
+		Fault:   Fault{Status: 502},
+	})
+
+	api := server.API()
+	api.Use(chaos.Interceptor())
+
+	for i := 0; i < 3; i++ {
+
+		_, err := api.GetSession("token")
+
+		assert.EqualError(t, err, "Unknown response: 500")
+
+	}
+
+	assert.Equal(t, 3, chaos.Injected())
+
+}
+
+func TestChaos_Probability(t *testing.T) {
+
+	server := NewServer()
+	defer server.Close()
+
+	chaos := NewChaos(42, ChaosRule{
+		Probability: 0.3,
+		Fault:       Fault{Status: 503},
+	})
+
+	api := server.API()
+	api.Use(chaos.Interceptor())
+
+	failed := 0
+
+	for i := 0; i < 200; i++ {
+		if _, err := api.GetGroup("testgroup"); err != crowd.ErrorGroupNotFound {
+			failed++
+		}
+	}
+
+	assert.Equal(t, chaos.Injected(), failed)
+	assert.InDelta(t, 60, failed, 25)
+
+}
+
+func TestChaos_Latency(t *testing.T) {
+
+	chaos := NewChaos(1, ChaosRule{Script: []Fault{{Latency: time.Hour}}})
+
+	api, err := crowd.NewAPI("http://crowd.invalid", "testapp", "password")
+
+	assert.Nil(t, err)
+
+	api.Use(chaos.Interceptor(), NewReplayer(nil).Interceptor())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	_, err = api.WithContext(ctx).GetUser("testuser")
+
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+}
@@ -0,0 +1,447 @@
+package crowdtest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/agile-rcm/crowd-go"
+	"github.com/valyala/fasthttp"
+	"io/ioutil"
+	"strings"
+	"sync"
+)
+
+// Replaces secrets in recorded interactions.
+const redacted = "REDACTED"
+
+// A recorded request and its response. Request bodies are compared as JSON
+// when replaying, so the order of their fields does not matter.
+type Interaction struct {
+	Operation      string            `json:"operation"`
+	Method         string            `json:"method"`
+	URI            string            `json:"uri"`
+	RequestHeader  map[string]string `json:"requestHeader,omitempty"`
+	RequestBody    json.RawMessage   `json:"requestBody,omitempty"`
+	Status         int               `json:"status"`
+	ContentType    string            `json:"contentType,omitempty"`
+	ResponseBody   json.RawMessage   `json:"responseBody,omitempty"`
+	ResponseString string            `json:"responseString,omitempty"`
+}
+
+// The golden file format, a list of interactions in the order they were
+// recorded.
+type Fixture struct {
+	Interactions []*Interaction `json:"interactions"`
+}
+
+// Records the requests of an API and the responses of crowd. Authorization
+// headers, passwords, session and event tokens are scrubbed.
+type Recorder struct {
+	mu      sync.Mutex
+	fixture Fixture
+}
+
+// Create a new recorder. Add it to an API with api.Use(recorder.Interceptor()).
+func NewRecorder() *Recorder {
+	return &Recorder{fixture: Fixture{Interactions: []*Interaction{}}}
+}
+
+// Get the interceptor recording the requests passing through it.
+func (r *Recorder) Interceptor() crowd.Interceptor {
+
+	return func(next crowd.RoundTrip) crowd.RoundTrip {
+		return func(ctx context.Context, request *fasthttp.Request, response *fasthttp.Response) error {
+
+			err := next(ctx, request, response)
+
+			if err != nil {
+				return err
+			}
+
+			interaction := &Interaction{
+				Operation:     crowd.OperationFromContext(ctx),
+				Method:        string(request.Header.Method()),
+				URI:           scrubURI(string(request.URI().RequestURI())),
+				RequestHeader: scrubHeader(&request.Header),
+				RequestBody:   scrubBody(request.Body()),
+				Status:        response.StatusCode(),
+				ContentType:   string(response.Header.ContentType()),
+			}
+
+			if body := response.Body(); json.Valid(body) {
+				interaction.ResponseBody = scrubResponse(body)
+			} else {
+				interaction.ResponseString = string(body)
+			}
+
+			r.mu.Lock()
+			r.fixture.Interactions = append(r.fixture.Interactions, interaction)
+			r.mu.Unlock()
+
+			return nil
+
+		}
+	}
+
+}
+
+// Get the interactions recorded so far.
+func (r *Recorder) Interactions() []*Interaction {
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]*Interaction(nil), r.fixture.Interactions...)
+
+}
+
+// Write the recorded interactions to a golden file.
+func (r *Recorder) Save(path string) error {
+
+	r.mu.Lock()
+	data, err := json.MarshalIndent(r.fixture, "", "  ")
+	r.mu.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(path, append(data, '\n'), 0644)
+
+}
+
+// Answers requests with recorded interactions instead of sending them to
+// crowd. A request matches an interaction with the same method and, after
+// scrubbing, uri and body. Matching interactions are replayed once each, in
+// the order they were recorded. Requests without a matching interaction, or
+// whose matching interactions were all replayed, fail.
+type Replayer struct {
+	// Repeat the last matching interaction once all were replayed, for tests
+	// which make the same request an unknown number of times.
+	RepeatLast bool
+
+	mu           sync.Mutex
+	interactions []*Interaction
+	used         []bool
+}
+
+// Create a replayer for the interactions of a golden file written by
+// Recorder.Save.
+func LoadReplayer(path string) (*Replayer, error) {
+
+	data, err := ioutil.ReadFile(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	fixture := Fixture{}
+
+	if err := json.Unmarshal(data, &fixture); err != nil {
+		return nil, fmt.Errorf("crowdtest: invalid fixture %s: %v", path, err)
+	}
+
+	return NewReplayer(fixture.Interactions), nil
+
+}
+
+// Create a replayer for the given interactions.
+func NewReplayer(interactions []*Interaction) *Replayer {
+
+	return &Replayer{
+		interactions: interactions,
+		used:         make([]bool, len(interactions)),
+	}
+
+}
+
+// Get the interceptor answering requests, it never calls the transport.
+func (r *Replayer) Interceptor() crowd.Interceptor {
+
+	return func(next crowd.RoundTrip) crowd.RoundTrip {
+		return func(ctx context.Context, request *fasthttp.Request, response *fasthttp.Response) error {
+
+			method := string(request.Header.Method())
+			uri := string(request.URI().RequestURI())
+
+			interaction, matched := r.match(method, scrubURI(uri), scrubBody(request.Body()))
+
+			switch {
+			case !matched:
+				return fmt.Errorf("crowdtest: no recorded interaction for %s %s", method, uri)
+			case interaction == nil:
+				return fmt.Errorf("crowdtest: all recorded interactions for %s %s were replayed", method, uri)
+			}
+
+			response.SetStatusCode(interaction.Status)
+
+			if interaction.ContentType != "" {
+				response.Header.SetContentType(interaction.ContentType)
+			}
+
+			if len(interaction.ResponseBody) > 0 {
+				response.SetBody(interaction.ResponseBody)
+			} else {
+				response.SetBodyString(interaction.ResponseString)
+			}
+
+			return nil
+
+		}
+	}
+
+}
+
+// Get the interactions which were not replayed, to check that a test made
+// all recorded requests.
+func (r *Replayer) Unused() []*Interaction {
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var unused []*Interaction
+
+	for i, interaction := range r.interactions {
+		if !r.used[i] {
+			unused = append(unused, interaction)
+		}
+	}
+
+	return unused
+
+}
+
+// Get the next matching interaction, nil if all were replayed, and whether
+// any interaction matches.
+func (r *Replayer) match(method, uri string, body json.RawMessage) (*Interaction, bool) {
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	last := -1
+
+	for i, interaction := range r.interactions {
+
+		if interaction.Method != method || interaction.URI != uri || !sameJSON(interaction.RequestBody, body) {
+			continue
+		}
+
+		if !r.used[i] {
+			r.used[i] = true
+			return interaction, true
+		}
+
+		last = i
+
+	}
+
+	if last < 0 {
+		return nil, false
+	}
+
+	if !r.RepeatLast {
+		return nil, true
+	}
+
+	return r.interactions[last], true
+
+}
+
+// Scrubbing
+
+// Get the request headers worth recording, with credentials redacted.
+func scrubHeader(header *fasthttp.RequestHeader) map[string]string {
+
+	scrubbed := make(map[string]string)
+
+	header.VisitAll(func(key, value []byte) {
+
+		switch name := string(key); {
+		case strings.EqualFold(name, "Authorization"):
+			scrubbed[name] = redacted
+		case strings.EqualFold(name, "User-Agent"), strings.EqualFold(name, "Host"), strings.EqualFold(name, "Content-Length"):
+			// Differ between runs and environments, not worth recording.
+		default:
+			scrubbed[name] = string(value)
+		}
+
+	})
+
+	return scrubbed
+
+}
+
+// Paths of the requests for a session or for events, followed by the token.
+var tokenPaths = []string{
+	"/rest/usermanagement/1/session/",
+	"/rest/usermanagement/1/event/",
+}
+
+// Redact the session or event token of a request uri.
+func scrubURI(uri string) string {
+
+	for _, path := range tokenPaths {
+
+		index := strings.Index(uri, path)
+
+		if index < 0 || len(uri) == index+len(path) {
+			continue
+		}
+
+		token := uri[index+len(path):]
+		rest := ""
+
+		if end := strings.IndexAny(token, "?#"); end >= 0 {
+			rest = token[end:]
+		}
+
+		return uri[:index+len(path)] + redacted + rest
+
+	}
+
+	return uri
+
+}
+
+// Redact the session and event tokens in a JSON response body: token and
+// newEventToken fields and the token in the href of links to the session.
+func scrubResponse(body []byte) json.RawMessage {
+
+	var decoded interface{}
+
+	if err := json.Unmarshal(body, &decoded); err != nil {
+		return nil
+	}
+
+	scrubbed, err := json.Marshal(scrubTokens(decoded))
+
+	if err != nil {
+		return nil
+	}
+
+	return scrubbed
+
+}
+
+func scrubTokens(value interface{}) interface{} {
+
+	switch value := value.(type) {
+	case map[string]interface{}:
+
+		for key, field := range value {
+
+			switch text, ok := field.(string); {
+			case ok && (key == "token" || key == "newEventToken"):
+				value[key] = redacted
+			case ok && key == "href":
+				value[key] = scrubURI(text)
+			default:
+				value[key] = scrubTokens(field)
+			}
+
+		}
+
+		return value
+
+	case []interface{}:
+
+		for i, element := range value {
+			value[i] = scrubTokens(element)
+		}
+
+		return value
+
+	default:
+		return value
+	}
+
+}
+
+// Redact the passwords in a JSON request body: the values of password fields
+// and the value of a bare password body, as sent to authenticate a user.
+// Bodies which are not JSON are dropped.
+func scrubBody(body []byte) json.RawMessage {
+
+	if len(bytes.TrimSpace(body)) == 0 {
+		return nil
+	}
+
+	var decoded interface{}
+
+	if err := json.Unmarshal(body, &decoded); err != nil {
+		return nil
+	}
+
+	if object, ok := decoded.(map[string]interface{}); ok && len(object) == 1 {
+		if _, ok := object["value"]; ok {
+			object["value"] = redacted
+		}
+	}
+
+	scrubbed, err := json.Marshal(scrubPasswords(decoded))
+
+	if err != nil {
+		return nil
+	}
+
+	return scrubbed
+
+}
+
+func scrubPasswords(value interface{}) interface{} {
+
+	switch value := value.(type) {
+	case map[string]interface{}:
+
+		for key, field := range value {
+
+			if !strings.EqualFold(key, "password") {
+				value[key] = scrubPasswords(field)
+				continue
+			}
+
+			if object, ok := field.(map[string]interface{}); ok {
+				object["value"] = redacted
+				continue
+			}
+
+			value[key] = redacted
+
+		}
+
+		return value
+
+	case []interface{}:
+
+		for i, element := range value {
+			value[i] = scrubPasswords(element)
+		}
+
+		return value
+
+	default:
+		return value
+	}
+
+}
+
+// Compare two JSON documents, ignoring the order of object fields.
+func sameJSON(a, b json.RawMessage) bool {
+
+	if len(a) == 0 || len(b) == 0 {
+		return len(a) == len(b)
+	}
+
+	var decodedA, decodedB interface{}
+
+	if json.Unmarshal(a, &decodedA) != nil || json.Unmarshal(b, &decodedB) != nil {
+		return false
+	}
+
+	encodedA, _ := json.Marshal(decodedA)
+	encodedB, _ := json.Marshal(decodedB)
+
+	return bytes.Equal(encodedA, encodedB)
+
+}
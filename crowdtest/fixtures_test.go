@@ -0,0 +1,219 @@
+package crowdtest
+
+import (
+	"flag"
+	"github.com/agile-rcm/crowd-go"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "update the golden files")
+
+// The scenario recorded in testdata/users.json.
+func usersScenario(t *testing.T, api *crowd.API) {
+
+	assert.Nil(t, api.AddUser("testuser", "secret", "Test", "User", "", "test@example.com", true))
+	assert.Nil(t, api.AuthenticateUser("testuser", "secret"))
+
+	user, err := api.GetUser("testuser")
+
+	assert.Nil(t, err)
+	assert.Equal(t, "test@example.com", user.Email)
+
+	assert.Nil(t, api.RemoveUser("testuser"))
+
+	_, err = api.GetUser("testuser")
+
+	assert.Equal(t, crowd.ErrorUserNotFound, err)
+
+}
+
+func TestReplayer_Golden(t *testing.T) {
+
+	golden := filepath.Join("testdata", "users.json")
+
+	if *update {
+
+		server := NewServer()
+		defer server.Close()
+
+		recorder := NewRecorder()
+
+		api := server.API()
+		api.Use(recorder.Interceptor())
+
+		usersScenario(t, api)
+
+		assert.Nil(t, recorder.Save(golden))
+
+	}
+
+	replayer, err := LoadReplayer(golden)
+
+	assert.Nil(t, err)
+
+	api, err := crowd.NewAPI("http://crowd.invalid", "testapp", "password")
+
+	assert.Nil(t, err)
+
+	api.Use(replayer.Interceptor())
+
+	usersScenario(t, api)
+
+	assert.Empty(t, replayer.Unused())
+
+}
+
+func TestRecorder_Scrub(t *testing.T) {
+
+	server := NewServer()
+	defer server.Close()
+
+	recorder := NewRecorder()
+
+	api := server.API()
+	api.Use(recorder.Interceptor())
+
+	assert.Nil(t, api.AddUser("testuser", "secret", "", "", "", "", true))
+	assert.Nil(t, api.AuthenticateUser("testuser", "secret"))
+
+	session, err := api.CreateSession("testuser", "secret", nil)
+
+	assert.Nil(t, err)
+
+	_, err = api.GetSession(session.Token)
+
+	assert.Nil(t, err)
+
+	path := filepath.Join(t.TempDir(), "fixture.json")
+
+	assert.Nil(t, recorder.Save(path))
+
+	data, err := ioutil.ReadFile(path)
+
+	assert.Nil(t, err)
+	assert.NotContains(t, string(data), "secret")
+	assert.NotContains(t, string(data), session.Token)
+
+	interactions := recorder.Interactions()
+
+	assert.Len(t, interactions, 4)
+	assert.Equal(t, "AddUser", interactions[0].Operation)
+	assert.Equal(t, redacted, interactions[0].RequestHeader["Authorization"])
+	assert.JSONEq(t, `{"value":"REDACTED"}`, string(interactions[1].RequestBody))
+	assert.JSONEq(t, `{"username":"testuser","password":"REDACTED"}`, string(interactions[2].RequestBody))
+	assert.Equal(t, "/rest/usermanagement/1/session/REDACTED", interactions[3].URI)
+
+	// Sessions are replayed whatever their token.
+	replayer := NewReplayer(interactions)
+
+	api = server.API()
+	api.Use(replayer.Interceptor())
+
+	replayed, err := api.GetSession("another-token")
+
+	assert.Nil(t, err)
+	assert.Equal(t, redacted, replayed.Token)
+
+	// Crowd also links to the session.
+	assert.JSONEq(t,
+		`{"token":"REDACTED","link":{"rel":"self","href":"http://crowd/rest/usermanagement/1/session/REDACTED"}}`,
+		string(scrubResponse([]byte(`{"token":"abc","link":{"rel":"self","href":"http://crowd/rest/usermanagement/1/session/abc"}}`))),
+	)
+
+}
+
+func TestRecorder_ScrubEvents(t *testing.T) {
+
+	server := NewServer()
+	defer server.Close()
+
+	recorder := NewRecorder()
+
+	api := server.API()
+	api.Use(recorder.Interceptor())
+
+	eventToken, err := api.GetEventToken()
+
+	assert.Nil(t, err)
+
+	events, err := api.GetEvents(eventToken)
+
+	assert.Nil(t, err)
+
+	path := filepath.Join(t.TempDir(), "fixture.json")
+
+	assert.Nil(t, recorder.Save(path))
+
+	data, err := ioutil.ReadFile(path)
+
+	assert.Nil(t, err)
+	assert.NotContains(t, string(data), eventToken)
+	assert.NotContains(t, string(data), events.NewEventToken)
+
+	interactions := recorder.Interactions()
+
+	assert.Len(t, interactions, 2)
+	assert.Equal(t, "/rest/usermanagement/1/event/REDACTED", interactions[1].URI)
+
+	// Events are replayed whatever their token.
+	replayer := NewReplayer(interactions)
+
+	api = server.API()
+	api.Use(replayer.Interceptor())
+
+	eventToken, err = api.GetEventToken()
+
+	assert.Nil(t, err)
+	assert.Equal(t, redacted, eventToken)
+
+	_, err = api.GetEvents("another-token")
+
+	assert.Nil(t, err)
+
+}
+
+func TestReplayer_Unmatched(t *testing.T) {
+
+	replayer := NewReplayer([]*Interaction{
+		{Method: "GET", URI: "/rest/usermanagement/1/group?groupname=testgroup", Status: 200, ResponseBody: []byte(`{"name":"testgroup"}`)},
+		{Method: "POST", URI: "/rest/usermanagement/1/authentication?username=testuser", RequestBody: []byte(`{"value":"REDACTED"}`), Status: 200},
+	})
+
+	api, err := crowd.NewAPI("http://crowd.invalid", "testapp", "password")
+
+	assert.Nil(t, err)
+
+	api.Use(replayer.Interceptor())
+
+	group, err := api.GetGroup("testgroup")
+
+	assert.Nil(t, err)
+	assert.Equal(t, "testgroup", group.Name)
+
+	assert.Nil(t, api.AuthenticateUser("testuser", "any password"))
+
+	_, err = api.GetGroup("othergroup")
+
+	assert.EqualError(t, err, "crowdtest: no recorded interaction for GET /rest/usermanagement/1/group?groupname=othergroup")
+	assert.Empty(t, replayer.Unused())
+
+	// The recorded interactions are used up.
+	_, err = api.GetGroup("testgroup")
+
+	assert.EqualError(t, err, "crowdtest: all recorded interactions for GET /rest/usermanagement/1/group?groupname=testgroup were replayed")
+
+	replayer.RepeatLast = true
+
+	for i := 0; i < 2; i++ {
+
+		group, err := api.GetGroup("testgroup")
+
+		assert.Nil(t, err)
+		assert.Equal(t, "testgroup", group.Name)
+
+	}
+
+}
@@ -0,0 +1,757 @@
+// Package crowdtest provides an in-memory crowd server for integration
+// tests. It implements the usermanagement REST resources used by the crowd
+// package, so scenarios can run offline against a real crowd.API.
+package crowdtest
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/agile-rcm/crowd-go"
+	"github.com/agile-rcm/crowd-go/crowdmock"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const restPath = "/rest/usermanagement/1"
+
+// A fake crowd server. The state is kept in a crowdmock.Client, which may be
+// used to inspect or change it directly.
+type Server struct {
+	*httptest.Server
+
+	// Credentials of the application allowed to use the server. Requests
+	// with other credentials are answered with status 401. Change them
+	// before making requests.
+	Application         string
+	ApplicationPassword string
+
+	// The state of the server.
+	Backend *crowdmock.Client
+
+	mu       sync.Mutex
+	latency  time.Duration
+	faults   []*fault
+	requests int
+}
+
+// A request answered with an error status instead of being handled.
+type fault struct {
+	method  string
+	pattern string
+	status  int
+	times   int
+}
+
+// Error body of crowd.
+type errorBody struct {
+	Reason  string `json:"reason"`
+	Message string `json:"message"`
+}
+
+// Create and start a new, empty server. Close it when done.
+func NewServer() *Server {
+
+	s := &Server{
+		Application:         "crowdtest",
+		ApplicationPassword: "password",
+		Backend:             crowdmock.NewClient(),
+	}
+
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+
+	return s
+
+}
+
+// Create an API for the server, authenticated as its application.
+func (s *Server) API(options ...crowd.Option) *crowd.API {
+
+	api, err := crowd.NewAPI(s.URL, s.Application, s.ApplicationPassword, options...)
+
+	if err != nil {
+		panic(err)
+	}
+
+	return api
+
+}
+
+// Seeding
+
+// Add an active user with the given password and direct memberships of the
+// given groups, creating missing groups. Panics if the user exists.
+func (s *Server) SeedUser(userName, password string, groups ...string) {
+
+	must(s.Backend.AddUser(userName, password, userName, "", userName, userName+"@example.com", true))
+
+	for _, groupName := range groups {
+		s.SeedGroup(groupName)
+		must(s.Backend.AddUserToGroup(userName, groupName))
+	}
+
+}
+
+// Add a group, if it does not exist yet, as child of the given parent
+// groups, creating missing parents.
+func (s *Server) SeedGroup(groupName string, parentGroups ...string) {
+
+	if _, err := s.Backend.GetGroup(groupName); err == crowd.ErrorGroupNotFound {
+		must(s.Backend.CreateGroup(groupName, "", true))
+	}
+
+	for _, parentGroupName := range parentGroups {
+		s.SeedGroup(parentGroupName)
+		must(s.Backend.AddChildGroupMembership(parentGroupName, groupName))
+	}
+
+}
+
+// Store attributes for a user.
+func (s *Server) SeedAttributes(userName string, attributes map[string][]string) {
+
+	stored := &crowd.Attributes{}
+
+	for name, values := range attributes {
+		stored.Attributes = append(stored.Attributes, &crowd.Attribute{Name: name, Values: values})
+	}
+
+	must(s.Backend.StoreUserAttributes(userName, stored))
+
+}
+
+func must(err error) {
+
+	if err != nil {
+		panic(fmt.Sprintf("crowdtest: seeding failed: %v", err))
+	}
+
+}
+
+// Fault injection
+
+// Delay every response by the given duration.
+func (s *Server) SetLatency(latency time.Duration) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.latency = latency
+
+}
+
+// Answer the next requests with the given method and path with the given
+// status. The path is relative to the REST resources, for example "/user",
+// and may be a pattern as accepted by path.Match, for example "/session/*".
+// An empty method or pattern matches all requests. A count of zero or less
+// fails all matching requests until ClearFaults is called.
+func (s *Server) Fail(method, pattern string, status, count int) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.faults = append(s.faults, &fault{
+		method:  method,
+		pattern: pattern,
+		status:  status,
+		times:   count,
+	})
+
+}
+
+// Remove all faults and the latency.
+func (s *Server) ClearFaults() {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.faults = nil
+	s.latency = 0
+
+}
+
+// Get the number of requests received, including rejected ones.
+func (s *Server) RequestCount() int {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.requests
+
+}
+
+// Get the injected fault status and the latency for a request. Returns a
+// status of zero if the request should be handled.
+func (s *Server) injected(method, resource string) (int, time.Duration) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.requests++
+
+	for i, f := range s.faults {
+
+		if f.method != "" && f.method != method {
+			continue
+		}
+
+		if matched, _ := path.Match(f.pattern, resource); f.pattern != "" && !matched {
+			continue
+		}
+
+		if f.times > 0 {
+
+			f.times--
+
+			if f.times == 0 {
+				s.faults = append(s.faults[:i:i], s.faults[i+1:]...)
+			}
+
+		}
+
+		return f.status, s.latency
+
+	}
+
+	return 0, s.latency
+
+}
+
+// Handling
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+
+	resource := strings.TrimPrefix(r.URL.Path, restPath)
+	status, latency := s.injected(r.Method, resource)
+
+	if latency > 0 {
+		time.Sleep(latency)
+	}
+
+	if status != 0 {
+		writeError(w, status, "OPERATION_FAILED", "Injected fault")
+		return
+	}
+
+	application, password, ok := r.BasicAuth()
+
+	if !ok || application != s.Application || password != s.ApplicationPassword {
+		w.Header().Set("WWW-Authenticate", `Basic realm="Crowd REST Service"`)
+		writeError(w, http.StatusUnauthorized, "APPLICATION_ACCESS_DENIED", "Application failed to authenticate")
+		return
+	}
+
+	if !strings.HasPrefix(r.URL.Path, restPath+"/") {
+		writeError(w, http.StatusNotFound, "NOT_FOUND", "Resource not found")
+		return
+	}
+
+	query := r.URL.Query()
+
+	switch {
+	case resource == "/user":
+		s.user(w, r, query.Get("username"))
+	case resource == "/user/rename" && r.Method == "POST":
+		s.renameUser(w, r, query.Get("username"))
+	case resource == "/user/password" && r.Method == "PUT":
+		s.setUserPassword(w, r, query.Get("username"))
+	case resource == "/user/attribute":
+		s.userAttribute(w, r, query.Get("username"), query.Get("attributename"))
+	case resource == "/authentication" && r.Method == "POST":
+		s.authentication(w, r, query.Get("username"))
+	case resource == "/user/group/nested" && r.Method == "GET":
+		groups, err := s.Backend.GetNestedGroupsForUser(query.Get("username"))
+		writeResult(w, http.StatusOK, pageGroups(groups, query), err)
+	case resource == "/user/group/direct" && r.Method == "GET":
+		groups, err := s.Backend.GetDirectGroupsForUser(query.Get("username"))
+		writeResult(w, http.StatusOK, pageGroups(groups, query), err)
+	case resource == "/user/group/direct":
+		s.userGroup(w, r, query.Get("username"), query.Get("groupname"))
+	case resource == "/group":
+		s.group(w, r, query.Get("groupname"))
+	case resource == "/group/attribute":
+		s.groupAttribute(w, r, query.Get("groupname"), query.Get("attributename"))
+	case resource == "/group/user/direct" && r.Method == "GET":
+		users, err := s.Backend.GetGroupMembers(query.Get("groupname"))
+		writeResult(w, http.StatusOK, pageUsers(users, query), err)
+	case resource == "/group/user/nested" && r.Method == "GET":
+		users, err := s.Backend.GetNestedGroupMembers(query.Get("groupname"))
+		writeResult(w, http.StatusOK, pageUsers(users, query), err)
+	case resource == "/search" && r.Method == "GET":
+		s.search(w, query)
+	case resource == "/group/child-group/direct" && r.Method == "GET":
+		groups, err := s.Backend.GetChildGroups(query.Get("groupname"))
+		writeResult(w, http.StatusOK, pageGroups(groups, query), err)
+	case resource == "/group/child-group/direct" && r.Method == "DELETE":
+		err := s.Backend.RemoveChildGroupMembership(query.Get("groupname"), query.Get("child-groupname"))
+		writeResult(w, http.StatusNoContent, nil, err)
+	case resource == "/group/child-group/direct" && r.Method == "POST":
+		s.groupMembership(w, r, query.Get("groupname"), true)
+	case resource == "/group/parent-group/direct" && r.Method == "POST":
+		s.groupMembership(w, r, query.Get("groupname"), false)
+	case resource == "/session" && r.Method == "POST":
+		s.createSession(w, r)
+	case strings.HasPrefix(resource, "/session/"):
+		s.session(w, r, strings.TrimPrefix(resource, "/session/"))
+	case resource == "/config/cookie" && r.Method == "GET":
+		config, err := s.Backend.GetCookieConfig()
+		writeResult(w, http.StatusOK, config, err)
+	case resource == "/event" && r.Method == "GET":
+		token, err := s.Backend.GetEventToken()
+		writeResult(w, http.StatusOK, &crowd.Events{NewEventToken: token, IncrementalSynchronisationAvailable: true}, err)
+	case strings.HasPrefix(resource, "/event/") && r.Method == "GET":
+		events, err := s.Backend.GetEvents(strings.TrimPrefix(resource, "/event/"))
+		writeResult(w, http.StatusOK, events, err)
+	default:
+		writeError(w, http.StatusNotFound, "NOT_FOUND", "Resource not found")
+	}
+
+}
+
+func (s *Server) user(w http.ResponseWriter, r *http.Request, userName string) {
+
+	switch r.Method {
+	case "GET":
+
+		user, err := s.Backend.GetUser(userName)
+
+		if err == nil && strings.Contains(r.URL.Query().Get("expand"), "attributes") {
+
+			attributes, err := s.Backend.GetUserAttributes(userName)
+
+			if err != nil {
+				writeResult(w, 0, nil, err)
+				return
+			}
+
+			user.Attributes = *attributes
+
+		}
+
+		writeResult(w, http.StatusOK, user, err)
+
+	case "POST":
+
+		user := &crowd.User{}
+
+		if !readBody(w, r, user) {
+			return
+		}
+
+		err := s.Backend.AddUser(user.Name, user.Password.Value, user.FirstName, user.LastName, user.DisplayName, user.Email, user.IsActive)
+
+		writeResult(w, http.StatusCreated, nil, err)
+
+	case "PUT":
+
+		user := &crowd.User{}
+
+		if !readBody(w, r, user) {
+			return
+		}
+
+		if !strings.EqualFold(user.Name, userName) {
+			writeError(w, http.StatusBadRequest, "INVALID_USER", "The username in the body does not match the username in the uri")
+			return
+		}
+
+		err := s.Backend.UpdateUser(userName, user.FirstName, user.LastName, user.DisplayName, user.Email, user.IsActive)
+
+		writeResult(w, http.StatusNoContent, nil, err)
+
+	case "DELETE":
+		writeResult(w, http.StatusNoContent, nil, s.Backend.RemoveUser(userName))
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "UNSUPPORTED_OPERATION", "Method not allowed")
+	}
+
+}
+
+func (s *Server) renameUser(w http.ResponseWriter, r *http.Request, userName string) {
+
+	rename := &crowd.UserRename{}
+
+	if !readBody(w, r, rename) {
+		return
+	}
+
+	if err := s.Backend.RenameUser(userName, rename.NewName); err != nil {
+		writeResult(w, 0, nil, err)
+		return
+	}
+
+	user, err := s.Backend.GetUser(rename.NewName)
+
+	writeResult(w, http.StatusOK, user, err)
+
+}
+
+func (s *Server) setUserPassword(w http.ResponseWriter, r *http.Request, userName string) {
+
+	password := &crowd.PasswordValue{}
+
+	if !readBody(w, r, password) {
+		return
+	}
+
+	writeResult(w, http.StatusNoContent, nil, s.Backend.SetUserPassword(userName, password.Value))
+
+}
+
+func (s *Server) userAttribute(w http.ResponseWriter, r *http.Request, userName, attributeName string) {
+
+	switch r.Method {
+	case "GET":
+		attributes, err := s.Backend.GetUserAttributes(userName)
+		writeResult(w, http.StatusOK, attributes, err)
+	case "POST":
+
+		attributes := &crowd.Attributes{}
+
+		if !readBody(w, r, attributes) {
+			return
+		}
+
+		writeResult(w, http.StatusNoContent, nil, s.Backend.StoreUserAttributes(userName, attributes))
+
+	case "DELETE":
+		writeResult(w, http.StatusNoContent, nil, s.Backend.RemoveUserAttribute(userName, attributeName))
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "UNSUPPORTED_OPERATION", "Method not allowed")
+	}
+
+}
+
+func (s *Server) groupAttribute(w http.ResponseWriter, r *http.Request, groupName, attributeName string) {
+
+	switch r.Method {
+	case "GET":
+		attributes, err := s.Backend.GetGroupAttributes(groupName)
+		writeResult(w, http.StatusOK, attributes, err)
+	case "POST":
+
+		attributes := &crowd.Attributes{}
+
+		if !readBody(w, r, attributes) {
+			return
+		}
+
+		writeResult(w, http.StatusNoContent, nil, s.Backend.StoreGroupAttributes(groupName, attributes))
+
+	case "DELETE":
+		writeResult(w, http.StatusNoContent, nil, s.Backend.RemoveGroupAttribute(groupName, attributeName))
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "UNSUPPORTED_OPERATION", "Method not allowed")
+	}
+
+}
+
+func (s *Server) authentication(w http.ResponseWriter, r *http.Request, userName string) {
+
+	password := &crowd.PasswordValue{}
+
+	if !readBody(w, r, password) {
+		return
+	}
+
+	if err := s.Backend.AuthenticateUser(userName, password.Value); err != nil {
+		writeResult(w, 0, nil, err)
+		return
+	}
+
+	user, err := s.Backend.GetUser(userName)
+
+	writeResult(w, http.StatusOK, user, err)
+
+}
+
+func (s *Server) userGroup(w http.ResponseWriter, r *http.Request, userName, groupName string) {
+
+	switch r.Method {
+	case "POST":
+
+		group := &crowd.GroupName{}
+
+		if !readBody(w, r, group) {
+			return
+		}
+
+		err := s.Backend.AddUserToGroup(userName, group.Name)
+
+		// Crowd rejects a missing group as invalid input.
+		if err == crowd.ErrorGroupNotFound {
+			writeError(w, http.StatusBadRequest, "GROUP_NOT_FOUND", err.Error())
+			return
+		}
+
+		writeResult(w, http.StatusCreated, nil, err)
+
+	case "DELETE":
+		writeResult(w, http.StatusNoContent, nil, s.Backend.RemoveUserFromGroup(userName, groupName))
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "UNSUPPORTED_OPERATION", "Method not allowed")
+	}
+
+}
+
+func (s *Server) group(w http.ResponseWriter, r *http.Request, groupName string) {
+
+	switch r.Method {
+	case "GET":
+		group, err := s.Backend.GetGroup(groupName)
+		writeResult(w, http.StatusOK, group, err)
+	case "POST":
+
+		group := &crowd.Group{}
+
+		if !readBody(w, r, group) {
+			return
+		}
+
+		writeResult(w, http.StatusCreated, nil, s.Backend.CreateGroup(group.Name, group.Description, group.Active))
+
+	case "PUT":
+
+		group := &crowd.Group{}
+
+		if !readBody(w, r, group) {
+			return
+		}
+
+		writeResult(w, http.StatusNoContent, nil, s.Backend.UpdateGroup(groupName, group.Description, group.Active))
+
+	case "DELETE":
+		writeResult(w, http.StatusNoContent, nil, s.Backend.RemoveGroup(groupName))
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "UNSUPPORTED_OPERATION", "Method not allowed")
+	}
+
+}
+
+// Add a group membership. The group in the uri is the parent if child is
+// true, the group in the body otherwise.
+func (s *Server) groupMembership(w http.ResponseWriter, r *http.Request, groupName string, child bool) {
+
+	other := &crowd.GroupName{}
+
+	if !readBody(w, r, other) {
+		return
+	}
+
+	var err error
+
+	if child {
+		err = s.Backend.AddChildGroupMembership(groupName, other.Name)
+	} else {
+		err = s.Backend.AddParentGroupMembership(other.Name, groupName)
+	}
+
+	writeResult(w, http.StatusCreated, nil, err)
+
+}
+
+func (s *Server) createSession(w http.ResponseWriter, r *http.Request) {
+
+	body := &struct {
+		UserName          string                   `json:"username"`
+		Password          string                   `json:"password"`
+		ValidationFactors *crowd.ValidationFactors `json:"validation-factors"`
+	}{}
+
+	if !readBody(w, r, body) {
+		return
+	}
+
+	session, err := s.Backend.CreateSession(body.UserName, body.Password, body.ValidationFactors)
+
+	writeResult(w, http.StatusCreated, session, err)
+
+}
+
+func (s *Server) session(w http.ResponseWriter, r *http.Request, token string) {
+
+	switch r.Method {
+	case "GET":
+		session, err := s.Backend.GetSession(token)
+		writeResult(w, http.StatusOK, session, err)
+	case "POST":
+
+		factors := &crowd.ValidationFactors{}
+
+		if !readBody(w, r, factors) {
+			return
+		}
+
+		session, err := s.Backend.ValidateSession(token, factors)
+
+		writeResult(w, http.StatusOK, session, err)
+
+	case "DELETE":
+		writeResult(w, http.StatusNoContent, nil, s.Backend.InvalidateSession(token))
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "UNSUPPORTED_OPERATION", "Method not allowed")
+	}
+
+}
+
+func (s *Server) search(w http.ResponseWriter, query url.Values) {
+
+	startIndex, maxResults := pageQuery(query)
+	restriction := query.Get("restriction")
+
+	switch query.Get("entity-type") {
+	case "user":
+		users, err := s.Backend.SearchUsers(restriction, startIndex, maxResults)
+		writeResult(w, http.StatusOK, users, err)
+	case "group":
+		groups, err := s.Backend.SearchGroups(restriction, startIndex, maxResults)
+		writeResult(w, http.StatusOK, groups, err)
+	default:
+		writeError(w, http.StatusBadRequest, "ILLEGAL_ARGUMENT", "Unknown entity type")
+	}
+
+}
+
+// Get the page requested by the start-index and max-results parameters.
+// Crowd returns at most 1000 results by default.
+func pageQuery(query url.Values) (int, int) {
+
+	startIndex, _ := strconv.Atoi(query.Get("start-index"))
+	maxResults, err := strconv.Atoi(query.Get("max-results"))
+
+	if err != nil {
+		maxResults = 1000
+	}
+
+	return startIndex, maxResults
+
+}
+
+// Get the bounds of the requested page of a listing.
+func pageRange(count int, query url.Values) (int, int) {
+
+	startIndex, maxResults := pageQuery(query)
+
+	if startIndex > count {
+		startIndex = count
+	}
+
+	end := count
+
+	if maxResults >= 0 && startIndex+maxResults < end {
+		end = startIndex + maxResults
+	}
+
+	return startIndex, end
+
+}
+
+func pageUsers(users *crowd.Users, query url.Values) *crowd.Users {
+
+	if users == nil {
+		return nil
+	}
+
+	startIndex, end := pageRange(len(users.Users), query)
+
+	return &crowd.Users{Users: users.Users[startIndex:end]}
+
+}
+
+func pageGroups(groups *crowd.Groups, query url.Values) *crowd.Groups {
+
+	if groups == nil {
+		return nil
+	}
+
+	startIndex, end := pageRange(len(groups.Groups), query)
+
+	return &crowd.Groups{Groups: groups.Groups[startIndex:end]}
+
+}
+
+func readBody(w http.ResponseWriter, r *http.Request, body interface{}) bool {
+
+	if err := json.NewDecoder(r.Body).Decode(body); err != nil {
+		writeError(w, http.StatusBadRequest, "ILLEGAL_ARGUMENT", "Invalid request body: "+err.Error())
+		return false
+	}
+
+	return true
+
+}
+
+// Write the result of a backend call: the body with the given status, or
+// the error with the status crowd uses for it.
+func writeResult(w http.ResponseWriter, status int, body interface{}, err error) {
+
+	if err != nil {
+		status, reason := errorStatus(err)
+		writeError(w, status, reason, err.Error())
+		return
+	}
+
+	if body == nil {
+		w.WriteHeader(status)
+		return
+	}
+
+	writeJSON(w, status, body)
+
+}
+
+func writeError(w http.ResponseWriter, status int, reason, message string) {
+	writeJSON(w, status, errorBody{Reason: reason, Message: message})
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	json.NewEncoder(w).Encode(body)
+
+}
+
+// Get the status and reason crowd responds with for an error.
+func errorStatus(err error) (int, string) {
+
+	switch err {
+	case crowd.ErrorUserNotFound:
+		return http.StatusNotFound, "USER_NOT_FOUND"
+	case crowd.ErrorGroupNotFound:
+		return http.StatusNotFound, "GROUP_NOT_FOUND"
+	case crowd.ErrorInvalidUserDataOrUserExists:
+		return http.StatusBadRequest, "INVALID_USER"
+	case crowd.ErrorGroupAlreadyExists:
+		return http.StatusBadRequest, "INVALID_GROUP"
+	case crowd.ErrorGroupNotFoundOrCircularDependency:
+		return http.StatusBadRequest, "INVALID_MEMBERSHIP"
+	case crowd.ErrorUserAlreadyInGroup:
+		return http.StatusConflict, "MEMBERSHIP_ALREADY_EXISTS"
+	case crowd.ErrorInvalidPassword:
+		return http.StatusBadRequest, "INVALID_CREDENTIAL"
+	case crowd.ErrorInvalidSearchRestriction:
+		return http.StatusBadRequest, "ILLEGAL_ARGUMENT"
+	case crowd.ErrorInvalidCredentials:
+		return http.StatusBadRequest, "INVALID_USER_AUTHENTICATION"
+	case crowd.ErrorSessionNotFound:
+		return http.StatusNotFound, "INVALID_SSO_TOKEN"
+	case crowd.ErrorInvalidValidationFactors:
+		return http.StatusBadRequest, "INVALID_SSO_TOKEN"
+	case crowd.ErrorEventsNotAvailable:
+		return http.StatusBadRequest, "INCREMENTAL_SYNC_NOT_AVAILABLE"
+	case crowd.ErrorEventTokenExpired:
+		return http.StatusBadRequest, "EVENT_TOKEN_EXPIRED"
+	case crowd.ErrorGeneralNoPermissions:
+		return http.StatusForbidden, "APPLICATION_PERMISSION_DENIED"
+	default:
+		return http.StatusInternalServerError, "OPERATION_FAILED"
+	}
+
+}
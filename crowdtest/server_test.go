@@ -0,0 +1,308 @@
+package crowdtest
+
+import (
+	"encoding/json"
+	"github.com/agile-rcm/crowd-go"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestServer_Users(t *testing.T) {
+
+	server := NewServer()
+	defer server.Close()
+
+	api := server.API()
+
+	err := api.AddUser("testuser", "password", "Test", "User", "", "test@example.com", true)
+
+	assert.Nil(t, err)
+
+	err = api.AddUser("testuser", "password", "Test", "User", "", "test@example.com", true)
+
+	assert.Equal(t, crowd.ErrorInvalidUserDataOrUserExists, err)
+
+	err = api.UpdateUser("testuser", "", "", "Tester", "", true)
+
+	assert.Nil(t, err)
+
+	user, err := api.GetUser("TestUser")
+
+	assert.Nil(t, err)
+	assert.Equal(t, "Tester", user.DisplayName)
+	assert.Equal(t, "test@example.com", user.Email)
+
+	assert.Nil(t, api.AuthenticateUser("testuser", "password"))
+	assert.Equal(t, crowd.ErrorInvalidCredentials, api.AuthenticateUser("testuser", "wrong"))
+
+	err = api.StoreUserAttributes("testuser", &crowd.Attributes{Attributes: []*crowd.Attribute{{Name: "team", Values: []string{"a"}}}})
+
+	assert.Nil(t, err)
+
+	attributes, err := api.GetUserAttributes("testuser")
+
+	assert.Nil(t, err)
+	assert.Equal(t, []*crowd.Attribute{{Name: "team", Values: []string{"a"}}}, attributes.Attributes)
+
+	assert.Nil(t, api.RemoveUser("testuser"))
+	assert.Equal(t, crowd.ErrorUserNotFound, api.RemoveUser("testuser"))
+
+}
+
+func TestServer_Groups(t *testing.T) {
+
+	server := NewServer()
+	defer server.Close()
+
+	server.SeedGroup("developers", "staff")
+	server.SeedUser("testuser", "password", "developers")
+
+	api := server.API()
+
+	assert.Equal(t, crowd.ErrorGroupNotFound, api.AddUserToGroup("testuser", "missing"))
+	assert.Equal(t, crowd.ErrorUserAlreadyInGroup, api.AddUserToGroup("testuser", "developers"))
+	assert.Equal(t, crowd.ErrorGroupAlreadyExists, api.CreateGroup("staff", "", true))
+
+	assert.Nil(t, api.CreateGroup("everyone", "", true))
+	assert.Nil(t, api.AddParentGroupMembership("everyone", "staff"))
+	assert.Equal(t, crowd.ErrorGroupNotFoundOrCircularDependency, api.AddChildGroupMembership("developers", "everyone"))
+
+	groups, err := api.GetNestedGroupsForUser("testuser")
+
+	assert.Nil(t, err)
+	assert.Len(t, groups.Groups, 3)
+
+	assert.Nil(t, api.RemoveUserFromGroup("testuser", "developers"))
+	assert.Nil(t, api.RemoveGroup("developers"))
+
+	_, err = api.GetGroup("developers")
+
+	assert.Equal(t, crowd.ErrorGroupNotFound, err)
+
+}
+
+func TestServer_Sessions(t *testing.T) {
+
+	server := NewServer()
+	defer server.Close()
+
+	server.SeedUser("testuser", "password")
+	server.Backend.CookieConfig = crowd.CookieConfig{Name: "crowd.token_key", Domain: ".example.com"}
+
+	api := server.API()
+
+	factors := &crowd.ValidationFactors{ValidationFactors: []*crowd.ValidationFactor{{Name: "remote_address", Value: "127.0.0.1"}}}
+
+	_, err := api.CreateSession("testuser", "wrong", factors)
+
+	assert.Equal(t, crowd.ErrorInvalidCredentials, err)
+
+	session, err := api.CreateSession("testuser", "password", factors)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "testuser", session.User.Name)
+
+	_, err = api.ValidateSession(session.Token, factors)
+
+	assert.Nil(t, err)
+
+	_, err = api.ValidateSession(session.Token, nil)
+
+	assert.Equal(t, crowd.ErrorInvalidValidationFactors, err)
+
+	assert.Nil(t, api.InvalidateSession(session.Token))
+
+	_, err = api.GetSession(session.Token)
+
+	assert.Equal(t, crowd.ErrorSessionNotFound, err)
+
+	config, err := api.GetCookieConfig()
+
+	assert.Nil(t, err)
+	assert.Equal(t, ".example.com", config.Domain)
+
+}
+
+func TestServer_Events(t *testing.T) {
+
+	server := NewServer()
+	defer server.Close()
+
+	api := server.API()
+
+	token, err := api.GetEventToken()
+
+	assert.Nil(t, err)
+
+	server.SeedUser("testuser", "password")
+
+	events, err := api.GetEvents(token)
+
+	assert.Nil(t, err)
+	assert.Equal(t, []*crowd.Event{{Operation: "CREATED", User: &crowd.User{Name: "testuser"}}}, events.Events)
+
+	server.Backend.ExpireEventTokens()
+
+	_, err = api.GetEvents(events.NewEventToken)
+
+	assert.Equal(t, crowd.ErrorEventTokenExpired, err)
+
+}
+
+func TestServer_ApplicationAuthentication(t *testing.T) {
+
+	server := NewServer()
+	defer server.Close()
+
+	api, err := crowd.NewAPI(server.URL, "otherapp", "password")
+
+	assert.Nil(t, err)
+
+	_, err = api.GetUser("testuser")
+
+	assert.EqualError(t, err, "Application failed to authenticate")
+
+}
+
+func TestServer_ErrorBody(t *testing.T) {
+
+	server := NewServer()
+	defer server.Close()
+
+	request, err := http.NewRequest("GET", server.URL+"/rest/usermanagement/1/user?username=testuser", nil)
+
+	assert.Nil(t, err)
+
+	request.SetBasicAuth(server.Application, server.ApplicationPassword)
+
+	response, err := http.DefaultClient.Do(request)
+
+	assert.Nil(t, err)
+
+	defer response.Body.Close()
+
+	body := errorBody{}
+
+	assert.Nil(t, json.NewDecoder(response.Body).Decode(&body))
+	assert.Equal(t, http.StatusNotFound, response.StatusCode)
+	assert.Equal(t, "USER_NOT_FOUND", body.Reason)
+
+}
+
+func TestServer_Fail(t *testing.T) {
+
+	server := NewServer()
+	defer server.Close()
+
+	server.SeedUser("testuser", "password")
+	server.Fail("GET", "/user", http.StatusServiceUnavailable, 2)
+
+	api := server.API()
+
+	for i := 0; i < 2; i++ {
+
+		_, err := api.GetUser("testuser")
+
+		assert.EqualError(t, err, "Unknown response: 503")
+
+	}
+
+	_, err := api.GetUser("testuser")
+
+	assert.Nil(t, err)
+
+	server.Fail("", "/session/*", http.StatusInternalServerError, 0)
+
+	for i := 0; i < 3; i++ {
+
+		_, err := api.GetSession("token")
+
+		assert.EqualError(t, err, "Unknown response: 500")
+
+	}
+
+	server.ClearFaults()
+
+	_, err = api.GetSession("token")
+
+	assert.Equal(t, crowd.ErrorSessionNotFound, err)
+	assert.Equal(t, 7, server.RequestCount())
+
+}
+
+func TestServer_SetLatency(t *testing.T) {
+
+	server := NewServer()
+	defer server.Close()
+
+	server.SetLatency(20 * time.Millisecond)
+
+	start := time.Now()
+
+	_, err := server.API().GetUser("testuser")
+
+	assert.Equal(t, crowd.ErrorUserNotFound, err)
+	assert.True(t, time.Since(start) >= 20*time.Millisecond)
+
+}
+
+func TestServer_Backend(t *testing.T) {
+
+	server := NewServer()
+	defer server.Close()
+
+	server.Backend.Hook = func(method string, args ...interface{}) error {
+
+		if method == "CreateGroup" {
+			return crowd.ErrorGeneralNoPermissions
+		}
+
+		return nil
+
+	}
+
+	err := server.API().CreateGroup("testgroup", "", true)
+
+	assert.Equal(t, crowd.ErrorGeneralNoPermissions, err)
+
+}
+
+func TestServer_Listings(t *testing.T) {
+
+	server := NewServer()
+	defer server.Close()
+
+	server.SeedGroup("developers", "staff")
+	server.SeedUser("alice", "password", "developers")
+	server.SeedUser("bob", "password", "staff")
+
+	api := server.API()
+
+	members, err := api.GetNestedGroupMembers("staff")
+
+	assert.Nil(t, err)
+	assert.Len(t, members.Users, 2)
+
+	assert.Nil(t, api.RenameUser("alice", "alicia"))
+	assert.Nil(t, api.SetUserPassword("alicia", "newpassword"))
+	assert.Nil(t, api.AuthenticateUser("alicia", "newpassword"))
+
+	users, err := api.SearchUsers("name = ali*", 0, 10)
+
+	assert.Nil(t, err)
+	assert.Len(t, users.Users, 1)
+	assert.Equal(t, "alicia", users.Users[0].Name)
+
+	groups, err := api.SearchGroups("", 1, 10)
+
+	assert.Nil(t, err)
+	assert.Len(t, groups.Groups, 1)
+	assert.Equal(t, "staff", groups.Groups[0].Name)
+
+	_, err = api.SearchUsers("nickname = x", 0, 10)
+
+	assert.Equal(t, crowd.ErrorInvalidSearchRestriction, err)
+
+}
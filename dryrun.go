@@ -0,0 +1,374 @@
+package crowd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sync"
+)
+
+// Makes the mutating calls of an API, such as AddUser or RemoveGroup, check
+// their input and record the request instead of sending it. The calls then
+// return nil as if they had succeeded. Reads, authentication and sessions
+// are still sent to crowd.
+//
+// A DryRun is safe for concurrent use and can be shared by several APIs.
+type DryRun struct {
+	// Check with GET requests that the users and groups of a call exist, or
+	// for AddUser, CreateGroup and RenameUser that they do not. A failed
+	// check returns the error crowd would, for example ErrorUserNotFound.
+	// Users and groups created, renamed or removed earlier in the dry run
+	// are checked against the dry run instead.
+	CheckExistence bool
+	// Called with each request that is not sent, for example to log it.
+	Log func(request *DryRunRequest)
+
+	mu       sync.Mutex
+	requests []*DryRunRequest
+	// Whether the users and groups changed by the dry run exist, by key of
+	// the subject.
+	changed map[string]bool
+}
+
+// A request which was not sent to crowd.
+type DryRunRequest struct {
+	// Logical operation, for example "AddUser".
+	Operation string
+	Method    string
+	Path      string
+	Query     string
+	// JSON body, or nil. Passwords are replaced by "[redacted]".
+	Body []byte
+}
+
+func (r *DryRunRequest) String() string {
+
+	uri := r.Path
+
+	if r.Query != "" {
+		uri += "?" + r.Query
+	}
+
+	if r.Body == nil {
+		return fmt.Sprintf("%s %s %s", r.Operation, r.Method, uri)
+	}
+
+	return fmt.Sprintf("%s %s %s %s", r.Operation, r.Method, uri, r.Body)
+
+}
+
+// Get the requests which were not sent, in the order they were made.
+func (d *DryRun) Requests() []*DryRunRequest {
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return append([]*DryRunRequest(nil), d.requests...)
+
+}
+
+// Make the mutating calls of the API dry runs. See DryRun.
+func WithDryRun(dryRun *DryRun) Option {
+	return func(api *API) {
+		api.dryRun = dryRun
+	}
+}
+
+// Make the mutating calls of an API using the context dry runs, for example
+// with api.WithContext(crowd.ContextWithDryRun(ctx, dryRun)). Overrides a
+// DryRun set with WithDryRun.
+func ContextWithDryRun(ctx context.Context, dryRun *DryRun) context.Context {
+	return context.WithValue(ctx, dryRunContextKey, dryRun)
+}
+
+func (api *API) dryRunOf(ctx context.Context) *DryRun {
+
+	if dryRun, ok := ctx.Value(dryRunContextKey).(*DryRun); ok {
+		return dryRun
+	}
+
+	return api.dryRun
+
+}
+
+// A user or group a call refers to.
+type dryRunSubject struct {
+	group bool
+	name  string
+	// Whether the subject has to exist, or must not exist.
+	exists bool
+}
+
+// Fields of the request bodies which name users and groups.
+type dryRunBody struct {
+	Name     *string        `json:"name"`
+	NewName  *string        `json:"new-name"`
+	Value    *string        `json:"value"`
+	Password *PasswordValue `json:"password"`
+}
+
+// Check and record a mutating request instead of sending it. Returns the
+// status crowd answers a successful request with. Requests which are not
+// covered by dry runs are not handled.
+func (d *DryRun) handle(api *API, method, uri string, body interface{}) (int, bool, error) {
+
+	operation := operationName(method, uri)
+	status, ok := dryRunStatus[operation]
+
+	if !ok {
+		return 0, false, nil
+	}
+
+	request := &DryRunRequest{
+		Operation: operation,
+		Method:    method,
+		Path:      requestPath(uri),
+		Query:     requestQuery(uri),
+	}
+
+	fields := &dryRunBody{}
+
+	if body != nil {
+
+		data, err := json.Marshal(body)
+
+		if err != nil {
+			return 0, true, err
+		}
+
+		if err := json.Unmarshal(data, fields); err != nil {
+			return 0, true, err
+		}
+
+		if request.Body, err = redactBody(operation, data); err != nil {
+			return 0, true, err
+		}
+
+	}
+
+	query, err := url.ParseQuery(request.Query)
+
+	if err != nil {
+		return 0, true, err
+	}
+
+	subjects, err := dryRunSubjects(operation, query, fields)
+
+	if err != nil {
+		return 0, true, err
+	}
+
+	if d.CheckExistence {
+
+		for _, subject := range subjects {
+			if err := d.checkSubject(api, subject); err != nil {
+				return 0, true, err
+			}
+		}
+
+	}
+
+	d.mu.Lock()
+	d.requests = append(d.requests, request)
+	d.record(operation, subjects)
+	d.mu.Unlock()
+
+	if d.Log != nil {
+		d.Log(request)
+	}
+
+	return status, true, nil
+
+}
+
+// Replace the passwords of a request body, so that they are neither kept
+// nor logged.
+func redactBody(operation string, data []byte) ([]byte, error) {
+
+	fields := make(map[string]interface{})
+
+	if err := json.Unmarshal(data, &fields); err != nil {
+		// Not an object, there is no password.
+		return data, nil
+	}
+
+	redacted := false
+
+	for name := range fields {
+		if name == "password" || (name == "value" && operation == "SetUserPassword") {
+			fields[name] = "[redacted]"
+			redacted = true
+		}
+	}
+
+	if !redacted {
+		return data, nil
+	}
+
+	return json.Marshal(fields)
+
+}
+
+// Statuses of successful responses to the operations covered by dry runs.
+var dryRunStatus = map[string]int{
+	"AddUser":                    201,
+	"UpdateUser":                 204,
+	"RemoveUser":                 204,
+	"RenameUser":                 200,
+	"SetUserPassword":            204,
+	"StoreUserAttributes":        204,
+	"RemoveUserAttribute":        204,
+	"AddUserToGroup":             201,
+	"RemoveUserFromGroup":        204,
+	"CreateGroup":                201,
+	"UpdateGroup":                204,
+	"RemoveGroup":                204,
+	"StoreGroupAttributes":       204,
+	"RemoveGroupAttribute":       204,
+	"AddChildGroupMembership":    201,
+	"RemoveChildGroupMembership": 204,
+	"AddParentGroupMembership":   201,
+}
+
+// Get the users and groups a request refers to. Returns
+// ErrorDryRunInvalidRequest if a name or a required value is empty.
+func dryRunSubjects(operation string, query url.Values, body *dryRunBody) ([]dryRunSubject, error) {
+
+	user := func(name string) dryRunSubject {
+		return dryRunSubject{name: name, exists: true}
+	}
+
+	group := func(name string) dryRunSubject {
+		return dryRunSubject{group: true, name: name, exists: true}
+	}
+
+	value := func(value *string) string {
+
+		if value == nil {
+			return ""
+		}
+
+		return *value
+
+	}
+
+	var subjects []dryRunSubject
+	var required []string
+
+	switch operation {
+	case "AddUser":
+
+		subjects = []dryRunSubject{{name: value(body.Name)}}
+
+		if body.Password == nil || body.Password.Value == "" {
+			return nil, ErrorInvalidUserDataOrUserExists
+		}
+
+	case "UpdateUser", "RemoveUser", "StoreUserAttributes":
+		subjects = []dryRunSubject{user(query.Get("username"))}
+	case "RemoveUserAttribute":
+		subjects = []dryRunSubject{user(query.Get("username"))}
+		required = []string{query.Get("attributename")}
+	case "RenameUser":
+		subjects = []dryRunSubject{user(query.Get("username")), {name: value(body.NewName)}}
+	case "SetUserPassword":
+		subjects = []dryRunSubject{user(query.Get("username"))}
+		required = []string{value(body.Value)}
+	case "AddUserToGroup":
+		subjects = []dryRunSubject{user(query.Get("username")), group(value(body.Name))}
+	case "RemoveUserFromGroup":
+		subjects = []dryRunSubject{user(query.Get("username")), group(query.Get("groupname"))}
+	case "CreateGroup":
+		subjects = []dryRunSubject{{group: true, name: value(body.Name)}}
+	case "UpdateGroup", "RemoveGroup", "StoreGroupAttributes":
+		subjects = []dryRunSubject{group(query.Get("groupname"))}
+	case "RemoveGroupAttribute":
+		subjects = []dryRunSubject{group(query.Get("groupname"))}
+		required = []string{query.Get("attributename")}
+	case "AddChildGroupMembership", "AddParentGroupMembership":
+		subjects = []dryRunSubject{group(query.Get("groupname")), group(value(body.Name))}
+	case "RemoveChildGroupMembership":
+		subjects = []dryRunSubject{group(query.Get("groupname")), group(query.Get("child-groupname"))}
+	}
+
+	for _, subject := range subjects {
+		required = append(required, subject.name)
+	}
+
+	for _, value := range required {
+		if value == "" {
+			return nil, ErrorDryRunInvalidRequest
+		}
+	}
+
+	return subjects, nil
+
+}
+
+// Remember the users and groups created, renamed or removed by a request.
+// Must be called with the lock held.
+func (d *DryRun) record(operation string, subjects []dryRunSubject) {
+
+	if d.changed == nil {
+		d.changed = make(map[string]bool)
+	}
+
+	switch operation {
+	case "AddUser", "CreateGroup":
+		d.changed[subjects[0].key()] = true
+	case "RemoveUser", "RemoveGroup":
+		d.changed[subjects[0].key()] = false
+	case "RenameUser":
+		d.changed[subjects[0].key()] = false
+		d.changed[subjects[1].key()] = true
+	}
+
+}
+
+func (s dryRunSubject) key() string {
+
+	if s.group {
+		return "group:" + cacheKey(s.name)
+	}
+
+	return "user:" + cacheKey(s.name)
+
+}
+
+// Check whether a user or group exists as required, from the changes of the
+// dry run or else with a GET request.
+func (d *DryRun) checkSubject(api *API, subject dryRunSubject) error {
+
+	d.mu.Lock()
+	exists, changed := d.changed[subject.key()]
+	d.mu.Unlock()
+
+	var err error
+
+	switch {
+	case changed && !exists && subject.group:
+		err = ErrorGroupNotFound
+	case changed && !exists:
+		err = ErrorUserNotFound
+	case changed:
+	case subject.group:
+		_, err = api.GetGroup(subject.name)
+	default:
+		_, err = api.GetUser(subject.name)
+	}
+
+	switch {
+	case err == nil && subject.exists:
+		return nil
+	case err == nil && subject.group:
+		return ErrorGroupAlreadyExists
+	case err == nil:
+		return ErrorInvalidUserDataOrUserExists
+	case (err == ErrorUserNotFound || err == ErrorGroupNotFound) && !subject.exists:
+		return nil
+	default:
+		return err
+	}
+
+}
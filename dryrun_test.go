@@ -0,0 +1,155 @@
+package crowd_test
+
+import (
+	"context"
+	"github.com/agile-rcm/crowd-go"
+	"github.com/agile-rcm/crowd-go/crowdtest"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestDryRun(t *testing.T) {
+
+	server := crowdtest.NewServer()
+	defer server.Close()
+
+	server.SeedGroup("developers")
+	server.SeedUser("alice", "password")
+
+	logged := []string{}
+	dryRun := &crowd.DryRun{Log: func(request *crowd.DryRunRequest) {
+		logged = append(logged, request.String())
+	}}
+
+	api := server.API(crowd.WithDryRun(dryRun))
+
+	assert.Nil(t, api.AddUser("bob", "secret", "Bob", "", "", "", true))
+	assert.Nil(t, api.AddUserToGroup("bob", "developers"))
+	assert.Nil(t, api.RemoveGroup("developers"))
+
+	// Nothing was sent.
+	assert.Equal(t, 0, server.Backend.CallCount("AddUserToGroup"))
+	assert.Equal(t, 0, server.Backend.CallCount("RemoveGroup"))
+
+	_, err := api.GetGroup("developers")
+
+	assert.Nil(t, err)
+
+	// Reads and authentication are sent.
+	assert.Nil(t, api.AuthenticateUser("alice", "password"))
+
+	requests := dryRun.Requests()
+
+	if assert.Len(t, requests, 3) {
+		assert.Equal(t, "AddUser", requests[0].Operation)
+		assert.Equal(t, "POST", requests[0].Method)
+		assert.Contains(t, string(requests[0].Body), `"name":"bob"`)
+		assert.Equal(t, "username=bob", requests[1].Query)
+	}
+
+	assert.Equal(t, `AddUserToGroup POST /rest/usermanagement/1/user/group/direct?username=bob {"name":"developers"}`, logged[1])
+	assert.Equal(t, "RemoveGroup DELETE /rest/usermanagement/1/group?groupname=developers", logged[2])
+
+	// Passwords are neither kept nor logged.
+	assert.Nil(t, api.SetUserPassword("alice", "secret"))
+	assert.Equal(t, `SetUserPassword PUT /rest/usermanagement/1/user/password?username=alice {"value":"[redacted]"}`, logged[3])
+
+	for _, request := range append(logged, string(requests[0].Body)) {
+		assert.NotContains(t, request, "secret")
+	}
+
+	// Inputs are validated.
+	assert.Equal(t, crowd.ErrorDryRunInvalidRequest, api.AddUserToGroup("", "developers"))
+	assert.Equal(t, crowd.ErrorDryRunInvalidRequest, api.RemoveUserAttribute("alice", ""))
+	assert.Equal(t, crowd.ErrorInvalidUserDataOrUserExists, api.AddUser("carol", "", "", "", "", "", true))
+	assert.Len(t, dryRun.Requests(), 4)
+
+}
+
+func TestDryRun_CheckExistence(t *testing.T) {
+
+	server := crowdtest.NewServer()
+	defer server.Close()
+
+	server.SeedGroup("developers")
+	server.SeedUser("alice", "password", "developers")
+
+	api := server.API(crowd.WithDryRun(&crowd.DryRun{CheckExistence: true}))
+
+	assert.Nil(t, api.AddUserToGroup("alice", "developers"))
+	assert.Nil(t, api.CreateGroup("testers", "", true))
+	assert.Equal(t, crowd.ErrorUserNotFound, api.AddUserToGroup("bob", "developers"))
+	assert.Equal(t, crowd.ErrorGroupNotFound, api.RemoveUserFromGroup("alice", "admins"))
+	assert.Equal(t, crowd.ErrorGroupAlreadyExists, api.CreateGroup("developers", "", true))
+	assert.Equal(t, crowd.ErrorInvalidUserDataOrUserExists, api.AddUser("alice", "secret", "", "", "", "", true))
+	assert.Equal(t, crowd.ErrorInvalidUserDataOrUserExists, api.RenameUser("alice", "alice"))
+
+	// Users and groups created, renamed or removed earlier in the dry run
+	// are checked against it.
+	assert.Nil(t, api.AddUserToGroup("alice", "testers"))
+	assert.Nil(t, api.AddChildGroupMembership("developers", "testers"))
+	assert.Nil(t, api.AddUser("bob", "secret", "", "", "", "", true))
+	assert.Nil(t, api.AddUserToGroup("bob", "testers"))
+	assert.Equal(t, crowd.ErrorGroupAlreadyExists, api.CreateGroup("testers", "", true))
+	assert.Nil(t, api.RenameUser("bob", "robert"))
+	assert.Equal(t, crowd.ErrorUserNotFound, api.AddUserToGroup("bob", "testers"))
+	assert.Nil(t, api.AddUserToGroup("robert", "testers"))
+	assert.Nil(t, api.RemoveGroup("developers"))
+	assert.Equal(t, crowd.ErrorGroupNotFound, api.AddUserToGroup("alice", "developers"))
+
+	// Nothing was created in crowd.
+	_, err := server.Backend.GetGroup("testers")
+
+	assert.Equal(t, crowd.ErrorGroupNotFound, err)
+
+}
+
+func TestDryRun_Context(t *testing.T) {
+
+	server := crowdtest.NewServer()
+	defer server.Close()
+
+	server.SeedUser("alice", "password")
+
+	api := server.API()
+	dryRun := &crowd.DryRun{}
+
+	assert.Nil(t, api.WithContext(crowd.ContextWithDryRun(context.Background(), dryRun)).RemoveUser("alice"))
+	assert.Len(t, dryRun.Requests(), 1)
+
+	_, err := api.GetUser("alice")
+
+	assert.Nil(t, err)
+
+	// Without the context the call is sent.
+	assert.Nil(t, api.RemoveUser("alice"))
+
+	_, err = api.GetUser("alice")
+
+	assert.Equal(t, crowd.ErrorUserNotFound, err)
+
+}
+
+func TestDryRun_Reconcile(t *testing.T) {
+
+	server := crowdtest.NewServer()
+	defer server.Close()
+
+	server.SeedUser("alice", "password")
+
+	dryRun := &crowd.DryRun{CheckExistence: true}
+	api := server.API(crowd.WithDryRun(dryRun))
+
+	desired, err := crowd.ParseDesiredState([]byte("groups:\n  - name: newgroup\n    members: [alice]\n"))
+
+	assert.Nil(t, err)
+
+	plan, err := crowd.PlanReconcile(api, desired, nil)
+
+	assert.Nil(t, err)
+
+	// The new group is a dry run too, but alice can be added to it.
+	assert.Nil(t, plan.Apply(api))
+	assert.Len(t, dryRun.Requests(), 2)
+
+}
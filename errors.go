@@ -7,6 +7,8 @@ var (
 	ErrorGeneralEmptyApplication 	= errors.New("You must set the crowd application name")
 	ErrorGeneralEmptyPassword 		= errors.New("You must set a password to access the crowd application")
 	ErrorGeneralNoPermissions     	= errors.New("Your application has no permission to perform the desired request")
+	ErrorCrowdUnavailable			= errors.New("Crowd is unavailable, requests are suspended by the circuit breaker")
+	ErrorDryRunInvalidRequest		= errors.New("The request of the dry run is not valid, for example a user or group name is empty")
 )
 
 var (
@@ -14,10 +16,37 @@ var (
 	ErrorUserNotFound      				= errors.New("User could not be found")
 	ErrorInvalidUserDataOrUserExists	= errors.New("Invalid user data, for example missing password or the user already exists")
 	ErrorInvalidUserDataOrMismatch		= errors.New("Invalid user data, for example the usernames in the body and the uri don't match")
+	ErrorInvalidCredentials				= errors.New("The user could not be authenticated, for example the password is wrong or the user is inactive")
+	ErrorInvalidPassword				= errors.New("The password does not meet the requirements of the directory")
 )
 
 var (
 	ErrorGroupNotFound      				= errors.New("Group could not be found")
 	ErrorGroupAlreadyExists 				= errors.New("Group already exists")
 	ErrorGroupNotFoundOrCircularDependency	= errors.New("Child group could not be found, or adding the membership would result in a circular dependency.")
-)
\ No newline at end of file
+)
+
+var (
+	ErrorInvalidSearchRestriction	= errors.New("The search restriction is not valid")
+)
+
+var (
+	ErrorEventsNotAvailable	= errors.New("Incremental synchronisation is not available for the application")
+	ErrorEventTokenExpired	= errors.New("The event token has expired or is not valid")
+)
+
+var (
+	ErrorSessionNotFound			= errors.New("Session could not be found, it may have expired or been invalidated")
+	ErrorInvalidValidationFactors	= errors.New("The validation factors do not match the session")
+)
+
+var (
+	ErrorImportNoNameColumn	= errors.New("The import file has no column for the user name")
+	ErrorImportNoUserName	= errors.New("The row has no user name")
+	ErrorImportNoPassword	= errors.New("The row of a new user has no password and passwords are not generated")
+	ErrorImportDuplicateUser	= errors.New("The user name is in an earlier row of the import file")
+)
+
+var (
+	ErrorReconcilePruneWithoutPrefix	= errors.New("Pruning without a prefix removes all groups which are not desired, it must be allowed explicitly")
+)
@@ -0,0 +1,672 @@
+package crowd
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// A user of an export, with its attributes and the groups it is a direct
+// member of.
+type ExportedUser struct {
+	User       *User
+	Attributes []*Attribute
+	Groups     []string
+}
+
+// A group of an export, with its attributes, direct members and direct child
+// groups.
+type ExportedGroup struct {
+	Group       *Group
+	Attributes  []*Attribute
+	Users       []string
+	ChildGroups []string
+}
+
+// Receives the entities of an export, all users before all groups.
+type ExportWriter interface {
+	WriteUser(user *ExportedUser) error
+	WriteGroup(group *ExportedGroup) error
+	// Finish the output. Does not close the underlying writer.
+	Close() error
+}
+
+type ExportOptions struct {
+	// Number of users and groups requested per page, 1000 if not set.
+	PageSize int
+}
+
+// Export all users and groups of crowd, with their attributes and direct
+// memberships. The users and groups are listed page by page and passed to the
+// writer one at a time, so memory use does not grow with the size of the
+// directory. The writer is closed when the export is complete.
+func Export(client Client, writer ExportWriter, options *ExportOptions) error {
+
+	pageSize := listPageSize
+
+	if options != nil && options.PageSize > 0 {
+		pageSize = options.PageSize
+	}
+
+	for startIndex := 0; ; startIndex += pageSize {
+
+		page, err := client.SearchUsers("", startIndex, pageSize)
+
+		if err != nil {
+			return err
+		}
+
+		for _, user := range page.Users {
+
+			exported, err := exportUser(client, user)
+
+			if err != nil {
+				return fmt.Errorf("exporting user %s: %v", user.Name, err)
+			}
+
+			if err := writer.WriteUser(exported); err != nil {
+				return err
+			}
+
+		}
+
+		if len(page.Users) < pageSize {
+			break
+		}
+
+	}
+
+	for startIndex := 0; ; startIndex += pageSize {
+
+		page, err := client.SearchGroups("", startIndex, pageSize)
+
+		if err != nil {
+			return err
+		}
+
+		for _, group := range page.Groups {
+
+			exported, err := exportGroup(client, group)
+
+			if err != nil {
+				return fmt.Errorf("exporting group %s: %v", group.Name, err)
+			}
+
+			if err := writer.WriteGroup(exported); err != nil {
+				return err
+			}
+
+		}
+
+		if len(page.Groups) < pageSize {
+			break
+		}
+
+	}
+
+	return writer.Close()
+
+}
+
+func exportUser(client Client, user *User) (*ExportedUser, error) {
+
+	attributes, err := client.GetUserAttributes(user.Name)
+
+	if err != nil {
+		return nil, err
+	}
+
+	groups, err := client.GetDirectGroupsForUser(user.Name)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &ExportedUser{User: user, Attributes: attributes.Attributes, Groups: groupNames(groups)}, nil
+
+}
+
+func exportGroup(client Client, group *Group) (*ExportedGroup, error) {
+
+	attributes, err := client.GetGroupAttributes(group.Name)
+
+	if err != nil {
+		return nil, err
+	}
+
+	members, err := client.GetGroupMembers(group.Name)
+
+	if err != nil {
+		return nil, err
+	}
+
+	children, err := client.GetChildGroups(group.Name)
+
+	if err != nil {
+		return nil, err
+	}
+
+	userNames := make([]string, len(members.Users))
+
+	for i, user := range members.Users {
+		userNames[i] = user.Name
+	}
+
+	return &ExportedGroup{Group: group, Attributes: attributes.Attributes, Users: userNames, ChildGroups: groupNames(children)}, nil
+
+}
+
+func groupNames(groups *Groups) []string {
+
+	names := make([]string, len(groups.Groups))
+
+	for i, group := range groups.Groups {
+		names[i] = group.Name
+	}
+
+	return names
+
+}
+
+// Get attributes as a map of names to values.
+func attributeMap(attributes []*Attribute) map[string][]string {
+
+	values := make(map[string][]string, len(attributes))
+
+	for _, attribute := range attributes {
+		values[attribute.Name] = attribute.Values
+	}
+
+	return values
+
+}
+
+// JSON
+
+type jsonExportWriter struct {
+	w       io.Writer
+	section string
+}
+
+type exportedUserJSON struct {
+	Name        string              `json:"name"`
+	FirstName   string              `json:"first-name"`
+	LastName    string              `json:"last-name"`
+	DisplayName string              `json:"display-name"`
+	Email       string              `json:"email"`
+	Active      bool                `json:"active"`
+	Attributes  map[string][]string `json:"attributes"`
+	Groups      []string            `json:"groups"`
+}
+
+type exportedGroupJSON struct {
+	Name        string              `json:"name"`
+	Description string              `json:"description"`
+	Active      bool                `json:"active"`
+	Attributes  map[string][]string `json:"attributes"`
+	ChildGroups []string            `json:"child-groups"`
+}
+
+// Write an export as a JSON object with the lists "users" and "groups", one
+// entity per line.
+func NewJSONExportWriter(w io.Writer) ExportWriter {
+	return &jsonExportWriter{w: w}
+}
+
+func (e *jsonExportWriter) WriteUser(user *ExportedUser) error {
+
+	return e.write("users", &exportedUserJSON{
+		Name:        user.User.Name,
+		FirstName:   user.User.FirstName,
+		LastName:    user.User.LastName,
+		DisplayName: user.User.DisplayName,
+		Email:       user.User.Email,
+		Active:      user.User.IsActive,
+		Attributes:  attributeMap(user.Attributes),
+		Groups:      user.Groups,
+	})
+
+}
+
+func (e *jsonExportWriter) WriteGroup(group *ExportedGroup) error {
+
+	return e.write("groups", &exportedGroupJSON{
+		Name:        group.Group.Name,
+		Description: group.Group.Description,
+		Active:      group.Group.Active,
+		Attributes:  attributeMap(group.Attributes),
+		ChildGroups: group.ChildGroups,
+	})
+
+}
+
+// Write an entity to a section, closing the previous section if needed.
+func (e *jsonExportWriter) write(section string, value interface{}) error {
+
+	data, err := json.Marshal(value)
+
+	if err != nil {
+		return err
+	}
+
+	prefix := ",\n"
+
+	if section != e.section {
+
+		if err := e.open(section); err != nil {
+			return err
+		}
+
+		prefix = "\n"
+
+	}
+
+	_, err = fmt.Fprintf(e.w, "%s  %s", prefix, data)
+
+	return err
+
+}
+
+// Close the current section and open the next one, which may be empty.
+func (e *jsonExportWriter) open(section string) error {
+
+	prefix := "{"
+
+	switch {
+	case e.section == "" && section == "groups":
+
+		if err := e.open("users"); err != nil {
+			return err
+		}
+
+		prefix = "\n],"
+
+	case e.section != "":
+		prefix = "\n],"
+	}
+
+	e.section = section
+
+	_, err := fmt.Fprintf(e.w, "%s\n\"%s\": [", prefix, section)
+
+	return err
+
+}
+
+func (e *jsonExportWriter) Close() error {
+
+	if e.section != "groups" {
+
+		if err := e.open("groups"); err != nil {
+			return err
+		}
+
+	}
+
+	_, err := fmt.Fprint(e.w, "\n]}\n")
+
+	return err
+
+}
+
+// CSV
+
+// Columns of the CSV export. Multiple groups are separated by ";", the
+// attributes are a JSON object of names to values.
+var (
+	exportUserColumns  = []string{"name", "first-name", "last-name", "display-name", "email", "active", "groups", "attributes"}
+	exportGroupColumns = []string{"name", "description", "active", "child-groups", "attributes"}
+)
+
+type csvExportWriter struct {
+	users  *csvTable
+	groups *csvTable
+}
+
+// A CSV file which gets its header row before the first record, or when it
+// is closed if there is none.
+type csvTable struct {
+	writer  *csv.Writer
+	columns []string
+	started bool
+}
+
+// Write the users and the groups of an export as CSV files with a header
+// row. The users file can be imported with ImportUsers and the default
+// mapping. Groups are not written if their writer is nil.
+func NewCSVExportWriter(users, groups io.Writer) ExportWriter {
+
+	e := &csvExportWriter{users: &csvTable{writer: csv.NewWriter(users), columns: exportUserColumns}}
+
+	if groups != nil {
+		e.groups = &csvTable{writer: csv.NewWriter(groups), columns: exportGroupColumns}
+	}
+
+	return e
+
+}
+
+func (e *csvExportWriter) WriteUser(user *ExportedUser) error {
+
+	attributes, err := json.Marshal(attributeMap(user.Attributes))
+
+	if err != nil {
+		return err
+	}
+
+	return e.users.write([]string{
+		user.User.Name,
+		user.User.FirstName,
+		user.User.LastName,
+		user.User.DisplayName,
+		user.User.Email,
+		strconv.FormatBool(user.User.IsActive),
+		strings.Join(user.Groups, ";"),
+		string(attributes),
+	})
+
+}
+
+func (e *csvExportWriter) WriteGroup(group *ExportedGroup) error {
+
+	if e.groups == nil {
+		return nil
+	}
+
+	attributes, err := json.Marshal(attributeMap(group.Attributes))
+
+	if err != nil {
+		return err
+	}
+
+	return e.groups.write([]string{
+		group.Group.Name,
+		group.Group.Description,
+		strconv.FormatBool(group.Group.Active),
+		strings.Join(group.ChildGroups, ";"),
+		string(attributes),
+	})
+
+}
+
+func (e *csvExportWriter) Close() error {
+
+	if err := e.users.close(); err != nil {
+		return err
+	}
+
+	if e.groups != nil {
+		return e.groups.close()
+	}
+
+	return nil
+
+}
+
+func (t *csvTable) start() error {
+
+	if t.started {
+		return nil
+	}
+
+	t.started = true
+
+	return t.writer.Write(t.columns)
+
+}
+
+func (t *csvTable) write(record []string) error {
+
+	if err := t.start(); err != nil {
+		return err
+	}
+
+	return t.writer.Write(record)
+
+}
+
+func (t *csvTable) close() error {
+
+	if err := t.start(); err != nil {
+		return err
+	}
+
+	t.writer.Flush()
+
+	return t.writer.Error()
+
+}
+
+// LDIF
+
+type ldifExportWriter struct {
+	w       *bufio.Writer
+	baseDN  string
+	options LDIFOptions
+}
+
+type LDIFOptions struct {
+	// Also write the active flag and the attributes of crowd, which the
+	// standard schemas lack: entries get the auxiliary object class
+	// crowdEntry, inactive ones crowdActive: FALSE and each attribute value
+	// a crowdAttribute value of the form name=value. The directory needs a
+	// schema defining them, for example with an OID arc of your own:
+	//
+	//	attributetype ( <oid>.1 NAME 'crowdActive'
+	//		EQUALITY booleanMatch
+	//		SYNTAX 1.3.6.1.4.1.1466.115.121.1.7 SINGLE-VALUE )
+	//	attributetype ( <oid>.2 NAME 'crowdAttribute'
+	//		EQUALITY caseExactMatch
+	//		SYNTAX 1.3.6.1.4.1.1466.115.121.1.15 )
+	//	objectclass ( <oid>.3 NAME 'crowdEntry' AUXILIARY
+	//		MAY ( crowdActive $ crowdAttribute ) )
+	CrowdSchema bool
+}
+
+// Write an export as LDIF, with users as inetOrgPerson entries under
+// ou=users and groups as groupOfNames entries under ou=groups of the base DN.
+// Users and child groups are member values of the groups they are direct
+// members of. Since groupOfNames requires a member, groups without any get
+// the empty DN as member. Only standard attributes are written unless
+// CrowdSchema is set, options may be nil.
+func NewLDIFExportWriter(w io.Writer, baseDN string, options *LDIFOptions) ExportWriter {
+
+	e := &ldifExportWriter{w: bufio.NewWriter(w), baseDN: baseDN}
+
+	if options != nil {
+		e.options = *options
+	}
+
+	return e
+
+}
+
+func (e *ldifExportWriter) userDN(name string) string {
+	return "uid=" + escapeDN(name) + ",ou=users," + e.baseDN
+}
+
+func (e *ldifExportWriter) groupDN(name string) string {
+	return "cn=" + escapeDN(name) + ",ou=groups," + e.baseDN
+}
+
+func (e *ldifExportWriter) WriteUser(user *ExportedUser) error {
+
+	e.line("dn", e.userDN(user.User.Name))
+	e.objectClass("inetOrgPerson")
+	e.line("uid", user.User.Name)
+	e.line("cn", first(user.User.DisplayName, user.User.Name))
+	e.line("sn", first(user.User.LastName, user.User.Name))
+
+	if user.User.FirstName != "" {
+		e.line("givenName", user.User.FirstName)
+	}
+
+	if user.User.DisplayName != "" {
+		e.line("displayName", user.User.DisplayName)
+	}
+
+	if user.User.Email != "" {
+		e.line("mail", user.User.Email)
+	}
+
+	e.crowd(user.User.IsActive, user.Attributes)
+
+	return e.end()
+
+}
+
+func (e *ldifExportWriter) WriteGroup(group *ExportedGroup) error {
+
+	e.line("dn", e.groupDN(group.Group.Name))
+	e.objectClass("groupOfNames")
+	e.line("cn", group.Group.Name)
+
+	if group.Group.Description != "" {
+		e.line("description", group.Group.Description)
+	}
+
+	var members []string
+
+	for _, userName := range group.Users {
+		members = append(members, e.userDN(userName))
+	}
+
+	for _, childName := range group.ChildGroups {
+		members = append(members, e.groupDN(childName))
+	}
+
+	if len(members) == 0 {
+		members = []string{""}
+	}
+
+	for _, member := range members {
+		e.line("member", member)
+	}
+
+	e.crowd(group.Group.Active, group.Attributes)
+
+	return e.end()
+
+}
+
+func (e *ldifExportWriter) objectClass(objectClass string) {
+
+	e.line("objectClass", objectClass)
+
+	if e.options.CrowdSchema {
+		e.line("objectClass", "crowdEntry")
+	}
+
+}
+
+// Write the active flag and the attributes with the crowd schema.
+func (e *ldifExportWriter) crowd(active bool, attributes []*Attribute) {
+
+	if !e.options.CrowdSchema {
+		return
+	}
+
+	if !active {
+		e.line("crowdActive", "FALSE")
+	}
+
+	e.attributes(attributes)
+
+}
+
+func (e *ldifExportWriter) attributes(attributes []*Attribute) {
+
+	sorted := append([]*Attribute(nil), attributes...)
+
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Name < sorted[j].Name
+	})
+
+	for _, attribute := range sorted {
+		for _, value := range attribute.Values {
+			e.line("crowdAttribute", attribute.Name+"="+value)
+		}
+	}
+
+}
+
+// Write a line of an entry, base64 encoded if the value is not safe.
+func (e *ldifExportWriter) line(name, value string) {
+
+	if value == "" {
+		fmt.Fprintf(e.w, "%s:\n", name)
+		return
+	}
+
+	if safeLDIFString(value) {
+		fmt.Fprintf(e.w, "%s: %s\n", name, value)
+		return
+	}
+
+	fmt.Fprintf(e.w, "%s:: %s\n", name, base64.StdEncoding.EncodeToString([]byte(value)))
+
+}
+
+// End an entry.
+func (e *ldifExportWriter) end() error {
+
+	e.w.WriteString("\n")
+
+	return e.w.Flush()
+
+}
+
+func (e *ldifExportWriter) Close() error {
+	return e.w.Flush()
+}
+
+// Check whether a value can be written without encoding, as defined by
+// RFC 2849.
+func safeLDIFString(value string) bool {
+
+	if value == "" {
+		return true
+	}
+
+	if value[0] == ' ' || value[0] == ':' || value[0] == '<' || value[len(value)-1] == ' ' {
+		return false
+	}
+
+	for _, r := range value {
+		if r == 0 || r == '\n' || r == '\r' || r >= utf8.RuneSelf {
+			return false
+		}
+	}
+
+	return true
+
+}
+
+// Escape the special characters of a DN value, as defined by RFC 4514.
+func escapeDN(value string) string {
+
+	var escaped strings.Builder
+
+	for i, r := range value {
+
+		switch {
+		case strings.ContainsRune(",+\"\\<>;=", r),
+			i == 0 && (r == ' ' || r == '#'),
+			i == len(value)-1 && r == ' ':
+			escaped.WriteByte('\\')
+		}
+
+		escaped.WriteRune(r)
+
+	}
+
+	return escaped.String()
+
+}
@@ -0,0 +1,176 @@
+package crowd_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/agile-rcm/crowd-go"
+	"github.com/agile-rcm/crowd-go/crowdmock"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func newExportClient(t *testing.T) *crowdmock.Client {
+
+	client := crowdmock.NewClient()
+
+	assert.Nil(t, client.CreateGroup("staff", "All staff", true))
+	assert.Nil(t, client.CreateGroup("developers", "", false))
+	assert.Nil(t, client.AddChildGroupMembership("staff", "developers"))
+	assert.Nil(t, client.StoreGroupAttributes("staff", &crowd.Attributes{Attributes: []*crowd.Attribute{{Name: "owner", Values: []string{"alice"}}}}))
+
+	assert.Nil(t, client.AddUser("alice", "password", "Alice", "Smith", "Alice Smith", "alice@example.com", true))
+	assert.Nil(t, client.AddUser("bob", "password", "Bob", "Jones", "", "bob@example.com", true))
+	assert.Nil(t, client.AddUser("carol", "password", "Carol", "", "", "carol@example.com", false))
+
+	assert.Nil(t, client.AddUserToGroup("alice", "developers"))
+	assert.Nil(t, client.AddUserToGroup("bob", "staff"))
+	assert.Nil(t, client.StoreUserAttributes("alice", &crowd.Attributes{Attributes: []*crowd.Attribute{{Name: "team", Values: []string{"backend", "ops"}}}}))
+
+	return client
+
+}
+
+func TestExport_JSON(t *testing.T) {
+
+	client := newExportClient(t)
+	output := &bytes.Buffer{}
+
+	assert.Nil(t, crowd.Export(client, crowd.NewJSONExportWriter(output), &crowd.ExportOptions{PageSize: 2}))
+
+	export := struct {
+		Users []struct {
+			Name       string              `json:"name"`
+			Active     bool                `json:"active"`
+			Attributes map[string][]string `json:"attributes"`
+			Groups     []string            `json:"groups"`
+		} `json:"users"`
+		Groups []struct {
+			Name        string              `json:"name"`
+			Attributes  map[string][]string `json:"attributes"`
+			ChildGroups []string            `json:"child-groups"`
+		} `json:"groups"`
+	}{}
+
+	assert.Nil(t, json.Unmarshal(output.Bytes(), &export))
+	assert.Len(t, export.Users, 3)
+	assert.Equal(t, []string{"backend", "ops"}, export.Users[0].Attributes["team"])
+	assert.Equal(t, []string{"developers"}, export.Users[0].Groups)
+	assert.False(t, export.Users[2].Active)
+	assert.Len(t, export.Groups, 2)
+	assert.Equal(t, "staff", export.Groups[1].Name)
+	assert.Equal(t, []string{"developers"}, export.Groups[1].ChildGroups)
+	assert.Equal(t, []string{"alice"}, export.Groups[1].Attributes["owner"])
+
+	// Listings are paged.
+	assert.Equal(t, 2, client.CallCount("SearchUsers"))
+	assert.Equal(t, 2, client.CallCount("SearchGroups"))
+
+	output.Reset()
+
+	assert.Nil(t, crowd.Export(crowdmock.NewClient(), crowd.NewJSONExportWriter(output), nil))
+	assert.JSONEq(t, `{"users": [], "groups": []}`, output.String())
+
+}
+
+func TestExport_CSV(t *testing.T) {
+
+	client := newExportClient(t)
+	users := &bytes.Buffer{}
+	groups := &bytes.Buffer{}
+
+	assert.Nil(t, crowd.Export(client, crowd.NewCSVExportWriter(users, groups), nil))
+	assert.Equal(t, "name,description,active,child-groups,attributes\n"+
+		"developers,,false,,{}\n"+
+		`staff,All staff,true,developers,"{""owner"":[""alice""]}"`+"\n", groups.String())
+
+	// The users can be imported into another crowd.
+	target := crowdmock.NewClient()
+
+	assert.Nil(t, target.CreateGroup("staff", "", true))
+	assert.Nil(t, target.CreateGroup("developers", "", true))
+
+	report, err := crowd.ImportUsers(target, users, nil, &crowd.ImportOptions{GeneratePasswords: true})
+
+	assert.Nil(t, err)
+	assert.Equal(t, 0, report.Failed())
+	assert.Equal(t, 3, report.Count(crowd.ImportCreated))
+
+	for _, name := range []string{"alice", "bob", "carol"} {
+
+		want, _ := client.GetUser(name)
+		got, err := target.GetUser(name)
+
+		if assert.Nil(t, err) {
+			assert.Equal(t, want.Email, got.Email)
+			assert.Equal(t, want.IsActive, got.IsActive)
+		}
+
+	}
+
+	attributes, err := target.GetUserAttributes("alice")
+
+	assert.Nil(t, err)
+	assert.Equal(t, []*crowd.Attribute{{Name: "team", Values: []string{"backend", "ops"}}}, attributes.Attributes)
+
+	memberships, err := target.GetDirectGroupsForUser("bob")
+
+	assert.Nil(t, err)
+	assert.Equal(t, "staff", memberships.Groups[0].Name)
+
+}
+
+func TestExport_LDIF(t *testing.T) {
+
+	client := newExportClient(t)
+	output := &bytes.Buffer{}
+
+	assert.Nil(t, client.AddUser("émile, jr", "password", "", "", "", "", true))
+	assert.Nil(t, client.CreateGroup("empty", "", true))
+	assert.Nil(t, crowd.Export(client, crowd.NewLDIFExportWriter(output, "dc=example,dc=com", nil), nil))
+
+	assert.Contains(t, output.String(), `dn: uid=alice,ou=users,dc=example,dc=com
+objectClass: inetOrgPerson
+uid: alice
+cn: Alice Smith
+sn: Smith
+givenName: Alice
+displayName: Alice Smith
+mail: alice@example.com
+
+`)
+	assert.Contains(t, output.String(), `dn: cn=staff,ou=groups,dc=example,dc=com
+objectClass: groupOfNames
+cn: staff
+description: All staff
+member: uid=bob,ou=users,dc=example,dc=com
+member: cn=developers,ou=groups,dc=example,dc=com
+
+`)
+	assert.Contains(t, output.String(), `dn: cn=empty,ou=groups,dc=example,dc=com
+objectClass: groupOfNames
+cn: empty
+member:
+
+`)
+	assert.Contains(t, output.String(), "dn:: dWlkPcOpbWlsZVwsIGpyLG91PXVzZXJzLGRjPWV4YW1wbGUsZGM9Y29t\n")
+	assert.NotContains(t, output.String(), "crowd")
+
+	// The crowd schema adds the active flag and the attributes.
+	output.Reset()
+
+	assert.Nil(t, crowd.Export(client, crowd.NewLDIFExportWriter(output, "dc=example,dc=com", &crowd.LDIFOptions{CrowdSchema: true}), nil))
+	assert.Contains(t, output.String(), `dn: cn=developers,ou=groups,dc=example,dc=com
+objectClass: groupOfNames
+objectClass: crowdEntry
+cn: developers
+member: uid=alice,ou=users,dc=example,dc=com
+crowdActive: FALSE
+
+`)
+	assert.Contains(t, output.String(), `mail: alice@example.com
+crowdAttribute: team=backend
+crowdAttribute: team=ops
+
+`)
+
+}
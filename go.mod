@@ -3,7 +3,12 @@ module github.com/agile-rcm/crowd-go
 go 1.15
 
 require (
-	github.com/stretchr/testify v1.6.1
+	github.com/prometheus/client_golang v1.11.1
+	github.com/stretchr/testify v1.7.0
 	github.com/valyala/fasthttp v1.18.0
+	go.opentelemetry.io/otel v1.0.0
+	go.opentelemetry.io/otel/sdk v1.0.0
+	go.opentelemetry.io/otel/trace v1.0.0
 	go.uber.org/zap v1.16.0
+	gopkg.in/yaml.v3 v3.0.1
 )
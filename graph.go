@@ -0,0 +1,539 @@
+package crowd
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The nested groups of crowd as a directed graph, with an edge from each
+// parent group to its direct child groups. Group names are compared without
+// case, like crowd does.
+//
+// A GroupGraph is a snapshot, it is not safe for concurrent use while edges
+// are added.
+type GroupGraph struct {
+	nodes map[string]*groupNode
+	// Whether the direct members of the groups were loaded.
+	members bool
+}
+
+type groupNode struct {
+	group *Group
+	// Whether the child groups were loaded, and with them all descendants.
+	walked bool
+	// Keys of the direct child and parent groups.
+	children []string
+	parents  []string
+	// Names of the direct members.
+	members []string
+}
+
+type GroupGraphOptions struct {
+	// Groups to start from, the graph contains them and all their
+	// descendants. All groups of crowd if empty.
+	Roots []string
+	// Load the direct members of the groups, needed for EffectiveMembers.
+	// Costs a request per group.
+	Members bool
+}
+
+// Returned when adding a child group would make a group its own descendant.
+type GroupCycleError struct {
+	// The groups of the cycle, starting and ending with the parent group.
+	Path []string
+}
+
+func (e *GroupCycleError) Error() string {
+	return "adding the child group would create a cycle: " + strings.Join(e.Path, " -> ")
+}
+
+// Build the graph of groups by walking the child group memberships from the
+// roots. Cycles which already exist in crowd are tolerated.
+func BuildGroupGraph(client Client, options *GroupGraphOptions) (*GroupGraph, error) {
+
+	if options == nil {
+		options = &GroupGraphOptions{}
+	}
+
+	graph := &GroupGraph{nodes: make(map[string]*groupNode), members: options.Members}
+	queue := []*Group{}
+
+	for _, root := range options.Roots {
+
+		group, err := client.GetGroup(root)
+
+		if err != nil {
+			return nil, err
+		}
+
+		queue = append(queue, group)
+
+	}
+
+	if len(options.Roots) == 0 {
+
+		groups, err := searchAllGroups(client)
+
+		if err != nil {
+			return nil, err
+		}
+
+		queue = groups.Groups
+
+	}
+
+	if err := graph.walk(client, queue); err != nil {
+		return nil, err
+	}
+
+	return graph, nil
+
+}
+
+// Load the groups and their descendants, walking the child group memberships
+// breadth first.
+func (g *GroupGraph) walk(client Client, queue []*Group) error {
+
+	for len(queue) > 0 {
+
+		group := queue[0]
+		queue = queue[1:]
+
+		node := g.node(group)
+
+		if node.walked {
+			continue
+		}
+
+		node.walked = true
+
+		children, err := client.GetChildGroups(group.Name)
+
+		if err != nil {
+			return fmt.Errorf("group %s: %v", group.Name, err)
+		}
+
+		for _, child := range children.Groups {
+			g.addEdge(node, g.node(child))
+			queue = append(queue, child)
+		}
+
+		if g.members {
+
+			members, err := client.GetGroupMembers(group.Name)
+
+			if err != nil {
+				return fmt.Errorf("group %s: %v", group.Name, err)
+			}
+
+			for _, user := range members.Users {
+				node.members = append(node.members, user.Name)
+			}
+
+		}
+
+	}
+
+	return nil
+
+}
+
+// List all groups, page by page.
+func searchAllGroups(client Client) (*Groups, error) {
+
+	groups := &Groups{Groups: []*Group{}}
+
+	for startIndex := 0; ; startIndex += listPageSize {
+
+		page, err := client.SearchGroups("", startIndex, listPageSize)
+
+		if err != nil {
+			return nil, err
+		}
+
+		groups.Groups = append(groups.Groups, page.Groups...)
+
+		if len(page.Groups) < listPageSize {
+			return groups, nil
+		}
+
+	}
+
+}
+
+// Get the node of a group, added if it is missing.
+func (g *GroupGraph) node(group *Group) *groupNode {
+
+	key := cacheKey(group.Name)
+
+	if node, ok := g.nodes[key]; ok {
+		return node
+	}
+
+	node := &groupNode{group: group}
+	g.nodes[key] = node
+
+	return node
+
+}
+
+func (g *GroupGraph) addEdge(parent, child *groupNode) {
+
+	childKey := cacheKey(child.group.Name)
+
+	for _, key := range parent.children {
+		if key == childKey {
+			return
+		}
+	}
+
+	parent.children = append(parent.children, childKey)
+	child.parents = append(child.parents, cacheKey(parent.group.Name))
+
+}
+
+// Whether the graph contains the group.
+func (g *GroupGraph) Has(groupName string) bool {
+	_, ok := g.nodes[cacheKey(groupName)]
+	return ok
+}
+
+// Get the names of all groups, sorted.
+func (g *GroupGraph) Groups() []string {
+
+	names := make([]string, 0, len(g.nodes))
+
+	for _, node := range g.nodes {
+		names = append(names, node.group.Name)
+	}
+
+	return sortNames(names)
+
+}
+
+// Get the names of the direct child groups, sorted.
+func (g *GroupGraph) Children(groupName string) []string {
+	return g.names(g.keys(groupName, func(node *groupNode) []string { return node.children }))
+}
+
+// Get the names of the direct parent groups, sorted. Only parents within the
+// graph are known.
+func (g *GroupGraph) Parents(groupName string) []string {
+	return g.names(g.keys(groupName, func(node *groupNode) []string { return node.parents }))
+}
+
+// Get the names of all groups nested in the group, sorted.
+func (g *GroupGraph) Descendants(groupName string) []string {
+	return g.names(g.reachable(cacheKey(groupName), func(node *groupNode) []string { return node.children }))
+}
+
+// Get the names of all groups the group is nested in, sorted.
+func (g *GroupGraph) Ancestors(groupName string) []string {
+	return g.names(g.reachable(cacheKey(groupName), func(node *groupNode) []string { return node.parents }))
+}
+
+// Get the names of the users which are members of the group or of a group
+// nested in it, sorted. The graph must be built with Members.
+func (g *GroupGraph) EffectiveMembers(groupName string) []string {
+
+	key := cacheKey(groupName)
+	seen := make(map[string]bool)
+	members := []string{}
+
+	for _, groupKey := range append(g.reachable(key, func(node *groupNode) []string { return node.children }), key) {
+
+		node, ok := g.nodes[groupKey]
+
+		if !ok {
+			continue
+		}
+
+		for _, userName := range node.members {
+
+			if !seen[cacheKey(userName)] {
+				seen[cacheKey(userName)] = true
+				members = append(members, userName)
+			}
+
+		}
+
+	}
+
+	return sortNames(members)
+
+}
+
+func (g *GroupGraph) keys(groupName string, next func(node *groupNode) []string) []string {
+
+	if node, ok := g.nodes[cacheKey(groupName)]; ok {
+		return next(node)
+	}
+
+	return nil
+
+}
+
+// Get the keys of the groups reachable from a group, without the group
+// itself unless it is part of a cycle.
+func (g *GroupGraph) reachable(key string, next func(node *groupNode) []string) []string {
+
+	seen := make(map[string]bool)
+	found := []string{}
+	stack := []string{key}
+
+	for len(stack) > 0 {
+
+		current := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		node, ok := g.nodes[current]
+
+		if !ok {
+			continue
+		}
+
+		for _, neighbour := range next(node) {
+
+			if !seen[neighbour] {
+				seen[neighbour] = true
+				found = append(found, neighbour)
+				stack = append(stack, neighbour)
+			}
+
+		}
+
+	}
+
+	return found
+
+}
+
+func (g *GroupGraph) names(keys []string) []string {
+
+	names := make([]string, 0, len(keys))
+
+	for _, key := range keys {
+		names = append(names, g.nodes[key].group.Name)
+	}
+
+	return sortNames(names)
+
+}
+
+// Check that the child group can be added to the parent group without
+// creating a cycle. Returns a *GroupCycleError if the parent is the child or
+// is nested in it. Only the graph is searched: the descendants of a child
+// group outside of it, for example of a graph built from Roots, are unknown
+// and a cycle through them is not found. AddChildGroupMembership loads them
+// from crowd first.
+func (g *GroupGraph) CheckChildGroupMembership(parentGroupName, childGroupName string) error {
+
+	if path := g.path(cacheKey(childGroupName), cacheKey(parentGroupName)); path != nil {
+		return &GroupCycleError{Path: append([]string{g.name(parentGroupName)}, path...)}
+	}
+
+	return nil
+
+}
+
+// Get the name of a group as it is in the graph.
+func (g *GroupGraph) name(groupName string) string {
+
+	if node, ok := g.nodes[cacheKey(groupName)]; ok {
+		return node.group.Name
+	}
+
+	return groupName
+
+}
+
+// Find the names of the groups on a path of child groups from one group to
+// another, both included, or nil if there is none.
+func (g *GroupGraph) path(from, to string) []string {
+
+	previous := map[string]string{from: ""}
+	queue := []string{from}
+
+	for len(queue) > 0 {
+
+		current := queue[0]
+		queue = queue[1:]
+
+		if current == to {
+
+			path := []string{}
+
+			for key := current; key != ""; key = previous[key] {
+				path = append([]string{g.name(key)}, path...)
+			}
+
+			return path
+
+		}
+
+		node, ok := g.nodes[current]
+
+		if !ok {
+			continue
+		}
+
+		for _, child := range node.children {
+
+			if _, ok := previous[child]; !ok {
+				previous[child] = current
+				queue = append(queue, child)
+			}
+
+		}
+
+	}
+
+	return nil
+
+}
+
+// Add a child group membership in crowd after checking that it does not
+// create a cycle, and add the edge to the graph. Groups outside the graph
+// are loaded from crowd with their descendants first.
+func (g *GroupGraph) AddChildGroupMembership(client Client, parentGroupName, childGroupName string) error {
+
+	queue := []*Group{}
+
+	for _, name := range []string{parentGroupName, childGroupName} {
+
+		if node, ok := g.nodes[cacheKey(name)]; ok && node.walked {
+			continue
+		}
+
+		group, err := client.GetGroup(name)
+
+		if err != nil {
+			return err
+		}
+
+		queue = append(queue, group)
+
+	}
+
+	if err := g.walk(client, queue); err != nil {
+		return err
+	}
+
+	if err := g.CheckChildGroupMembership(parentGroupName, childGroupName); err != nil {
+		return err
+	}
+
+	if err := client.AddChildGroupMembership(parentGroupName, childGroupName); err != nil {
+		return err
+	}
+
+	g.addEdge(g.nodes[cacheKey(parentGroupName)], g.nodes[cacheKey(childGroupName)])
+
+	return nil
+
+}
+
+// Write the graph in the Graphviz DOT language. Inactive groups are drawn
+// dashed, and with members loaded, groups are labelled with their number of
+// direct members.
+func (g *GroupGraph) WriteDOT(w io.Writer) error {
+
+	lines := []string{"digraph groups {", "  node [shape=box];"}
+
+	for _, name := range g.Groups() {
+
+		node := g.nodes[cacheKey(name)]
+		attributes := []string{}
+
+		if g.members {
+			attributes = append(attributes, "label="+strconv.Quote(fmt.Sprintf("%s (%d)", name, len(node.members))))
+		}
+
+		if !node.group.Active {
+			attributes = append(attributes, "style=dashed")
+		}
+
+		line := "  " + strconv.Quote(name)
+
+		if len(attributes) > 0 {
+			line += " [" + strings.Join(attributes, ", ") + "]"
+		}
+
+		lines = append(lines, line+";")
+
+	}
+
+	for _, name := range g.Groups() {
+		for _, child := range g.Children(name) {
+			lines = append(lines, fmt.Sprintf("  %s -> %s;", strconv.Quote(name), strconv.Quote(child)))
+		}
+	}
+
+	lines = append(lines, "}")
+
+	_, err := io.WriteString(w, strings.Join(lines, "\n")+"\n")
+
+	return err
+
+}
+
+// Write the groups nested in a group as a text tree. A group nested more than
+// once is repeated, a cycle is marked and not followed.
+func (g *GroupGraph) WriteTree(w io.Writer, groupName string) error {
+
+	if !g.Has(groupName) {
+		return ErrorGroupNotFound
+	}
+
+	lines := []string{g.name(groupName)}
+
+	var walk func(name, indent string, ancestors map[string]bool)
+
+	walk = func(name, indent string, ancestors map[string]bool) {
+
+		children := g.Children(name)
+
+		for i, child := range children {
+
+			branch, next := "├── ", "│   "
+
+			if i == len(children)-1 {
+				branch, next = "└── ", "    "
+			}
+
+			if ancestors[cacheKey(child)] {
+				lines = append(lines, indent+branch+child+" (cycle)")
+				continue
+			}
+
+			lines = append(lines, indent+branch+child)
+
+			ancestors[cacheKey(child)] = true
+			walk(child, indent+next, ancestors)
+			delete(ancestors, cacheKey(child))
+
+		}
+
+	}
+
+	walk(groupName, "", map[string]bool{cacheKey(groupName): true})
+
+	_, err := io.WriteString(w, strings.Join(lines, "\n")+"\n")
+
+	return err
+
+}
+
+// Sort names without case.
+func sortNames(names []string) []string {
+
+	sort.Slice(names, func(i, j int) bool {
+		return cacheKey(names[i]) < cacheKey(names[j])
+	})
+
+	return names
+
+}
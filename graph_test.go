@@ -0,0 +1,151 @@
+package crowd_test
+
+import (
+	"bytes"
+	"github.com/agile-rcm/crowd-go"
+	"github.com/agile-rcm/crowd-go/crowdmock"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// Create the groups
+//
+//	staff -> developers -> backend
+//	      -> ops        -> backend
+func newGraphClient(t *testing.T) *crowdmock.Client {
+
+	client := crowdmock.NewClient()
+
+	for _, name := range []string{"staff", "developers", "ops", "backend", "other"} {
+		assert.Nil(t, client.CreateGroup(name, "", name != "ops"))
+	}
+
+	assert.Nil(t, client.AddChildGroupMembership("staff", "developers"))
+	assert.Nil(t, client.AddChildGroupMembership("staff", "ops"))
+	assert.Nil(t, client.AddChildGroupMembership("developers", "backend"))
+	assert.Nil(t, client.AddChildGroupMembership("ops", "backend"))
+
+	assert.Nil(t, client.AddUser("alice", "password", "", "", "", "", true))
+	assert.Nil(t, client.AddUser("bob", "password", "", "", "", "", true))
+	assert.Nil(t, client.AddUserToGroup("alice", "backend"))
+	assert.Nil(t, client.AddUserToGroup("bob", "ops"))
+	assert.Nil(t, client.AddUserToGroup("alice", "staff"))
+
+	return client
+
+}
+
+func TestBuildGroupGraph(t *testing.T) {
+
+	client := newGraphClient(t)
+
+	graph, err := crowd.BuildGroupGraph(client, &crowd.GroupGraphOptions{Members: true})
+
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"backend", "developers", "ops", "other", "staff"}, graph.Groups())
+	assert.Equal(t, []string{"developers", "ops"}, graph.Children("staff"))
+	assert.Equal(t, []string{"developers", "ops"}, graph.Parents("backend"))
+	assert.Equal(t, []string{"backend", "developers", "ops"}, graph.Descendants("STAFF"))
+	assert.Equal(t, []string{"developers", "ops", "staff"}, graph.Ancestors("backend"))
+	assert.Empty(t, graph.Descendants("other"))
+	assert.Equal(t, []string{"alice", "bob"}, graph.EffectiveMembers("staff"))
+	assert.Equal(t, []string{"alice"}, graph.EffectiveMembers("developers"))
+
+	graph, err = crowd.BuildGroupGraph(client, &crowd.GroupGraphOptions{Roots: []string{"developers"}})
+
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"backend", "developers"}, graph.Groups())
+	assert.Empty(t, graph.EffectiveMembers("developers"), "members are not loaded")
+
+	_, err = crowd.BuildGroupGraph(client, &crowd.GroupGraphOptions{Roots: []string{"missing"}})
+
+	assert.Equal(t, crowd.ErrorGroupNotFound, err)
+
+}
+
+func TestGroupGraph_AddChildGroupMembership(t *testing.T) {
+
+	client := newGraphClient(t)
+
+	graph, err := crowd.BuildGroupGraph(client, nil)
+
+	assert.Nil(t, err)
+
+	err = graph.AddChildGroupMembership(client, "backend", "staff")
+
+	if assert.IsType(t, &crowd.GroupCycleError{}, err) {
+		assert.Equal(t, []string{"backend", "staff", "developers", "backend"}, err.(*crowd.GroupCycleError).Path)
+	}
+
+	assert.Equal(t, "adding the child group would create a cycle: ops -> ops", graph.CheckChildGroupMembership("ops", "ops").Error())
+	assert.Equal(t, 4, client.CallCount("AddChildGroupMembership"), "the cycle was not sent to crowd")
+
+	assert.Nil(t, graph.AddChildGroupMembership(client, "other", "staff"))
+	assert.Equal(t, []string{"backend", "developers", "ops", "staff"}, graph.Descendants("other"))
+
+	// The new edge is taken into account.
+	assert.NotNil(t, graph.CheckChildGroupMembership("backend", "other"))
+
+	children, err := client.GetChildGroups("other")
+
+	assert.Nil(t, err)
+	assert.Equal(t, "staff", children.Groups[0].Name)
+
+	// Groups outside a graph built from roots are loaded from crowd, so the
+	// cycle through them is found and their details are kept.
+	graph, err = crowd.BuildGroupGraph(client, &crowd.GroupGraphOptions{Roots: []string{"backend"}})
+
+	assert.Nil(t, err)
+	assert.False(t, graph.Has("developers"))
+	assert.IsType(t, &crowd.GroupCycleError{}, graph.AddChildGroupMembership(client, "backend", "developers"))
+	assert.Equal(t, []string{"backend"}, graph.Descendants("developers"))
+
+	assert.Nil(t, graph.AddChildGroupMembership(client, "ops", "developers"))
+	assert.Equal(t, []string{"backend", "developers"}, graph.Children("ops"))
+
+	output := &bytes.Buffer{}
+
+	assert.Nil(t, graph.WriteDOT(output))
+	assert.Contains(t, output.String(), `"ops" [style=dashed];`)
+
+	assert.Equal(t, crowd.ErrorGroupNotFound, graph.AddChildGroupMembership(client, "backend", "missing"))
+
+}
+
+func TestGroupGraph_Write(t *testing.T) {
+
+	client := newGraphClient(t)
+
+	graph, err := crowd.BuildGroupGraph(client, &crowd.GroupGraphOptions{Roots: []string{"staff"}, Members: true})
+
+	assert.Nil(t, err)
+
+	output := &bytes.Buffer{}
+
+	assert.Nil(t, graph.WriteTree(output, "staff"))
+	assert.Equal(t, `staff
+├── developers
+│   └── backend
+└── ops
+    └── backend
+`, output.String())
+
+	output.Reset()
+
+	assert.Nil(t, graph.WriteDOT(output))
+	assert.Equal(t, `digraph groups {
+  node [shape=box];
+  "backend" [label="backend (1)"];
+  "developers" [label="developers (0)"];
+  "ops" [label="ops (1)", style=dashed];
+  "staff" [label="staff (1)"];
+  "developers" -> "backend";
+  "ops" -> "backend";
+  "staff" -> "developers";
+  "staff" -> "ops";
+}
+`, output.String())
+
+	assert.Equal(t, crowd.ErrorGroupNotFound, graph.WriteTree(output, "other"))
+
+}
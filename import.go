@@ -0,0 +1,569 @@
+package crowd
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Maps the columns of a CSV file, by their name in the header row, to the
+// fields, attributes and group memberships of users. Columns which are not
+// mapped are ignored.
+type ImportMapping struct {
+	Name        string
+	FirstName   string
+	LastName    string
+	DisplayName string
+	Email       string
+	Password    string
+	// Column with "true" or "false", users are active if it is empty.
+	Active string
+	// Column with the names of the groups of the user.
+	Groups string
+	// Columns starting with the prefix are attributes named by the rest of
+	// the column name, for example "attribute:team".
+	AttributePrefix string
+	// Attributes from other columns, by column name.
+	Attributes map[string]string
+	// Column with a JSON object of attribute names to values, as written by
+	// the CSV export.
+	AttributesJSON string
+	// Separator of multiple groups or attribute values in a cell.
+	Separator string
+}
+
+// Get the mapping of the columns written by the CSV export.
+func DefaultImportMapping() *ImportMapping {
+
+	return &ImportMapping{
+		Name:            "name",
+		FirstName:       "first-name",
+		LastName:        "last-name",
+		DisplayName:     "display-name",
+		Email:           "email",
+		Password:        "password",
+		Active:          "active",
+		Groups:          "groups",
+		AttributePrefix: "attribute:",
+		AttributesJSON:  "attributes",
+		Separator:       ";",
+	}
+
+}
+
+// What the import does with users which already exist.
+type ExistingUserPolicy int
+
+const (
+	// Leave existing users unchanged.
+	SkipExistingUsers ExistingUserPolicy = iota
+	// Update the details of existing users with the mapped columns, and add
+	// their attributes and groups. The password is only set if the row has
+	// one.
+	UpdateExistingUsers
+)
+
+type ImportOptions struct {
+	// Number of rows imported concurrently, 4 if not set.
+	Workers int
+	// Check the rows and the existing users and groups, without changing
+	// anything.
+	DryRun   bool
+	Existing ExistingUserPolicy
+	// Give new users without a password in the file a random one, for
+	// example when importing an export, which has no passwords. The users
+	// have to reset their password to log in. Otherwise such rows fail with
+	// ErrorImportNoPassword.
+	GeneratePasswords bool
+}
+
+type ImportAction string
+
+const (
+	ImportCreated ImportAction = "created"
+	ImportUpdated ImportAction = "updated"
+	ImportSkipped ImportAction = "skipped"
+)
+
+// The outcome of a row. The action is what was done, or attempted if the row
+// failed.
+type ImportResult struct {
+	// Line of the row in the file, the header is line 1.
+	Line     int
+	UserName string
+	Action   ImportAction
+	Err      error
+}
+
+type ImportReport struct {
+	// Results of all rows, in the order of the file.
+	Results []*ImportResult
+	DryRun  bool
+}
+
+// Count the rows with the given action which did not fail.
+func (r *ImportReport) Count(action ImportAction) int {
+
+	count := 0
+
+	for _, result := range r.Results {
+		if result.Err == nil && result.Action == action {
+			count++
+		}
+	}
+
+	return count
+
+}
+
+// Count the rows which failed.
+func (r *ImportReport) Failed() int {
+
+	count := 0
+
+	for _, result := range r.Results {
+		if result.Err != nil {
+			count++
+		}
+	}
+
+	return count
+
+}
+
+// Write the results as CSV with the columns line, user, action and error.
+func (r *ImportReport) WriteCSV(w io.Writer) error {
+
+	writer := csv.NewWriter(w)
+
+	if err := writer.Write([]string{"line", "user", "action", "error"}); err != nil {
+		return err
+	}
+
+	for _, result := range r.Results {
+
+		message := ""
+
+		if result.Err != nil {
+			message = result.Err.Error()
+		}
+
+		if err := writer.Write([]string{strconv.Itoa(result.Line), result.UserName, string(result.Action), message}); err != nil {
+			return err
+		}
+
+	}
+
+	writer.Flush()
+
+	return writer.Error()
+
+}
+
+// A parsed row of the file.
+type importRow struct {
+	line     int
+	user     User
+	password string
+	// Nil if the cell is empty.
+	active     *bool
+	attributes []*Attribute
+	groups     []string
+	err        error
+}
+
+// Import users from a CSV file with a header row. Each row creates a user,
+// or updates or skips an existing one, then stores its attributes and adds
+// it to its groups. Rows are imported concurrently by a bounded number of
+// workers, a failed row does not stop the import. Malformed rows and rows
+// repeating the user name of an earlier row fail without being imported. A
+// nil mapping uses DefaultImportMapping, nil options the defaults.
+//
+// An error is returned if the file cannot be read or has no column for the
+// user name, together with the results of the rows imported until then.
+func ImportUsers(client Client, reader io.Reader, mapping *ImportMapping, options *ImportOptions) (*ImportReport, error) {
+
+	if mapping == nil {
+		mapping = DefaultImportMapping()
+	}
+
+	if options == nil {
+		options = &ImportOptions{}
+	}
+
+	workers := options.Workers
+
+	if workers <= 0 {
+		workers = 4
+	}
+
+	report := &ImportReport{DryRun: options.DryRun}
+
+	records := csv.NewReader(reader)
+	records.FieldsPerRecord = -1
+
+	header, err := records.Read()
+
+	if err == io.EOF {
+		return report, nil
+	}
+
+	if err != nil {
+		return report, err
+	}
+
+	columns := make(map[string]int)
+
+	for i, name := range header {
+		columns[strings.TrimSpace(name)] = i
+	}
+
+	if _, ok := columns[mapping.Name]; !ok {
+		return report, ErrorImportNoNameColumn
+	}
+
+	rows := make(chan *importRow)
+	results := make(chan *ImportResult)
+
+	wg := sync.WaitGroup{}
+
+	for i := 0; i < workers; i++ {
+
+		wg.Add(1)
+
+		go func() {
+
+			defer wg.Done()
+
+			for row := range rows {
+				results <- importUser(client, row, options)
+			}
+
+		}()
+
+	}
+
+	done := make(chan struct{})
+
+	go func() {
+
+		for result := range results {
+			report.Results = append(report.Results, result)
+		}
+
+		close(done)
+
+	}()
+
+	line := 1
+	seen := make(map[string]bool)
+
+	for {
+
+		record, readErr := records.Read()
+
+		if readErr == io.EOF {
+			break
+		}
+
+		line++
+
+		// The reader continues with the next record after a parse error.
+		if _, ok := readErr.(*csv.ParseError); ok {
+			results <- &ImportResult{Line: line, Action: ImportSkipped, Err: readErr}
+			continue
+		}
+
+		if readErr != nil {
+			err = readErr
+			break
+		}
+
+		row := mapping.parse(line, header, columns, record)
+
+		// Rows of the same user would be imported concurrently.
+		if key := cacheKey(row.user.Name); key != "" {
+
+			if seen[key] {
+				results <- &ImportResult{Line: line, UserName: row.user.Name, Action: ImportSkipped, Err: ErrorImportDuplicateUser}
+				continue
+			}
+
+			seen[key] = true
+
+		}
+
+		rows <- row
+
+	}
+
+	close(rows)
+	wg.Wait()
+	close(results)
+	<-done
+
+	sort.Slice(report.Results, func(i, j int) bool {
+		return report.Results[i].Line < report.Results[j].Line
+	})
+
+	return report, err
+
+}
+
+// Map a record to a row.
+func (m *ImportMapping) parse(line int, header []string, columns map[string]int, record []string) *importRow {
+
+	row := &importRow{line: line}
+
+	cell := func(column string) string {
+
+		i, ok := columns[column]
+
+		if column == "" || !ok || i >= len(record) {
+			return ""
+		}
+
+		return strings.TrimSpace(record[i])
+
+	}
+
+	row.user.Name = cell(m.Name)
+	row.user.FirstName = cell(m.FirstName)
+	row.user.LastName = cell(m.LastName)
+	row.user.DisplayName = cell(m.DisplayName)
+	row.user.Email = cell(m.Email)
+	row.password = cell(m.Password)
+	row.groups = m.split(cell(m.Groups))
+
+	if value := cell(m.Active); value != "" {
+
+		active, err := strconv.ParseBool(value)
+
+		if err != nil {
+			row.err = fmt.Errorf("invalid value of active: %q", value)
+		}
+
+		row.active = &active
+
+	}
+
+	if value := cell(m.AttributesJSON); value != "" {
+
+		values := make(map[string][]string)
+
+		if err := json.Unmarshal([]byte(value), &values); err != nil {
+			row.err = fmt.Errorf("invalid attributes: %v", err)
+		}
+
+		names := make([]string, 0, len(values))
+
+		for name := range values {
+			names = append(names, name)
+		}
+
+		sort.Strings(names)
+
+		for _, name := range names {
+			row.attributes = append(row.attributes, &Attribute{Name: name, Values: values[name]})
+		}
+
+	}
+
+	for i, column := range header {
+
+		column = strings.TrimSpace(column)
+		name, ok := m.Attributes[column]
+
+		if !ok && m.AttributePrefix != "" && strings.HasPrefix(column, m.AttributePrefix) {
+			name, ok = strings.TrimPrefix(column, m.AttributePrefix), true
+		}
+
+		if !ok || i >= len(record) {
+			continue
+		}
+
+		if values := m.split(record[i]); len(values) > 0 {
+			row.attributes = append(row.attributes, &Attribute{Name: name, Values: values})
+		}
+
+	}
+
+	return row
+
+}
+
+// Split a cell into its non-empty values.
+func (m *ImportMapping) split(value string) []string {
+
+	parts := []string{value}
+
+	if m.Separator != "" {
+		parts = strings.Split(value, m.Separator)
+	}
+
+	values := []string{}
+
+	for _, part := range parts {
+		if part = strings.TrimSpace(part); part != "" {
+			values = append(values, part)
+		}
+	}
+
+	return values
+
+}
+
+// Import a row.
+func importUser(client Client, row *importRow, options *ImportOptions) *ImportResult {
+
+	result := &ImportResult{Line: row.line, UserName: row.user.Name, Action: ImportCreated}
+
+	if row.user.Name == "" {
+		result.Err = ErrorImportNoUserName
+		return result
+	}
+
+	if row.err != nil {
+		result.Err = row.err
+		return result
+	}
+
+	existing, err := client.GetUser(row.user.Name)
+
+	switch {
+	case err == nil && options.Existing == SkipExistingUsers:
+		result.Action = ImportSkipped
+		return result
+	case err == nil:
+		result.Action = ImportUpdated
+	case err != ErrorUserNotFound:
+		result.Err = err
+		return result
+	}
+
+	// Crowd would reject the new user, the dry run reports it too.
+	if existing == nil && row.password == "" && !options.GeneratePasswords {
+		result.Err = ErrorImportNoPassword
+		return result
+	}
+
+	if options.DryRun {
+		result.Err = checkGroups(client, row.groups)
+		return result
+	}
+
+	if existing == nil {
+		result.Err = addImportedUser(client, row, options)
+	} else {
+		result.Err = updateImportedUser(client, row, existing)
+	}
+
+	if result.Err != nil {
+		return result
+	}
+
+	if len(row.attributes) > 0 {
+
+		if err := client.StoreUserAttributes(row.user.Name, &Attributes{Attributes: row.attributes}); err != nil {
+			result.Err = fmt.Errorf("storing attributes: %w", err)
+			return result
+		}
+
+	}
+
+	for _, groupName := range row.groups {
+
+		if err := client.AddUserToGroup(row.user.Name, groupName); err != nil && err != ErrorUserAlreadyInGroup {
+			result.Err = fmt.Errorf("adding to group %s: %w", groupName, err)
+			return result
+		}
+
+	}
+
+	return result
+
+}
+
+func addImportedUser(client Client, row *importRow, options *ImportOptions) error {
+
+	active := true
+
+	if row.active != nil {
+		active = *row.active
+	}
+
+	password := row.password
+
+	if password == "" && options.GeneratePasswords {
+
+		var err error
+
+		if password, err = randomPassword(); err != nil {
+			return err
+		}
+
+	}
+
+	user := row.user
+
+	return client.AddUser(user.Name, password, user.FirstName, user.LastName, user.DisplayName, user.Email, active)
+
+}
+
+// Update an existing user with the non-empty cells of a row.
+func updateImportedUser(client Client, row *importRow, existing *User) error {
+
+	user := *existing
+	user.FirstName = first(row.user.FirstName, user.FirstName)
+	user.LastName = first(row.user.LastName, user.LastName)
+	user.DisplayName = first(row.user.DisplayName, user.DisplayName)
+	user.Email = first(row.user.Email, user.Email)
+
+	if row.active != nil {
+		user.IsActive = *row.active
+	}
+
+	if err := client.UpdateUser(user.Name, user.FirstName, user.LastName, user.DisplayName, user.Email, user.IsActive); err != nil {
+		return err
+	}
+
+	if row.password != "" {
+		return client.SetUserPassword(user.Name, row.password)
+	}
+
+	return nil
+
+}
+
+// Get the first value which is not empty.
+func first(values ...string) string {
+
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+
+	return ""
+
+}
+
+// Check that the groups of a row exist.
+func checkGroups(client Client, groupNames []string) error {
+
+	for _, groupName := range groupNames {
+
+		if _, err := client.GetGroup(groupName); err != nil {
+			return fmt.Errorf("group %s: %w", groupName, err)
+		}
+
+	}
+
+	return nil
+
+}
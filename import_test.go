@@ -0,0 +1,176 @@
+package crowd_test
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"github.com/agile-rcm/crowd-go"
+	"github.com/agile-rcm/crowd-go/crowdmock"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+const importFile = `name,first-name,last-name,email,password,active,groups,attribute:team
+alice,Alice,Smith,alice@example.com,secret,true,developers;staff,backend
+bob,,,bob@example.com,secret,false,,
+,Nobody,,,,,,
+carol,Carol,,,secret,maybe,,
+existing,New,,,,,missing,
+`
+
+func newImportClient(t *testing.T) *crowdmock.Client {
+
+	client := crowdmock.NewClient()
+
+	assert.Nil(t, client.CreateGroup("developers", "", true))
+	assert.Nil(t, client.CreateGroup("staff", "", true))
+	assert.Nil(t, client.AddUser("existing", "password", "Old", "User", "", "old@example.com", true))
+
+	return client
+
+}
+
+func TestImportUsers(t *testing.T) {
+
+	client := newImportClient(t)
+
+	report, err := crowd.ImportUsers(client, strings.NewReader(importFile), nil, &crowd.ImportOptions{Workers: 2})
+
+	assert.Nil(t, err)
+	assert.Len(t, report.Results, 5)
+	assert.Equal(t, 2, report.Count(crowd.ImportCreated))
+	assert.Equal(t, 1, report.Count(crowd.ImportSkipped))
+	assert.Equal(t, 2, report.Failed())
+
+	assert.Equal(t, 2, report.Results[0].Line)
+	assert.Equal(t, crowd.ErrorImportNoUserName, report.Results[2].Err)
+	assert.EqualError(t, report.Results[3].Err, `invalid value of active: "maybe"`)
+
+	user, err := client.GetUser("alice")
+
+	assert.Nil(t, err)
+	assert.Equal(t, "Alice", user.FirstName)
+	assert.Nil(t, client.AuthenticateUser("alice", "secret"))
+
+	groups, err := client.GetNestedGroupsForUser("alice")
+
+	assert.Nil(t, err)
+	assert.Len(t, groups.Groups, 2)
+
+	attributes, err := client.GetUserAttributes("alice")
+
+	assert.Nil(t, err)
+	assert.Equal(t, []*crowd.Attribute{{Name: "team", Values: []string{"backend"}}}, attributes.Attributes)
+
+	user, err = client.GetUser("bob")
+
+	assert.Nil(t, err)
+	assert.False(t, user.IsActive)
+
+	user, err = client.GetUser("existing")
+
+	assert.Nil(t, err)
+	assert.Equal(t, "Old", user.FirstName)
+
+	output := &bytes.Buffer{}
+
+	assert.Nil(t, report.WriteCSV(output))
+	assert.Equal(t, "line,user,action,error\n2,alice,created,\n3,bob,created,\n4,,created,The row has no user name\n", strings.Join(strings.SplitAfter(output.String(), "\n")[:4], ""))
+
+}
+
+func TestImportUsers_Update(t *testing.T) {
+
+	client := newImportClient(t)
+
+	input := "name,first-name,groups\nexisting,New,developers\n"
+
+	report, err := crowd.ImportUsers(client, strings.NewReader(input), nil, &crowd.ImportOptions{Existing: crowd.UpdateExistingUsers})
+
+	assert.Nil(t, err)
+	assert.Equal(t, 1, report.Count(crowd.ImportUpdated))
+
+	user, err := client.GetUser("existing")
+
+	assert.Nil(t, err)
+	assert.Equal(t, "New", user.FirstName)
+	assert.Equal(t, "User", user.LastName)
+	assert.Equal(t, "old@example.com", user.Email)
+	assert.Nil(t, client.AuthenticateUser("existing", "password"))
+
+	groups, err := client.GetNestedGroupsForUser("existing")
+
+	assert.Nil(t, err)
+	assert.Len(t, groups.Groups, 1)
+
+}
+
+func TestImportUsers_DryRun(t *testing.T) {
+
+	client := newImportClient(t)
+
+	report, err := crowd.ImportUsers(client, strings.NewReader(importFile), nil, &crowd.ImportOptions{DryRun: true, Existing: crowd.UpdateExistingUsers})
+
+	assert.Nil(t, err)
+	assert.True(t, report.DryRun)
+	assert.Equal(t, 2, report.Count(crowd.ImportCreated))
+	assert.Equal(t, crowd.ImportUpdated, report.Results[4].Action)
+	assert.Contains(t, report.Results[4].Err.Error(), "group missing")
+	assert.Equal(t, 1, client.CallCount("AddUser"), "only the existing user")
+	assert.Equal(t, 0, client.CallCount("UpdateUser"))
+
+	_, err = crowd.ImportUsers(client, strings.NewReader("login\nalice\n"), nil, nil)
+
+	assert.Equal(t, crowd.ErrorImportNoNameColumn, err)
+
+	mapping := crowd.DefaultImportMapping()
+	mapping.Name = "login"
+	mapping.Attributes = map[string]string{"dept": "department"}
+
+	report, err = crowd.ImportUsers(client, strings.NewReader("login,dept\ndave,sales\n"), mapping, &crowd.ImportOptions{DryRun: true})
+
+	assert.Nil(t, err)
+	assert.Equal(t, crowd.ErrorImportNoPassword, report.Results[0].Err, "crowd would reject the user")
+
+	report, err = crowd.ImportUsers(client, strings.NewReader("login,dept\ndave,sales\n"), mapping, &crowd.ImportOptions{DryRun: true, GeneratePasswords: true})
+
+	assert.Nil(t, err)
+	assert.Equal(t, 1, report.Count(crowd.ImportCreated))
+	assert.Equal(t, 0, report.Failed())
+
+	// Errors of crowd are wrapped.
+	report, err = crowd.ImportUsers(client, strings.NewReader(importFile), nil, &crowd.ImportOptions{DryRun: true, Existing: crowd.UpdateExistingUsers})
+
+	assert.Nil(t, err)
+	assert.True(t, errors.Is(report.Results[4].Err, crowd.ErrorGroupNotFound))
+
+}
+
+func TestImportUsers_InvalidRows(t *testing.T) {
+
+	client := newImportClient(t)
+
+	input := "name,password\nalice,secret\nbob,\"sec\"ret\nAlice,other\ncarol,secret\n"
+
+	report, err := crowd.ImportUsers(client, strings.NewReader(input), nil, &crowd.ImportOptions{Workers: 2})
+
+	assert.Nil(t, err)
+	assert.Len(t, report.Results, 4)
+	assert.Equal(t, 2, report.Count(crowd.ImportCreated))
+	assert.Equal(t, 2, report.Failed())
+
+	// The malformed row does not stop the import.
+	assert.Equal(t, 3, report.Results[1].Line)
+	assert.True(t, errors.Is(report.Results[1].Err, csv.ErrQuote))
+
+	// Names are case insensitive.
+	assert.Equal(t, "Alice", report.Results[2].UserName)
+	assert.Equal(t, crowd.ErrorImportDuplicateUser, report.Results[2].Err)
+	assert.Nil(t, client.AuthenticateUser("alice", "secret"))
+
+	_, err = client.GetUser("carol")
+
+	assert.Nil(t, err)
+
+}
@@ -0,0 +1,31 @@
+package crowd
+
+import (
+	"context"
+	"github.com/valyala/fasthttp"
+)
+
+// Sends a request to crowd and reads the response into response.
+type RoundTrip func(ctx context.Context, request *fasthttp.Request, response *fasthttp.Response) error
+
+// Wraps a RoundTrip, for example to modify requests and responses, log them
+// or to answer requests without sending them to crowd.
+type Interceptor func(next RoundTrip) RoundTrip
+
+// Add interceptors around the transport of the API. The first interceptor
+// added is the outermost one. Interceptors run after rate limiting and
+// inside the circuit breaker, so their errors count as failures.
+// Use must not be called concurrently with requests.
+func (api *API) Use(interceptors ...Interceptor) {
+	// Never share the backing array with copies made by WithContext.
+	current := api.interceptors[:len(api.interceptors):len(api.interceptors)]
+
+	api.interceptors = append(current, interceptors...)
+}
+
+// Get the logical operation of a request, for example "GetUser", inside an
+// interceptor.
+func OperationFromContext(ctx context.Context) string {
+	operation, _ := ctx.Value(operationContextKey).(string)
+	return operation
+}
@@ -0,0 +1,88 @@
+package crowd
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/valyala/fasthttp"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAPI_Use(t *testing.T) {
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		assert.Equal(t, "outer,inner", r.Header.Get("X-Test"))
+
+		w.WriteHeader(http.StatusNoContent)
+
+	}))
+	defer server.Close()
+
+	api, err := NewAPI(server.URL, "testapp", "password")
+
+	assert.Nil(t, err)
+
+	var operations []string
+
+	header := func(value string) Interceptor {
+		return func(next RoundTrip) RoundTrip {
+			return func(ctx context.Context, request *fasthttp.Request, response *fasthttp.Response) error {
+
+				operations = append(operations, OperationFromContext(ctx))
+
+				if previous := request.Header.Peek("X-Test"); len(previous) > 0 {
+					value = string(previous) + "," + value
+				}
+
+				request.Header.Set("X-Test", value)
+
+				return next(ctx, request, response)
+
+			}
+		}
+	}
+
+	api.Use(header("outer"), header("inner"))
+
+	err = api.RemoveUser("testuser")
+
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"RemoveUser", "RemoveUser"}, operations)
+
+}
+
+func TestAPI_UseFaultInjection(t *testing.T) {
+
+	api, err := NewAPI("http://crowd.invalid", "testapp", "password")
+
+	assert.Nil(t, err)
+
+	testError := errors.New("injected")
+
+	api.Use(func(next RoundTrip) RoundTrip {
+		return func(ctx context.Context, request *fasthttp.Request, response *fasthttp.Response) error {
+
+			if OperationFromContext(ctx) == "GetUser" {
+				response.SetStatusCode(http.StatusOK)
+				response.SetBodyString(`{"name": "testuser"}`)
+				return nil
+			}
+
+			return testError
+
+		}
+	})
+
+	user, err := api.GetUser("testuser")
+
+	assert.Nil(t, err)
+	assert.Equal(t, "testuser", user.Name)
+
+	err = api.RemoveUser("testuser")
+
+	assert.Equal(t, testError, err)
+
+}
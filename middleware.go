@@ -0,0 +1,300 @@
+package crowd
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type contextKey int
+
+const (
+	userContextKey contextKey = iota
+	operationContextKey
+	dryRunContextKey
+)
+
+// Store the name of an authenticated crowd user in a context. Authentication
+// middlewares use this to hand the user over to RequireGroups.
+func ContextWithUser(ctx context.Context, userName string) context.Context {
+	return context.WithValue(ctx, userContextKey, userName)
+}
+
+// Get the name of the authenticated crowd user from a context.
+func UserFromContext(ctx context.Context) (string, bool) {
+	userName, ok := ctx.Value(userContextKey).(string)
+	return userName, ok && userName != ""
+}
+
+type GroupMatch int
+
+const (
+	// The user must be a member of at least one of the groups.
+	MatchAnyGroup GroupMatch = iota
+	// The user must be a member of all of the groups.
+	MatchAllGroups
+)
+
+// Authorizes http requests based on the (nested) crowd group memberships of
+// the requesting user.
+type GroupAuthorizer struct {
+	api    Client
+	groups *ttlCache
+}
+
+// Create a new group authorizer. The groups of each user are cached for the
+// given duration, a duration of zero disables the cache.
+func NewGroupAuthorizer(api Client, cacheTTL time.Duration) *GroupAuthorizer {
+
+	return &GroupAuthorizer{
+		api:    api,
+		groups: newTTLCache(cacheTTL),
+	}
+
+}
+
+// Create a middleware which only passes requests of users matching the given
+// groups. The user is taken from the request context (see ContextWithUser),
+// if there is none the HTTP Basic credentials of the request are verified
+// against crowd instead.
+func (a *GroupAuthorizer) RequireGroups(match GroupMatch, groups ...string) func(http.Handler) http.Handler {
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+			userName, ok := UserFromContext(r.Context())
+
+			if !ok {
+
+				basicUser, basicPassword, hasBasicAuth := r.BasicAuth()
+
+				var err error
+
+				if hasBasicAuth {
+					err = a.api.AuthenticateUser(basicUser, basicPassword)
+				}
+
+				switch {
+				case !hasBasicAuth || isRejected(err):
+					w.Header().Set("WWW-Authenticate", `Basic realm="crowd"`)
+					http.Error(w, "Unauthorized: no authenticated crowd user", http.StatusUnauthorized)
+					return
+				case err != nil:
+					http.Error(w, "Service Unavailable: could not verify crowd credentials", http.StatusServiceUnavailable)
+					return
+				}
+
+				userName = basicUser
+				r = r.WithContext(ContextWithUser(r.Context(), userName))
+
+			}
+
+			userGroups, err := a.userGroups(userName)
+
+			switch {
+			case errors.Is(err, ErrorUserNotFound):
+				userGroups = map[string]bool{}
+			case err != nil:
+				http.Error(w, "Service Unavailable: could not resolve crowd groups", http.StatusServiceUnavailable)
+				return
+			}
+
+			if !matchGroups(userGroups, match, groups) {
+				http.Error(w, forbiddenMessage(userName, match, groups), http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+
+		})
+	}
+
+}
+
+// Forget the cached groups of a user, for example after changing memberships.
+func (a *GroupAuthorizer) Invalidate(userName string) {
+	a.groups.delete(strings.ToLower(userName))
+}
+
+func (a *GroupAuthorizer) userGroups(userName string) (map[string]bool, error) {
+
+	key := strings.ToLower(userName)
+
+	if cached, ok := a.groups.get(key); ok {
+		return cached.(map[string]bool), nil
+	}
+
+	groups, err := a.api.GetNestedGroupsForUser(userName)
+
+	if err != nil {
+		return nil, err
+	}
+
+	userGroups := make(map[string]bool, len(groups.Groups))
+
+	// Crowd group names are case insensitive.
+	for _, group := range groups.Groups {
+		userGroups[strings.ToLower(group.Name)] = true
+	}
+
+	a.groups.set(key, userGroups)
+
+	return userGroups, nil
+
+}
+
+// Protects http handlers with HTTP Basic authentication, verifying the
+// credentials against crowd.
+type BasicAuth struct {
+	Realm       string
+	api         Client
+	credentials *ttlCache
+	salt        []byte
+	authorizer  *GroupAuthorizer
+}
+
+// Create a new HTTP Basic authenticator. Successful verifications are cached
+// for the given duration, keyed by a salted hash of the credentials. The
+// groups checked by RequireGroups are cached for the same duration, so a
+// removed membership is noticed at the same time as a changed password. A
+// duration of zero disables both caches.
+func NewBasicAuth(api Client, cacheTTL time.Duration) (*BasicAuth, error) {
+
+	salt := make([]byte, 32)
+
+	_, err := rand.Read(salt)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &BasicAuth{
+		Realm:       "crowd",
+		api:         api,
+		credentials: newTTLCache(cacheTTL),
+		salt:        salt,
+		authorizer:  NewGroupAuthorizer(api, cacheTTL),
+	}, nil
+
+}
+
+// Wrap a handler, so it is only called for requests with valid crowd
+// credentials. The authenticated user is stored in the request context.
+func (b *BasicAuth) Handler(next http.Handler) http.Handler {
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		userName, password, ok := r.BasicAuth()
+
+		var err error
+
+		if ok {
+			err = b.verify(userName, password)
+		}
+
+		switch {
+		case !ok || isRejected(err):
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf("Basic realm=%q", b.Realm))
+			http.Error(w, "Unauthorized: invalid crowd credentials", http.StatusUnauthorized)
+			return
+		case err != nil:
+			http.Error(w, "Service Unavailable: could not verify crowd credentials", http.StatusServiceUnavailable)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(ContextWithUser(r.Context(), userName)))
+
+	})
+
+}
+
+// Create a middleware which authenticates requests and additionally requires
+// the user to match the given groups. The groups of a user are cached like
+// the credentials, see NewBasicAuth.
+func (b *BasicAuth) RequireGroups(match GroupMatch, groups ...string) func(http.Handler) http.Handler {
+
+	requireGroups := b.authorizer.RequireGroups(match, groups...)
+
+	return func(next http.Handler) http.Handler {
+		return b.Handler(requireGroups(next))
+	}
+
+}
+
+func (b *BasicAuth) verify(userName, password string) error {
+
+	key := b.credentialsKey(userName, password)
+
+	if _, ok := b.credentials.get(key); ok {
+		return nil
+	}
+
+	if err := b.api.AuthenticateUser(userName, password); err != nil {
+		return err
+	}
+
+	b.credentials.set(key, true)
+
+	return nil
+
+}
+
+// Whether crowd rejected the credentials. Other errors, such as
+// ErrorCrowdUnavailable or transport errors, mean that they could not be
+// verified.
+func isRejected(err error) bool {
+	return errors.Is(err, ErrorInvalidCredentials) || errors.Is(err, ErrorUserNotFound)
+}
+
+func (b *BasicAuth) credentialsKey(userName, password string) string {
+
+	hash := sha256.New()
+	hash.Write(b.salt)
+	hash.Write([]byte(userName))
+	hash.Write([]byte{0})
+	hash.Write([]byte(password))
+
+	return hex.EncodeToString(hash.Sum(nil))
+
+}
+
+func matchGroups(userGroups map[string]bool, match GroupMatch, groups []string) bool {
+
+	for _, group := range groups {
+
+		isMember := userGroups[strings.ToLower(group)]
+
+		if match == MatchAnyGroup && isMember {
+			return true
+		}
+
+		if match == MatchAllGroups && !isMember {
+			return false
+		}
+
+	}
+
+	return match == MatchAllGroups
+
+}
+
+func forbiddenMessage(userName string, match GroupMatch, groups []string) string {
+
+	quantifier := "any"
+
+	if match == MatchAllGroups {
+		quantifier = "all"
+	}
+
+	return fmt.Sprintf(
+		"Forbidden: user %q must be a member of %s of the groups: %s",
+		userName, quantifier, strings.Join(groups, ", "),
+	)
+
+}
@@ -0,0 +1,278 @@
+package crowd
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func newGroupsServer(t *testing.T, lookups *int) *httptest.Server {
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		switch r.URL.Path {
+		case "/rest/usermanagement/1/authentication":
+			w.WriteHeader(http.StatusOK)
+		case "/rest/usermanagement/1/user/group/nested":
+			*lookups++
+
+			resp := Groups{Groups: []*Group{{Name: "Admins"}, {Name: "developers"}}}
+			respBytes, err := json.Marshal(resp)
+
+			assert.Nil(t, err)
+
+			w.WriteHeader(http.StatusOK)
+			w.Write(respBytes)
+		default:
+			http.Error(w, "not found", http.StatusNotFound)
+		}
+
+	}))
+
+}
+
+func TestGroupAuthorizer_RequireGroups(t *testing.T) {
+
+	lookups := 0
+
+	server := newGroupsServer(t, &lookups)
+	defer server.Close()
+
+	api, err := NewAPI(server.URL, "testapp", "password")
+
+	assert.Nil(t, err)
+
+	authorizer := NewGroupAuthorizer(api, time.Minute)
+
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		userName, _ := UserFromContext(r.Context())
+
+		assert.Equal(t, "testuser", userName)
+
+		w.WriteHeader(http.StatusOK)
+
+	})
+
+	tests := []struct {
+		match  GroupMatch
+		groups []string
+		status int
+	}{
+		{MatchAnyGroup, []string{"admins", "testers"}, http.StatusOK},
+		{MatchAnyGroup, []string{"testers"}, http.StatusForbidden},
+		{MatchAllGroups, []string{"admins", "developers"}, http.StatusOK},
+		{MatchAllGroups, []string{"admins", "testers"}, http.StatusForbidden},
+	}
+
+	for _, test := range tests {
+
+		handler := authorizer.RequireGroups(test.match, test.groups...)(ok)
+
+		request := httptest.NewRequest("GET", "/", nil)
+		request = request.WithContext(ContextWithUser(request.Context(), "testuser"))
+		recorder := httptest.NewRecorder()
+
+		handler.ServeHTTP(recorder, request)
+
+		assert.Equal(t, test.status, recorder.Code)
+
+	}
+
+	assert.Equal(t, 1, lookups)
+
+}
+
+func TestGroupAuthorizer_RequireGroupsBasicAuth(t *testing.T) {
+
+	lookups := 0
+
+	server := newGroupsServer(t, &lookups)
+	defer server.Close()
+
+	api, err := NewAPI(server.URL, "testapp", "password")
+
+	assert.Nil(t, err)
+
+	handler := NewGroupAuthorizer(api, time.Minute).RequireGroups(MatchAnyGroup, "admins")(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		}),
+	)
+
+	request := httptest.NewRequest("GET", "/", nil)
+	recorder := httptest.NewRecorder()
+
+	handler.ServeHTTP(recorder, request)
+
+	assert.Equal(t, http.StatusUnauthorized, recorder.Code)
+	assert.NotEmpty(t, recorder.Header().Get("WWW-Authenticate"))
+
+	request = httptest.NewRequest("GET", "/", nil)
+	request.SetBasicAuth("testuser", "secret")
+	recorder = httptest.NewRecorder()
+
+	handler.ServeHTTP(recorder, request)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+
+}
+
+func TestBasicAuth_Handler(t *testing.T) {
+
+	authentications := 0
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		assert.Equal(t, "/rest/usermanagement/1/authentication", r.URL.Path)
+
+		authentications++
+
+		body := &PasswordValue{}
+		err := json.NewDecoder(r.Body).Decode(body)
+
+		assert.Nil(t, err)
+
+		if body.Value != "secret" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+
+	}))
+	defer server.Close()
+
+	api, err := NewAPI(server.URL, "testapp", "password")
+
+	assert.Nil(t, err)
+
+	basicAuth, err := NewBasicAuth(api, time.Minute)
+
+	assert.Nil(t, err)
+
+	handler := basicAuth.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		userName, ok := UserFromContext(r.Context())
+
+		assert.True(t, ok)
+		assert.Equal(t, "testuser", userName)
+
+		w.WriteHeader(http.StatusOK)
+
+	}))
+
+	for i := 0; i < 3; i++ {
+
+		request := httptest.NewRequest("GET", "/", nil)
+		request.SetBasicAuth("testuser", "secret")
+		recorder := httptest.NewRecorder()
+
+		handler.ServeHTTP(recorder, request)
+
+		assert.Equal(t, http.StatusOK, recorder.Code)
+
+	}
+
+	assert.Equal(t, 1, authentications)
+
+	request := httptest.NewRequest("GET", "/", nil)
+	request.SetBasicAuth("testuser", "wrong")
+	recorder := httptest.NewRecorder()
+
+	handler.ServeHTTP(recorder, request)
+
+	assert.Equal(t, http.StatusUnauthorized, recorder.Code)
+	assert.Equal(t, `Basic realm="crowd"`, recorder.Header().Get("WWW-Authenticate"))
+	assert.Equal(t, 2, authentications)
+
+}
+
+func TestBasicAuth_RequireGroups(t *testing.T) {
+
+	lookups := 0
+
+	server := newGroupsServer(t, &lookups)
+	defer server.Close()
+
+	api, err := NewAPI(server.URL, "testapp", "password")
+
+	assert.Nil(t, err)
+
+	basicAuth, err := NewBasicAuth(api, time.Minute)
+
+	assert.Nil(t, err)
+
+	handler := basicAuth.RequireGroups(MatchAllGroups, "admins", "testers")(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		}),
+	)
+
+	request := httptest.NewRequest("GET", "/", nil)
+	request.SetBasicAuth("testuser", "secret")
+	recorder := httptest.NewRecorder()
+
+	handler.ServeHTTP(recorder, request)
+
+	assert.Equal(t, http.StatusForbidden, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), "admins, testers")
+
+}
+
+func TestBasicAuth_HandlerUnavailable(t *testing.T) {
+
+	api, err := NewAPI("http://127.0.0.1:1", "testapp", "password")
+
+	assert.Nil(t, err)
+
+	basicAuth, err := NewBasicAuth(api, time.Minute)
+
+	assert.Nil(t, err)
+
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	handlers := []http.Handler{
+		basicAuth.Handler(ok),
+		NewGroupAuthorizer(api, time.Minute).RequireGroups(MatchAnyGroup, "admins")(ok),
+	}
+
+	for _, handler := range handlers {
+
+		request := httptest.NewRequest("GET", "/", nil)
+		request.SetBasicAuth("testuser", "secret")
+		recorder := httptest.NewRecorder()
+
+		handler.ServeHTTP(recorder, request)
+
+		assert.Equal(t, http.StatusServiceUnavailable, recorder.Code)
+		assert.Empty(t, recorder.Header().Get("WWW-Authenticate"))
+
+	}
+
+	// An open circuit breaker is reported the same way.
+	breaker := NewCircuitBreaker(BreakerSettings{ConsecutiveFailures: 1, OpenTimeout: time.Minute})
+	breaker.done(allowed(t, breaker), false)
+
+	api, err = NewAPI("http://127.0.0.1:1", "testapp", "password", WithCircuitBreaker(breaker))
+
+	assert.Nil(t, err)
+
+	basicAuth, err = NewBasicAuth(api, time.Minute)
+
+	assert.Nil(t, err)
+
+	request := httptest.NewRequest("GET", "/", nil)
+	request.SetBasicAuth("testuser", "secret")
+	recorder := httptest.NewRecorder()
+
+	basicAuth.Handler(ok).ServeHTTP(recorder, request)
+
+	assert.Equal(t, http.StatusServiceUnavailable, recorder.Code)
+
+}
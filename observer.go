@@ -0,0 +1,52 @@
+package crowd
+
+import (
+	"context"
+	"github.com/valyala/fasthttp"
+	"time"
+)
+
+// Describes a finished request to crowd.
+type RequestInfo struct {
+	// The logical operation, for example "GetUser" or "AddUserToGroup".
+	Operation string
+	Method    string
+	// The path of the request, without query.
+	Path string
+	// The query of the request. It may contain user and group names.
+	Query string
+	// The HTTP status, zero if there was no response.
+	Status   int
+	Duration time.Duration
+	// Transport errors and errors of the client itself, for example
+	// ErrorCrowdUnavailable. Error responses of crowd are reported by Status.
+	Err error
+}
+
+// Gets notified about every request made by an API, for example to collect
+// metrics. Implementations must be safe for concurrent use.
+type Observer interface {
+	ObserveRequest(info RequestInfo)
+}
+
+// Report every request to the given observer.
+func WithObserver(observer Observer) Option {
+	return func(api *API) {
+		api.observer = observer
+	}
+}
+
+// Traces every request made by an API. StartRequest is called before the
+// request with the context of the API (see API.WithContext) and the headers
+// of the request, so trace context can be propagated to crowd. The returned
+// function is called when the request is finished.
+type Tracer interface {
+	StartRequest(ctx context.Context, info RequestInfo, header *fasthttp.RequestHeader) func(info RequestInfo)
+}
+
+// Trace every request with the given tracer.
+func WithTracer(tracer Tracer) Option {
+	return func(api *API) {
+		api.tracer = tracer
+	}
+}
@@ -0,0 +1,112 @@
+package crowd
+
+import (
+	"net/url"
+	"strings"
+)
+
+// Logical operation names of the crowd REST resources, used to label
+// observed requests.
+var operations = map[string]string{
+	"GET /rest/usermanagement/1/user":                       "GetUser",
+	"POST /rest/usermanagement/1/user":                      "AddUser",
+	"PUT /rest/usermanagement/1/user":                       "UpdateUser",
+	"DELETE /rest/usermanagement/1/user":                    "RemoveUser",
+	"GET /rest/usermanagement/1/user/attribute":             "GetUserAttributes",
+	"POST /rest/usermanagement/1/user/attribute":            "StoreUserAttributes",
+	"DELETE /rest/usermanagement/1/user/attribute":          "RemoveUserAttribute",
+	"POST /rest/usermanagement/1/user/rename":               "RenameUser",
+	"PUT /rest/usermanagement/1/user/password":              "SetUserPassword",
+	"POST /rest/usermanagement/1/authentication":            "AuthenticateUser",
+	"GET /rest/usermanagement/1/user/group/nested":          "GetNestedGroupsForUser",
+	"GET /rest/usermanagement/1/user/group/direct":          "GetDirectGroupsForUser",
+	"POST /rest/usermanagement/1/user/group/direct":         "AddUserToGroup",
+	"DELETE /rest/usermanagement/1/user/group/direct":       "RemoveUserFromGroup",
+	"GET /rest/usermanagement/1/group":                      "GetGroup",
+	"POST /rest/usermanagement/1/group":                     "CreateGroup",
+	"DELETE /rest/usermanagement/1/group":                   "RemoveGroup",
+	"GET /rest/usermanagement/1/group/attribute":            "GetGroupAttributes",
+	"POST /rest/usermanagement/1/group/attribute":           "StoreGroupAttributes",
+	"GET /rest/usermanagement/1/group/user/direct":          "GetGroupMembers",
+	"GET /rest/usermanagement/1/group/user/nested":          "GetNestedGroupMembers",
+	"GET /rest/usermanagement/1/group/child-group/direct":   "GetChildGroups",
+	"POST /rest/usermanagement/1/group/child-group/direct":  "AddChildGroupMembership",
+	"POST /rest/usermanagement/1/group/parent-group/direct": "AddParentGroupMembership",
+	"GET /rest/usermanagement/1/event":                      "GetEventToken",
+	"POST /rest/usermanagement/1/session":                   "CreateSession",
+	"GET /rest/usermanagement/1/config/cookie":              "GetCookieConfig",
+
+	// Group changes made by the reconciler.
+	"PUT /rest/usermanagement/1/group":                       "UpdateGroup",
+	"DELETE /rest/usermanagement/1/group/attribute":          "RemoveGroupAttribute",
+	"DELETE /rest/usermanagement/1/group/child-group/direct": "RemoveChildGroupMembership",
+}
+
+// Searches, by their entity-type query parameter.
+var searchOperations = map[string]string{
+	"user":  "SearchUsers",
+	"group": "SearchGroups",
+}
+
+// Resources with a variable path, matched by prefix.
+var operationPrefixes = []struct {
+	method string
+	prefix string
+	name   string
+}{
+	{"GET", "/rest/usermanagement/1/event/", "GetEvents"},
+	{"GET", "/rest/usermanagement/1/session/", "GetSession"},
+	{"POST", "/rest/usermanagement/1/session/", "ValidateSession"},
+	{"DELETE", "/rest/usermanagement/1/session/", "InvalidateSession"},
+}
+
+// Get the logical operation of a request, for example "GetUser".
+func operationName(method, uri string) string {
+
+	path := requestPath(uri)
+
+	if name, ok := operations[method+" "+path]; ok {
+		return name
+	}
+
+	if method == "GET" && path == "/rest/usermanagement/1/search" {
+
+		query, _ := url.ParseQuery(requestQuery(uri))
+
+		if name, ok := searchOperations[query.Get("entity-type")]; ok {
+			return name
+		}
+
+	}
+
+	for _, operation := range operationPrefixes {
+		if method == operation.method && strings.HasPrefix(path, operation.prefix) {
+			return operation.name
+		}
+	}
+
+	return "Unknown"
+
+}
+
+// Strip the query from a request uri.
+func requestPath(uri string) string {
+
+	if i := strings.IndexByte(uri, '?'); i >= 0 {
+		return uri[:i]
+	}
+
+	return uri
+
+}
+
+// Get the query of a request uri.
+func requestQuery(uri string) string {
+
+	if i := strings.IndexByte(uri, '?'); i >= 0 {
+		return uri[i+1:]
+	}
+
+	return ""
+
+}
@@ -0,0 +1,106 @@
+package crowd
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// A token bucket rate limiter. Tokens are added with a fixed rate up to the
+// burst size, every request takes one token.
+type RateLimiter struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+	now    func() time.Time
+}
+
+// Create a new rate limiter allowing the given number of requests per second
+// and bursts of up to burst requests. A rate of zero or less disables the
+// limit.
+func NewRateLimiter(requestsPerSecond float64, burst int) *RateLimiter {
+
+	if burst < 1 {
+		burst = 1
+	}
+
+	return &RateLimiter{
+		rate:   requestsPerSecond,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		now:    time.Now,
+	}
+
+}
+
+// Wait until a request is allowed to pass. Returns the error of the context
+// if it is done before, or if its deadline would pass while waiting.
+func (l *RateLimiter) Wait(ctx context.Context) error {
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	delay := l.reserve()
+
+	if delay == 0 {
+		return nil
+	}
+
+	if deadline, ok := ctx.Deadline(); ok && deadline.Before(l.now().Add(delay)) {
+		l.cancel()
+		return context.DeadlineExceeded
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		l.cancel()
+		return ctx.Err()
+	}
+
+}
+
+// Take a token and return how long to wait until it is actually available.
+func (l *RateLimiter) reserve() time.Duration {
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.rate <= 0 {
+		return 0
+	}
+
+	now := l.now()
+
+	if !l.last.IsZero() {
+		l.tokens = math.Min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	}
+
+	l.last = now
+	l.tokens--
+
+	if l.tokens >= 0 {
+		return 0
+	}
+
+	return time.Duration(-l.tokens / l.rate * float64(time.Second))
+
+}
+
+// Return a reserved token which was not used.
+func (l *RateLimiter) cancel() {
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.tokens = math.Min(l.burst, l.tokens+1)
+
+}
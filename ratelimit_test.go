@@ -0,0 +1,54 @@
+package crowd
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter_reserve(t *testing.T) {
+
+	now := time.Now()
+
+	limiter := NewRateLimiter(10, 2)
+	limiter.now = func() time.Time { return now }
+
+	assert.Equal(t, time.Duration(0), limiter.reserve())
+	assert.Equal(t, time.Duration(0), limiter.reserve())
+	assert.Equal(t, 100*time.Millisecond, limiter.reserve())
+
+	now = now.Add(time.Second)
+
+	assert.Equal(t, time.Duration(0), limiter.reserve())
+	assert.Equal(t, time.Duration(0), limiter.reserve())
+
+}
+
+func TestRateLimiter_Wait(t *testing.T) {
+
+	limiter := NewRateLimiter(1, 1)
+
+	assert.Nil(t, limiter.Wait(context.Background()))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	assert.Equal(t, context.DeadlineExceeded, limiter.Wait(ctx))
+
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+
+	assert.Equal(t, context.Canceled, limiter.Wait(ctx))
+
+}
+
+func TestRateLimiter_WaitUnlimited(t *testing.T) {
+
+	limiter := NewRateLimiter(0, 1)
+
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, limiter.Wait(context.Background()))
+	}
+
+}
@@ -0,0 +1,570 @@
+package crowd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"io"
+	"sort"
+	"strings"
+)
+
+// The desired state of groups managed as code.
+//
+//	groups:
+//	  - name: developers
+//	    description: All developers
+//	    attributes:
+//	      owner: [alice]
+//	    children: [backend, frontend]
+//	    members: [alice, bob]
+type DesiredState struct {
+	Groups []*DesiredGroup `json:"groups" yaml:"groups"`
+}
+
+type DesiredGroup struct {
+	Name        string `json:"name" yaml:"name"`
+	Description string `json:"description" yaml:"description"`
+	// Groups are active if not set.
+	Active     *bool               `json:"active,omitempty" yaml:"active,omitempty"`
+	Attributes map[string][]string `json:"attributes,omitempty" yaml:"attributes,omitempty"`
+	// Names of the direct child groups.
+	Children []string `json:"children,omitempty" yaml:"children,omitempty"`
+	// Names of the users which are direct members.
+	Members []string `json:"members,omitempty" yaml:"members,omitempty"`
+}
+
+func (g *DesiredGroup) isActive() bool {
+	return g.Active == nil || *g.Active
+}
+
+// Read a desired state in YAML or JSON. Unknown fields, duplicate groups and
+// cycles of child groups are errors.
+func ParseDesiredState(data []byte) (*DesiredState, error) {
+
+	state := &DesiredState{}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+
+	if err := decoder.Decode(state); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("invalid desired state: %v", err)
+	}
+
+	if err := state.validate(); err != nil {
+		return nil, err
+	}
+
+	return state, nil
+
+}
+
+func (s *DesiredState) validate() error {
+
+	groups := make(map[string]*DesiredGroup)
+
+	for _, group := range s.Groups {
+
+		if group.Name == "" {
+			return errors.New("invalid desired state: group without name")
+		}
+
+		if _, ok := groups[cacheKey(group.Name)]; ok {
+			return fmt.Errorf("invalid desired state: group %s is defined twice", group.Name)
+		}
+
+		groups[cacheKey(group.Name)] = group
+
+	}
+
+	// Depth first search for a child group which is also an ancestor.
+	visiting := make(map[string]bool)
+	done := make(map[string]bool)
+
+	var visit func(groupKey string, path []string) error
+
+	visit = func(groupKey string, path []string) error {
+
+		if visiting[groupKey] {
+			return fmt.Errorf("invalid desired state: cycle of child groups %s", strings.Join(path, " -> "))
+		}
+
+		group, ok := groups[groupKey]
+
+		if !ok || done[groupKey] {
+			return nil
+		}
+
+		visiting[groupKey] = true
+
+		for _, child := range group.Children {
+			if err := visit(cacheKey(child), append(path, child)); err != nil {
+				return err
+			}
+		}
+
+		visiting[groupKey] = false
+		done[groupKey] = true
+
+		return nil
+
+	}
+
+	for _, group := range s.Groups {
+		if err := visit(cacheKey(group.Name), []string{group.Name}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+
+}
+
+// A change of a plan, named after the Client method which applies it.
+type PlanAction string
+
+const (
+	PlanCreateGroup                PlanAction = "CreateGroup"
+	PlanUpdateGroup                PlanAction = "UpdateGroup"
+	PlanStoreGroupAttributes       PlanAction = "StoreGroupAttributes"
+	PlanAddChildGroupMembership    PlanAction = "AddChildGroupMembership"
+	PlanAddUserToGroup             PlanAction = "AddUserToGroup"
+	PlanRemoveUserFromGroup        PlanAction = "RemoveUserFromGroup"
+	PlanRemoveChildGroupMembership PlanAction = "RemoveChildGroupMembership"
+	PlanRemoveGroupAttribute       PlanAction = "RemoveGroupAttribute"
+	PlanRemoveGroup                PlanAction = "RemoveGroup"
+)
+
+// Order of the actions in a plan: groups are created before they are
+// referenced, and removed after their memberships.
+var planOrder = map[PlanAction]int{
+	PlanCreateGroup:                0,
+	PlanUpdateGroup:                1,
+	PlanStoreGroupAttributes:       2,
+	PlanAddChildGroupMembership:    3,
+	PlanAddUserToGroup:             4,
+	PlanRemoveUserFromGroup:        5,
+	PlanRemoveChildGroupMembership: 6,
+	PlanRemoveGroupAttribute:       7,
+	PlanRemoveGroup:                8,
+}
+
+type PlanStep struct {
+	Action PlanAction `json:"action"`
+	Group  string     `json:"group"`
+	// The user, child group or attribute of the step.
+	Target      string   `json:"target,omitempty"`
+	Description string   `json:"description,omitempty"`
+	Active      bool     `json:"active,omitempty"`
+	Values      []string `json:"values,omitempty"`
+}
+
+// Describe the step, prefixed with + for additions, ~ for changes and - for
+// removals.
+func (s *PlanStep) String() string {
+
+	state := "active"
+
+	if !s.Active {
+		state = "inactive"
+	}
+
+	switch s.Action {
+	case PlanCreateGroup:
+		return fmt.Sprintf("+ create group %s (%q, %s)", s.Group, s.Description, state)
+	case PlanUpdateGroup:
+		return fmt.Sprintf("~ update group %s (%q, %s)", s.Group, s.Description, state)
+	case PlanStoreGroupAttributes:
+		return fmt.Sprintf("~ set attribute %s of group %s to %q", s.Target, s.Group, s.Values)
+	case PlanAddChildGroupMembership:
+		return fmt.Sprintf("+ add group %s to group %s", s.Target, s.Group)
+	case PlanAddUserToGroup:
+		return fmt.Sprintf("+ add user %s to group %s", s.Target, s.Group)
+	case PlanRemoveUserFromGroup:
+		return fmt.Sprintf("- remove user %s from group %s", s.Target, s.Group)
+	case PlanRemoveChildGroupMembership:
+		return fmt.Sprintf("- remove group %s from group %s", s.Target, s.Group)
+	case PlanRemoveGroupAttribute:
+		return fmt.Sprintf("- remove attribute %s of group %s", s.Target, s.Group)
+	case PlanRemoveGroup:
+		return fmt.Sprintf("- remove group %s", s.Group)
+	default:
+		return fmt.Sprintf("? %s %s %s", s.Action, s.Group, s.Target)
+	}
+
+}
+
+// The steps which bring crowd to a desired state, in the order they are
+// applied.
+type Plan struct {
+	Steps []*PlanStep `json:"steps"`
+}
+
+// Write the steps, one per line.
+func (p *Plan) String() string {
+
+	lines := make([]string, len(p.Steps))
+
+	for i, step := range p.Steps {
+		lines[i] = step.String() + "\n"
+	}
+
+	return strings.Join(lines, "")
+
+}
+
+// Apply the steps in order. Stops at the first failed step and returns its
+// error, the steps before it have been applied.
+func (p *Plan) Apply(client Client) error {
+
+	for _, step := range p.Steps {
+		if err := step.apply(client); err != nil {
+			return fmt.Errorf("%s: %v", strings.TrimLeft(step.String(), "+~- "), err)
+		}
+	}
+
+	return nil
+
+}
+
+func (s *PlanStep) apply(client Client) error {
+
+	switch s.Action {
+	case PlanCreateGroup:
+		return client.CreateGroup(s.Group, s.Description, s.Active)
+	case PlanUpdateGroup:
+		return client.UpdateGroup(s.Group, s.Description, s.Active)
+	case PlanStoreGroupAttributes:
+		return client.StoreGroupAttributes(s.Group, &Attributes{Attributes: []*Attribute{{Name: s.Target, Values: s.Values}}})
+	case PlanAddChildGroupMembership:
+		return client.AddChildGroupMembership(s.Group, s.Target)
+	case PlanAddUserToGroup:
+		return client.AddUserToGroup(s.Target, s.Group)
+	case PlanRemoveUserFromGroup:
+		return client.RemoveUserFromGroup(s.Target, s.Group)
+	case PlanRemoveChildGroupMembership:
+		return client.RemoveChildGroupMembership(s.Group, s.Target)
+	case PlanRemoveGroupAttribute:
+		return client.RemoveGroupAttribute(s.Group, s.Target)
+	case PlanRemoveGroup:
+		return client.RemoveGroup(s.Group)
+	default:
+		return fmt.Errorf("unknown action %s", s.Action)
+	}
+
+}
+
+type ReconcileOptions struct {
+	// Remove what is not in the desired state: members, child groups and
+	// attributes of the desired groups, and whole groups. Without pruning,
+	// the plan only adds and changes.
+	Prune bool
+	// Only groups whose name starts with the prefix are pruned, so that
+	// groups managed elsewhere are left alone. Required with Prune unless
+	// PruneAll is set.
+	PrunePrefix string
+	// Allow pruning without a prefix, which removes every group of crowd
+	// that is not in the desired state, crowd-administrators included.
+	PruneAll bool
+}
+
+// Compare the desired state with crowd and plan the steps to reach it.
+// Returns an error if a member or child group neither exists nor is in the
+// desired state, and ErrorReconcilePruneWithoutPrefix if pruning has neither
+// a prefix nor PruneAll.
+func PlanReconcile(client Client, desired *DesiredState, options *ReconcileOptions) (*Plan, error) {
+
+	if options == nil {
+		options = &ReconcileOptions{}
+	}
+
+	if options.Prune && options.PrunePrefix == "" && !options.PruneAll {
+		return nil, ErrorReconcilePruneWithoutPrefix
+	}
+
+	r := &reconciler{
+		client:  client,
+		options: options,
+		desired: make(map[string]bool),
+		users:   make(map[string]bool),
+		plan:    &Plan{Steps: []*PlanStep{}},
+	}
+
+	for _, group := range desired.Groups {
+		r.desired[cacheKey(group.Name)] = true
+	}
+
+	for _, group := range desired.Groups {
+		if err := r.group(group); err != nil {
+			return nil, fmt.Errorf("group %s: %v", group.Name, err)
+		}
+	}
+
+	if options.Prune {
+		if err := r.pruneGroups(); err != nil {
+			return nil, err
+		}
+	}
+
+	sort.SliceStable(r.plan.Steps, func(i, j int) bool {
+		return planOrder[r.plan.Steps[i].Action] < planOrder[r.plan.Steps[j].Action]
+	})
+
+	return r.plan, nil
+
+}
+
+type reconciler struct {
+	client  Client
+	options *ReconcileOptions
+	// Keys of the desired groups.
+	desired map[string]bool
+	// Whether users exist, by key.
+	users map[string]bool
+	plan  *Plan
+}
+
+func (r *reconciler) add(step *PlanStep) {
+	r.plan.Steps = append(r.plan.Steps, step)
+}
+
+// Whether the extra entries of a group are removed.
+func (r *reconciler) prunes(groupName string) bool {
+	return r.options.Prune && strings.HasPrefix(cacheKey(groupName), cacheKey(r.options.PrunePrefix))
+}
+
+// Plan the steps of a desired group.
+func (r *reconciler) group(group *DesiredGroup) error {
+
+	attributes := &Attributes{}
+	children := &Groups{}
+	members := &Users{}
+
+	live, err := r.client.GetGroup(group.Name)
+
+	switch {
+	case err == ErrorGroupNotFound:
+		r.add(&PlanStep{Action: PlanCreateGroup, Group: group.Name, Description: group.Description, Active: group.isActive()})
+	case err != nil:
+		return err
+	default:
+
+		if live.Description != group.Description || live.Active != group.isActive() {
+			r.add(&PlanStep{Action: PlanUpdateGroup, Group: group.Name, Description: group.Description, Active: group.isActive()})
+		}
+
+		if attributes, err = r.client.GetGroupAttributes(group.Name); err != nil {
+			return err
+		}
+
+		if children, err = r.client.GetChildGroups(group.Name); err != nil {
+			return err
+		}
+
+		if members, err = r.client.GetGroupMembers(group.Name); err != nil {
+			return err
+		}
+
+	}
+
+	r.attributes(group, attributeMap(attributes.Attributes))
+
+	if err := r.children(group, children); err != nil {
+		return err
+	}
+
+	return r.members(group, members)
+
+}
+
+func (r *reconciler) attributes(group *DesiredGroup, live map[string][]string) {
+
+	names := make([]string, 0, len(group.Attributes))
+
+	for name := range group.Attributes {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+
+		values, ok := live[name]
+
+		if !ok || !sameValues(values, group.Attributes[name]) {
+			r.add(&PlanStep{Action: PlanStoreGroupAttributes, Group: group.Name, Target: name, Values: group.Attributes[name]})
+		}
+
+	}
+
+	if !r.prunes(group.Name) {
+		return
+	}
+
+	names = names[:0]
+
+	for name := range live {
+		if _, ok := group.Attributes[name]; !ok {
+			names = append(names, name)
+		}
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		r.add(&PlanStep{Action: PlanRemoveGroupAttribute, Group: group.Name, Target: name})
+	}
+
+}
+
+func (r *reconciler) children(group *DesiredGroup, live *Groups) error {
+
+	existing := make(map[string]bool)
+
+	for _, child := range live.Groups {
+		existing[cacheKey(child.Name)] = true
+	}
+
+	wanted := make(map[string]bool)
+
+	for _, childName := range group.Children {
+
+		wanted[cacheKey(childName)] = true
+
+		if existing[cacheKey(childName)] {
+			continue
+		}
+
+		if !r.desired[cacheKey(childName)] {
+
+			if _, err := r.client.GetGroup(childName); err != nil {
+				return fmt.Errorf("child group %s: %v", childName, err)
+			}
+
+		}
+
+		r.add(&PlanStep{Action: PlanAddChildGroupMembership, Group: group.Name, Target: childName})
+
+	}
+
+	if r.prunes(group.Name) {
+		for _, child := range live.Groups {
+			if !wanted[cacheKey(child.Name)] {
+				r.add(&PlanStep{Action: PlanRemoveChildGroupMembership, Group: group.Name, Target: child.Name})
+			}
+		}
+	}
+
+	return nil
+
+}
+
+func (r *reconciler) members(group *DesiredGroup, live *Users) error {
+
+	existing := make(map[string]bool)
+
+	for _, member := range live.Users {
+		existing[cacheKey(member.Name)] = true
+	}
+
+	wanted := make(map[string]bool)
+
+	for _, userName := range group.Members {
+
+		wanted[cacheKey(userName)] = true
+
+		if existing[cacheKey(userName)] {
+			continue
+		}
+
+		if err := r.checkUser(userName); err != nil {
+			return fmt.Errorf("member %s: %v", userName, err)
+		}
+
+		r.add(&PlanStep{Action: PlanAddUserToGroup, Group: group.Name, Target: userName})
+
+	}
+
+	if r.prunes(group.Name) {
+		for _, member := range live.Users {
+			if !wanted[cacheKey(member.Name)] {
+				r.add(&PlanStep{Action: PlanRemoveUserFromGroup, Group: group.Name, Target: member.Name})
+			}
+		}
+	}
+
+	return nil
+
+}
+
+// Check that a user exists, once per user.
+func (r *reconciler) checkUser(userName string) error {
+
+	if r.users[cacheKey(userName)] {
+		return nil
+	}
+
+	if _, err := r.client.GetUser(userName); err != nil {
+		return err
+	}
+
+	r.users[cacheKey(userName)] = true
+
+	return nil
+
+}
+
+// Plan the removal of the groups with the prune prefix which are not desired.
+func (r *reconciler) pruneGroups() error {
+
+	restriction := ""
+
+	if r.options.PrunePrefix != "" {
+		restriction = fmt.Sprintf("name = %q", r.options.PrunePrefix+"*")
+	}
+
+	for startIndex := 0; ; startIndex += listPageSize {
+
+		page, err := r.client.SearchGroups(restriction, startIndex, listPageSize)
+
+		if err != nil {
+			return err
+		}
+
+		for _, group := range page.Groups {
+			if r.prunes(group.Name) && !r.desired[cacheKey(group.Name)] {
+				r.add(&PlanStep{Action: PlanRemoveGroup, Group: group.Name})
+			}
+		}
+
+		if len(page.Groups) < listPageSize {
+			return nil
+		}
+
+	}
+
+}
+
+// Compare attribute values, ignoring their order.
+func sameValues(a, b []string) bool {
+
+	if len(a) != len(b) {
+		return false
+	}
+
+	a = append([]string(nil), a...)
+	b = append([]string(nil), b...)
+
+	sort.Strings(a)
+	sort.Strings(b)
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+
+}
@@ -0,0 +1,152 @@
+package crowd_test
+
+import (
+	"github.com/agile-rcm/crowd-go"
+	"github.com/agile-rcm/crowd-go/crowdmock"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+const desiredState = `
+groups:
+  - name: team-staff
+    description: All staff
+    attributes:
+      owner: [alice]
+    children: [team-developers]
+    members: [bob]
+  - name: team-developers
+    active: false
+    members: [alice]
+`
+
+func newReconcileClient(t *testing.T) *crowdmock.Client {
+
+	client := crowdmock.NewClient()
+
+	assert.Nil(t, client.AddUser("alice", "password", "", "", "", "", true))
+	assert.Nil(t, client.AddUser("bob", "password", "", "", "", "", true))
+	assert.Nil(t, client.AddUser("carol", "password", "", "", "", "", true))
+
+	assert.Nil(t, client.CreateGroup("team-staff", "Staff", true))
+	assert.Nil(t, client.CreateGroup("team-old", "", true))
+	assert.Nil(t, client.CreateGroup("other", "", true))
+	assert.Nil(t, client.AddUserToGroup("carol", "team-staff"))
+	assert.Nil(t, client.AddChildGroupMembership("team-staff", "other"))
+	assert.Nil(t, client.StoreGroupAttributes("team-staff", &crowd.Attributes{Attributes: []*crowd.Attribute{{Name: "cost-centre", Values: []string{"1"}}}}))
+
+	return client
+
+}
+
+func TestPlanReconcile(t *testing.T) {
+
+	client := newReconcileClient(t)
+
+	desired, err := crowd.ParseDesiredState([]byte(desiredState))
+
+	assert.Nil(t, err)
+
+	plan, err := crowd.PlanReconcile(client, desired, nil)
+
+	assert.Nil(t, err)
+	assert.Equal(t, `+ create group team-developers ("", inactive)
+~ update group team-staff ("All staff", active)
+~ set attribute owner of group team-staff to ["alice"]
++ add group team-developers to group team-staff
++ add user bob to group team-staff
++ add user alice to group team-developers
+`, plan.String())
+
+	plan, err = crowd.PlanReconcile(client, desired, &crowd.ReconcileOptions{Prune: true, PrunePrefix: "team-"})
+
+	assert.Nil(t, err)
+	assert.Len(t, plan.Steps, 10)
+	assert.Equal(t, `- remove user carol from group team-staff
+- remove group other from group team-staff
+- remove attribute cost-centre of group team-staff
+- remove group team-old
+`, (&crowd.Plan{Steps: plan.Steps[6:]}).String())
+
+	assert.Nil(t, plan.Apply(client))
+
+	// The desired state is reached.
+	plan, err = crowd.PlanReconcile(client, desired, &crowd.ReconcileOptions{Prune: true, PrunePrefix: "team-"})
+
+	assert.Nil(t, err)
+	assert.Empty(t, plan.Steps)
+
+	group, err := client.GetGroup("team-developers")
+
+	assert.Nil(t, err)
+	assert.False(t, group.Active)
+
+	_, err = client.GetGroup("team-old")
+
+	assert.Equal(t, crowd.ErrorGroupNotFound, err)
+
+	// Groups without the prefix are left alone.
+	_, err = client.GetGroup("other")
+
+	assert.Nil(t, err)
+
+}
+
+func TestPlanReconcile_Errors(t *testing.T) {
+
+	client := newReconcileClient(t)
+
+	desired, err := crowd.ParseDesiredState([]byte(`{"groups": [{"name": "team-staff", "members": ["dave"]}]}`))
+
+	assert.Nil(t, err)
+
+	_, err = crowd.PlanReconcile(client, desired, nil)
+
+	assert.EqualError(t, err, "group team-staff: member dave: User could not be found")
+
+	// Pruning every group has to be allowed explicitly.
+	desired, err = crowd.ParseDesiredState([]byte(`{"groups": [{"name": "team-staff"}]}`))
+
+	assert.Nil(t, err)
+
+	_, err = crowd.PlanReconcile(client, desired, &crowd.ReconcileOptions{Prune: true})
+
+	assert.Equal(t, crowd.ErrorReconcilePruneWithoutPrefix, err)
+
+	plan, err := crowd.PlanReconcile(client, desired, &crowd.ReconcileOptions{Prune: true, PruneAll: true})
+
+	assert.Nil(t, err)
+	assert.Contains(t, plan.String(), "- remove group other\n")
+
+	plan = &crowd.Plan{Steps: []*crowd.PlanStep{
+		{Action: crowd.PlanAddUserToGroup, Group: "team-old", Target: "alice"},
+		{Action: crowd.PlanAddUserToGroup, Group: "missing", Target: "bob"},
+		{Action: crowd.PlanAddUserToGroup, Group: "team-old", Target: "carol"},
+	}}
+
+	assert.EqualError(t, plan.Apply(client), "add user bob to group missing: Group could not be found")
+	assert.Equal(t, 3, client.CallCount("AddUserToGroup"), "the seeded membership and the steps until the failed one")
+
+}
+
+func TestParseDesiredState(t *testing.T) {
+
+	for input, message := range map[string]string{
+		"groups:\n  - description: x\n":                                         "invalid desired state: group without name",
+		"groups:\n  - name: a\n  - name: A\n":                                   "invalid desired state: group A is defined twice",
+		"groups:\n  - name: a\n    owner: x\n":                                  "invalid desired state: yaml: unmarshal errors:\n  line 3: field owner not found in type crowd.DesiredGroup",
+		"groups:\n  - {name: a, children: [b]}\n  - {name: b, children: [a]}\n": "invalid desired state: cycle of child groups a -> b -> a",
+	} {
+
+		_, err := crowd.ParseDesiredState([]byte(input))
+
+		assert.EqualError(t, err, message)
+
+	}
+
+	state, err := crowd.ParseDesiredState(nil)
+
+	assert.Nil(t, err)
+	assert.Empty(t, state.Groups)
+
+}
@@ -32,6 +32,10 @@ type Group struct {
 	Active		bool	`json:"active,omitempty"`
 }
 
+type Groups struct {
+	Groups	[]*Group	`json:"groups"`
+}
+
 type GroupAttributes struct {
 	Name		string			`json:"attributes"`
 	Attributes	[]*Attribute	`json:"attribute"`
@@ -51,6 +55,62 @@ type User struct {
 	Attributes  Attributes		`json:"attributes,omitempty"`
 }
 
+type Users struct {
+	Users	[]*User	`json:"users"`
+}
+
 type UserRename struct {
 	NewName string `json:"new-name"`
-}
\ No newline at end of file
+}
+
+// Event Structs
+
+type Events struct {
+	NewEventToken                       string   `json:"newEventToken"`
+	IncrementalSynchronisationAvailable bool     `json:"incrementalSynchronisationAvailable"`
+	Events                              []*Event `json:"events"`
+}
+
+// A change in crowd. Depending on the kind of event either the user, the
+// group or the membership fields are set.
+type Event struct {
+	Operation    string  `json:"operation"`
+	User         *User   `json:"user,omitempty"`
+	Group        *Group  `json:"group,omitempty"`
+	ChildUser    *User   `json:"childUser,omitempty"`
+	ParentGroups *Groups `json:"parentGroups,omitempty"`
+	ChildGroups  *Groups `json:"childGroups,omitempty"`
+}
+
+// Session Structs
+
+// A factor the session is bound to, for example the remote address.
+type ValidationFactor struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type ValidationFactors struct {
+	ValidationFactors []*ValidationFactor `json:"validationFactors"`
+}
+
+type authenticationContext struct {
+	UserName          string             `json:"username"`
+	Password          string             `json:"password"`
+	ValidationFactors *ValidationFactors `json:"validation-factors,omitempty"`
+}
+
+// A single sign-on session. The dates are milliseconds since the epoch.
+type Session struct {
+	Token       string `json:"token"`
+	User        *User  `json:"user,omitempty"`
+	CreatedDate int64  `json:"created-date"`
+	ExpiryDate  int64  `json:"expiry-date"`
+}
+
+// The single sign-on cookie configured in crowd.
+type CookieConfig struct {
+	Domain string `json:"domain"`
+	Secure bool   `json:"secure"`
+	Name   string `json:"name"`
+}
@@ -0,0 +1,780 @@
+package crowd
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// How a user or group differs between a source and a target crowd.
+type DiffState string
+
+const (
+	DiffOnlyInSource DiffState = "only-in-source"
+	DiffOnlyInTarget DiffState = "only-in-target"
+	DiffChanged      DiffState = "changed"
+)
+
+type UserDiff struct {
+	Name  string
+	State DiffState
+	// Nil if the user only exists in the other crowd.
+	Source *ExportedUser
+	Target *ExportedUser
+	// What differs for changed users: "first-name", "last-name",
+	// "display-name", "email", "active", "attribute <name>" and
+	// "group <name>" for a direct membership. Attributes and memberships of
+	// one crowd only end with " (added)" or " (removed)", as seen from the
+	// target.
+	Changes []string
+}
+
+// Whether the user differs in its details or attribute values, not only in
+// memberships or in attributes set in one crowd only.
+func (d *UserDiff) Conflict() bool {
+	return isConflict(d.Changes)
+}
+
+type GroupDiff struct {
+	Name  string
+	State DiffState
+	// Nil if the group only exists in the other crowd.
+	Source *ExportedGroup
+	Target *ExportedGroup
+	// What differs for changed groups: "description", "active",
+	// "attribute <name>" and "child-group <name>", marked like the changes
+	// of users.
+	Changes []string
+}
+
+// Whether the group differs in its details or attribute values, not only in
+// child groups or in attributes set in one crowd only.
+func (d *GroupDiff) Conflict() bool {
+	return isConflict(d.Changes)
+}
+
+// Whether a change is in a value set in both crowds. Memberships and
+// attributes in one crowd only are marked as added or removed.
+func isConflict(changes []string) bool {
+
+	for _, change := range changes {
+		if !strings.HasSuffix(change, " (added)") && !strings.HasSuffix(change, " (removed)") {
+			return true
+		}
+	}
+
+	return false
+
+}
+
+// Whether the details, not the attributes or memberships, differ.
+func hasDetailChanges(changes []string) bool {
+
+	for _, change := range changes {
+		if isConflict([]string{change}) && !strings.HasPrefix(change, "attribute ") {
+			return true
+		}
+	}
+
+	return false
+
+}
+
+// The users and groups which differ between two crowds, sorted by name.
+// Entries which are the same in both are left out.
+type DirectoryDiff struct {
+	Users  []*UserDiff
+	Groups []*GroupDiff
+	// The whole source, for the memberships of renamed users and groups.
+	source *directorySnapshot
+}
+
+// Whether the crowds are the same.
+func (d *DirectoryDiff) Empty() bool {
+	return len(d.Users) == 0 && len(d.Groups) == 0
+}
+
+// Write the differences, one entry per line, prefixed with + for entries
+// only in the source, - for entries only in the target and ~ for changed
+// ones, followed by their changes.
+func (d *DirectoryDiff) WriteText(w io.Writer) error {
+
+	states := map[DiffState]string{DiffOnlyInSource: "+", DiffOnlyInTarget: "-", DiffChanged: "~"}
+
+	for _, user := range d.Users {
+		if err := writeDiffLine(w, states[user.State], "user", user.Name, user.Changes); err != nil {
+			return err
+		}
+	}
+
+	for _, group := range d.Groups {
+		if err := writeDiffLine(w, states[group.State], "group", group.Name, group.Changes); err != nil {
+			return err
+		}
+	}
+
+	return nil
+
+}
+
+func writeDiffLine(w io.Writer, state, kind, name string, changes []string) error {
+
+	line := fmt.Sprintf("%s %s %s", state, kind, name)
+
+	if len(changes) > 0 {
+		line += ": " + strings.Join(changes, ", ")
+	}
+
+	_, err := fmt.Fprintln(w, line)
+
+	return err
+
+}
+
+// Compare the users, attributes, groups and direct memberships of two
+// crowds. Both are read completely into memory.
+func DiffDirectories(source, target Client, options *ExportOptions) (*DirectoryDiff, error) {
+
+	from := &directorySnapshot{}
+
+	if err := Export(source, from, options); err != nil {
+		return nil, fmt.Errorf("reading source: %v", err)
+	}
+
+	to := &directorySnapshot{}
+
+	if err := Export(target, to, options); err != nil {
+		return nil, fmt.Errorf("reading target: %v", err)
+	}
+
+	diff := &DirectoryDiff{Users: []*UserDiff{}, Groups: []*GroupDiff{}, source: from}
+
+	targetUsers := make(map[string]*ExportedUser)
+
+	for _, user := range to.users {
+		targetUsers[cacheKey(user.User.Name)] = user
+	}
+
+	for _, user := range from.users {
+
+		key := cacheKey(user.User.Name)
+		other, ok := targetUsers[key]
+		delete(targetUsers, key)
+
+		if !ok {
+			diff.Users = append(diff.Users, &UserDiff{Name: user.User.Name, State: DiffOnlyInSource, Source: user})
+			continue
+		}
+
+		if changes := userChanges(user, other); len(changes) > 0 {
+			diff.Users = append(diff.Users, &UserDiff{Name: user.User.Name, State: DiffChanged, Source: user, Target: other, Changes: changes})
+		}
+
+	}
+
+	for _, user := range targetUsers {
+		diff.Users = append(diff.Users, &UserDiff{Name: user.User.Name, State: DiffOnlyInTarget, Target: user})
+	}
+
+	targetGroups := make(map[string]*ExportedGroup)
+
+	for _, group := range to.groups {
+		targetGroups[cacheKey(group.Group.Name)] = group
+	}
+
+	for _, group := range from.groups {
+
+		key := cacheKey(group.Group.Name)
+		other, ok := targetGroups[key]
+		delete(targetGroups, key)
+
+		if !ok {
+			diff.Groups = append(diff.Groups, &GroupDiff{Name: group.Group.Name, State: DiffOnlyInSource, Source: group})
+			continue
+		}
+
+		if changes := groupChanges(group, other); len(changes) > 0 {
+			diff.Groups = append(diff.Groups, &GroupDiff{Name: group.Group.Name, State: DiffChanged, Source: group, Target: other, Changes: changes})
+		}
+
+	}
+
+	for _, group := range targetGroups {
+		diff.Groups = append(diff.Groups, &GroupDiff{Name: group.Group.Name, State: DiffOnlyInTarget, Target: group})
+	}
+
+	sort.Slice(diff.Users, func(i, j int) bool {
+		return cacheKey(diff.Users[i].Name) < cacheKey(diff.Users[j].Name)
+	})
+
+	sort.Slice(diff.Groups, func(i, j int) bool {
+		return cacheKey(diff.Groups[i].Name) < cacheKey(diff.Groups[j].Name)
+	})
+
+	return diff, nil
+
+}
+
+// Collects an export in memory.
+type directorySnapshot struct {
+	users  []*ExportedUser
+	groups []*ExportedGroup
+}
+
+func (s *directorySnapshot) WriteUser(user *ExportedUser) error {
+	s.users = append(s.users, user)
+	return nil
+}
+
+func (s *directorySnapshot) WriteGroup(group *ExportedGroup) error {
+	s.groups = append(s.groups, group)
+	return nil
+}
+
+func (s *directorySnapshot) Close() error {
+	return nil
+}
+
+func userChanges(source, target *ExportedUser) []string {
+
+	changes := []string{}
+
+	fields := []struct {
+		name           string
+		source, target string
+	}{
+		{"first-name", source.User.FirstName, target.User.FirstName},
+		{"last-name", source.User.LastName, target.User.LastName},
+		{"display-name", source.User.DisplayName, target.User.DisplayName},
+		{"email", source.User.Email, target.User.Email},
+	}
+
+	for _, field := range fields {
+		if field.source != field.target {
+			changes = append(changes, field.name)
+		}
+	}
+
+	if source.User.IsActive != target.User.IsActive {
+		changes = append(changes, "active")
+	}
+
+	changes = append(changes, attributeChanges(source.Attributes, target.Attributes)...)
+
+	return append(changes, nameChanges("group", source.Groups, target.Groups)...)
+
+}
+
+func groupChanges(source, target *ExportedGroup) []string {
+
+	changes := []string{}
+
+	if source.Group.Description != target.Group.Description {
+		changes = append(changes, "description")
+	}
+
+	if source.Group.Active != target.Group.Active {
+		changes = append(changes, "active")
+	}
+
+	changes = append(changes, attributeChanges(source.Attributes, target.Attributes)...)
+
+	return append(changes, nameChanges("child-group", source.ChildGroups, target.ChildGroups)...)
+
+}
+
+// Compare attributes. Attributes in one crowd only are marked as added or
+// removed, as seen from the target.
+func attributeChanges(source, target []*Attribute) []string {
+
+	changes := []string{}
+	targetValues := attributeMap(target)
+
+	for _, attribute := range source {
+
+		values, ok := targetValues[attribute.Name]
+		delete(targetValues, attribute.Name)
+
+		switch {
+		case !ok:
+			changes = append(changes, "attribute "+attribute.Name+" (added)")
+		case !sameValues(attribute.Values, values):
+			changes = append(changes, "attribute "+attribute.Name)
+		}
+
+	}
+
+	names := make([]string, 0, len(targetValues))
+
+	for name := range targetValues {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		changes = append(changes, "attribute "+name+" (removed)")
+	}
+
+	return changes
+
+}
+
+// Compare lists of names, for example of groups, ignoring case and order.
+func nameChanges(kind string, source, target []string) []string {
+
+	changes := []string{}
+
+	for _, name := range missingNames(source, target) {
+		changes = append(changes, kind+" "+name+" (added)")
+	}
+
+	for _, name := range missingNames(target, source) {
+		changes = append(changes, kind+" "+name+" (removed)")
+	}
+
+	return changes
+
+}
+
+// Get the names which are not in other.
+func missingNames(names, other []string) []string {
+
+	existing := make(map[string]bool, len(other))
+
+	for _, name := range other {
+		existing[cacheKey(name)] = true
+	}
+
+	missing := []string{}
+
+	for _, name := range names {
+		if !existing[cacheKey(name)] {
+			missing = append(missing, name)
+		}
+	}
+
+	sort.Strings(missing)
+
+	return missing
+
+}
+
+// What a sync does with users and groups which exist in both crowds but
+// differ in their details or attribute values.
+type ConflictPolicy int
+
+const (
+	// Overwrite the target with the details and attribute values of the
+	// source.
+	SourceWins ConflictPolicy = iota
+	// Leave the details and attributes of the target unchanged.
+	SkipConflicts
+	// Copy the source entry under a new name, its name with a suffix, with
+	// all its source memberships and child groups, and leave the target
+	// entry unchanged.
+	RenameConflicts
+)
+
+type SyncOptions struct {
+	Conflicts ConflictPolicy
+	// Suffix of renamed users and groups, "-migrated" if not set.
+	RenameSuffix string
+}
+
+// A change made by a sync.
+type SyncStep struct {
+	// What was done, for example "create user alice".
+	Description string
+	Err         error
+}
+
+type SyncReport struct {
+	Steps []*SyncStep
+	// Users created in the target. Crowd does not disclose passwords, so
+	// they are created with a random password and have to reset it.
+	PasswordsNotCopied []string
+}
+
+// Count the steps which failed.
+func (r *SyncReport) Failed() int {
+
+	count := 0
+
+	for _, step := range r.Steps {
+		if step.Err != nil {
+			count++
+		}
+	}
+
+	return count
+
+}
+
+// Copy the differences of the diff to the target: users and groups only in
+// the source are created with their attributes, memberships only in the
+// source are added and conflicts are resolved by the policy. Nothing is
+// removed from the target. A failed step does not stop the sync, the report
+// lists each step.
+func (d *DirectoryDiff) Sync(target Client, options *SyncOptions) *SyncReport {
+
+	copied := SyncOptions{}
+
+	if options != nil {
+		copied = *options
+	}
+
+	if copied.RenameSuffix == "" {
+		copied.RenameSuffix = "-migrated"
+	}
+
+	s := &syncer{
+		target:       target,
+		options:      &copied,
+		users:        make(map[string]string),
+		groups:       make(map[string]string),
+		failedUsers:  make(map[string]bool),
+		failedGroups: make(map[string]bool),
+		report:       &SyncReport{Steps: []*SyncStep{}, PasswordsNotCopied: []string{}},
+	}
+
+	// Groups first, so that they exist when users and child groups are
+	// added to them.
+	for _, group := range d.Groups {
+		s.group(group)
+	}
+
+	groups := make(map[string]*GroupDiff, len(d.Groups))
+
+	for _, group := range d.Groups {
+		groups[cacheKey(group.Name)] = group
+	}
+
+	for _, group := range d.sourceGroups() {
+		s.childGroups(group, groups[cacheKey(group.Group.Name)])
+	}
+
+	users := make(map[string]*UserDiff, len(d.Users))
+
+	for _, user := range d.Users {
+		users[cacheKey(user.Name)] = user
+		s.user(user)
+	}
+
+	for _, user := range d.sourceUsers() {
+		s.memberships(user, users[cacheKey(user.User.Name)])
+	}
+
+	return s.report
+
+}
+
+// Get all users of the source, or only those of the diff if it was not made
+// by DiffDirectories.
+func (d *DirectoryDiff) sourceUsers() []*ExportedUser {
+
+	if d.source != nil {
+		return d.source.users
+	}
+
+	users := []*ExportedUser{}
+
+	for _, user := range d.Users {
+		if user.Source != nil {
+			users = append(users, user.Source)
+		}
+	}
+
+	return users
+
+}
+
+func (d *DirectoryDiff) sourceGroups() []*ExportedGroup {
+
+	if d.source != nil {
+		return d.source.groups
+	}
+
+	groups := []*ExportedGroup{}
+
+	for _, group := range d.Groups {
+		if group.Source != nil {
+			groups = append(groups, group.Source)
+		}
+	}
+
+	return groups
+
+}
+
+type syncer struct {
+	target  Client
+	options *SyncOptions
+	// Names of renamed users and groups in the target, by key of the source
+	// name.
+	users  map[string]string
+	groups map[string]string
+	// Keys of the target names of users and groups which could not be
+	// created. Their memberships are skipped.
+	failedUsers  map[string]bool
+	failedGroups map[string]bool
+	report       *SyncReport
+}
+
+// Run a step and record it.
+func (s *syncer) step(description string, fn func() error) error {
+
+	err := fn()
+
+	s.report.Steps = append(s.report.Steps, &SyncStep{Description: description, Err: err})
+
+	return err
+
+}
+
+func (s *syncer) groupName(name string) string {
+	return first(s.groups[cacheKey(name)], name)
+}
+
+func (s *syncer) userName(name string) string {
+	return first(s.users[cacheKey(name)], name)
+}
+
+func (s *syncer) group(diff *GroupDiff) {
+
+	source := diff.Source
+
+	switch {
+	case diff.State == DiffOnlyInTarget:
+		return
+	case diff.State == DiffChanged && diff.Conflict() && s.options.Conflicts == RenameConflicts:
+		s.groups[cacheKey(diff.Name)] = diff.Name + s.options.RenameSuffix
+	case diff.State == DiffChanged:
+
+		if !diff.Conflict() || s.options.Conflicts == SourceWins {
+			s.groupAttributes(diff.Name, source.Attributes, diff.Target.Attributes)
+		}
+
+		if s.options.Conflicts == SourceWins && hasDetailChanges(diff.Changes) {
+			s.step("update group "+diff.Name, func() error {
+				return s.target.UpdateGroup(diff.Name, source.Group.Description, source.Group.Active)
+			})
+		}
+
+		return
+
+	}
+
+	name := s.groupName(diff.Name)
+
+	err := s.step("create group "+name, func() error {
+		return s.target.CreateGroup(name, source.Group.Description, source.Group.Active)
+	})
+
+	if err != nil {
+		s.failedGroups[cacheKey(name)] = true
+		return
+	}
+
+	s.groupAttributes(name, source.Attributes, nil)
+
+}
+
+// Store the source attributes which are missing or, if the source wins,
+// different in the target.
+func (s *syncer) groupAttributes(groupName string, source, target []*Attribute) {
+
+	if changed := s.changedAttributes(source, target); len(changed) > 0 {
+		s.step("store attributes of group "+groupName, func() error {
+			return s.target.StoreGroupAttributes(groupName, &Attributes{Attributes: changed})
+		})
+	}
+
+}
+
+func (s *syncer) userAttributes(userName string, source, target []*Attribute) {
+
+	if changed := s.changedAttributes(source, target); len(changed) > 0 {
+		s.step("store attributes of user "+userName, func() error {
+			return s.target.StoreUserAttributes(userName, &Attributes{Attributes: changed})
+		})
+	}
+
+}
+
+func (s *syncer) changedAttributes(source, target []*Attribute) []*Attribute {
+
+	targetValues := attributeMap(target)
+	changed := []*Attribute{}
+
+	for _, attribute := range source {
+
+		values, ok := targetValues[attribute.Name]
+
+		if !ok || (s.options.Conflicts == SourceWins && !sameValues(attribute.Values, values)) {
+			changed = append(changed, attribute)
+		}
+
+	}
+
+	return changed
+
+}
+
+// Add the child groups of a source group which are missing in the target.
+// The diff is nil if the group is the same in both crowds.
+func (s *syncer) childGroups(source *ExportedGroup, diff *GroupDiff) {
+
+	parent := s.groupName(source.Group.Name)
+	existing := source.ChildGroups
+
+	switch {
+	case parent != source.Group.Name:
+		// A renamed group is new and has no child groups yet.
+		existing = nil
+	case diff != nil && diff.Target != nil:
+		existing = diff.Target.ChildGroups
+	case diff != nil:
+		existing = nil
+	}
+
+	if s.failedGroups[cacheKey(parent)] {
+		return
+	}
+
+	for _, child := range s.missingGroups(source.ChildGroups, existing) {
+
+		if s.failedGroups[cacheKey(child)] {
+			continue
+		}
+
+		s.step("add group "+child+" to group "+parent, func() error {
+			return s.target.AddChildGroupMembership(parent, child)
+		})
+
+	}
+
+}
+
+// Get the groups which are missing in the existing ones, with their names
+// in the target. Renamed groups are new, so they are always missing.
+func (s *syncer) missingGroups(groupNames, existing []string) []string {
+
+	missing := []string{}
+
+	for _, groupName := range groupNames {
+		if renamed := s.groupName(groupName); renamed != groupName || len(missingNames([]string{groupName}, existing)) > 0 {
+			missing = append(missing, renamed)
+		}
+	}
+
+	sort.Strings(missing)
+
+	return missing
+
+}
+
+func (s *syncer) user(diff *UserDiff) {
+
+	source := diff.Source
+
+	switch {
+	case diff.State == DiffOnlyInTarget:
+		return
+	case diff.State == DiffChanged && diff.Conflict() && s.options.Conflicts == RenameConflicts:
+		s.users[cacheKey(diff.Name)] = diff.Name + s.options.RenameSuffix
+	case diff.State == DiffChanged:
+
+		if !diff.Conflict() || s.options.Conflicts == SourceWins {
+			s.userAttributes(diff.Name, source.Attributes, diff.Target.Attributes)
+		}
+
+		if s.options.Conflicts == SourceWins && hasDetailChanges(diff.Changes) {
+
+			user := source.User
+
+			s.step("update user "+diff.Name, func() error {
+				return s.target.UpdateUser(diff.Name, user.FirstName, user.LastName, user.DisplayName, user.Email, user.IsActive)
+			})
+
+		}
+
+		return
+
+	}
+
+	name := s.userName(diff.Name)
+	user := source.User
+
+	err := s.step("create user "+name, func() error {
+
+		password, err := randomPassword()
+
+		if err != nil {
+			return err
+		}
+
+		return s.target.AddUser(name, password, user.FirstName, user.LastName, user.DisplayName, user.Email, user.IsActive)
+
+	})
+
+	if err != nil {
+		s.failedUsers[cacheKey(name)] = true
+		return
+	}
+
+	s.report.PasswordsNotCopied = append(s.report.PasswordsNotCopied, name)
+	s.userAttributes(name, source.Attributes, nil)
+
+}
+
+// Add the memberships of a source user which are missing in the target. The
+// diff is nil if the user is the same in both crowds.
+func (s *syncer) memberships(source *ExportedUser, diff *UserDiff) {
+
+	userName := s.userName(source.User.Name)
+	existing := source.Groups
+
+	switch {
+	case userName != source.User.Name:
+		// A renamed user is new and has no memberships yet.
+		existing = nil
+	case diff != nil && diff.Target != nil:
+		existing = diff.Target.Groups
+	case diff != nil:
+		existing = nil
+	}
+
+	if s.failedUsers[cacheKey(userName)] {
+		return
+	}
+
+	for _, groupName := range s.missingGroups(source.Groups, existing) {
+
+		if s.failedGroups[cacheKey(groupName)] {
+			continue
+		}
+
+		s.step("add user "+userName+" to group "+groupName, func() error {
+			return s.target.AddUserToGroup(userName, groupName)
+		})
+
+	}
+
+}
+
+// Generate a random password for a user whose password is unknown.
+func randomPassword() (string, error) {
+
+	random := make([]byte, 24)
+
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(random), nil
+
+}
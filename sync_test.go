@@ -0,0 +1,188 @@
+package crowd_test
+
+import (
+	"bytes"
+	"github.com/agile-rcm/crowd-go"
+	"github.com/agile-rcm/crowd-go/crowdmock"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// Create a source and a target crowd which share the user alice and the
+// group staff, with different details.
+func newSyncClients(t *testing.T) (*crowdmock.Client, *crowdmock.Client) {
+
+	source := crowdmock.NewClient()
+
+	assert.Nil(t, source.CreateGroup("staff", "All staff", true))
+	assert.Nil(t, source.CreateGroup("developers", "", true))
+	assert.Nil(t, source.AddChildGroupMembership("staff", "developers"))
+	assert.Nil(t, source.AddUser("alice", "password", "Alice", "Smith", "Alice Smith", "alice@example.com", true))
+	assert.Nil(t, source.AddUser("bob", "password", "Bob", "", "Bob", "bob@example.com", true))
+	assert.Nil(t, source.AddUserToGroup("alice", "developers"))
+	assert.Nil(t, source.AddUserToGroup("bob", "staff"))
+	assert.Nil(t, source.StoreUserAttributes("alice", &crowd.Attributes{Attributes: []*crowd.Attribute{{Name: "team", Values: []string{"backend"}}}}))
+
+	target := crowdmock.NewClient()
+
+	assert.Nil(t, target.CreateGroup("staff", "Staff", true))
+	assert.Nil(t, target.CreateGroup("admins", "", true))
+	assert.Nil(t, target.AddUser("alice", "password", "Alice", "Jones", "Alice Jones", "alice@example.org", true))
+	assert.Nil(t, target.AddUserToGroup("alice", "admins"))
+	assert.Nil(t, target.StoreUserAttributes("alice", &crowd.Attributes{Attributes: []*crowd.Attribute{{Name: "team", Values: []string{"ops"}}}}))
+
+	return source, target
+
+}
+
+func TestDiffDirectories(t *testing.T) {
+
+	source, target := newSyncClients(t)
+
+	diff, err := crowd.DiffDirectories(source, target, nil)
+
+	assert.Nil(t, err)
+
+	output := &bytes.Buffer{}
+
+	assert.Nil(t, diff.WriteText(output))
+	assert.Equal(t, `~ user alice: last-name, display-name, email, attribute team, group developers (added), group admins (removed)
++ user bob
+- group admins
++ group developers
+~ group staff: description, child-group developers (added)
+`, output.String())
+
+	assert.True(t, diff.Users[0].Conflict())
+	assert.Equal(t, "Jones", diff.Users[0].Target.User.LastName)
+
+	diff, err = crowd.DiffDirectories(source, source, nil)
+
+	assert.Nil(t, err)
+	assert.True(t, diff.Empty())
+
+}
+
+func TestDirectoryDiff_Sync(t *testing.T) {
+
+	source, target := newSyncClients(t)
+
+	diff, err := crowd.DiffDirectories(source, target, nil)
+
+	assert.Nil(t, err)
+
+	report := diff.Sync(target, nil)
+
+	assert.Equal(t, 0, report.Failed())
+	assert.Equal(t, []string{"bob"}, report.PasswordsNotCopied)
+
+	// The target now has everything of the source, the source won the
+	// conflicts and nothing was removed.
+	diff, err = crowd.DiffDirectories(source, target, nil)
+
+	assert.Nil(t, err)
+
+	output := &bytes.Buffer{}
+
+	assert.Nil(t, diff.WriteText(output))
+	assert.Equal(t, "~ user alice: group admins (removed)\n- group admins\n", output.String())
+
+	// Passwords are not copied.
+	assert.Equal(t, crowd.ErrorInvalidCredentials, target.AuthenticateUser("bob", "password"))
+	assert.Nil(t, target.AuthenticateUser("alice", "password"))
+
+}
+
+func TestDirectoryDiff_SyncFailedCreation(t *testing.T) {
+
+	source, target := newSyncClients(t)
+
+	diff, err := crowd.DiffDirectories(source, target, nil)
+
+	assert.Nil(t, err)
+
+	// Bob and the group developers can no longer be created.
+	assert.Nil(t, target.AddUser("bob", "password", "", "", "", "", true))
+	assert.Nil(t, target.CreateGroup("developers", "", true))
+
+	report := diff.Sync(target, nil)
+
+	assert.Equal(t, 2, report.Failed())
+
+	// Their memberships are not tried.
+	for _, step := range report.Steps {
+		assert.NotContains(t, step.Description, "add user bob")
+		assert.NotContains(t, step.Description, "developers to group")
+		assert.NotContains(t, step.Description, "to group developers")
+	}
+
+}
+
+func TestDirectoryDiff_SyncConflicts(t *testing.T) {
+
+	source, target := newSyncClients(t)
+
+	diff, err := crowd.DiffDirectories(source, target, nil)
+
+	assert.Nil(t, err)
+	assert.Equal(t, 0, diff.Sync(target, &crowd.SyncOptions{Conflicts: crowd.SkipConflicts}).Failed())
+
+	alice, err := target.GetUser("alice")
+
+	assert.Nil(t, err)
+	assert.Equal(t, "Jones", alice.LastName)
+
+	groups, err := target.GetDirectGroupsForUser("alice")
+
+	assert.Nil(t, err)
+	assert.Len(t, groups.Groups, 2, "memberships are added to conflicting users")
+
+	source, target = newSyncClients(t)
+
+	// Carol and the group everyone are the same in both crowds, but the
+	// renamed group staff gets their memberships too.
+	for _, client := range []*crowdmock.Client{source, target} {
+		assert.Nil(t, client.AddUser("carol", "password", "Carol", "", "Carol", "carol@example.com", true))
+		assert.Nil(t, client.AddUserToGroup("carol", "staff"))
+		assert.Nil(t, client.CreateGroup("everyone", "", true))
+		assert.Nil(t, client.AddChildGroupMembership("everyone", "staff"))
+	}
+
+	diff, err = crowd.DiffDirectories(source, target, nil)
+
+	assert.Nil(t, err)
+
+	report := diff.Sync(target, &crowd.SyncOptions{Conflicts: crowd.RenameConflicts, RenameSuffix: "-a"})
+
+	assert.Equal(t, 0, report.Failed())
+	assert.Equal(t, []string{"alice-a", "bob"}, report.PasswordsNotCopied)
+
+	renamed, err := target.GetUser("alice-a")
+
+	assert.Nil(t, err)
+	assert.Equal(t, "Smith", renamed.LastName)
+
+	members, err := target.GetGroupMembers("staff-a")
+
+	assert.Nil(t, err)
+
+	if assert.Len(t, members.Users, 2) {
+		assert.ElementsMatch(t, []string{"bob", "carol"}, []string{members.Users[0].Name, members.Users[1].Name})
+	}
+
+	children, err := target.GetChildGroups("staff-a")
+
+	assert.Nil(t, err)
+	assert.Equal(t, "developers", children.Groups[0].Name)
+
+	children, err = target.GetChildGroups("everyone")
+
+	assert.Nil(t, err)
+	assert.Len(t, children.Groups, 2)
+
+	alice, err = target.GetUser("alice")
+
+	assert.Nil(t, err)
+	assert.Equal(t, "Jones", alice.LastName)
+
+}